@@ -6,31 +6,49 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
+	"net"
+	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 	"unicode"
 
 	"gopkg.in/yaml.v3"
 
 	// "text/tabwriter" // No longer needed for Markdown style
 
+	"github.com/OG-Open-Source/PanelBase/internal/blobstore"
 	"github.com/OG-Open-Source/PanelBase/internal/configuration"
 	"github.com/OG-Open-Source/PanelBase/internal/container"
+	"github.com/OG-Open-Source/PanelBase/internal/downloader"
+	"github.com/OG-Open-Source/PanelBase/internal/events"
 	"github.com/OG-Open-Source/PanelBase/internal/extension/commands"
 	"github.com/OG-Open-Source/PanelBase/internal/extension/plugins"
 	"github.com/OG-Open-Source/PanelBase/internal/extension/themes"
+	"github.com/OG-Open-Source/PanelBase/internal/kvstore"
 	"github.com/OG-Open-Source/PanelBase/internal/logger"
 	"github.com/OG-Open-Source/PanelBase/internal/rpc"
+	"github.com/OG-Open-Source/PanelBase/internal/secrets"
 	"github.com/OG-Open-Source/PanelBase/internal/utils"
 	"github.com/spf13/cobra"
 )
 
 var (
 	// Used for flags.
-	// cfgFile string // Example flag definition
+	dataDir      string   // --data-dir: PanelBase home directory
+	cfgFile      string   // --config: config file (default <home>/configs/config.yaml)
+	cfgOverrides []string // --set key=value: config overrides, applied over the environment
+
+	appConfigCache       *configuration.Config // Set by loadAppConfig
+	configWarningsLogged bool                  // Set by newAppLogger
 
 	rootCmd = &cobra.Command{
 		Use:   "panelbase",
@@ -148,8 +166,18 @@ func main() {
 }
 
 func init() {
-	// Here you will define your flags and configuration settings.
-	// rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.panelbase.yaml)")
+	rootCmd.PersistentFlags().StringVar(&dataDir, "data-dir", "", "PanelBase home directory holding configs, extensions and data (default $"+configuration.HomeEnvVar+" or the working directory)")
+	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default <data-dir>/configs/config.yaml)")
+	rootCmd.PersistentFlags().StringArrayVar(&cfgOverrides, "set", nil, "override a config key, e.g. --set server.port=8080 (repeatable; wins over "+configuration.EnvPrefix+"* variables)")
+	rootCmd.PersistentPreRun = func(cmd *cobra.Command, args []string) {
+		configuration.SetHome(configuration.ResolveHome(dataDir))
+		overrides, err := configuration.ParseOverrides(cfgOverrides)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		configuration.SetFlagOverrides(overrides)
+	}
 
 	// Add commands to root command
 	rootCmd.AddCommand(serverCmd)
@@ -157,6 +185,9 @@ func init() {
 	rootCmd.AddCommand(pluginCmd)    // Add plugin command here
 	rootCmd.AddCommand(commandCmd)   // Add command command here
 	rootCmd.AddCommand(containerCmd) // Add container command here
+	rootCmd.AddCommand(configCmd)
+	rootCmd.AddCommand(secretsCmd)
+	rootCmd.AddCommand(cacheCmd)
 
 	// Hide the default help command from the list of available commands
 	rootCmd.SetHelpCommand(&cobra.Command{Hidden: true})
@@ -171,6 +202,7 @@ var serverCmd = &cobra.Command{
 
 func init() {
 	serverCmd.AddCommand(serverStartCmd)
+	serverCmd.AddCommand(serverStatusCmd)
 }
 
 // func init() { // Moved AddCommand to rootCmd init
@@ -189,6 +221,78 @@ The server will continue running until manually stopped (e.g., Ctrl+C).`,
 	},
 }
 
+var serverStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the status and RPC call metrics of the running server",
+	Long: `Connects to the running PanelBase server and prints its process information
+together with per-method RPC call counts, error counts and latencies.`,
+	Example: `  panelbase server status`,
+	Args:    cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		client := connectToServerForCLI()
+		if client == nil {
+			fmt.Println("PanelBase server is not running.")
+			os.Exit(1)
+		}
+		defer client.Close()
+
+		status, err := client.Status()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error getting server status: %v\n", err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("PanelBase server is running (pid %d) at %s.\n", status.PID, client.Info().Address)
+		fmt.Printf("Started:    %s (up %s)\n", status.StartedAt.Format(time.RFC3339), time.Since(status.StartedAt).Round(time.Second))
+		fmt.Printf("Goroutines: %d\n", status.Goroutines)
+		fmt.Println()
+
+		if len(status.Methods) == 0 {
+			fmt.Println("No RPC calls recorded yet.")
+			return
+		}
+
+		headers := []string{"METHOD", "CALLS", "ERRORS", "AVG", "MAX"}
+		var rows [][]string
+		for _, m := range status.Methods {
+			rows = append(rows, []string{
+				m.Method,
+				fmt.Sprintf("%d", m.Calls),
+				fmt.Sprintf("%d", m.Errors),
+				m.AvgTime().Round(time.Microsecond).String(),
+				m.MaxTime.Round(time.Microsecond).String(),
+			})
+		}
+		printTable(headers, rows)
+	},
+}
+
+// printTable prints rows under headers in columns padded to their widest cell.
+func printTable(headers []string, rows [][]string) {
+	columnWidths := make([]int, len(headers))
+	for i, h := range headers {
+		columnWidths[i] = calculateDisplayWidth(h)
+	}
+	for _, row := range rows {
+		for i, cell := range row {
+			if w := calculateDisplayWidth(cell); w > columnWidths[i] {
+				columnWidths[i] = w
+			}
+		}
+	}
+
+	for _, cells := range append([][]string{headers}, rows...) {
+		for i, cell := range cells {
+			fmt.Print(cell)
+			if i < len(cells)-1 {
+				fmt.Print(strings.Repeat(" ", columnWidths[i]-calculateDisplayWidth(cell)))
+				fmt.Print("  ") // Two spaces as separator
+			}
+		}
+		fmt.Println()
+	}
+}
+
 // --- Theme Command ---
 var themeCmd = &cobra.Command{
 	Use:   "themes", // Changed from "theme" to "themes"
@@ -201,23 +305,42 @@ var themeInstallCmd = &cobra.Command{
 	Short: "Install a theme from a URL or local path",
 	Long: `Downloads and installs a theme from the specified source.
 The source must be either a direct URL pointing to a 'theme.yaml' file
-or a local filesystem path to a 'theme.yaml' file.
+or a local filesystem path to a 'theme.yaml' file. It may also be a URL or
+local path of a theme archive (.zip, .tar.gz or .tgz, see 'themes pack').
 
 The command will:
 1. Fetch and validate the 'theme.yaml' metadata.
 2. Create a directory for the theme under 'ext/themes/'.
-3. Download all files specified in the 'structure' section of the metadata.
+3. Download all files specified in the 'structure' section of the metadata,
+   or extract them from the archive, and verify their SHA256 sums.
 4. Save the original 'theme.yaml' alongside the downloaded files.`,
 	Example: `  panelbase theme install https://example.com/path/to/mytheme.yaml
   panelbase theme install /path/to/local/theme.yaml
-  panelbase theme install ./my_local_theme.yaml --force`,
+  panelbase theme install ./my_local_theme.yaml --force
+  panelbase theme install ./mytheme-1.0.0.tar.gz`,
 	Args: cobra.ExactArgs(1), // Requires exactly one argument: the source
 	Run: func(cmd *cobra.Command, args []string) {
 		source := args[0]
 		force, _ := cmd.Flags().GetBool("force") // Get the value of the --force flag
 
+		// Route through the running server if there is one; in-process mode is only the fallback.
+		if client := connectToServerForCLI(); client != nil {
+			defer client.Close()
+			reply, err := client.InstallTheme(sourceForServer(source), force)
+			if err != nil {
+				if errors.Is(err, themes.ErrThemeAlreadyExistsNoForce) {
+					fmt.Fprintln(os.Stderr, "Theme already exists. Use --force to reinstall it.")
+				} else {
+					fmt.Fprintf(os.Stderr, "Error installing theme: %v\n", err)
+				}
+				os.Exit(1)
+			}
+			fmt.Printf("Theme '%s' (version %s) installed by the running server.\n", reply.Name, reply.Version)
+			return
+		}
+
 		// For CLI commands, we primarily use fmt for output, but initialize logger for manager dependencies.
-		appLogger, err := logger.NewLogger() // Use standard logger initialization
+		appLogger, err := newAppLogger() // Use standard logger initialization
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Failed to initialize logger for CLI: %v\n", err)
 			os.Exit(1)
@@ -225,7 +348,7 @@ The command will:
 		defer appLogger.Close() // Still good practice to close it
 
 		// Initialize ID Generator (needed for ThemeManager)
-		cfgForInstall, errCfg := configuration.LoadConfig() // Load config to get security settings
+		cfgForInstall, errCfg := loadAppConfig() // Load config to get security settings
 		if errCfg != nil {
 			// appLogger.Logf("Failed to load configuration for theme install: %v", errCfg) // Removed CLI layer log
 			fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", errCfg)
@@ -239,12 +362,13 @@ The command will:
 		}
 
 		// Initialize only necessary managers for this command
-		themeMgr, err := themes.NewThemeManager(appLogger, idGenForInstall) // Pass idGen
+		themeMgr, err := themes.NewThemeManager(appLogger, idGenForInstall, appPaths().ThemesDir) // Pass idGen
 		if err != nil {
 			// appLogger.Logf("Failed to initialize Theme Manager for install: %v", err) // Removed CLI layer log
 			fmt.Fprintf(os.Stderr, "Failed to initialize Theme Manager: %v\n", err) // User-facing error
 			os.Exit(1)
 		}
+		themeMgr.SetDownloader(newCachingDownloader(appLogger))
 
 		// Call the new package-level Install function
 		_, err = themes.Install(themeMgr, source, force) // meta is no longer needed here
@@ -268,7 +392,7 @@ The command will:
 var themeListCmd = &cobra.Command{
 	Use:   "list [theme_id]",
 	Short: "Lists all installed themes or details of a specific theme",
-	Long: `Displays a list of all themes currently installed and registered in the global state file (configs/themes.json).
+	Long: `Displays a list of all themes currently installed and registered in the global state store (state.db in paths.state_dir).
 If a theme_id is provided, it displays detailed information for that specific theme from its local theme.json file, presented vertically.`,
 	Example: `  panelbase theme list
   panelbase theme list thm_J4yoW1B5kDzy`,
@@ -279,7 +403,7 @@ If a theme_id is provided, it displays detailed information for that specific th
 
 		if len(args) == 0 {
 			// --- List all themes (horizontal) ---
-			themeMgr, err := themes.NewThemeManager(appLogger, idGen)
+			themeMgr, err := themes.NewThemeManager(appLogger, idGen, appPaths().ThemesDir)
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "Failed to initialize Theme Manager: %v\n", err)
 				os.Exit(1)
@@ -376,7 +500,7 @@ If a theme_id is provided, it displays detailed information for that specific th
 			themeID := args[0]
 
 			// Initialize ThemeManager (appLogger and idGen are already initialized at the start of Run)
-			themeMgr, err := themes.NewThemeManager(appLogger, idGen)
+			themeMgr, err := themes.NewThemeManager(appLogger, idGen, appPaths().ThemesDir)
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "Failed to initialize Theme Manager: %v\n", err)
 				os.Exit(1)
@@ -441,15 +565,24 @@ If a theme_id is provided, it displays detailed information for that specific th
 }
 
 func init() {
-	themeCmd.AddCommand(themeInstallCmd) // Add install subcommand to theme command
-	themeCmd.AddCommand(themeListCmd)    // Add list subcommand to theme command
-	themeCmd.AddCommand(themeRemoveCmd)  // Add remove subcommand
-	themeCmd.AddCommand(themeCreateCmd)  // Add create subcommand
-	themeCmd.AddCommand(themeUpdateCmd)  // Add update subcommand
+	themeCmd.AddCommand(themeInstallCmd)  // Add install subcommand to theme command
+	themeCmd.AddCommand(themeListCmd)     // Add list subcommand to theme command
+	themeCmd.AddCommand(themeRemoveCmd)   // Add remove subcommand
+	themeCmd.AddCommand(themeCreateCmd)   // Add create subcommand
+	themeCmd.AddCommand(themeUpdateCmd)   // Add update subcommand
+	themeCmd.AddCommand(themeVerifyCmd)   // Add verify subcommand
+	themeCmd.AddCommand(themeRollbackCmd) // Add rollback subcommand
+	themeCmd.AddCommand(themePackCmd)     // Add pack subcommand
+	themeCmd.AddCommand(themeDevCmd)      // Add dev subcommand
 
 	// Add --force flag to theme install command
 	themeInstallCmd.Flags().BoolP("force", "f", false, "Force overwrite if theme directory already exists")
 	// Flags for themeCreateCmd are removed as it's now interactive.
+	themePackCmd.Flags().StringP("output", "o", "", "Archive to write; .zip, .tar.gz or .tgz (default <name>-<version>.tar.gz)")
+	themeDevCmd.Flags().String("host", "127.0.0.1", "Address to listen on")
+	themeDevCmd.Flags().IntP("port", "p", 8080, "Port to listen on")
+	themeDevCmd.Flags().StringArray("setting", nil, "Set a UI setting declared in theme.yaml, e.g. --setting accent_color=#ff6600 (repeatable)")
+	themeDevCmd.Flags().Duration("interval", 500*time.Millisecond, "How often to check the theme files for changes")
 }
 
 var themeCreateCmd = &cobra.Command{
@@ -554,7 +687,7 @@ within that directory. The 'source_link' field is mandatory.`,
 		finalSourceLink := sourceLinkInput
 
 		// Initialize ThemeManager
-		themeMgr, err := themes.NewThemeManager(cliLogger, idGen) // Use cliLogger and idGen from the top
+		themeMgr, err := themes.NewThemeManager(cliLogger, idGen, appPaths().ThemesDir) // Use cliLogger and idGen from the top
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Failed to initialize Theme Manager: %v\n", err)
 			// appLogger.Logf("Failed to initialize Theme Manager for create: %v", err) // Removed CLI layer log
@@ -615,6 +748,195 @@ within that directory. The 'source_link' field is mandatory.`,
 	},
 }
 
+var themePackCmd = &cobra.Command{
+	Use:   "pack <directory_path>",
+	Short: "Bundle a theme directory and its theme.yaml into one archive",
+	Long: `Packs the files listed in the theme.yaml of a directory (see 'themes create') together with
+the theme.yaml itself into a .zip or .tar.gz archive. The archive can be installed from a local
+path or a URL with 'themes install'; the files are verified against the sums of the embedded
+theme.yaml, so only the archive has to be hosted.`,
+	Example: `  panelbase themes pack ./my-theme
+  panelbase themes pack ./my-theme -o dist/my-theme.zip`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+		appLogger, _, idGen := initBaseForCLI()
+		defer appLogger.Close()
+
+		themeMgr, err := themes.NewThemeManager(appLogger, idGen, appPaths().ThemesDir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to initialize Theme Manager: %v\n", err)
+			os.Exit(1)
+		}
+
+		if output == "" {
+			// Named after the theme, which is only known from its manifest
+			meta, err := themes.ReadManifest(args[0])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error packing theme: %v\n", err)
+				os.Exit(1)
+			}
+			output = themes.ArchiveName(meta)
+		}
+		meta, err := themes.Pack(themeMgr, args[0], output)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error packing theme: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Theme '%s' (v%s) packed into %s\n", meta.Name, meta.Version, output)
+		fmt.Printf("  panelbase themes install \"%s\"\n", output)
+	},
+}
+
+var themeDevCmd = &cobra.Command{
+	Use:   "dev <directory_path>",
+	Short: "Preview a theme directory with live reload while editing it",
+	Long: `Serves a theme directory the way a container with the theme applied would serve it:
+templates, layouts, partials, translations, error pages and ui_settings.json all work the same.
+If the theme.yaml declares a parent, the installed parent's files are served beneath it.
+UI settings start from the defaults declared in theme.yaml and can be changed with --setting.
+
+The files are checked for changes every --interval; open pages then reload themselves through
+an injected script. No theme.yaml is needed until the theme is packed or installed.`,
+	Example: `  panelbase themes dev ./my-theme
+  panelbase themes dev ./my-theme -p 9000 --setting accent_color=#ff6600`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		host, _ := cmd.Flags().GetString("host")
+		port, _ := cmd.Flags().GetInt("port")
+		assignments, _ := cmd.Flags().GetStringArray("setting")
+		interval, _ := cmd.Flags().GetDuration("interval")
+		if interval <= 0 {
+			fmt.Fprintf(os.Stderr, "Error: --interval must be positive\n")
+			os.Exit(1)
+		}
+		themeDir, err := filepath.Abs(args[0])
+		if err == nil {
+			var info os.FileInfo
+			if info, err = os.Stat(themeDir); err == nil && !info.IsDir() {
+				err = fmt.Errorf("'%s' is not a directory", themeDir)
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		values := make(map[string]string, len(assignments))
+		for _, assignment := range assignments {
+			key, value, ok := strings.Cut(assignment, "=")
+			if !ok || key == "" {
+				fmt.Fprintf(os.Stderr, "Error: invalid setting '%s', expected key=value\n", assignment)
+				os.Exit(1)
+			}
+			values[key] = value
+		}
+
+		if err := runThemeDev(themeDir, host, port, values, interval); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// runThemeDev serves themeDir for 'themes dev' until the process is interrupted. It returns
+// instead of exiting on errors, so the preview directory is removed and the logger closed.
+func runThemeDev(themeDir, host string, port int, values map[string]string, interval time.Duration) error {
+	appLogger, _, idGen := initBaseForCLI()
+	defer appLogger.Close()
+	themeMgr, err := themes.NewThemeManager(appLogger, idGen, appPaths().ThemesDir)
+	if err != nil {
+		return fmt.Errorf("failed to initialize Theme Manager: %w", err)
+	}
+
+	// The theme is served beneath an empty web directory, like a container's, whose parent
+	// holds the ui_settings.json generated from theme.yaml.
+	previewRoot, err := os.MkdirTemp("", "panelbase-theme-dev-")
+	if err != nil {
+		return fmt.Errorf("failed to create preview directory: %w", err)
+	}
+	defer os.RemoveAll(previewRoot)
+	webDir := filepath.Join(previewRoot, "web")
+	if err := os.Mkdir(webDir, 0755); err != nil {
+		return fmt.Errorf("failed to create preview directory: %w", err)
+	}
+
+	var layersMu sync.Mutex
+	layers := []string{themeDir}
+	currentLayers := func() []string {
+		layersMu.Lock()
+		defer layersMu.Unlock()
+		return layers
+	}
+	// loadTheme re-reads theme.yaml and regenerates ui_settings.json. Problems are reported
+	// but the directory keeps being served, since the author is probably fixing them.
+	loadTheme := func() {
+		newLayers, schema, err := themes.SourceLayers(themeMgr, themeDir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		}
+		layersMu.Lock()
+		layers = newLayers
+		layersMu.Unlock()
+
+		settings := schema.Defaults()
+		for key, raw := range values {
+			value, err := schema.Parse(key, raw)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: ignoring --setting %s=%s: %v\n", key, raw, err)
+				continue
+			}
+			settings[key] = value
+		}
+		data, _ := json.MarshalIndent(settings, "", "  ")
+		if err := os.WriteFile(filepath.Join(previewRoot, "ui_settings.json"), append(data, '\n'), 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to write UI settings: %v\n", err)
+		}
+	}
+	loadTheme()
+
+	handler, err := container.NewContainerWebHandler(webDir, container.WebHandlerOptions{ThemeLayers: currentLayers})
+	if err != nil {
+		return fmt.Errorf("failed to create web handler: %w", err)
+	}
+	defer handler.Close()
+	liveReloader := container.NewLiveReloader(handler)
+	server := &http.Server{Addr: net.JoinHostPort(host, fmt.Sprintf("%d", port)), Handler: liveReloader}
+
+	stopWatcher := make(chan struct{})
+	defer close(stopWatcher)
+	go container.WatchFiles(currentLayers, interval, stopWatcher, func(changed []string) {
+		for _, path := range changed {
+			if filepath.Base(path) == "theme.yaml" {
+				loadTheme() // Settings or the parent may have changed
+				break
+			}
+		}
+		fmt.Printf("%s Changed: %s\n", time.Now().Format("15:04:05"), strings.Join(changed, ", "))
+		liveReloader.Reload()
+	})
+
+	// Registered before anything is printed, so an early Ctrl+C still cleans up.
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigChan)
+
+	listener, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		return err
+	}
+	go server.Serve(listener)
+	fmt.Printf("Previewing '%s' at http://%s/\n", themeDir, listener.Addr())
+	for _, layer := range currentLayers()[1:] {
+		fmt.Printf("  Parent layer: %s\n", layer)
+	}
+	fmt.Println("Watching for changes. Press Ctrl+C to stop.")
+
+	<-sigChan
+	liveReloader.Close() // Ends the event streams of open pages
+	server.Close()
+	return nil
+}
+
 var themeUpdateCmd = &cobra.Command{
 	Use:   "update <theme_id>",
 	Short: "Update an installed theme to the latest version from its source",
@@ -624,15 +946,28 @@ The theme_id is the local directory name of the theme (e.g., "thm_abc123").`,
 	Args:    cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		themeID := args[0]
+
+		if client := connectToServerForCLI(); client != nil {
+			defer client.Close()
+			reply, err := client.UpdateTheme(themeID)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error updating theme '%s': %v\n", themeID, err)
+				os.Exit(1)
+			}
+			printUpdateReply("Theme", themeID, reply)
+			return
+		}
+
 		appLogger, _, idGen := initBaseForCLI()
 		defer appLogger.Close() // Ensure logger is closed
 
-		themeMgr, err := themes.NewThemeManager(appLogger, idGen)
+		themeMgr, err := themes.NewThemeManager(appLogger, idGen, appPaths().ThemesDir)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Failed to initialize Theme Manager: %v\n", err)
 			// appLogger.Logf("Failed to initialize Theme Manager for update: %v", err) // Removed CLI layer log
 			os.Exit(1)
 		}
+		themeMgr.SetDownloader(newCachingDownloader(appLogger))
 
 		// Call the new package-level Update function
 		_, err = themes.Update(themeMgr, themeID) // updatedMeta is no longer needed here
@@ -649,16 +984,26 @@ var themeRemoveCmd = &cobra.Command{
 	Use:   "remove <theme_id>",
 	Short: "Remove an installed theme",
 	Long: `Removes a theme based on its ID. This action will:
-1. Remove the theme's entry from the global state file (configs/themes.json).
+1. Remove the theme's entry from the global state store (state.db in paths.state_dir).
 2. Delete the theme's directory from 'ext/themes/'.`,
 	Example: `  panelbase themes remove thm_J4yoW1B5kDzy`,
 	Args:    cobra.ExactArgs(1), // Requires exactly one argument: the theme_id
 	Run: func(cmd *cobra.Command, args []string) {
 		themeID := args[0]
 
+		if client := connectToServerForCLI(); client != nil {
+			defer client.Close()
+			if err := client.RemoveTheme(themeID); err != nil {
+				fmt.Fprintf(os.Stderr, "Error removing theme '%s': %v\n", themeID, err)
+				os.Exit(1)
+			}
+			fmt.Printf("Theme %s removed by the running server.\n", themeID)
+			return
+		}
+
 		appLogger, _, idGen := initBaseForCLI() // Initialize base components
 
-		themeMgr, err := themes.NewThemeManager(appLogger, idGen)
+		themeMgr, err := themes.NewThemeManager(appLogger, idGen, appPaths().ThemesDir)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Failed to initialize Theme Manager: %v\n", err)
 			os.Exit(1)
@@ -675,6 +1020,106 @@ var themeRemoveCmd = &cobra.Command{
 	},
 }
 
+var themeRollbackCmd = &cobra.Command{
+	Use:   "rollback <theme_id>",
+	Short: "Restore the previous version of a theme",
+	Long: `Restores the version of a theme that was replaced by its last update or forced re-install.
+The previous version is verified against its SHA256 sums before it is restored, and the
+replaced version is kept in its place, so running rollback again undoes the rollback.`,
+	Example: `  panelbase themes rollback thm_J4yoW1B5kDzy`,
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		themeID := args[0]
+
+		if client := connectToServerForCLI(); client != nil {
+			defer client.Close()
+			reply, err := client.RollbackTheme(themeID)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error rolling back theme '%s': %v\n", themeID, err)
+				os.Exit(1)
+			}
+			fmt.Printf("Theme %s rolled back to '%s' version %s by the running server.\n", themeID, reply.Name, reply.Version)
+			return
+		}
+
+		appLogger, _, idGen := initBaseForCLI()
+		themeMgr, err := themes.NewThemeManager(appLogger, idGen, appPaths().ThemesDir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to initialize Theme Manager: %v\n", err)
+			os.Exit(1)
+		}
+		if _, err := themes.Rollback(themeMgr, themeID); err != nil {
+			fmt.Fprintf(os.Stderr, "Error rolling back theme '%s': %v\n", themeID, err)
+			os.Exit(1)
+		}
+		// The success message is logged by the themes package.
+	},
+}
+
+var themeVerifyCmd = &cobra.Command{
+	Use:   "verify [theme_id]",
+	Short: "Check installed theme files against their SHA256 sums",
+	Long: `Re-hashes the files of an installed theme (or of every installed theme when no ID is given)
+and compares them with the structure recorded in the theme's local theme.json. Modified,
+missing and extra files are reported. Exits with status 1 if any theme does not match.`,
+	Example: `  panelbase themes verify
+  panelbase themes verify thm_J4yoW1B5kDzy`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		appLogger, _, idGen := initBaseForCLI()
+		themeMgr, err := themes.NewThemeManager(appLogger, idGen, appPaths().ThemesDir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to initialize Theme Manager: %v\n", err)
+			os.Exit(1)
+		}
+
+		themeIDs := args
+		if len(themeIDs) == 0 {
+			themesState, err := configuration.LoadThemesState()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error loading themes state: %v\n", err)
+				os.Exit(1)
+			}
+			for id := range themesState {
+				themeIDs = append(themeIDs, id)
+			}
+			sort.Strings(themeIDs)
+			if len(themeIDs) == 0 {
+				fmt.Println("No themes installed.")
+				return
+			}
+		}
+
+		failed := false
+		for _, themeID := range themeIDs {
+			result, err := themes.Verify(themeMgr, themeID)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s: error: %v\n", themeID, err)
+				failed = true
+				continue
+			}
+			if result.OK() {
+				fmt.Printf("%s: %s (v%s) OK, %d files verified.\n", themeID, result.Name, result.Version, result.Checked)
+				continue
+			}
+			failed = true
+			fmt.Printf("%s: %s (v%s) FAILED: %s\n", themeID, result.Name, result.Version, result.Summary())
+			for _, m := range result.Modified {
+				fmt.Printf("  modified: %s (expected %s, got %s)\n", m.Path, m.Expected, m.Actual)
+			}
+			for _, p := range result.Missing {
+				fmt.Printf("  missing:  %s\n", p)
+			}
+			for _, p := range result.Extra {
+				fmt.Printf("  extra:    %s\n", p)
+			}
+		}
+		if failed {
+			os.Exit(1)
+		}
+	},
+}
+
 // --- Plugin Command ---
 var pluginCmd = &cobra.Command{
 	Use:   "plugins", // Changed from "plugin" to "plugins"
@@ -695,17 +1140,29 @@ or a local filesystem path to a 'plugin.yaml' file.`,
 		source := args[0]
 		force, _ := cmd.Flags().GetBool("force")
 
+		if client := connectToServerForCLI(); client != nil {
+			defer client.Close()
+			reply, err := client.InstallPlugin(sourceForServer(source), force)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error installing plugin: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("Plugin '%s' (version %s) installed by the running server.\n", reply.Name, reply.Version)
+			return
+		}
+
 		// Initialize dependencies (Logger, Config, IDGen)
 		appLogger, _, idGen := initBaseForCLI() // Use helper, ignore cfg for now
 
 		// Initialize Plugin Manager
 		// Plugin Manager initialization requires RPC address, handled in startPanelBaseServer
-		pluginMgr, err := plugins.NewPluginManager(appLogger, idGen)
+		pluginMgr, err := plugins.NewPluginManager(appLogger, idGen, appPaths().PluginsDir)
 		if err != nil {
 			appLogger.Logf("Failed to initialize Plugin Manager for install: %v", err)
 			fmt.Fprintf(os.Stderr, "Failed to initialize Plugin Manager: %v\n", err)
 			os.Exit(1)
 		}
+		pluginMgr.SetDownloader(newCachingDownloader(appLogger))
 
 		// Call the install method
 		_, err = pluginMgr.InstallPlugin(source, force)
@@ -732,7 +1189,7 @@ var pluginListCmd = &cobra.Command{
 		// Assuming for CLI list, we might need a simplified NewPluginManager or pass a dummy/default.
 		// For now, let's assume NewPluginManager can handle a potentially empty rpcAddr for CLI list.
 		// This was simplified in a previous step for the CLI context.
-		pluginMgr, err := plugins.NewPluginManager(appLogger, idGen) // Simplified for CLI
+		pluginMgr, err := plugins.NewPluginManager(appLogger, idGen, appPaths().PluginsDir) // Simplified for CLI
 		if err != nil {
 			// appLogger.Logf("Failed to initialize Plugin Manager for list: %v", err) // No internal logging for CLI list
 			fmt.Fprintf(os.Stderr, "Failed to initialize Plugin Manager: %v\n", err)
@@ -826,15 +1283,32 @@ This involves deleting the plugin's directory and updating the state file.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		pluginID := args[0]
 
+		if client := connectToServerForCLI(); client != nil {
+			defer client.Close()
+			if err := client.RemovePlugin(pluginID); err != nil {
+				fmt.Fprintf(os.Stderr, "Error removing plugin %s: %v\n", pluginID, err)
+				os.Exit(1)
+			}
+			fmt.Printf("Plugin %s removed by the running server.\n", pluginID)
+			return
+		}
+
 		appLogger, _, idGen := initBaseForCLI() // Use helper
 
 		// Initialize Plugin Manager
-		pluginMgr, err := plugins.NewPluginManager(appLogger, idGen)
+		pluginMgr, err := plugins.NewPluginManager(appLogger, idGen, appPaths().PluginsDir)
 		if err != nil {
 			appLogger.Logf("Failed to initialize Plugin Manager for remove: %v", err)
 			fmt.Fprintf(os.Stderr, "Failed to initialize Plugin Manager: %v\n", err)
 			os.Exit(1)
 		}
+		// Attach the kv store so the plugin's stored data is removed as well
+		kvStore, err := kvstore.NewStore(filepath.Join(appPaths().DataDir, "kv"))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to open kv store: %v\n", err)
+			os.Exit(1)
+		}
+		pluginMgr.SetKVStore(kvStore)
 
 		// Call the remove method
 		err = pluginMgr.RemovePlugin(pluginID)
@@ -857,13 +1331,26 @@ If the plugin's source link is not available or the update process fails, the or
 	Args:    cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		pluginID := args[0]
+
+		if client := connectToServerForCLI(); client != nil {
+			defer client.Close()
+			reply, err := client.UpdatePlugin(pluginID)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error updating plugin %s: %v\n", pluginID, err)
+				os.Exit(1)
+			}
+			printUpdateReply("Plugin", pluginID, reply)
+			return
+		}
+
 		appLogger, _, idGen := initBaseForCLI()
 
-		pluginMgr, err := plugins.NewPluginManager(appLogger, idGen)
+		pluginMgr, err := plugins.NewPluginManager(appLogger, idGen, appPaths().PluginsDir)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Failed to initialize Plugin Manager: %v\n", err)
 			os.Exit(1)
 		}
+		pluginMgr.SetDownloader(newCachingDownloader(appLogger))
 
 		_, err = pluginMgr.UpdatePlugin(pluginID) // Correctly assign two return values
 		if err != nil {
@@ -902,9 +1389,20 @@ or a local filesystem path to a 'command.yaml' file. The associated script will
 		source := args[0]
 		force, _ := cmd.Flags().GetBool("force")
 
+		if client := connectToServerForCLI(); client != nil {
+			defer client.Close()
+			reply, err := client.InstallCommand(sourceForServer(source), force)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error installing command: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("Command '%s' (version %s) installed by the running server.\n", reply.Name, reply.Version)
+			return
+		}
+
 		appLogger, _, idGen := initBaseForCLI()
 
-		commandMgr, err := commands.NewCommandManager(appLogger, idGen)
+		commandMgr, err := commands.NewCommandManager(appLogger, idGen, appPaths().CommandsDir)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Failed to initialize Command Manager: %v\n", err)
 			os.Exit(1)
@@ -928,7 +1426,7 @@ var commandListCmd = &cobra.Command{
 		appLogger, _, idGen := initBaseForCLI() // Use helper, appLogger for manager init
 
 		// Initialize Command Manager
-		commandMgr, err := commands.NewCommandManager(appLogger, idGen)
+		commandMgr, err := commands.NewCommandManager(appLogger, idGen, appPaths().CommandsDir)
 		if err != nil {
 			// appLogger.Logf("Failed to initialize Command Manager for list: %v", err) // No internal logging
 			fmt.Fprintf(os.Stderr, "Failed to initialize Command Manager: %v\n", err)
@@ -1037,10 +1535,20 @@ This involves deleting the script file and updating the state file.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		commandID := args[0]
 
+		if client := connectToServerForCLI(); client != nil {
+			defer client.Close()
+			if err := client.RemoveCommand(commandID); err != nil {
+				fmt.Fprintf(os.Stderr, "Error removing command %s: %v\n", commandID, err)
+				os.Exit(1)
+			}
+			fmt.Printf("Command %s removed by the running server.\n", commandID)
+			return
+		}
+
 		appLogger, _, idGen := initBaseForCLI() // Use helper
 
 		// Initialize Command Manager
-		commandMgr, err := commands.NewCommandManager(appLogger, idGen)
+		commandMgr, err := commands.NewCommandManager(appLogger, idGen, appPaths().CommandsDir)
 		if err != nil {
 			appLogger.Logf("Failed to initialize Command Manager for remove: %v", err)
 			fmt.Fprintf(os.Stderr, "Failed to initialize Command Manager: %v\n", err)
@@ -1068,9 +1576,21 @@ If the command's source link is not available or the update process fails, the o
 	Args:    cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		commandID := args[0]
+
+		if client := connectToServerForCLI(); client != nil {
+			defer client.Close()
+			reply, err := client.UpdateCommand(commandID)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error updating command %s: %v\n", commandID, err)
+				os.Exit(1)
+			}
+			printUpdateReply("Command", commandID, reply)
+			return
+		}
+
 		appLogger, _, idGen := initBaseForCLI()
 
-		commandMgr, err := commands.NewCommandManager(appLogger, idGen)
+		commandMgr, err := commands.NewCommandManager(appLogger, idGen, appPaths().CommandsDir)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Failed to initialize Command Manager: %v\n", err)
 			os.Exit(1)
@@ -1085,12 +1605,63 @@ If the command's source link is not available or the update process fails, the o
 	},
 }
 
+var commandRunCmd = &cobra.Command{
+	Use:   "run <command_name> [args...]",
+	Short: "Run an installed command script",
+	Long: `Runs an installed command script with sh. The script receives the global secrets and,
+with --container, the container's secrets as environment variables (container secrets win),
+plus PANELBASE_CONTAINER_ID. If the server is running, the command runs in the server process.
+The exit code of the script becomes the exit code of this command.`,
+	Example: `  panelbase commands run backup --container ctr_abc123 -- --full`,
+	Args:    cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		name, commandArgs := args[0], args[1:]
+		containerID, _ := cmd.Flags().GetString("container")
+		if containerID != "" {
+			if err := secrets.ValidateScope(containerID); err != nil || containerID == secrets.GlobalScope {
+				fmt.Fprintf(os.Stderr, "Error: invalid container ID '%s'\n", containerID)
+				os.Exit(1)
+			}
+		}
+
+		if client := connectToServerForCLI(); client != nil {
+			reply, err := client.RunCommand(name, containerID, commandArgs)
+			client.Close() // os.Exit below skips deferred calls
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error running command %s: %v\n", name, err)
+				os.Exit(1)
+			}
+			os.Stdout.Write(reply.Stdout)
+			os.Stderr.Write(reply.Stderr)
+			os.Exit(reply.ExitCode)
+		}
+
+		appLogger, _, idGen := initBaseForCLI()
+		commandMgr, err := commands.NewCommandManager(appLogger, idGen, appPaths().CommandsDir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to initialize Command Manager: %v\n", err)
+			os.Exit(1)
+		}
+		secretStore := openSecretsForCLI()
+		commandMgr.SetSecretsProvider(secretStore.Environment)
+
+		exitCode, err := commandMgr.RunCommand(name, containerID, commandArgs, os.Stdout, os.Stderr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error running command %s: %v\n", name, err)
+			os.Exit(1)
+		}
+		os.Exit(exitCode)
+	},
+}
+
 func init() {
 	commandCmd.AddCommand(commandInstallCmd)
 	commandCmd.AddCommand(commandListCmd)
 	commandCmd.AddCommand(commandRemoveCmd)
 	commandCmd.AddCommand(commandUpdateCmd)
+	commandCmd.AddCommand(commandRunCmd)
 	commandInstallCmd.Flags().BoolP("force", "f", false, "Force overwrite if command script already exists")
+	commandRunCmd.Flags().String("container", "", "Container whose secrets are injected (in addition to global secrets)")
 }
 
 // --- Container Command ---
@@ -1110,17 +1681,27 @@ PanelBase will manage the server process.`,
 	Args:    cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		containerID := args[0]
-		appLogger, containerMgr := initForContainerCLI()
 
-		appLogger.Logf("Attempting to start web server for container: %s", containerID)
-		err := containerMgr.StartWebServer(containerID)
-		if err != nil {
+		// With a server running, the web server must live in the server process.
+		if client := connectToServerForCLI(); client != nil {
+			defer client.Close()
+			if err := client.StartContainer(containerID); err != nil {
+				fmt.Fprintf(os.Stderr, "Error starting container %s: %v\n", containerID, err)
+				os.Exit(1)
+			}
+			fmt.Printf("Successfully started container %s.\n", containerID)
+			return
+		}
+
+		// Without a server a web server would exit with this process, so only the persisted
+		// status is changed; the server starts the container on its next launch.
+		appLogger, containerMgr := initForContainerCLI()
+		if err := containerMgr.SetPersistedStatus(containerID, container.StatusRunning); err != nil {
 			appLogger.Logf("Error starting container %s: %v", containerID, err)
 			fmt.Fprintf(os.Stderr, "Error starting container %s: %v\n", containerID, err)
 			os.Exit(1)
 		}
-		// Note: StartWebServer logs success internally.
-		fmt.Printf("Successfully started container %s.\n", containerID)
+		fmt.Printf("Container %s marked as running. No PanelBase server is running; it will be served once 'panelbase server start' is run.\n", containerID)
 	},
 }
 
@@ -1132,37 +1713,197 @@ var containerStopCmd = &cobra.Command{
 	Args:    cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		containerID := args[0]
-		appLogger, containerMgr := initForContainerCLI()
 
-		appLogger.Logf("Attempting to stop web server for container: %s", containerID)
-		err := containerMgr.StopWebServer(containerID)
-		if err != nil {
+		if client := connectToServerForCLI(); client != nil {
+			defer client.Close()
+			if err := client.StopContainer(containerID); err != nil {
+				fmt.Fprintf(os.Stderr, "Error stopping container %s: %v\n", containerID, err)
+				os.Exit(1)
+			}
+			fmt.Printf("Successfully stopped container %s.\n", containerID)
+			return
+		}
+
+		// Nothing is served without a server; clearing the persisted status keeps the server from
+		// starting the container on its next launch.
+		appLogger, containerMgr := initForContainerCLI()
+		if err := containerMgr.SetPersistedStatus(containerID, container.StatusStopped); err != nil {
 			appLogger.Logf("Error stopping container %s: %v", containerID, err)
 			fmt.Fprintf(os.Stderr, "Error stopping container %s: %v\n", containerID, err)
 			os.Exit(1)
 		}
-		// Note: StopWebServer logs success internally.
-		fmt.Printf("Successfully stopped container %s.\n", containerID)
+		fmt.Printf("Container %s marked as stopped. No PanelBase server is running; it will not be served when the server starts.\n", containerID)
 	},
 }
 
-var containerListCmd = &cobra.Command{
-	Use:     "list",
-	Short:   "List all managed containers and their status",
-	Long:    `Displays a list of all containers discovered by PanelBase, along with their current status, port, and web directory.`,
-	Example: `  panelbase container list`,
-	Args:    cobra.NoArgs,
+var containerApplyThemeCmd = &cobra.Command{
+	Use:   "apply-theme <container_id> [theme_id]",
+	Short: "Serve an installed theme from a container",
+	Long: `Serves the files of an installed theme beneath the container's own web directory.
+If the theme declares a parent (by source link or thm_id), the parent's files are served
+wherever the child does not override them. Files in the container's web directory always win.
+Settings declared by the theme are added to the container's ui_settings.json with their
+defaults; existing values are kept. Running containers pick up the change with their next
+request. Use --none to remove the theme.`,
+	Example: `  panelbase containers apply-theme ctr_abc123 thm_def456
+  panelbase containers apply-theme ctr_abc123 --none`,
+	Args: cobra.RangeArgs(1, 2),
 	Run: func(cmd *cobra.Command, args []string) {
-		_, containerMgr := initForContainerCLI() // appLogger no longer needed for direct logging here
-
-		// appLogger.Log("Listing containers...") // Removed internal log
-		ids := containerMgr.ListContainers()
-		if len(ids) == 0 {
-			fmt.Println("No containers found.")
+		containerID := args[0]
+		none, _ := cmd.Flags().GetBool("none")
+		themeID := ""
+		if len(args) == 2 {
+			themeID = args[1]
+		}
+		if (themeID == "") == !none {
+			fmt.Fprintln(os.Stderr, "Error: specify either a theme ID or --none")
+			os.Exit(1)
+		}
+
+		if client := connectToServerForCLI(); client != nil {
+			defer client.Close()
+			if err := client.ApplyContainerTheme(containerID, themeID); err != nil {
+				fmt.Fprintf(os.Stderr, "Error applying theme to container %s: %v\n", containerID, err)
+				os.Exit(1)
+			}
+		} else {
+			containerMgr := initThemedContainersForCLI()
+			if err := containerMgr.ApplyTheme(containerID, themeID); err != nil {
+				fmt.Fprintf(os.Stderr, "Error applying theme to container %s: %v\n", containerID, err)
+				os.Exit(1)
+			}
+		}
+		if themeID == "" {
+			fmt.Printf("Removed the theme of container %s.\n", containerID)
+		} else {
+			fmt.Printf("Applied theme %s to container %s.\n", themeID, containerID)
+		}
+	},
+}
+
+var containerSettingsCmd = &cobra.Command{
+	Use:   "settings",
+	Short: "Show and change the UI settings of a container",
+	Long: `UI settings are stored in the container's ui_settings.json and passed to its templates.
+The applied theme declares which settings exist, their types and defaults.`,
+}
+
+var containerSettingsShowCmd = &cobra.Command{
+	Use:     "show <container_id>",
+	Short:   "Show the UI settings of a container",
+	Example: `  panelbase containers settings show ctr_abc123`,
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		containerMgr := initThemedContainersForCLI()
+		settings, schema, err := containerMgr.UISettings(args[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading settings of container %s: %v\n", args[0], err)
+			os.Exit(1)
+		}
+		var keys []string
+		if schema != nil {
+			keys = schema.Keys()
+		}
+		declared := make(map[string]bool, len(keys))
+		for _, key := range keys {
+			declared[key] = true
+		}
+		var undeclared []string
+		for key := range settings {
+			if !declared[key] {
+				undeclared = append(undeclared, key)
+			}
+		}
+		sort.Strings(undeclared)
+		if len(keys)+len(undeclared) == 0 {
+			fmt.Println("No UI settings.")
 			return
 		}
 
-		headers := []string{"ID", "NAME (WEBDIR)", "STATUS", "PORT", "LAST ERROR"}
+		var rows [][]string
+		for _, key := range keys {
+			valueStr := "(unset)"
+			if value, ok := settings[key]; ok {
+				valueStr = fmt.Sprintf("%v", value)
+			}
+			rows = append(rows, []string{key, valueStr, schema.Describe(key)})
+		}
+		for _, key := range undeclared {
+			rows = append(rows, []string{key, fmt.Sprintf("%v", settings[key]), "not declared by the theme"})
+		}
+		printTable([]string{"KEY", "VALUE", "DECLARATION"}, rows)
+	},
+}
+
+var containerSettingsSetCmd = &cobra.Command{
+	Use:   "set <container_id> <key=value>...",
+	Short: "Change UI settings of a container",
+	Long: `Validates each value against the settings schema of the container's applied theme and
+stores it in ui_settings.json. Nothing is stored if any value is invalid.`,
+	Example: `  panelbase containers settings set ctr_abc123 accent_color=#ff6600 show_footer=false`,
+	Args:    cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		containerID := args[0]
+		values := make(map[string]string, len(args)-1)
+		for _, assignment := range args[1:] {
+			key, value, ok := strings.Cut(assignment, "=")
+			if !ok || key == "" {
+				fmt.Fprintf(os.Stderr, "Error: invalid assignment '%s', expected key=value\n", assignment)
+				os.Exit(1)
+			}
+			values[key] = value
+		}
+
+		if client := connectToServerForCLI(); client != nil {
+			defer client.Close()
+			if err := client.SetContainerSettings(containerID, values); err != nil {
+				fmt.Fprintf(os.Stderr, "Error changing settings of container %s: %v\n", containerID, err)
+				os.Exit(1)
+			}
+		} else if err := initThemedContainersForCLI().SetUISettings(containerID, values); err != nil {
+			fmt.Fprintf(os.Stderr, "Error changing settings of container %s: %v\n", containerID, err)
+			os.Exit(1)
+		}
+		fmt.Printf("Updated %d setting(s) of container %s.\n", len(values), containerID)
+	},
+}
+
+var containerListCmd = &cobra.Command{
+	Use:     "list",
+	Short:   "List all managed containers and their status",
+	Long:    `Displays a list of all containers discovered by PanelBase, along with their current status, port, and web directory.`,
+	Example: `  panelbase container list`,
+	Args:    cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		// Prefer the server's view, since it knows which web servers are actually running.
+		var infos []container.ContainerInfo
+		if client := connectToServerForCLI(); client != nil {
+			var err error
+			infos, err = client.ListContainers()
+			client.Close()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error listing containers: %v\n", err)
+				os.Exit(1)
+			}
+		} else {
+			_, containerMgr := initForContainerCLI() // appLogger no longer needed for direct logging here
+			ids := containerMgr.ListContainers()
+			sort.Strings(ids)
+			for _, id := range ids {
+				if info, exists := containerMgr.GetContainerInfo(id); exists {
+					infos = append(infos, *info)
+				} else {
+					fmt.Fprintf(os.Stderr, "Error: Container ID %s listed but details not found.\n", id)
+					infos = append(infos, container.ContainerInfo{ID: id, Status: container.StatusError, LastError: "Details not found"})
+				}
+			}
+		}
+		if len(infos) == 0 {
+			fmt.Println("No containers found.")
+			return
+		}
+
+		headers := []string{"ID", "NAME (WEBDIR)", "STATUS", "PORT", "THEME", "LAST ERROR"}
 		columnWidths := make([]int, len(headers)) // Correctly initialize columnWidths
 
 		// Set initial widths based on headers, NAME (WEBDIR) column fixed to 15
@@ -1170,7 +1911,8 @@ var containerListCmd = &cobra.Command{
 		columnWidths[1] = 15                                // NAME (WEBDIR) (fixed)
 		columnWidths[2] = calculateDisplayWidth(headers[2]) // STATUS
 		columnWidths[3] = calculateDisplayWidth(headers[3]) // PORT
-		columnWidths[4] = calculateDisplayWidth(headers[4]) // LAST ERROR
+		columnWidths[4] = calculateDisplayWidth(headers[4]) // THEME
+		columnWidths[5] = calculateDisplayWidth(headers[5]) // LAST ERROR
 
 		if headerNameWidth := calculateDisplayWidth(headers[1]); headerNameWidth > columnWidths[1] {
 			columnWidths[1] = headerNameWidth
@@ -1181,25 +1923,26 @@ var containerListCmd = &cobra.Command{
 			WebDir    string // This will be the truncated name
 			Status    string
 			Port      string
+			Theme     string
 			LastError string
 		}
 		var rows []containerRow
 
-		for _, id := range ids {
-			info, exists := containerMgr.GetContainerInfo(id)
-			var row containerRow
-			if exists {
-				lastErrStr := info.LastError
-				if lastErrStr == "" {
-					lastErrStr = "N/A"
-				}
-				displayWebDir := truncateStringToDisplayWidth(info.WebDir, 15)
-				row = containerRow{info.ID, displayWebDir, string(info.Status), fmt.Sprintf("%d", info.Port), lastErrStr}
-			} else {
-				fmt.Fprintf(os.Stderr, "Error: Container ID %s listed but details not found.\n", id)
-				// For error case, WebDir is N/A, which is short, no truncation needed.
-				row = containerRow{id, "N/A", "Error", "N/A", "Details not found"}
+		for _, info := range infos {
+			lastErrStr := info.LastError
+			if lastErrStr == "" {
+				lastErrStr = "N/A"
+			}
+			webDir, port := "N/A", "N/A" // Placeholders for containers whose details were not found
+			if info.WebDir != "" {
+				webDir = truncateStringToDisplayWidth(info.WebDir, 15)
+				port = fmt.Sprintf("%d", info.Port)
 			}
+			theme := info.Theme
+			if theme == "" {
+				theme = "N/A"
+			}
+			row := containerRow{info.ID, webDir, string(info.Status), port, theme, lastErrStr}
 			rows = append(rows, row)
 
 			if idWidth := calculateDisplayWidth(row.ID); idWidth > columnWidths[0] {
@@ -1215,8 +1958,11 @@ var containerListCmd = &cobra.Command{
 			if portWidth := calculateDisplayWidth(row.Port); portWidth > columnWidths[3] {
 				columnWidths[3] = portWidth
 			}
-			if lastErrorWidth := calculateDisplayWidth(row.LastError); lastErrorWidth > columnWidths[4] {
-				columnWidths[4] = lastErrorWidth
+			if themeWidth := calculateDisplayWidth(row.Theme); themeWidth > columnWidths[4] {
+				columnWidths[4] = themeWidth
+			}
+			if lastErrorWidth := calculateDisplayWidth(row.LastError); lastErrorWidth > columnWidths[5] {
+				columnWidths[5] = lastErrorWidth
 			}
 		}
 
@@ -1235,7 +1981,7 @@ var containerListCmd = &cobra.Command{
 
 		// Print data rows
 		for _, row := range rows {
-			cells := []string{row.ID, row.WebDir, row.Status, row.Port, row.LastError}
+			cells := []string{row.ID, row.WebDir, row.Status, row.Port, row.Theme, row.LastError}
 			for i, cell := range cells {
 				fmt.Print(cell)
 				padding := columnWidths[i] - calculateDisplayWidth(cell)
@@ -1255,21 +2001,486 @@ func init() {
 	containerCmd.AddCommand(containerStartCmd)
 	containerCmd.AddCommand(containerStopCmd)
 	containerCmd.AddCommand(containerListCmd)
+	containerCmd.AddCommand(containerApplyThemeCmd)
+	containerApplyThemeCmd.Flags().Bool("none", false, "Remove the applied theme")
+	containerCmd.AddCommand(containerSettingsCmd)
+	containerSettingsCmd.AddCommand(containerSettingsShowCmd)
+	containerSettingsCmd.AddCommand(containerSettingsSetCmd)
 	// Add flags to container commands if needed later (e.g., --port for create)
 }
 
+// --- Config Command ---
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect, edit and validate the PanelBase configuration",
+	Long:  `Commands for inspecting, editing and validating the configuration file and the values PanelBase actually uses.`,
+}
+
+var configShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Print the configuration",
+	Long: `Prints the configuration file. With --effective, prints every key after applying
+defaults, the configuration file, ` + configuration.EnvPrefix + `* environment variables and --set flags
+(in increasing precedence), together with the source of each value.`,
+	Example: `  panelbase config show
+  PANELBASE_SERVER_PORT=8080 panelbase config show --effective --set logging.plugins.default_level=debug`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		effective, _ := cmd.Flags().GetBool("effective")
+		cfg, err := loadAppConfig()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
+			os.Exit(1)
+		}
+		if !effective {
+			path := configFilePath()
+			data, err := os.ReadFile(path)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to read config file: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("# %s\n%s", path, data)
+			return
+		}
+
+		var rows [][]string
+		for _, value := range configuration.EffectiveValues(cfg) {
+			rows = append(rows, []string{value.Key, value.Value, value.Source})
+		}
+		printTable([]string{"KEY", "VALUE", "SOURCE"}, rows)
+		if len(cfg.Warnings) > 0 {
+			fmt.Println("\nWarnings:")
+			for _, warning := range cfg.Warnings {
+				fmt.Printf("  %s\n", warning)
+			}
+		}
+	},
+}
+
+var configValidateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Check the configuration for invalid, unknown and deprecated keys",
+	Long: `Checks the configuration file, together with any ` + configuration.EnvPrefix + `* environment variables and
+--set flags, and reports every invalid, unknown or deprecated key with its line number.
+The file is not modified. Exits with status 1 if any error is found.
+
+With 'strict: true' in the file (or --set strict=true), settings that would otherwise be
+replaced by defaults (such as a missing server.port) are errors, and the server refuses
+to start while any error remains.`,
+	Example: `  panelbase config validate
+  panelbase config validate --config /etc/panelbase/config.yaml --set strict=true`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		path := configFilePath()
+		issues, err := configuration.ValidateConfigFile(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error validating configuration: %v\n", err)
+			os.Exit(1)
+		}
+		errorCount := 0
+		for _, issue := range issues {
+			if issue.Severity == configuration.SeverityError {
+				errorCount++
+			}
+			fmt.Printf("%s: %s\n", issue.Severity, issue)
+		}
+		if errorCount > 0 {
+			fmt.Fprintf(os.Stderr, "Configuration '%s' has %d error(s).\n", path, errorCount)
+			os.Exit(1)
+		}
+		fmt.Printf("Configuration '%s' is valid.\n", path)
+	},
+}
+
+var configGetCmd = &cobra.Command{
+	Use:   "get <key>",
+	Short: "Print the value of a config key from the config file",
+	Long: `Prints the value of a dotted config key (e.g. server.port) as written in the config file.
+Sections (e.g. server) are printed as YAML. For keys not present in the file, the value in
+effect is printed and a note is written to stderr.`,
+	Example: `  panelbase config get server.port
+  panelbase config get logging.plugins`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		key := args[0]
+		path, err := prepareConfigFileForEdit()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		value, found, err := configuration.GetConfigValue(path, key)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if !found {
+			// Only the value in effect needs the whole config, so a broken file does not stop 'get'.
+			cfg, err := loadAppConfig()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "'%s' is not set in the config file, and the value in effect is unknown: %v\n", key, err)
+				os.Exit(1)
+			}
+			for _, effective := range configuration.EffectiveValues(cfg) {
+				if effective.Key == key {
+					fmt.Fprintf(os.Stderr, "'%s' is not set in the config file; value in effect (%s):\n", key, effective.Source)
+					fmt.Println(effective.Value)
+					return
+				}
+			}
+			fmt.Fprintf(os.Stderr, "'%s' is not set in the config file.\n", key)
+			os.Exit(1)
+		}
+		fmt.Println(value)
+	},
+}
+
+var configSetCmd = &cobra.Command{
+	Use:   "set <key> <value>",
+	Short: "Set a config key in the config file",
+	Long: `Sets a dotted config key in the config file, keeping its comments and key order.
+The value is checked against the key's type and validated before the file is written
+atomically. Map keys take "key=value,key=value"; single map entries can be set with
+their full key (e.g. logging.plugins.levels.plg_abc123 debug).
+
+A running server picks up the change automatically; keys that need a restart are reported.`,
+	Example: `  panelbase config set server.port 40082
+  panelbase config set logging.plugins.default_level debug`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		key, value := args[0], args[1]
+		path, err := prepareConfigFileForEdit()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if err := configuration.SetConfigValue(path, key, value); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Set %s = %s in '%s'.\n", key, value, path)
+		if (configuration.Change{Key: key}).NeedsRestart() {
+			fmt.Println("This key takes effect after the server is restarted.")
+		}
+
+		// The edit itself is valid; report what else still needs fixing in the file.
+		issues, err := configuration.ValidateConfigFile(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error validating configuration: %v\n", err)
+			os.Exit(1)
+		}
+		if configuration.HasErrors(issues) {
+			fmt.Fprintf(os.Stderr, "Warning: '%s' still has errors (run 'panelbase config validate' for details):\n", path)
+			for _, issue := range issues {
+				if issue.Severity == configuration.SeverityError {
+					fmt.Fprintf(os.Stderr, "  %s\n", issue)
+				}
+			}
+		}
+	},
+}
+
+// prepareConfigFileForEdit returns the config file path for 'config get' and 'config set'.
+// A missing default file is created and an older file is migrated, but the config is not
+// loaded: strict mode or a type error must not stop the commands used to repair the file.
+func prepareConfigFileForEdit() (string, error) {
+	path := configFilePath()
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		if _, err := loadAppConfig(); err != nil { // Creates the default file (or reports the missing --config file)
+			return "", fmt.Errorf("failed to load configuration: %w", err)
+		}
+		return path, nil
+	}
+	report, err := configuration.MigrateConfigFile(path)
+	if err != nil {
+		return "", err
+	}
+	if report.Changed() {
+		configuration.PrintMigrationReport(report)
+	}
+	return path, nil
+}
+
+func init() {
+	configCmd.AddCommand(configShowCmd)
+	configCmd.AddCommand(configValidateCmd)
+	configCmd.AddCommand(configGetCmd)
+	configCmd.AddCommand(configSetCmd)
+	configShowCmd.Flags().Bool("effective", false, "Print the merged configuration with the source of each value")
+}
+
+// --- Secrets Command ---
+var secretsCmd = &cobra.Command{
+	Use:   "secrets",
+	Short: "Manage encrypted secrets",
+	Long: `Commands for managing secrets stored encrypted (AES-256-GCM) in the state directory.
+Secrets are global or scoped to a container, and are injected into the environment of
+command scripts. Plugins can read a secret over RPC only if it was set with --allow-plugin.
+
+The key is read from PANELBASE_SECRETS_KEY (base64, 32 bytes), else from the file named by
+PANELBASE_SECRETS_KEY_FILE, else from secrets.key in the state directory (created if missing).
+The default secrets.key sits next to secrets.json, so anyone who can read the state directory
+can decrypt the secrets; it only protects copies of secrets.json made without it. For
+encryption at rest, keep the key outside the state directory with one of the variables.
+
+Names that control how commands run (PATH, HOME, IFS, LD_*, LC_*, PANELBASE_*, ...) are rejected.`,
+}
+
+// secretScopeFlag returns the scope selected by --container (global when unset).
+func secretScopeFlag(cmd *cobra.Command) string {
+	containerID, _ := cmd.Flags().GetString("container")
+	if containerID == "" {
+		return secrets.GlobalScope
+	}
+	return containerID
+}
+
+// openSecretsForCLI opens the secrets store in the configured state directory. Exits on error.
+func openSecretsForCLI() *secrets.Store {
+	store, err := secrets.Open(appPaths().StateDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open secrets store: %v\n", err)
+		os.Exit(1)
+	}
+	return store
+}
+
+var secretsSetCmd = &cobra.Command{
+	Use:   "set <name> [value]",
+	Short: "Set a secret",
+	Long: `Sets a secret. The name must be a valid environment variable name. If value is omitted
+it is read from standard input (a single trailing newline is removed), which keeps it out
+of the shell history. Setting a secret replaces its value and its plugin grants.`,
+	Example: `  panelbase secrets set API_TOKEN s3cr3t
+  panelbase secrets set DB_PASSWORD --container ctr_abc123 --allow-plugin plg_abc123 < password.txt`,
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		name, scope := args[0], secretScopeFlag(cmd)
+		allowedPlugins, _ := cmd.Flags().GetStringSlice("allow-plugin")
+
+		var value []byte
+		if len(args) == 2 {
+			value = []byte(args[1])
+		} else {
+			data, err := io.ReadAll(io.LimitReader(os.Stdin, secrets.MaxValueBytes+1))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error reading secret value from stdin: %v\n", err)
+				os.Exit(1)
+			}
+			value = []byte(strings.TrimSuffix(strings.TrimSuffix(string(data), "\n"), "\r"))
+		}
+
+		if err := openSecretsForCLI().Set(scope, name, value, allowedPlugins); err != nil {
+			fmt.Fprintf(os.Stderr, "Error setting secret %s: %v\n", name, err)
+			os.Exit(1)
+		}
+		fmt.Printf("Secret %s set (scope: %s).\n", name, scope)
+	},
+}
+
+var secretsGetCmd = &cobra.Command{
+	Use:     "get <name>",
+	Short:   "Print the value of a secret",
+	Example: `  panelbase secrets get API_TOKEN --container ctr_abc123`,
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		name, scope := args[0], secretScopeFlag(cmd)
+		value, err := openSecretsForCLI().Get(scope, name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(string(value))
+	},
+}
+
+var secretsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List secrets (without values)",
+	Long:  `Lists the secrets of every scope, or only of one container with --container.`,
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		scope, _ := cmd.Flags().GetString("container")
+		infos, err := openSecretsForCLI().List(scope)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error listing secrets: %v\n", err)
+			os.Exit(1)
+		}
+		if len(infos) == 0 {
+			fmt.Println("No secrets stored.")
+			return
+		}
+		rows := make([][]string, 0, len(infos))
+		for _, info := range infos {
+			plugins := strings.Join(info.AllowedPlugins, ",")
+			if plugins == "" {
+				plugins = "-"
+			}
+			rows = append(rows, []string{info.Scope, info.Name, plugins, info.UpdatedAt.Local().Format("2006-01-02 15:04:05")})
+		}
+		printTable([]string{"SCOPE", "NAME", "PLUGINS", "UPDATED"}, rows)
+	},
+}
+
+var secretsRemoveCmd = &cobra.Command{
+	Use:     "rm <name>",
+	Aliases: []string{"remove"},
+	Short:   "Remove a secret",
+	Example: `  panelbase secrets rm API_TOKEN --container ctr_abc123`,
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		name, scope := args[0], secretScopeFlag(cmd)
+		existed, err := openSecretsForCLI().Delete(scope, name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error removing secret %s: %v\n", name, err)
+			os.Exit(1)
+		}
+		if !existed {
+			fmt.Fprintf(os.Stderr, "Secret %s not found (scope: %s).\n", name, scope)
+			os.Exit(1)
+		}
+		fmt.Printf("Secret %s removed (scope: %s).\n", name, scope)
+	},
+}
+
+func init() {
+	secretsCmd.AddCommand(secretsSetCmd)
+	secretsCmd.AddCommand(secretsGetCmd)
+	secretsCmd.AddCommand(secretsListCmd)
+	secretsCmd.AddCommand(secretsRemoveCmd)
+	for _, c := range []*cobra.Command{secretsSetCmd, secretsGetCmd, secretsListCmd, secretsRemoveCmd} {
+		c.Flags().String("container", "", "Container ID the secret belongs to (global when omitted)")
+	}
+	secretsSetCmd.Flags().StringSlice("allow-plugin", nil, "Plugin ID allowed to read the secret over RPC (repeatable)")
+}
+
+// --- Cache Command ---
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Inspect and prune the download cache",
+	Long: `Commands for the download cache in paths.cache_dir. Theme assets are kept under their SHA256
+sum in blobs/, so re-installs and updates only download files whose sum changed, and a theme
+can be re-installed offline from a local theme.yaml. Other downloads are kept in http/ and
+revalidated with the server before they are reused. This includes plugin files: plugin.yaml
+declares no checksums, so plugins never use blobs/ and cannot be re-installed offline.
+
+Everything in the cache can be deleted at any time; it is only a copy.`,
+}
+
+var cacheStatsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "Show the size of the download cache",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		appLogger, _, idGen := initBaseForCLI()
+		defer appLogger.Close()
+
+		blobs := openBlobStoreForCLI()
+		blobStats, err := blobs.Stats()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading blob cache: %v\n", err)
+			os.Exit(1)
+		}
+		httpEntries, httpBytes, err := downloader.CacheUsage(filepath.Join(appPaths().CacheDir, httpCacheDirName))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading HTTP cache: %v\n", err)
+			os.Exit(1)
+		}
+		referenced, err := installedThemeSums(appLogger, idGen)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading installed themes: %v\n", err)
+			os.Exit(1)
+		}
+		inUse := 0
+		for sum := range referenced {
+			if ok, _ := blobs.Has(sum); ok {
+				inUse++
+			}
+		}
+
+		fmt.Printf("Cache directory: %s\n", appPaths().CacheDir)
+		fmt.Printf("  blobs: %d files, %s (%d used by installed themes)\n", blobStats.Blobs, downloader.FormatBytes(blobStats.Bytes), inUse)
+		fmt.Printf("  http:  %d files, %s\n", httpEntries, downloader.FormatBytes(httpBytes))
+	},
+}
+
+var cachePruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Remove unused files from the download cache",
+	Long: `Removes blobs that no installed theme (or its rollback backup) refers to, and HTTP cache
+entries that were not used within --max-age. With --all the whole cache is emptied.`,
+	Example: `  panelbase cache prune
+  panelbase cache prune --max-age 168h
+  panelbase cache prune --all`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		all, _ := cmd.Flags().GetBool("all")
+		maxAge, _ := cmd.Flags().GetDuration("max-age")
+		appLogger, _, idGen := initBaseForCLI()
+		defer appLogger.Close()
+
+		var keep map[string]bool // nil removes every blob
+		if !all {
+			var err error
+			if keep, err = installedThemeSums(appLogger, idGen); err != nil {
+				fmt.Fprintf(os.Stderr, "Error reading installed themes: %v\n", err)
+				os.Exit(1)
+			}
+		} else {
+			maxAge = 0
+		}
+		removedBlobs, err := openBlobStoreForCLI().Prune(keep)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error pruning blob cache: %v\n", err)
+			os.Exit(1)
+		}
+		httpEntries, httpBytes, err := downloader.PruneCache(filepath.Join(appPaths().CacheDir, httpCacheDirName), maxAge)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error pruning HTTP cache: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Removed %d blobs (%s) and %d HTTP cache entries (%s).\n",
+			removedBlobs.Blobs, downloader.FormatBytes(removedBlobs.Bytes), httpEntries, downloader.FormatBytes(httpBytes))
+	},
+}
+
+// openBlobStoreForCLI opens the blob cache in paths.cache_dir or exits with an error.
+func openBlobStoreForCLI() *blobstore.Store {
+	blobs, err := blobstore.Open(filepath.Join(appPaths().CacheDir, blobCacheDirName))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening blob cache: %v\n", err)
+		os.Exit(1)
+	}
+	return blobs
+}
+
+// installedThemeSums returns the sums of all files of installed themes and their backups.
+func installedThemeSums(appLogger *logger.Logger, idGen *utils.IDGenerator) (map[string]bool, error) {
+	themeMgr, err := themes.NewThemeManager(appLogger, idGen, appPaths().ThemesDir)
+	if err != nil {
+		return nil, err
+	}
+	return themes.InstalledSums(themeMgr)
+}
+
+func init() {
+	cacheCmd.AddCommand(cacheStatsCmd)
+	cacheCmd.AddCommand(cachePruneCmd)
+	cachePruneCmd.Flags().Bool("all", false, "Remove everything, including blobs of installed themes")
+	cachePruneCmd.Flags().Duration("max-age", 30*24*time.Hour, "Remove HTTP cache entries not used for this long")
+}
+
 // initForContainerCLI initializes Logger, Config, IDGenerator, and ContainerManager
 // needed for container CLI commands. Exits on fatal initialization error.
 func initForContainerCLI() (*logger.Logger, *container.ContainerManager) {
 	// For CLI, initialize logger but rely on fmt for direct user output.
-	appLogger, err := logger.NewLogger() // Use standard initialization
+	appLogger, err := newAppLogger() // Use standard initialization
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to initialize logger for CLI: %v\n", err)
 		os.Exit(1)
 	}
 	// No defer close here, as the command finishes quickly.
 
-	cfg, err := configuration.LoadConfig()
+	cfg, err := loadAppConfig()
 	if err != nil {
 		appLogger.Logf("Failed to load configuration for container CLI: %v", err)
 		if cfg == nil { // Ensure cfg is checked for nil before accessing its members
@@ -1288,7 +2499,7 @@ func initForContainerCLI() (*logger.Logger, *container.ContainerManager) {
 	}
 
 	// Corrected NewContainerManager call
-	containerMgr, err := container.NewContainerManager(idGen, cfg.Server.Host, appLogger)
+	containerMgr, err := container.NewContainerManager(idGen, cfg.Server.Host, appLogger, appPaths().ContainersDir)
 	if err != nil {
 		appLogger.Logf("Failed to initialize Container Manager for CLI: %v", err)
 		fmt.Fprintf(os.Stderr, "Failed to initialize Container Manager: %v\n", err)
@@ -1297,16 +2508,47 @@ func initForContainerCLI() (*logger.Logger, *container.ContainerManager) {
 	return appLogger, containerMgr
 }
 
+// initThemedContainersForCLI initializes a Container Manager that resolves applied themes and
+// their settings through a Theme Manager. Exits on fatal initialization error.
+func initThemedContainersForCLI() *container.ContainerManager {
+	appLogger, cfg, idGen := initBaseForCLI()
+	containerMgr, err := container.NewContainerManager(idGen, cfg.Server.Host, appLogger, appPaths().ContainersDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to initialize Container Manager: %v\n", err)
+		os.Exit(1)
+	}
+	themeMgr, err := themes.NewThemeManager(appLogger, idGen, appPaths().ThemesDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to initialize Theme Manager: %v\n", err)
+		os.Exit(1)
+	}
+	setContainerThemeResolvers(containerMgr, themeMgr)
+	return containerMgr
+}
+
+// setContainerThemeResolvers lets containers serve applied themes with their parents and
+// validate UI settings against the themes' settings schemas.
+func setContainerThemeResolvers(containerMgr *container.ContainerManager, themeMgr *themes.ThemeManager) {
+	containerMgr.SetThemeResolver(func(id string) ([]string, error) { return themes.Layers(themeMgr, id) })
+	containerMgr.SetSettingsResolver(func(id string) (container.SettingsSchema, error) {
+		schema, err := themes.Settings(themeMgr, id)
+		if err != nil {
+			return nil, err
+		}
+		return schema, nil
+	})
+}
+
 // initBaseForCLI initializes Logger, Config, and IDGenerator.
 // It's a common utility for CLI commands that don't need the full server setup
 // but require these base components. Exits on fatal initialization error.
 func initBaseForCLI() (*logger.Logger, *configuration.Config, *utils.IDGenerator) {
-	appLogger, err := logger.NewLogger()
+	appLogger, err := newAppLogger()
 	if err != nil {
 		log.Fatalf("Failed to initialize logger for CLI: %v", err)
 	}
 
-	cfg, err := configuration.LoadConfig()
+	cfg, err := loadAppConfig()
 	if err != nil {
 		appLogger.Logf("Failed to load configuration for CLI: %v", err)
 		if cfg == nil {
@@ -1327,10 +2569,130 @@ func initBaseForCLI() (*logger.Logger, *configuration.Config, *utils.IDGenerator
 	return appLogger, cfg, idGen
 }
 
+// Subdirectories of paths.cache_dir: files keyed by their SHA256 sum, and responses keyed by URL
+// for conditional requests.
+const (
+	blobCacheDirName = "blobs"
+	httpCacheDirName = "http"
+)
+
+// newCachingDownloader creates the downloader for theme and plugin installs. Files with a known
+// sum are taken from <cache_dir>/blobs when present; other responses are cached under
+// <cache_dir>/http. It returns nil (the managers keep their uncached default) if the cache
+// directories cannot be created.
+func newCachingDownloader(appLogger *logger.Logger) *downloader.Downloader {
+	blobs, err := blobstore.Open(filepath.Join(appPaths().CacheDir, blobCacheDirName))
+	if err != nil {
+		appLogger.Logf("Warning: download cache disabled: %v", err)
+		return nil
+	}
+	dl, err := downloader.New(appLogger, downloader.Options{CacheDir: filepath.Join(appPaths().CacheDir, httpCacheDirName), Blobs: blobs})
+	if err != nil {
+		appLogger.Logf("Warning: download cache disabled: %v", err)
+		return nil
+	}
+	return dl
+}
+
+// configFilePath returns the config file in use: --config, or the default inside the PanelBase home.
+func configFilePath() string {
+	if cfgFile != "" {
+		return cfgFile
+	}
+	return configuration.DefaultConfigPath()
+}
+
+// loadAppConfig loads the configuration once per process and points the state store at
+// paths.state_dir, so every manager created afterwards shares the configured directories.
+func loadAppConfig() (*configuration.Config, error) {
+	if appConfigCache != nil {
+		return appConfigCache, nil
+	}
+	cfg, err := configuration.LoadConfig(cfgFile)
+	if err != nil {
+		return cfg, err
+	}
+	configuration.SetStateDir(cfg.Paths.StateDir)
+	appConfigCache = cfg
+	return cfg, nil
+}
+
+// newAppLogger creates the logger in paths.logs_dir and logs the notices collected while
+// loading the configuration (only the first logger of the process logs them). The first logger
+// also receives the state store's notices, such as migrated legacy state files.
+func newAppLogger() (*logger.Logger, error) {
+	cfg, err := loadAppConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load configuration: %w", err)
+	}
+	appLogger, err := logger.NewLogger(cfg.Paths.LogsDir)
+	if err != nil {
+		return nil, err
+	}
+	if !configWarningsLogged {
+		configWarningsLogged = true
+		for _, warning := range cfg.Warnings {
+			appLogger.Logf("Config: %s", warning)
+		}
+		configuration.SetStateLogger(appLogger) // Also logs notices from a store opened earlier
+	}
+	return appLogger, nil
+}
+
+// appPaths returns the resolved directories from the configuration. Exits if it cannot be loaded.
+func appPaths() configuration.PathsConfig {
+	cfg, err := loadAppConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
+		os.Exit(1)
+	}
+	return cfg.Paths
+}
+
+// runtimeInfoPath returns the location of the running server's runtime info file.
+func runtimeInfoPath() string {
+	return filepath.Join(appPaths().StateDir, "server.json")
+}
+
+// connectToServerForCLI returns a client for the running PanelBase server, or nil when no
+// server is up and the command should fall back to in-process managers.
+func connectToServerForCLI() *rpc.Client {
+	client, err := rpc.ConnectToRunningServer(runtimeInfoPath())
+	if err != nil {
+		if !errors.Is(err, rpc.ErrServerNotRunning) {
+			fmt.Fprintf(os.Stderr, "Failed to connect to the running PanelBase server: %v\n", err)
+			os.Exit(1)
+		}
+		return nil
+	}
+	return client
+}
+
+// sourceForServer makes a local source path absolute, since the server resolves paths
+// relative to its own working directory. URLs are returned unchanged.
+func sourceForServer(source string) string {
+	if u, err := url.Parse(source); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+		return source
+	}
+	if abs, err := filepath.Abs(source); err == nil {
+		return abs
+	}
+	return source
+}
+
+// printUpdateReply reports the result of an update performed by the running server.
+func printUpdateReply(kind, id string, reply *rpc.ExtensionReply) {
+	if !reply.Changed {
+		fmt.Printf("%s %s is already up to date.\n", kind, id)
+		return
+	}
+	fmt.Printf("%s %s updated to '%s' version %s by the running server.\n", kind, id, reply.Name, reply.Version)
+}
+
 // startPanelBaseServer initializes and starts all core components of PanelBase.
 func startPanelBaseServer(cmd *cobra.Command, args []string) {
 	// Initialize Logger
-	appLogger, err := logger.NewLogger()
+	appLogger, err := newAppLogger()
 	if err != nil {
 		log.Fatalf("Failed to initialize logger: %v", err)
 	}
@@ -1338,13 +2700,15 @@ func startPanelBaseServer(cmd *cobra.Command, args []string) {
 	appLogger.Log("Logger initialized.")
 
 	// Load Configuration
-	appConfig, err := configuration.LoadConfig()
+	appConfig, err := loadAppConfig()
 	if err != nil {
 		appLogger.Logf("Failed to load configuration: %v", err)
 		os.Exit(1)
 	}
 	appLogger.Log("Configuration loaded.")
-	// Removed logging of appConfig.Paths as they are now hardcoded in managers
+	appLogger.Logf("Using directories: themes=%s plugins=%s commands=%s containers=%s data=%s state=%s cache=%s",
+		appConfig.Paths.ThemesDir, appConfig.Paths.PluginsDir, appConfig.Paths.CommandsDir,
+		appConfig.Paths.ContainersDir, appConfig.Paths.DataDir, appConfig.Paths.StateDir, appConfig.Paths.CacheDir)
 
 	// Initialize ID Generator
 	idGenerator, err := utils.NewIDGenerator(&appConfig.Security)
@@ -1354,49 +2718,132 @@ func startPanelBaseServer(cmd *cobra.Command, args []string) {
 	}
 	appLogger.Log("ID Generator initialized.")
 
+	// Refuse to start a second server; CLI invocations would otherwise talk to whichever wrote the runtime file last.
+	if client, err := rpc.ConnectToRunningServer(runtimeInfoPath()); err == nil {
+		info := client.Info()
+		client.Close()
+		appLogger.Logf("Another PanelBase server (pid %d) is already running at %s.", info.PID, info.Address)
+		os.Exit(1)
+	}
+
 	// Initialize Managers
 	// Corrected NewContainerManager call
-	containerMgr, err := container.NewContainerManager(idGenerator, appConfig.Server.Host, appLogger)
+	containerMgr, err := container.NewContainerManager(idGenerator, appConfig.Server.Host, appLogger, appPaths().ContainersDir)
 	if err != nil {
 		appLogger.Logf("Failed to initialize Container Manager: %v", err)
 		os.Exit(1)
 	}
+	containerMgr.SetTemplatePollInterval(time.Duration(appConfig.Server.TemplatePollSeconds) * time.Second)
 	appLogger.Log("Container Manager initialized.")
 
-	themeMgr, err := themes.NewThemeManager(appLogger, idGenerator) // Removed path argument
+	themeMgr, err := themes.NewThemeManager(appLogger, idGenerator, appPaths().ThemesDir) // Removed path argument
 	if err != nil {
 		appLogger.Logf("Failed to initialize Theme Manager: %v", err)
 		os.Exit(1)
 	}
 	appLogger.Log("Theme Manager initialized.")
+	setContainerThemeResolvers(containerMgr, themeMgr)
 
-	pluginMgr, err := plugins.NewPluginManager(appLogger, idGenerator) // Removed path argument
+	pluginMgr, err := plugins.NewPluginManager(appLogger, idGenerator, appPaths().PluginsDir) // Removed path argument
 	if err != nil {
 		appLogger.Logf("Failed to initialize Plugin Manager: %v", err)
 		os.Exit(1)
 	}
 	appLogger.Log("Plugin Manager initialized.")
 
-	commandMgr, err := commands.NewCommandManager(appLogger, idGenerator) // Removed path argument
+	sharedDownloader := newCachingDownloader(appLogger) // One pool and cache for theme and plugin installs
+	themeMgr.SetDownloader(sharedDownloader)
+	pluginMgr.SetDownloader(sharedDownloader)
+
+	kvStore, err := kvstore.NewStore(filepath.Join(appPaths().DataDir, "kv"))
+	if err != nil {
+		appLogger.Logf("Failed to initialize KV store: %v", err)
+		os.Exit(1)
+	}
+	pluginMgr.SetKVStore(kvStore)
+	appLogger.Log("KV store initialized.")
+
+	commandMgr, err := commands.NewCommandManager(appLogger, idGenerator, appPaths().CommandsDir) // Removed path argument
 	if err != nil {
 		appLogger.Logf("Failed to initialize Command Manager: %v", err)
 		os.Exit(1)
 	}
 	appLogger.Log("Command Manager initialized.")
 
-	// Suppress "declared and not used" errors for managers if RPC server doesn't use them yet.
-	// This is a temporary measure. Ideally, RPC server would use these managers.
-	_ = containerMgr
-	_ = themeMgr
-	_ = pluginMgr
-	_ = commandMgr
+	secretStore, err := secrets.Open(appPaths().StateDir)
+	if err != nil {
+		appLogger.Logf("Failed to open secrets store: %v", err)
+		os.Exit(1)
+	}
+	commandMgr.SetSecretsProvider(secretStore.Environment) // Commands see global and per-container secrets
+	appLogger.Logf("Secrets store opened: %s", secretStore.Path())
+	if secretStore.UsesDefaultKeyFile() {
+		appLogger.Logf("Note: the secrets key is stored next to the secrets file; set %s or %s to keep it elsewhere.", secrets.KeyEnvVar, secrets.KeyFileEnvVar)
+	}
+
+	// Lifecycle events from all managers go through a single bus; plugins subscribe via EventService.
+	eventBus := events.NewBus()
+	containerMgr.SetEventBus(eventBus)
+	themeMgr.SetEventBus(eventBus)
+	pluginMgr.SetEventBus(eventBus)
+	commandMgr.SetEventBus(eventBus)
+	appLogger.Log("Event bus initialized.")
+
+	// Only the server serves containers; CLI processes merely load them.
+	containerMgr.StartPersistedContainers()
+
+	// Serve management services on loopback so CLI invocations can route mutations through this process.
+	managementToken, err := idGenerator.TokenID()
+	if err != nil {
+		appLogger.Logf("Failed to generate management token: %v", err)
+		os.Exit(1)
+	}
+	managers := &rpc.Managers{Containers: containerMgr, Themes: themeMgr, Plugins: pluginMgr, Commands: commandMgr}
+	managementAddr, err := rpc.StartManagementServer(appLogger, managers, managementToken)
+	if err != nil {
+		appLogger.Logf("Failed to start management server: %v", err)
+		os.Exit(1)
+	}
+	// Plugins log in to the RPC server with a per-plugin token written to their directory, so
+	// services acting on behalf of a plugin never trust a plugin ID sent by the caller.
+	pluginCredentials, err := rpc.NewPluginCredentials()
+	if err != nil {
+		appLogger.Logf("Failed to create plugin credentials: %v", err)
+		os.Exit(1)
+	}
+	pluginMgr.SetCredentialIssuer(pluginCredentials.Token)
+	if err := pluginMgr.IssueCredentials(); err != nil {
+		appLogger.Logf("Warning: failed to write some plugin credentials: %v", err)
+	}
+	if err := rpc.RegisterPluginAuth(appLogger, pluginCredentials); err != nil {
+		appLogger.Logf("Failed to register plugin authentication: %v", err)
+		os.Exit(1)
+	}
+	if err := rpc.RegisterKVService(appLogger, kvStore); err != nil {
+		appLogger.Logf("Failed to register KV service: %v", err)
+		os.Exit(1)
+	}
+	if err := rpc.RegisterSecretService(appLogger, secretStore); err != nil {
+		appLogger.Logf("Failed to register secret service: %v", err)
+		os.Exit(1)
+	}
+	if err := rpc.RegisterEventService(appLogger, eventBus, idGenerator); err != nil {
+		appLogger.Logf("Failed to register event service: %v", err)
+		os.Exit(1)
+	}
 
 	// Start RPC Server
 	rpcHost := appConfig.Server.Host
 	rpcPort := appConfig.Server.Port
 
+	pluginLogPolicy, err := rpc.NewPluginLogPolicy(appConfig.Logging.Plugins)
+	if err != nil {
+		appLogger.Logf("Invalid plugin logging configuration: %v", err)
+		os.Exit(1)
+	}
+
 	rpcReadyChan := make(chan struct{})
-	err = rpc.StartRPCServer(appLogger, idGenerator, rpcHost, rpcPort, rpcReadyChan)
+	err = rpc.StartRPCServer(appLogger, idGenerator, pluginLogPolicy, rpcHost, rpcPort, rpcReadyChan)
 	if err != nil {
 		appLogger.Logf("Failed to start RPC server: %v", err)
 		os.Exit(1)
@@ -1405,6 +2852,108 @@ func startPanelBaseServer(cmd *cobra.Command, args []string) {
 	<-rpcReadyChan
 	appLogger.Logf("RPC server started and listening on %s:%d.", rpcHost, rpcPort)
 
+	// Publish the address and token for CLI invocations.
+	runtimeInfo := &rpc.RuntimeInfo{
+		PID:       os.Getpid(),
+		Address:   managementAddr, // Loopback only; plugins use the RPC listener on server.host
+		Token:     managementToken,
+		StartedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+	if err := rpc.WriteRuntimeInfo(runtimeInfo, runtimeInfoPath()); err != nil {
+		appLogger.Logf("Failed to write runtime info: %v", err)
+		os.Exit(1)
+	}
+
+	// Serve Prometheus metrics on the local admin port, if enabled.
+	if appConfig.Server.AdminPort != 0 {
+		adminAddr := net.JoinHostPort("127.0.0.1", fmt.Sprintf("%d", appConfig.Server.AdminPort))
+		adminMux := http.NewServeMux()
+		adminMux.Handle("/metrics", rpc.MetricsHandler())
+		adminListener, err := net.Listen("tcp", adminAddr)
+		if err != nil {
+			appLogger.Logf("Failed to listen on admin address '%s': %v", adminAddr, err)
+			os.Exit(1)
+		}
+		go func() {
+			if err := http.Serve(adminListener, adminMux); err != nil {
+				appLogger.Logf("Admin metrics endpoint stopped: %v", err)
+			}
+		}()
+		appLogger.Logf("Metrics endpoint available at http://%s/metrics", adminAddr)
+	}
+
+	// Reload the configuration when the file changes or on SIGHUP. Only runtime-safe keys are
+	// applied; the others are reported as needing a restart.
+	configPath := configFilePath()
+	reloader, err := configuration.NewReloader(configPath, appConfig, configuration.DefaultReloadInterval)
+	if err != nil {
+		appLogger.Logf("Failed to initialize config reloader: %v", err)
+		os.Exit(1)
+	}
+	reloader.OnReload(func(old, newCfg *configuration.Config, changes []configuration.Change) {
+		applyConfigReload(appLogger, newCfg, changes, idGenerator, pluginLogPolicy, containerMgr)
+	})
+	reloader.OnError(func(err error) {
+		appLogger.Logf("%v", err)
+	})
+	stopReloader := make(chan struct{})
+	defer close(stopReloader)
+	reloader.Start(stopReloader)
+	appLogger.Logf("Watching config file '%s' for changes (or send SIGHUP to reload).", configPath)
+
 	appLogger.Log("PanelBase server is running. Press Ctrl+C to stop.")
-	select {}
+
+	// Block until interrupted, then remove the runtime info so the CLI falls back to in-process mode.
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	sig := <-sigChan
+	for sig == syscall.SIGHUP {
+		appLogger.Log("Received SIGHUP, reloading configuration.")
+		reloader.Reload() // Failures are logged by OnError
+		sig = <-sigChan
+	}
+	appLogger.Logf("Received %s, shutting down.", sig)
+	if err := rpc.RemoveRuntimeInfo(runtimeInfoPath()); err != nil {
+		appLogger.Logf("Failed to remove runtime info: %v", err)
+	}
+}
+
+// applyConfigReload applies the runtime-safe changes of a reloaded configuration and reports
+// the keys that need a restart.
+func applyConfigReload(appLogger *logger.Logger, newCfg *configuration.Config, changes []configuration.Change,
+	idGenerator *utils.IDGenerator, pluginLogPolicy *rpc.PluginLogPolicy, containerMgr *container.ContainerManager) {
+	for _, warning := range newCfg.Warnings {
+		appLogger.Logf("Config: %s", warning)
+	}
+	if len(changes) == 0 {
+		appLogger.Log("Configuration reloaded: no effective changes.")
+		return
+	}
+	appLogger.Logf("Configuration reloaded: %d change(s).", len(changes))
+
+	var applyErrs []string
+	if err := pluginLogPolicy.Update(newCfg.Logging.Plugins); err != nil {
+		applyErrs = append(applyErrs, err.Error())
+	}
+	if err := idGenerator.Update(&newCfg.Security); err != nil {
+		applyErrs = append(applyErrs, err.Error())
+	}
+	containerMgr.SetGlobalHost(newCfg.Server.Host)
+	containerMgr.SetTemplatePollInterval(time.Duration(newCfg.Server.TemplatePollSeconds) * time.Second)
+
+	for _, change := range changes {
+		switch {
+		case change.NeedsRestart():
+			appLogger.Logf("  %s: '%s' -> '%s' (restart required to take effect)", change.Key, change.OldValue, change.NewValue)
+		case change.Key == "server.template_poll_seconds":
+			appLogger.Logf("  %s: '%s' -> '%s' (applied to containers started from now on)", change.Key, change.OldValue, change.NewValue)
+		case change.Key == "server.host":
+			appLogger.Logf("  %s: '%s' -> '%s' (applied to containers started from now on; the RPC listener changes on restart)", change.Key, change.OldValue, change.NewValue)
+		default:
+			appLogger.Logf("  %s: '%s' -> '%s' (applied)", change.Key, change.OldValue, change.NewValue)
+		}
+	}
+	for _, applyErr := range applyErrs {
+		appLogger.Logf("  Failed to apply reloaded setting: %s", applyErr)
+	}
 }
@@ -0,0 +1,196 @@
+package atomicfile
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// BackupSuffix is appended to a file name for the copy of its previous contents.
+const BackupSuffix = ".bak"
+
+// WriteFile replaces path with data so that readers (and a crash at any point) observe
+// either the old or the new contents, never a truncated file:
+//  1. data is written to a temp file in the same directory and fsynced,
+//  2. the current file (if any) is kept as path.bak,
+//  3. the temp file is renamed over path and the directory is fsynced.
+func WriteFile(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory '%s': %w", dir, err)
+	}
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file for '%s': %w", path, err)
+	}
+	tmpName := tmp.Name()
+	cleanup := func() {
+		tmp.Close()
+		os.Remove(tmpName)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		cleanup()
+		return fmt.Errorf("failed to write temp file for '%s': %w", path, err)
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		cleanup()
+		return fmt.Errorf("failed to set permissions on temp file for '%s': %w", path, err)
+	}
+	if err := tmp.Sync(); err != nil {
+		cleanup()
+		return fmt.Errorf("failed to sync temp file for '%s': %w", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpName)
+		return fmt.Errorf("failed to close temp file for '%s': %w", path, err)
+	}
+
+	if err := backup(path); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+
+	if err := os.Rename(tmpName, path); err != nil {
+		os.Remove(tmpName)
+		return fmt.Errorf("failed to replace '%s': %w", path, err)
+	}
+	syncDir(dir)
+	return nil
+}
+
+// CopyFile copies src to dst through a temp file in dst's directory, so readers never see
+// dst half-written. Unlike WriteFile it keeps no backup and does not fsync: the copies it is
+// meant for (caches, staged downloads) can always be made again.
+func CopyFile(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tmp, err := os.CreateTemp(filepath.Dir(dst), filepath.Base(dst)+".tmp*")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(tmp, in); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+// backup keeps the current contents of path as path.bak. A hard link is used when possible
+// so the backup costs no copy; otherwise the contents are copied.
+func backup(path string) error {
+	if _, err := os.Stat(path); err != nil {
+		if os.IsNotExist(err) {
+			return nil // Nothing to back up yet
+		}
+		return fmt.Errorf("failed to stat '%s' before backup: %w", path, err)
+	}
+	bakPath := path + BackupSuffix
+	if err := os.Remove(bakPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove old backup '%s': %w", bakPath, err)
+	}
+	if err := os.Link(path, bakPath); err == nil {
+		return nil
+	}
+	src, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open '%s' for backup: %w", path, err)
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat '%s' for backup: %w", path, err)
+	}
+	dst, err := os.OpenFile(bakPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return fmt.Errorf("failed to create backup '%s': %w", bakPath, err)
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return fmt.Errorf("failed to copy '%s' to backup: %w", path, err)
+	}
+	if err := dst.Sync(); err != nil {
+		dst.Close()
+		return fmt.Errorf("failed to sync backup '%s': %w", bakPath, err)
+	}
+	return dst.Close()
+}
+
+// syncDir fsyncs a directory so a rename inside it is durable. Errors are ignored because
+// some platforms (e.g., Windows) do not support syncing directories.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
+}
+
+// ReadFile reads path and checks it with validate (nil accepts any contents).
+// If the file is unreadable or invalid and path.bak holds valid contents, the backup is
+// restored in place, the damaged file is kept as path.corrupt-<timestamp>, and recovered is true.
+// A missing file is never restored, since it may have been deleted on purpose; ReadFile then
+// returns an error satisfying os.IsNotExist even when path.bak exists.
+func ReadFile(path string, validate func([]byte) error) (data []byte, recovered bool, err error) {
+	data, readErr := os.ReadFile(path)
+	if os.IsNotExist(readErr) {
+		return nil, false, readErr
+	}
+	if readErr == nil {
+		if validate == nil {
+			return data, false, nil
+		}
+		if readErr = validate(data); readErr == nil {
+			return data, false, nil
+		}
+	}
+
+	bakPath := path + BackupSuffix
+	bakData, bakErr := os.ReadFile(bakPath)
+	if bakErr != nil || (validate != nil && validate(bakData) != nil) {
+		return nil, false, readErr // Report the original problem; the backup cannot help
+	}
+
+	// Keep the damaged file for inspection, then restore the backup.
+	corruptPath := fmt.Sprintf("%s.corrupt-%s", path, time.Now().UTC().Format("20060102T150405Z"))
+	if err := os.Rename(path, corruptPath); err != nil {
+		return nil, false, fmt.Errorf("failed to set aside damaged file '%s': %w", path, err)
+	}
+	perm := os.FileMode(0644)
+	if info, err := os.Stat(bakPath); err == nil {
+		perm = info.Mode().Perm()
+	}
+	if err := WriteFile(path, bakData, perm); err != nil {
+		return nil, false, fmt.Errorf("failed to restore '%s' from backup: %w", path, err)
+	}
+	return bakData, true, nil
+}
+
+// Remove deletes path and its backup. Missing files are not an error.
+func Remove(path string) error {
+	for _, p := range []string{path, path + BackupSuffix} {
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove '%s': %w", p, err)
+		}
+	}
+	return nil
+}
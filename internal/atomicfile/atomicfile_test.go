@@ -0,0 +1,48 @@
+package atomicfile
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFileKeepsBackupAndRecovers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	if err := WriteFile(path, []byte(`{"v":1}`), 0644); err != nil {
+		t.Fatalf("first write: %v", err)
+	}
+	if err := WriteFile(path, []byte(`{"v":2}`), 0644); err != nil {
+		t.Fatalf("second write: %v", err)
+	}
+	if bak, err := os.ReadFile(path + BackupSuffix); err != nil || string(bak) != `{"v":1}` {
+		t.Fatalf("backup = %q, %v; want previous contents", bak, err)
+	}
+
+	// Simulate a torn write and check the backup is restored.
+	if err := os.WriteFile(path, []byte(`{"v":`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	validate := func(data []byte) error {
+		var v map[string]int
+		return json.Unmarshal(data, &v)
+	}
+	data, recovered, err := ReadFile(path, validate)
+	if err != nil || !recovered || string(data) != `{"v":1}` {
+		t.Fatalf("ReadFile = %q, %v, %v; want backup contents recovered", data, recovered, err)
+	}
+	if onDisk, _ := os.ReadFile(path); string(onDisk) != `{"v":1}` {
+		t.Fatalf("file on disk = %q; want restored backup", onDisk)
+	}
+
+	// A deleted file stays deleted even though its backup is still there.
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, recovered, err := ReadFile(path, validate); !os.IsNotExist(err) || recovered {
+		t.Fatalf("ReadFile of deleted file = %v, %v; want not-exist error", recovered, err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("deleted file was recreated from its backup")
+	}
+}
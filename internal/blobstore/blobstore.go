@@ -0,0 +1,208 @@
+package blobstore
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/OG-Open-Source/PanelBase/internal/atomicfile"
+	"github.com/OG-Open-Source/PanelBase/internal/utils"
+)
+
+// staleTempAge is the age after which a temporary file is taken as left over by an interrupted copy.
+const staleTempAge = time.Hour
+
+// Store is a content-addressed file store: each file is kept once under its SHA256 sum (as
+// computed by utils.CalculateFileSHA256) in <dir>/<sum[:2]>/<sum>. Files are always copied in
+// and out rather than hard-linked: a hard link would let an in-place edit of an installed asset
+// (e.g. by a theme developer) silently change the blob and every other install using it. Blobs
+// are still re-hashed before every use, which also catches blobs hard-linked by older versions.
+// A Store is safe for concurrent use by several processes.
+type Store struct {
+	dir string
+}
+
+// Stats describes the contents of a Store.
+type Stats struct {
+	Blobs int
+	Bytes int64
+}
+
+// Open returns the store rooted at dir, creating the directory if needed.
+func Open(dir string) (*Store, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("blob store directory cannot be empty")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create blob store directory '%s': %w", dir, err)
+	}
+	return &Store{dir: dir}, nil
+}
+
+// Dir returns the root directory of the store.
+func (s *Store) Dir() string {
+	return s.dir
+}
+
+// validSum reports whether sum is a lowercase hex SHA256, the only names used inside the store.
+func validSum(sum string) bool {
+	if len(sum) != 64 {
+		return false
+	}
+	for _, c := range sum {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// blobPath returns where the blob with the given (lowercase) sum is kept.
+func (s *Store) blobPath(sum string) string {
+	return filepath.Join(s.dir, sum[:2], sum)
+}
+
+// Has reports whether the store holds a blob for sum. The blob is not re-hashed.
+func (s *Store) Has(sum string) (bool, error) {
+	sum = strings.ToLower(sum)
+	if !validSum(sum) {
+		return false, nil
+	}
+	_, err := os.Stat(s.blobPath(sum))
+	if err == nil {
+		return true, nil
+	}
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return false, err
+}
+
+// Get places the blob with the given sum at dest, replacing dest if it exists. It reports false
+// without an error when the store has no intact copy; a blob whose content no longer matches its
+// sum is removed.
+func (s *Store) Get(sum, dest string) (bool, error) {
+	sum = strings.ToLower(sum)
+	if !validSum(sum) {
+		return false, nil
+	}
+	blobPath := s.blobPath(sum)
+	actual, err := utils.CalculateFileSHA256(blobPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to hash blob '%s': %w", blobPath, err)
+	}
+	if actual != sum {
+		os.Remove(blobPath) // Damaged or modified; the next download stores a fresh copy
+		return false, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return false, fmt.Errorf("failed to create directory for '%s': %w", dest, err)
+	}
+	if err := os.Remove(dest); err != nil && !os.IsNotExist(err) {
+		return false, fmt.Errorf("failed to replace '%s': %w", dest, err)
+	}
+	if err := atomicfile.CopyFile(blobPath, dest, 0644); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil // Pruned in the meantime
+		}
+		return false, fmt.Errorf("failed to copy blob '%s' to '%s': %w", blobPath, dest, err)
+	}
+	return true, nil
+}
+
+// Put adds the file at src under the given sum unless the store already has it. The caller
+// must have verified that src matches sum.
+func (s *Store) Put(sum, src string) error {
+	sum = strings.ToLower(sum)
+	if !validSum(sum) {
+		return fmt.Errorf("invalid SHA256 sum '%s'", sum)
+	}
+	blobPath := s.blobPath(sum)
+	if _, err := os.Stat(blobPath); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(blobPath), 0755); err != nil {
+		return fmt.Errorf("failed to create blob directory '%s': %w", filepath.Dir(blobPath), err)
+	}
+	if err := atomicfile.CopyFile(src, blobPath, 0644); err != nil {
+		return fmt.Errorf("failed to store '%s' as blob '%s': %w", src, sum, err)
+	}
+	return nil
+}
+
+// walk calls fn for every file in the store. sum is empty for files that are not blobs, such as
+// temporary files of copies in progress.
+func (s *Store) walk(fn func(path, sum string, info fs.FileInfo) error) error {
+	return filepath.WalkDir(s.dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil // Removed concurrently
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		sum := d.Name()
+		if !validSum(sum) {
+			sum = ""
+		}
+		return fn(p, sum, info)
+	})
+}
+
+// Stats counts the blobs in the store and their size.
+func (s *Store) Stats() (Stats, error) {
+	var stats Stats
+	err := s.walk(func(_, sum string, info fs.FileInfo) error {
+		if sum == "" {
+			return nil
+		}
+		stats.Blobs++
+		stats.Bytes += info.Size()
+		return nil
+	})
+	if err != nil {
+		return stats, fmt.Errorf("failed to scan blob store '%s': %w", s.dir, err)
+	}
+	return stats, nil
+}
+
+// Prune removes every blob whose sum is not in keep; a nil keep empties the store. Temporary
+// files left by interrupted copies are removed as well. It returns the number of removed blobs
+// and their size.
+func (s *Store) Prune(keep map[string]bool) (Stats, error) {
+	var removed Stats
+	err := s.walk(func(p, sum string, info fs.FileInfo) error {
+		if sum == "" {
+			if time.Since(info.ModTime()) > staleTempAge {
+				os.Remove(p)
+			}
+			return nil
+		}
+		if keep[sum] {
+			return nil
+		}
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		removed.Blobs++
+		removed.Bytes += info.Size()
+		return nil
+	})
+	if err != nil {
+		return removed, fmt.Errorf("failed to prune blob store '%s': %w", s.dir, err)
+	}
+	return removed, nil
+}
@@ -0,0 +1,80 @@
+package blobstore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPutGetAndPrune(t *testing.T) {
+	store, err := Open(filepath.Join(t.TempDir(), "blobs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	work := t.TempDir()
+	write := func(name, content string) (string, string) {
+		p := filepath.Join(work, name)
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		sum := sha256.Sum256([]byte(content))
+		return p, hex.EncodeToString(sum[:])
+	}
+	keptPath, keptSum := write("kept.css", "body {}")
+	dropPath, dropSum := write("dropped.js", "alert(1)")
+	for _, f := range [][2]string{{keptSum, keptPath}, {dropSum, dropPath}} {
+		if err := store.Put(f[0], f[1]); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+	}
+
+	dest := filepath.Join(work, "out", "kept.css")
+	if ok, err := store.Get(keptSum, dest); err != nil || !ok {
+		t.Fatalf("Get = %v, %v; want the stored blob", ok, err)
+	}
+	if got, _ := os.ReadFile(dest); string(got) != "body {}" {
+		t.Errorf("Get wrote %q", got)
+	}
+
+	// Editing a stored or handed-out file in place leaves its blob alone.
+	for _, p := range []string{keptPath, dest} {
+		f, err := os.OpenFile(p, os.O_WRONLY|os.O_TRUNC, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.WriteString("edited")
+		f.Close()
+	}
+	if ok, err := store.Get(keptSum, filepath.Join(work, "out", "again.css")); err != nil || !ok {
+		t.Fatalf("Get after editing a copy = %v, %v; want the intact blob", ok, err)
+	}
+
+	// A blob whose content no longer matches its sum must be discarded.
+	if err := os.WriteFile(store.blobPath(dropSum), []byte("tampered"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := store.Get(dropSum, filepath.Join(work, "out", "dropped.js")); err != nil || ok {
+		t.Errorf("Get of a modified blob = %v, %v; want a miss", ok, err)
+	}
+	if has, _ := store.Has(dropSum); has {
+		t.Errorf("modified blob was not removed")
+	}
+
+	otherPath, otherSum := write("other.txt", "other")
+	if err := store.Put(otherSum, otherPath); err != nil {
+		t.Fatal(err)
+	}
+	removed, err := store.Prune(map[string]bool{keptSum: true})
+	if err != nil {
+		t.Fatalf("Prune: %v", err)
+	}
+	if removed.Blobs != 1 || removed.Bytes != int64(len("other")) {
+		t.Errorf("Prune removed %+v; want the one unreferenced blob", removed)
+	}
+	stats, err := store.Stats()
+	if err != nil || stats.Blobs != 1 || stats.Bytes != int64(len("body {}")) {
+		t.Errorf("Stats = %+v, %v; want only the kept blob", stats, err)
+	}
+}
@@ -1,47 +1,86 @@
 package configuration
 
 import (
-	"encoding/json"
 	"fmt"
 	"math/rand"
 	"os"
 	"path/filepath"
 	"time"
 
+	"github.com/OG-Open-Source/PanelBase/internal/atomicfile"
 	"gopkg.in/yaml.v3"
 )
 
 const (
-	defaultConfigDir         = "configs"
-	defaultConfigPath        = "configs/config.yaml"
-	defaultThemesStatePath   = "configs/themes.json"   // Default path for themes state
-	defaultPluginsStatePath  = "configs/plugins.json"  // Default path for plugins state
-	defaultCommandsStatePath = "configs/commands.json" // Default path for commands state
-	defaultHost              = "0.0.0.0"
-	minPort                  = 1024
-	maxPort                  = 49151
+	defaultConfigDir        = "configs"
+	defaultConfigPath       = "configs/config.yaml"
+	legacyThemesStateFile   = "themes.json"   // Pre-store themes state, migrated into the state store
+	legacyPluginsStateFile  = "plugins.json"  // Pre-store plugins state, migrated into the state store
+	legacyCommandsStateFile = "commands.json" // Pre-store commands state, migrated into the state store
+	defaultHost             = "0.0.0.0"
+	minPort                 = 1024
+	maxPort                 = 49151
+
+	defaultPluginLogLevel     = "info" // Minimum level for plugin log entries when none is configured
+	defaultPluginLogPerSecond = 20     // Sustained plugin log entries per second per plugin
+	defaultPluginLogBurst     = 50     // Entries a plugin may log in a burst before being rate-limited
 )
 
 // Config holds the application's configuration.
 type Config struct {
 	Version  string         `yaml:"version"`
+	Strict   bool           `yaml:"strict"` // Refuse to start on an invalid config instead of substituting defaults
 	Server   ServerConfig   `yaml:"server"`
 	Security SecurityConfig `yaml:"security"`
-	// Paths    PathsConfig    `yaml:"paths"` // Removed Paths configuration
+	Logging  LoggingConfig  `yaml:"logging"`
+	Paths    PathsConfig    `yaml:"paths"`
+
+	Sources  map[string]string `yaml:"-"` // Where each dotted key's value came from (see EffectiveValues)
+	Warnings []string          `yaml:"-"` // Notices produced while loading, for the caller to log
+}
+
+// PathsConfig holds the directories PanelBase writes to. Relative paths are resolved against
+// the PanelBase home (--data-dir, PANELBASE_HOME, or the working directory), so the program
+// itself can live on a read-only volume.
+type PathsConfig struct {
+	ThemesDir     string `yaml:"themes_dir"`
+	PluginsDir    string `yaml:"plugins_dir"`
+	CommandsDir   string `yaml:"commands_dir"`
+	ContainersDir string `yaml:"containers_dir"`
+	LogsDir       string `yaml:"logs_dir"`
+	DataDir       string `yaml:"data_dir"`  // Plugin key-value data
+	StateDir      string `yaml:"state_dir"` // state.db and the runtime info file of a running server
+	CacheDir      string `yaml:"cache_dir"` // Downloaded extension assets; safe to delete
 }
 
-// PathsConfig holds configuration for various directory paths. - REMOVED
-// type PathsConfig struct {
-// 	ThemesDir     string `yaml:"themesDir"`
-// 	PluginsDir    string `yaml:"pluginsDir"`
-// 	CommandsDir   string `yaml:"commandsDir"`
-// 	ContainersDir string `yaml:"containersDir"`
-// }
+// Resolve returns a copy of p with every relative path joined onto home.
+func (p PathsConfig) Resolve(home string) PathsConfig {
+	resolve := func(dir string) string {
+		if home == "" || filepath.IsAbs(dir) {
+			return dir
+		}
+		return filepath.Join(home, dir)
+	}
+	return PathsConfig{
+		ThemesDir:     resolve(p.ThemesDir),
+		PluginsDir:    resolve(p.PluginsDir),
+		CommandsDir:   resolve(p.CommandsDir),
+		ContainersDir: resolve(p.ContainersDir),
+		LogsDir:       resolve(p.LogsDir),
+		DataDir:       resolve(p.DataDir),
+		StateDir:      resolve(p.StateDir),
+		CacheDir:      resolve(p.CacheDir),
+	}
+}
 
 // ServerConfig holds configuration related to the main PanelBase process and default container settings.
 type ServerConfig struct {
-	Host string `yaml:"host"`
-	Port int    `yaml:"port"`
+	Host      string `yaml:"host"`
+	Port      int    `yaml:"port"`
+	AdminPort int    `yaml:"admin_port"` // Local (127.0.0.1) port for the Prometheus metrics endpoint; 0 disables it
+	// TemplatePollSeconds > 0 makes container web servers check their cached templates for changes
+	// at that interval; 0 checks the files of a cached template on every request instead.
+	TemplatePollSeconds int `yaml:"template_poll_seconds,omitempty"`
 }
 
 // SecurityConfig holds security-related configuration.
@@ -55,27 +94,102 @@ type SecretsConfig struct {
 	Length   int    `yaml:"length"`
 }
 
-// LoadConfig loads the configuration from the specified path or the default path.
+// LoggingConfig holds logging-related configuration.
+type LoggingConfig struct {
+	Plugins PluginLoggingConfig `yaml:"plugins"`
+}
+
+// PluginLoggingConfig controls how log entries sent by plugins over RPC are filtered.
+type PluginLoggingConfig struct {
+	DefaultLevel string            `yaml:"default_level"` // debug, info, warn or error
+	Levels       map[string]string `yaml:"levels"`        // Per-plugin minimum level, keyed by plugin ID (plg_...)
+	RateLimit    RateLimitConfig   `yaml:"rate_limit"`
+}
+
+// RateLimitConfig describes a token bucket: PerSecond tokens are added each second, up to Burst.
+type RateLimitConfig struct {
+	PerSecond float64 `yaml:"per_second"`
+	Burst     int     `yaml:"burst"`
+}
+
+// HomeEnvVar names the environment variable that sets the PanelBase home directory.
+const HomeEnvVar = "PANELBASE_HOME"
+
+// home is the directory relative paths (config file, data directories) are resolved against.
+// Empty means the working directory.
+var home string
+
+// ResolveHome picks the PanelBase home: the --data-dir flag value if set, otherwise PANELBASE_HOME.
+func ResolveHome(flagValue string) string {
+	if flagValue != "" {
+		return flagValue
+	}
+	return os.Getenv(HomeEnvVar)
+}
+
+// SetHome sets the PanelBase home used by LoadConfig and the default state store location.
+func SetHome(dir string) {
+	home = dir
+	SetStateDir(filepath.Join(dir, defaultConfigDir))
+}
+
+// Home returns the PanelBase home directory ("" for the working directory).
+func Home() string {
+	return home
+}
+
+// DefaultConfigPath returns the config file location inside the PanelBase home.
+func DefaultConfigPath() string {
+	return filepath.Join(home, defaultConfigPath)
+}
+
+// LoadConfig loads the configuration from the specified path or the default path, then applies
+// environment (PANELBASE_*) and flag (SetFlagOverrides) overrides. Precedence is
+// flags > environment > file > defaults; cfg.Sources records where each key came from.
+// Nothing is printed: notices about defaults, migrations and created files are returned in
+// cfg.Warnings so the caller can log them once a logger exists.
+// The returned Paths are resolved against the PanelBase home.
 func LoadConfig(configPath ...string) (*Config, error) {
-	path := defaultConfigPath
+	path := DefaultConfigPath()
 	if len(configPath) > 0 && configPath[0] != "" {
 		path = configPath[0]
 	}
 
+	var warnings []string
 	data, err := os.ReadFile(path)
 	if err != nil {
-		if os.IsNotExist(err) && path == defaultConfigPath {
-			fmt.Printf("Warning: Config file '%s' not found. Creating default config file.\n", path)
-			defaultCfg := applyDefaults(&Config{})
-			errWrite := writeDefaultConfig(path, defaultCfg)
-			if errWrite != nil {
-				fmt.Printf("Error: Failed to write default config file '%s': %v. Using defaults in memory.\n", path, errWrite)
-			} else {
-				fmt.Printf("Info: Default config file created at '%s'.\n", path)
+		if !os.IsNotExist(err) || path != DefaultConfigPath() {
+			return nil, fmt.Errorf("failed to read config file '%s': %w", path, err)
+		}
+		warnings = append(warnings, fmt.Sprintf("Config file '%s' not found. Creating default config file.", path))
+		defaultCfg := applyDefaults(&Config{})
+		defaultCfg.Warnings = nil // The defaults written to the file are not worth a warning each
+		if errWrite := writeDefaultConfig(path, defaultCfg); errWrite != nil {
+			warnings = append(warnings, fmt.Sprintf("Failed to write default config file '%s': %v. Using defaults in memory.", path, errWrite))
+			if err := applyOverrides(defaultCfg, path, nil); err != nil {
+				return nil, err
 			}
+			applyDefaultsTracked(defaultCfg)
+			defaultCfg.Warnings = append(warnings, defaultCfg.Warnings...)
+			defaultCfg.Paths = defaultCfg.Paths.Resolve(home)
 			return defaultCfg, nil
 		}
-		return nil, fmt.Errorf("failed to read config file '%s': %w", path, err)
+		warnings = append(warnings, fmt.Sprintf("Default config file created at '%s'.", path))
+		if data, err = os.ReadFile(path); err != nil {
+			return nil, fmt.Errorf("failed to read config file '%s': %w", path, err)
+		}
+	}
+
+	// Bring files written by older versions up to the current schema (backup + rewrite).
+	report, err := MigrateConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if report.Changed() {
+		warnings = append(warnings, report.Summary()...)
+		if data, err = os.ReadFile(path); err != nil {
+			return nil, fmt.Errorf("failed to read migrated config file '%s': %w", path, err)
+		}
 	}
 
 	var cfg Config
@@ -83,48 +197,77 @@ func LoadConfig(configPath ...string) (*Config, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to unmarshal config file '%s': %w", path, err)
 	}
-	return applyDefaults(&cfg), nil
+	if err := applyOverrides(&cfg, path, fileKeys(data)); err != nil {
+		return nil, err
+	}
+	issues := validateLoaded(&cfg, data)
+	if cfg.Strict && HasErrors(issues) {
+		return nil, &ValidationError{Path: path, Issues: issues}
+	}
+	for _, issue := range issues {
+		warnings = append(warnings, fmt.Sprintf("%s: %s", path, issue))
+	}
+	applyDefaultsTracked(&cfg)
+	cfg.Warnings = append(warnings, cfg.Warnings...)
+	cfg.Paths = cfg.Paths.Resolve(home)
+	return &cfg, nil
 }
 
 // applyDefaults sets default values for missing or invalid configuration options.
+// Each substitution that deserves the user's attention is added to cfg.Warnings.
 func applyDefaults(cfg *Config) *Config {
 	if cfg.Version == "" {
-		cfg.Version = "v1"
+		cfg.Version = CurrentConfigVersion
 	}
 	if cfg.Server.Host == "" {
 		cfg.Server.Host = defaultHost
-		fmt.Println("Info: Server host not specified. Using default:", defaultHost)
+		cfg.Warnings = append(cfg.Warnings, "Server host not specified. Using default: "+defaultHost)
 	}
 	if cfg.Server.Port < minPort || cfg.Server.Port > maxPort {
 		rand.Seed(time.Now().UnixNano())
 		cfg.Server.Port = rand.Intn(maxPort-minPort+1) + minPort
-		fmt.Printf("Info: Server port (for RPC) not specified or invalid. Using random port: %d\n", cfg.Server.Port)
+		cfg.Warnings = append(cfg.Warnings, fmt.Sprintf("Server port (for RPC) not specified or invalid. Using random port: %d", cfg.Server.Port))
+	}
+	if cfg.Server.AdminPort != 0 && (cfg.Server.AdminPort < minPort || cfg.Server.AdminPort > maxPort) {
+		cfg.Warnings = append(cfg.Warnings, fmt.Sprintf("Server admin_port %d is outside %d-%d. Metrics endpoint disabled.", cfg.Server.AdminPort, minPort, maxPort))
+		cfg.Server.AdminPort = 0
+	}
+	if cfg.Server.TemplatePollSeconds < 0 {
+		cfg.Warnings = append(cfg.Warnings, fmt.Sprintf("Server template_poll_seconds %d is negative. Checking templates on every request.", cfg.Server.TemplatePollSeconds))
+		cfg.Server.TemplatePollSeconds = 0
 	}
 	if cfg.Security.Secrets.Alphabet == "" {
 		cfg.Security.Secrets.Alphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-		fmt.Println("Warning: Security secrets alphabet was missing. Using default.")
+		cfg.Warnings = append(cfg.Warnings, "Security secrets alphabet was missing. Using default.")
 	}
 	if cfg.Security.Secrets.Length <= 0 {
 		cfg.Security.Secrets.Length = 12
-		fmt.Println("Warning: Security secrets length was missing or invalid. Using default (12).")
+		cfg.Warnings = append(cfg.Warnings, "Security secrets length was missing or invalid. Using default (12).")
+	}
+	// Plugin logging defaults are applied silently; the whole section is optional.
+	if cfg.Logging.Plugins.DefaultLevel == "" {
+		cfg.Logging.Plugins.DefaultLevel = defaultPluginLogLevel
 	}
-	// Defaults for Paths - REMOVED
-	// if cfg.Paths.ThemesDir == "" {
-	// 	cfg.Paths.ThemesDir = "ext/themes"
-	// 	fmt.Println("Info: Paths.ThemesDir not specified. Using default:", cfg.Paths.ThemesDir)
-	// }
-	// if cfg.Paths.PluginsDir == "" {
-	// 	cfg.Paths.PluginsDir = "ext/plugins"
-	// 	fmt.Println("Info: Paths.PluginsDir not specified. Using default:", cfg.Paths.PluginsDir)
-	// }
-	// if cfg.Paths.CommandsDir == "" {
-	// 	cfg.Paths.CommandsDir = "ext/commands"
-	// 	fmt.Println("Info: Paths.CommandsDir not specified. Using default:", cfg.Paths.CommandsDir)
-	// }
-	// if cfg.Paths.ContainersDir == "" {
-	// 	cfg.Paths.ContainersDir = "containers" // Or a path under ext/ if preferred
-	// 	fmt.Println("Info: Paths.ContainersDir not specified. Using default:", cfg.Paths.ContainersDir)
-	// }
+	if cfg.Logging.Plugins.RateLimit.PerSecond <= 0 {
+		cfg.Logging.Plugins.RateLimit.PerSecond = defaultPluginLogPerSecond
+	}
+	if cfg.Logging.Plugins.RateLimit.Burst <= 0 {
+		cfg.Logging.Plugins.RateLimit.Burst = defaultPluginLogBurst
+	}
+	// Paths default to the historical layout relative to the PanelBase home.
+	setDefault := func(field *string, value string) {
+		if *field == "" {
+			*field = value
+		}
+	}
+	setDefault(&cfg.Paths.ThemesDir, "ext/themes")
+	setDefault(&cfg.Paths.PluginsDir, "ext/plugins")
+	setDefault(&cfg.Paths.CommandsDir, "ext/commands")
+	setDefault(&cfg.Paths.ContainersDir, "containers")
+	setDefault(&cfg.Paths.LogsDir, "logs")
+	setDefault(&cfg.Paths.DataDir, "data")
+	setDefault(&cfg.Paths.StateDir, defaultConfigDir)
+	setDefault(&cfg.Paths.CacheDir, "cache")
 	return cfg
 }
 
@@ -138,7 +281,7 @@ func writeDefaultConfig(path string, cfg *Config) error {
 	if err != nil {
 		return fmt.Errorf("failed to marshal default config to YAML: %w", err)
 	}
-	err = os.WriteFile(path, data, 0644)
+	err = atomicfile.WriteFile(path, data, 0644)
 	if err != nil {
 		return fmt.Errorf("failed to write default config file '%s': %w", path, err)
 	}
@@ -185,133 +328,3 @@ type ExtensionStateStore struct {
 	Plugins  map[string]InstalledPluginEntry  `json:"plugins,omitempty"`  // Key is PlgID (plg_id)
 	Commands map[string]InstalledCommandEntry `json:"commands,omitempty"` // Key is Filename
 }
-
-// --- Generic Extension State Loading/Saving ---
-
-// LoadThemesState loads the theme state from the themes JSON file.
-func LoadThemesState(statePath ...string) (map[string]InstalledThemeEntry, error) {
-	path := defaultThemesStatePath
-	if len(statePath) > 0 && statePath[0] != "" {
-		path = statePath[0]
-	}
-	return loadState[InstalledThemeEntry](path)
-}
-
-// SaveThemesState saves the theme state to the themes JSON file.
-func SaveThemesState(themes map[string]InstalledThemeEntry, statePath ...string) error {
-	path := defaultThemesStatePath
-	if len(statePath) > 0 && statePath[0] != "" {
-		path = statePath[0]
-	}
-	// Wrap the map in the expected top-level structure for themes.json
-	dataToSave := map[string]interface{}{"themes": themes}
-	return saveState(dataToSave, path)
-}
-
-// LoadPluginsState loads the plugin state from the plugins JSON file.
-func LoadPluginsState(statePath ...string) (map[string]InstalledPluginEntry, error) {
-	path := defaultPluginsStatePath
-	if len(statePath) > 0 && statePath[0] != "" {
-		path = statePath[0]
-	}
-	return loadState[InstalledPluginEntry](path)
-}
-
-// SavePluginsState saves the plugin state to the plugins JSON file.
-func SavePluginsState(plugins map[string]InstalledPluginEntry, statePath ...string) error {
-	path := defaultPluginsStatePath
-	if len(statePath) > 0 && statePath[0] != "" {
-		path = statePath[0]
-	}
-	// Wrap the map in the expected top-level structure for plugins.json
-	dataToSave := map[string]interface{}{"plugins": plugins}
-	return saveState(dataToSave, path)
-}
-
-// LoadCommandsState loads the command state from the commands JSON file.
-func LoadCommandsState(statePath ...string) (map[string]InstalledCommandEntry, error) {
-	path := defaultCommandsStatePath
-	if len(statePath) > 0 && statePath[0] != "" {
-		path = statePath[0]
-	}
-	return loadState[InstalledCommandEntry](path)
-}
-
-// SaveCommandsState saves the command state to the commands JSON file.
-func SaveCommandsState(commands map[string]InstalledCommandEntry, statePath ...string) error {
-	path := defaultCommandsStatePath
-	if len(statePath) > 0 && statePath[0] != "" {
-		path = statePath[0]
-	}
-	// Wrap the map in the expected top-level structure for commands.json
-	dataToSave := map[string]interface{}{"commands": commands}
-	return saveState(dataToSave, path)
-}
-
-// loadState is a generic function to load a map[string]T from a JSON file.
-// It expects the JSON to have a top-level key (e.g., "themes", "plugins") whose value is the map.
-func loadState[T any](path string) (map[string]T, error) {
-	stateMap := make(map[string]T)
-
-	data, err := os.ReadFile(path)
-	if err != nil {
-		if os.IsNotExist(err) {
-			// Return an empty map if the file doesn't exist
-			return stateMap, nil
-		}
-		return nil, fmt.Errorf("failed to read state file '%s': %w", path, err)
-	}
-
-	if len(data) == 0 {
-		// Return an empty map if the file is empty
-		return stateMap, nil
-	}
-
-	// We need to unmarshal into a temporary structure to extract the nested map
-	var tempStore map[string]map[string]T
-	err = json.Unmarshal(data, &tempStore)
-	if err != nil {
-		// Attempt to unmarshal directly into the map if the top-level key is missing (e.g., old format or direct map save)
-		errDirect := json.Unmarshal(data, &stateMap)
-		if errDirect == nil {
-			// If direct unmarshal works, return the result but maybe log a warning about format?
-			// fmt.Printf("Warning: State file '%s' might be missing the top-level key. Loaded directly.\n", path)
-			return stateMap, nil
-		}
-		// If both fail, return the original error from unmarshalling the expected structure
-		return nil, fmt.Errorf("failed to unmarshal state file '%s': %w", path, err)
-	}
-
-	// Extract the actual map from the first key found in the temp store
-	// This assumes there's only one top-level key (like "themes", "plugins")
-	for _, v := range tempStore {
-		stateMap = v
-		break // Only take the first map found
-	}
-
-	// Ensure the map is not nil even if the file contained an empty object under the key
-	if stateMap == nil {
-		stateMap = make(map[string]T)
-	}
-
-	return stateMap, nil
-}
-
-// saveState is a generic function to save any data structure (usually a map wrapper) to a JSON file.
-func saveState(dataToSave interface{}, path string) error {
-	dir := filepath.Dir(path)
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return fmt.Errorf("failed to create state directory '%s': %w", dir, err)
-	}
-
-	data, err := json.MarshalIndent(dataToSave, "", "  ")
-	if err != nil {
-		return fmt.Errorf("failed to marshal state data: %w", err)
-	}
-
-	err = os.WriteFile(path, data, 0644)
-	if err != nil {
-		return fmt.Errorf("failed to write state file '%s': %w", path, err)
-	}
-	return nil
-}
@@ -0,0 +1,187 @@
+package configuration
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/OG-Open-Source/PanelBase/internal/atomicfile"
+	"gopkg.in/yaml.v3"
+)
+
+// GetConfigValue returns the value of a dotted key as written in the config file. Scalars are
+// returned as-is; sections and maps are returned as YAML. found is false when the key is
+// valid but not present in the file (its default applies).
+func GetConfigValue(path, key string) (value string, found bool, err error) {
+	if _, _, err := lookupConfigKey(key); err != nil && key != "version" && !configSections()[key] {
+		return "", false, err // Unknown key (sections and the read-only version can be read)
+	}
+	doc, err := readConfigDocument(path)
+	if err != nil {
+		return "", false, err
+	}
+	node := doc.Content[0]
+	for _, part := range strings.Split(key, ".") {
+		if node = mappingValue(node, part); node == nil {
+			return "", false, nil
+		}
+	}
+	if node.Kind == yaml.ScalarNode {
+		return node.Value, true, nil
+	}
+	out, err := yaml.Marshal(node)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to encode '%s': %w", key, err)
+	}
+	return strings.TrimRight(string(out), "\n"), true, nil
+}
+
+// SetConfigValue sets a dotted key in the config file, keeping comments, key order and
+// formatting of the rest of the file. The value is parsed as the key's type (maps take
+// "key=value,key=value"), the edited file is validated, and it is written atomically.
+// Entries of maps can be set individually, e.g. logging.plugins.levels.plg_abc123=debug.
+func SetConfigValue(path, key, value string) error {
+	field, mapEntry, err := lookupConfigKey(key)
+	if err != nil {
+		return err
+	}
+
+	// Parse the value with the same rules as overrides, then encode it as a YAML node.
+	var scratch Config
+	target := configField{Key: key, value: fieldValue(&scratch, field.Key)}
+	var valueNode *yaml.Node
+	if mapEntry {
+		valueNode = &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}
+	} else {
+		if err := setFieldValue(target, value); err != nil {
+			return err
+		}
+		if valueNode, err = encodeFieldValue(target.value); err != nil {
+			return fmt.Errorf("failed to encode value for '%s': %w", key, err)
+		}
+	}
+
+	doc, err := readConfigDocument(path)
+	if err != nil {
+		return err
+	}
+	parts := strings.Split(key, ".")
+	parent := doc.Content[0]
+	for _, part := range parts[:len(parts)-1] {
+		child := mappingValue(parent, part)
+		if child == nil || child.Kind != yaml.MappingNode {
+			replacement := &yaml.Node{Kind: yaml.MappingNode}
+			setMappingValue(parent, part, replacement)
+			child = replacement
+		}
+		child.Style = 0 // Switch empty flow mappings ({}) to block style once they get entries
+		parent = child
+	}
+	setMappingValue(parent, parts[len(parts)-1], valueNode)
+
+	data, err := yaml.Marshal(doc)
+	if err != nil {
+		return fmt.Errorf("failed to encode config file '%s': %w", path, err)
+	}
+	issues, err := validateConfigData(data, path, false)
+	if err != nil {
+		return err
+	}
+	for _, issue := range issues {
+		if issue.Severity == SeverityError && (issue.Key == key || strings.HasPrefix(issue.Key, key+".")) {
+			return fmt.Errorf("invalid value for '%s': %s", key, issue.Message)
+		}
+	}
+
+	perm := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		perm = info.Mode().Perm()
+	}
+	if err := atomicfile.WriteFile(path, data, perm); err != nil {
+		return fmt.Errorf("failed to write config file '%s': %w", path, err)
+	}
+	return nil
+}
+
+// lookupConfigKey finds the field a dotted key refers to. mapEntry is true for keys naming one
+// entry of a map field (e.g. logging.plugins.levels.plg_abc123).
+func lookupConfigKey(key string) (field configField, mapEntry bool, err error) {
+	for _, f := range configFields(&Config{}) {
+		if f.Key == key {
+			if !f.overridable() {
+				return f, false, fmt.Errorf("config key '%s' is managed by PanelBase and cannot be set", key)
+			}
+			return f, false, nil
+		}
+		if f.value.Kind() == reflect.Map && strings.HasPrefix(key, f.Key+".") && !strings.Contains(strings.TrimPrefix(key, f.Key+"."), ".") {
+			return f, true, nil
+		}
+	}
+	if configSections()[key] {
+		return configField{}, false, fmt.Errorf("'%s' is a section; set its keys individually", key)
+	}
+	return configField{}, false, fmt.Errorf("unknown config key '%s'", key)
+}
+
+// configSections returns the dotted keys of the nested structs of Config (e.g. server, logging.plugins).
+func configSections() map[string]bool {
+	sections := make(map[string]bool)
+	for _, f := range configFields(&Config{}) {
+		parts := strings.Split(f.Key, ".")
+		for i := 1; i < len(parts); i++ {
+			sections[strings.Join(parts[:i], ".")] = true
+		}
+	}
+	return sections
+}
+
+// fieldValue returns the addressable field of cfg for a dotted key.
+func fieldValue(cfg *Config, key string) reflect.Value {
+	for _, f := range configFields(cfg) {
+		if f.Key == key {
+			return f.value
+		}
+	}
+	return reflect.Value{}
+}
+
+// encodeFieldValue builds the YAML node for a parsed field value.
+func encodeFieldValue(v reflect.Value) (*yaml.Node, error) {
+	switch v.Kind() {
+	case reflect.String:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: v.String()}, nil
+	case reflect.Int:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: strconv.FormatInt(v.Int(), 10)}, nil
+	case reflect.Float64:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!float", Value: strconv.FormatFloat(v.Float(), 'g', -1, 64)}, nil
+	case reflect.Bool:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: strconv.FormatBool(v.Bool())}, nil
+	default:
+		node := &yaml.Node{}
+		if err := node.Encode(v.Interface()); err != nil {
+			return nil, err
+		}
+		return node, nil
+	}
+}
+
+// readConfigDocument parses the config file into a node tree whose root is a mapping.
+func readConfigDocument(path string) (*yaml.Node, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file '%s': %w", path, err)
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse config file '%s': %w", path, err)
+	}
+	if doc.Kind == 0 { // Empty file
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("config file '%s' must be a YAML mapping", path)
+	}
+	return &doc, nil
+}
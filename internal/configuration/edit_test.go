@@ -0,0 +1,60 @@
+package configuration
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSetConfigValueKeepsComments(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	original := "# PanelBase config\nversion: v3\nserver:\n    host: 0.0.0.0 # all interfaces\n    port: 40082\n"
+	if err := os.WriteFile(path, []byte(original), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := SetConfigValue(path, "server.port", "40083"); err != nil {
+		t.Fatalf("SetConfigValue: %v", err)
+	}
+	if err := SetConfigValue(path, "logging.plugins.levels.plg_abc", "debug"); err != nil {
+		t.Fatalf("SetConfigValue map entry: %v", err)
+	}
+	if err := SetConfigValue(path, "server.port", "80"); err == nil {
+		t.Error("expected an out-of-range port to be rejected")
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"# PanelBase config", "# all interfaces", "port: 40083", "plg_abc: debug"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("config file is missing %q:\n%s", want, data)
+		}
+	}
+	if value, found, err := GetConfigValue(path, "server.port"); err != nil || !found || value != "40083" {
+		t.Errorf("GetConfigValue(server.port) = %q, %v, %v; want 40083", value, found, err)
+	}
+}
+
+func TestSetConfigValueRepairsInvalidStrictFile(t *testing.T) {
+	// LoadConfig refuses this file, but get and set work on it so it can be repaired.
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("version: v3\nstrict: true\nserver:\n    port: abc\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if value, found, err := GetConfigValue(path, "server.port"); err != nil || !found || value != "abc" {
+		t.Fatalf("GetConfigValue = %q, %v, %v; want the invalid value as written", value, found, err)
+	}
+	if err := SetConfigValue(path, "server.port", "40082"); err != nil {
+		t.Fatalf("SetConfigValue: %v", err)
+	}
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig after repair: %v", err)
+	}
+	if cfg.Server.Port != 40082 {
+		t.Errorf("server.port = %d; want 40082", cfg.Server.Port)
+	}
+}
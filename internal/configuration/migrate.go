@@ -0,0 +1,402 @@
+package configuration
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/OG-Open-Source/PanelBase/internal/atomicfile"
+	"github.com/OG-Open-Source/PanelBase/internal/store"
+)
+
+// CurrentConfigVersion is the config.yaml schema version written by this build.
+const CurrentConfigVersion = "v3"
+
+// --- Config Migrations ---
+
+// ConfigMigration upgrades a config document from one schema version to the next.
+// Apply edits the YAML node tree in place (so comments and key order survive) and
+// returns a human-readable line for every change it made.
+type ConfigMigration struct {
+	From        string
+	To          string
+	Description string
+	Apply       func(root *yaml.Node) ([]string, error)
+}
+
+// configMigrations lists every config migration in order. Each From must equal the previous To.
+var configMigrations = []ConfigMigration{
+	{
+		From:        "v1",
+		To:          "v2",
+		Description: "drop the removed camelCase 'paths' section and add the server.admin_port and logging sections",
+		Apply:       migrateConfigV1ToV2,
+	},
+	{
+		From:        "v2",
+		To:          "v3",
+		Description: "add the configurable 'paths' section with snake_case keys",
+		Apply:       migrateConfigV2ToV3,
+	},
+}
+
+// MigrationReport describes what a config migration did.
+type MigrationReport struct {
+	Path        string
+	FromVersion string
+	ToVersion   string
+	BackupPath  string   // Copy of the file before migration; empty if nothing was written
+	Changes     []string // One line per change, prefixed with the migration step
+}
+
+// Changed reports whether the migration modified the config.
+func (r *MigrationReport) Changed() bool {
+	return r != nil && r.FromVersion != r.ToVersion
+}
+
+// MigrateConfigData applies every pending migration to a config document and returns the
+// migrated YAML. The report's BackupPath is left empty; MigrateConfigFile fills it.
+func MigrateConfigData(data []byte) ([]byte, *MigrationReport, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse config: %w", err)
+	}
+	if doc.Kind == 0 { // Empty file: nothing to migrate, defaults apply
+		return data, &MigrationReport{FromVersion: CurrentConfigVersion, ToVersion: CurrentConfigVersion}, nil
+	}
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, nil, fmt.Errorf("config must be a YAML mapping")
+	}
+	root := doc.Content[0]
+
+	version := "v1" // Files written before the version key existed are v1
+	if node := mappingValue(root, "version"); node != nil && node.Value != "" {
+		version = node.Value
+	}
+	report := &MigrationReport{FromVersion: version, ToVersion: version}
+	if version == CurrentConfigVersion {
+		return data, report, nil
+	}
+	if compareVersions(version, CurrentConfigVersion) > 0 {
+		return nil, nil, fmt.Errorf("config version '%s' is newer than the supported version '%s'; upgrade PanelBase", version, CurrentConfigVersion)
+	}
+
+	for _, migration := range configMigrations {
+		if migration.From != report.ToVersion {
+			continue
+		}
+		changes, err := migration.Apply(root)
+		if err != nil {
+			return nil, nil, fmt.Errorf("config migration %s -> %s failed: %w", migration.From, migration.To, err)
+		}
+		if mappingValue(root, "version") == nil {
+			// Files without a version key get one at the top, where defaults put it.
+			versionKey := &yaml.Node{Kind: yaml.ScalarNode, Value: "version"}
+			if len(root.Content) > 0 { // Keep a leading file comment above the new key
+				versionKey.HeadComment, root.Content[0].HeadComment = root.Content[0].HeadComment, ""
+			}
+			root.Content = append([]*yaml.Node{versionKey, {Kind: yaml.ScalarNode, Value: migration.To}}, root.Content...)
+		} else {
+			setMappingValue(root, "version", &yaml.Node{Kind: yaml.ScalarNode, Value: migration.To})
+		}
+		for _, change := range changes {
+			report.Changes = append(report.Changes, fmt.Sprintf("[%s -> %s] %s", migration.From, migration.To, change))
+		}
+		report.ToVersion = migration.To
+	}
+	if report.ToVersion != CurrentConfigVersion {
+		return nil, nil, fmt.Errorf("no migration path from config version '%s' to '%s'", version, CurrentConfigVersion)
+	}
+
+	out, err := yaml.Marshal(&doc)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encode migrated config: %w", err)
+	}
+	return out, report, nil
+}
+
+// MigrateConfigFile migrates the config file at path in place. Before writing, the original
+// file is copied to "<path>.<old version>.bak". A config that is already current is left untouched.
+func MigrateConfigFile(path string) (*MigrationReport, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file '%s': %w", path, err)
+	}
+	migrated, report, err := MigrateConfigData(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to migrate config file '%s': %w", path, err)
+	}
+	report.Path = path
+	if !report.Changed() {
+		return report, nil
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat config file '%s': %w", path, err)
+	}
+	report.BackupPath = fmt.Sprintf("%s.%s.bak", path, report.FromVersion)
+	if err := atomicfile.WriteFile(report.BackupPath, data, info.Mode().Perm()); err != nil {
+		return nil, fmt.Errorf("failed to write config backup '%s': %w", report.BackupPath, err)
+	}
+	if err := atomicfile.WriteFile(path, migrated, info.Mode().Perm()); err != nil {
+		return nil, fmt.Errorf("failed to write migrated config file '%s': %w", path, err)
+	}
+	return report, nil
+}
+
+// Summary describes the migration as human-readable lines.
+func (r *MigrationReport) Summary() []string {
+	if !r.Changed() {
+		return []string{fmt.Sprintf("Config file '%s' is already at version %s.", r.Path, r.ToVersion)}
+	}
+	lines := []string{fmt.Sprintf("Migrated config file '%s' from %s to %s (backup: '%s'):", r.Path, r.FromVersion, r.ToVersion, r.BackupPath)}
+	for _, change := range r.Changes {
+		lines = append(lines, "  - "+change)
+	}
+	return lines
+}
+
+// PrintMigrationReport prints a config migration report to stdout.
+func PrintMigrationReport(report *MigrationReport) {
+	for _, line := range report.Summary() {
+		fmt.Println("Info: " + line)
+	}
+}
+
+// migrateConfigV1ToV2 removes the 'paths' section (dropped from Config without a migration, so it
+// was silently ignored) and adds the sections introduced since v1 with their default values.
+func migrateConfigV1ToV2(root *yaml.Node) ([]string, error) {
+	var changes []string
+
+	if paths := mappingValue(root, "paths"); paths != nil {
+		var values []string
+		if paths.Kind == yaml.MappingNode {
+			for i := 0; i+1 < len(paths.Content); i += 2 {
+				values = append(values, fmt.Sprintf("%s=%s", paths.Content[i].Value, paths.Content[i+1].Value))
+			}
+		}
+		deleteMappingKey(root, "paths")
+		changes = append(changes, fmt.Sprintf("removed unused 'paths' section (%s); default directories are used", strings.Join(values, ", ")))
+	}
+
+	server := mappingValue(root, "server")
+	if server == nil {
+		server = &yaml.Node{Kind: yaml.MappingNode}
+		setMappingValue(root, "server", server)
+	}
+	if server.Kind == yaml.MappingNode && mappingValue(server, "admin_port") == nil {
+		setMappingValue(server, "admin_port", &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: "0"})
+		changes = append(changes, "added server.admin_port: 0 (metrics endpoint disabled)")
+	}
+
+	if mappingValue(root, "logging") == nil {
+		logging := &yaml.Node{}
+		if err := logging.Encode(LoggingConfig{Plugins: PluginLoggingConfig{
+			DefaultLevel: defaultPluginLogLevel,
+			Levels:       map[string]string{},
+			RateLimit:    RateLimitConfig{PerSecond: defaultPluginLogPerSecond, Burst: defaultPluginLogBurst},
+		}}); err != nil {
+			return nil, fmt.Errorf("failed to encode default logging section: %w", err)
+		}
+		setMappingValue(root, "logging", logging)
+		changes = append(changes, "added 'logging' section with default plugin log settings")
+	}
+	return changes, nil
+}
+
+// legacyPathKeys maps the camelCase 'paths' keys of v1 builds to their v3 names. v2 builds
+// ignored the section, so a v2 file only has them if they were added back by hand.
+var legacyPathKeys = map[string]string{
+	"themesDir":     "themes_dir",
+	"pluginsDir":    "plugins_dir",
+	"commandsDir":   "commands_dir",
+	"containersDir": "containers_dir",
+}
+
+// migrateConfigV2ToV3 adds the 'paths' section that makes the directories configurable. Legacy
+// camelCase keys are renamed, and every missing key is added with its default, which is the
+// layout v2 builds used.
+func migrateConfigV2ToV3(root *yaml.Node) ([]string, error) {
+	var changes []string
+
+	paths := mappingValue(root, "paths")
+	if paths == nil {
+		paths = &yaml.Node{Kind: yaml.MappingNode}
+		setMappingValue(root, "paths", paths)
+	}
+	if paths.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("'paths' must be a mapping")
+	}
+	for i := 0; i+1 < len(paths.Content); i += 2 {
+		key := paths.Content[i]
+		if newKey, ok := legacyPathKeys[key.Value]; ok && mappingValue(paths, newKey) == nil {
+			changes = append(changes, fmt.Sprintf("renamed paths.%s to paths.%s (%s)", key.Value, newKey, paths.Content[i+1].Value))
+			key.Value = newKey
+		}
+	}
+
+	defaults := &yaml.Node{}
+	if err := defaults.Encode(applyDefaults(&Config{}).Paths); err != nil {
+		return nil, fmt.Errorf("failed to encode default paths section: %w", err)
+	}
+	for i := 0; i+1 < len(defaults.Content); i += 2 {
+		key, value := defaults.Content[i].Value, defaults.Content[i+1]
+		if mappingValue(paths, key) == nil {
+			setMappingValue(paths, key, value)
+			changes = append(changes, fmt.Sprintf("added paths.%s: %s", key, value.Value))
+		}
+	}
+	return changes, nil
+}
+
+// --- YAML node helpers ---
+
+// mappingValue returns the value node for key in a mapping node, or nil.
+func mappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	if mapping == nil || mapping.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// setMappingValue replaces the value for key, or appends the pair if the key is missing.
+func setMappingValue(mapping *yaml.Node, key string, value *yaml.Node) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			// Keep comments attached to the old value.
+			value.HeadComment, value.LineComment, value.FootComment = mapping.Content[i+1].HeadComment, mapping.Content[i+1].LineComment, mapping.Content[i+1].FootComment
+			mapping.Content[i+1] = value
+			return
+		}
+	}
+	mapping.Content = append(mapping.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, value)
+}
+
+// deleteMappingKey removes key and its value from a mapping node.
+func deleteMappingKey(mapping *yaml.Node, key string) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			mapping.Content = append(mapping.Content[:i], mapping.Content[i+2:]...)
+			return
+		}
+	}
+}
+
+// compareVersions compares "vN" version strings numerically. Unparsable versions sort last,
+// so an unknown version is treated as newer than this build supports.
+func compareVersions(a, b string) int {
+	na, errA := strconv.Atoi(strings.TrimPrefix(a, "v"))
+	nb, errB := strconv.Atoi(strings.TrimPrefix(b, "v"))
+	switch {
+	case errA != nil && errB != nil:
+		return strings.Compare(a, b)
+	case errA != nil:
+		return 1
+	case errB != nil:
+		return -1
+	case na < nb:
+		return -1
+	case na > nb:
+		return 1
+	}
+	return 0
+}
+
+// --- State Migrations ---
+
+// stateSchemaKey holds the state schema version in the meta bucket.
+const stateSchemaKey = "schema_version"
+
+// StateMigration upgrades the data in the state store to schema Version.
+// Apply runs inside the migration transaction and returns a line per change.
+type StateMigration struct {
+	Version     int
+	Description string
+	Apply       func(tx *store.Tx) ([]string, error)
+}
+
+// stateMigrations lists every state migration in ascending Version order.
+var stateMigrations = []StateMigration{
+	{
+		Version:     1,
+		Description: "rename the themes key 'last_updated_at' to 'last_updated'",
+		Apply:       migrateStateThemeLastUpdated,
+	},
+}
+
+// migrateStateSchema applies pending state migrations in one transaction and prints what changed.
+func migrateStateSchema(st *store.Store) error {
+	var changes []string
+	var from, to int
+	err := st.Update(func(tx *store.Tx) error {
+		changes = nil
+		if _, err := tx.Get(store.BucketMeta, stateSchemaKey, &from); err != nil {
+			return err
+		}
+		to = from
+		for _, migration := range stateMigrations {
+			if migration.Version <= from {
+				continue
+			}
+			applied, err := migration.Apply(tx)
+			if err != nil {
+				return fmt.Errorf("state migration %d (%s) failed: %w", migration.Version, migration.Description, err)
+			}
+			for _, change := range applied {
+				changes = append(changes, fmt.Sprintf("[state v%d] %s", migration.Version, change))
+			}
+			to = migration.Version
+		}
+		if to == from {
+			return nil
+		}
+		return tx.Put(store.BucketMeta, stateSchemaKey, to)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to migrate state store '%s': %w", st.Path(), err)
+	}
+	if len(changes) > 0 {
+		fmt.Printf("Info: Migrated state store '%s' from schema %d to %d:\n", st.Path(), from, to)
+		for _, change := range changes {
+			fmt.Printf("  - %s\n", change)
+		}
+	}
+	return nil
+}
+
+// migrateStateThemeLastUpdated renames the legacy 'last_updated_at' key of theme entries.
+// The entries are edited as raw JSON because the typed entry no longer has the old key.
+func migrateStateThemeLastUpdated(tx *store.Tx) ([]string, error) {
+	var changes []string
+	for _, key := range tx.Keys(store.BucketThemes) {
+		raw, _ := tx.GetRaw(store.BucketThemes, key)
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &fields); err != nil {
+			return nil, fmt.Errorf("failed to decode theme entry '%s': %w", key, err)
+		}
+		old, ok := fields["last_updated_at"]
+		if !ok {
+			continue
+		}
+		delete(fields, "last_updated_at")
+		if current, exists := fields["last_updated"]; !exists || bytes.Equal(current, []byte(`""`)) {
+			fields["last_updated"] = old
+		}
+		if err := tx.Put(store.BucketThemes, key, fields); err != nil {
+			return nil, err
+		}
+		changes = append(changes, fmt.Sprintf("theme '%s': renamed last_updated_at to last_updated", key))
+	}
+	return changes, nil
+}
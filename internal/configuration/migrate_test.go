@@ -0,0 +1,68 @@
+package configuration
+
+import (
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestMigrateConfigDataFromV1(t *testing.T) {
+	input := []byte("server:\n    host: 127.0.0.1 # keep me\n    port: 40082\npaths:\n    themesDir: ext/themes\n")
+	out, report, err := MigrateConfigData(input)
+	if err != nil {
+		t.Fatalf("MigrateConfigData: %v", err)
+	}
+	if report.FromVersion != "v1" || report.ToVersion != CurrentConfigVersion || len(report.Changes) == 0 {
+		t.Fatalf("report = %+v; want v1 -> %s with changes", report, CurrentConfigVersion)
+	}
+	if !strings.Contains(string(out), "# keep me") {
+		t.Errorf("migrated config lost a comment:\n%s", out)
+	}
+
+	var cfg map[string]interface{}
+	if err := yaml.Unmarshal(out, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg["version"] != CurrentConfigVersion {
+		t.Errorf("version = %v; want %s", cfg["version"], CurrentConfigVersion)
+	}
+	// v1 'paths' keys were ignored and are dropped by v1 -> v2; v2 -> v3 adds the defaults.
+	paths, _ := cfg["paths"].(map[string]interface{})
+	if _, ok := paths["themesDir"]; ok || paths["themes_dir"] != "ext/themes" {
+		t.Errorf("paths = %v; want the v1 keys dropped and defaults added", paths)
+	}
+
+	// Running again is a no-op.
+	again, report, err := MigrateConfigData(out)
+	if err != nil || report.Changed() || string(again) != string(out) {
+		t.Fatalf("second migration changed the config (err=%v, report=%+v)", err, report)
+	}
+}
+
+func TestMigrateConfigDataFromV2RenamesPathKeys(t *testing.T) {
+	input := []byte("version: v2\npaths:\n    pluginsDir: /srv/plugins # moved\n    logs_dir: /var/log/panelbase\n")
+	out, report, err := MigrateConfigData(input)
+	if err != nil {
+		t.Fatalf("MigrateConfigData: %v", err)
+	}
+	if report.FromVersion != "v2" || report.ToVersion != CurrentConfigVersion {
+		t.Fatalf("report = %+v; want v2 -> %s", report, CurrentConfigVersion)
+	}
+	var cfg Config
+	if err := yaml.Unmarshal(out, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Paths.PluginsDir != "/srv/plugins" || cfg.Paths.LogsDir != "/var/log/panelbase" || cfg.Paths.ThemesDir != "ext/themes" {
+		t.Errorf("paths = %+v; want pluginsDir renamed, logs_dir kept and defaults added", cfg.Paths)
+	}
+	if !strings.Contains(string(out), "# moved") {
+		t.Errorf("migrated config lost a comment:\n%s", out)
+	}
+}
+
+func TestMigrateConfigDataRejectsNewerVersion(t *testing.T) {
+	if _, _, err := MigrateConfigData([]byte("version: v99\n")); err == nil {
+		t.Fatal("expected an error for a config newer than this build")
+	}
+}
@@ -0,0 +1,245 @@
+package configuration
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix starts the name of every environment variable that overrides a config key.
+// The rest of the name is the dotted YAML key in upper case with dots replaced by
+// underscores, e.g. server.port -> PANELBASE_SERVER_PORT.
+const EnvPrefix = "PANELBASE_"
+
+// Sources of a configuration value, from lowest to highest precedence.
+const (
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceEnv     = "env"
+	SourceFlag    = "flag"
+)
+
+// flagOverrides holds the --set key=value pairs given on the command line.
+var flagOverrides map[string]string
+
+// SetFlagOverrides sets the command-line overrides applied by LoadConfig on top of the file
+// and the environment. Keys are dotted YAML keys (e.g. server.port).
+func SetFlagOverrides(overrides map[string]string) {
+	flagOverrides = overrides
+}
+
+// ParseOverrides parses "key=value" strings (as given to --set) and checks that every key
+// names an overridable config key.
+func ParseOverrides(pairs []string) (map[string]string, error) {
+	known := make(map[string]bool)
+	for _, field := range configFields(&Config{}) {
+		if field.overridable() {
+			known[field.Key] = true
+		}
+	}
+	overrides := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		key, value, ok := strings.Cut(pair, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid override '%s': expected key=value", pair)
+		}
+		if !known[key] {
+			return nil, fmt.Errorf("unknown config key '%s' in override '%s'", key, pair)
+		}
+		overrides[key] = value
+	}
+	return overrides, nil
+}
+
+// configField is one leaf of the Config tree, addressed by its dotted YAML key.
+type configField struct {
+	Key   string        // Dotted YAML key, e.g. server.port
+	Env   string        // Environment variable overriding the key, e.g. PANELBASE_SERVER_PORT
+	value reflect.Value // Addressable field inside the Config it was taken from
+}
+
+// overridable reports whether the key may be set from the environment or flags. The schema
+// version only describes the file (and PANELBASE_VERSION is too common a variable name).
+func (f configField) overridable() bool {
+	return f.Key != "version"
+}
+
+// configFields lists the leaves of cfg in declaration order. Nested structs are walked;
+// maps (e.g. logging.plugins.levels) are leaves.
+func configFields(cfg *Config) []configField {
+	var fields []configField
+	var walk func(v reflect.Value, prefix string)
+	walk = func(v reflect.Value, prefix string) {
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			name := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+			if name == "" || name == "-" {
+				continue
+			}
+			key := name
+			if prefix != "" {
+				key = prefix + "." + name
+			}
+			if v.Field(i).Kind() == reflect.Struct {
+				walk(v.Field(i), key)
+				continue
+			}
+			fields = append(fields, configField{
+				Key:   key,
+				Env:   EnvPrefix + strings.ToUpper(strings.ReplaceAll(key, ".", "_")),
+				value: v.Field(i),
+			})
+		}
+	}
+	walk(reflect.ValueOf(cfg).Elem(), "")
+	return fields
+}
+
+// setFieldValue parses raw into the field. Maps take "key=value,key=value".
+func setFieldValue(field configField, raw string) error {
+	v := field.value
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Int:
+		n, err := strconv.Atoi(strings.TrimSpace(raw))
+		if err != nil {
+			return fmt.Errorf("invalid integer '%s' for '%s'", raw, field.Key)
+		}
+		v.SetInt(int64(n))
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(strings.TrimSpace(raw), 64)
+		if err != nil {
+			return fmt.Errorf("invalid number '%s' for '%s'", raw, field.Key)
+		}
+		v.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(strings.TrimSpace(raw))
+		if err != nil {
+			return fmt.Errorf("invalid boolean '%s' for '%s'", raw, field.Key)
+		}
+		v.SetBool(b)
+	case reflect.Map:
+		m := reflect.MakeMap(v.Type())
+		for _, pair := range strings.Split(raw, ",") {
+			if strings.TrimSpace(pair) == "" {
+				continue
+			}
+			k, val, ok := strings.Cut(pair, "=")
+			if !ok {
+				return fmt.Errorf("invalid entry '%s' for '%s': expected key=value", pair, field.Key)
+			}
+			m.SetMapIndex(reflect.ValueOf(strings.TrimSpace(k)), reflect.ValueOf(strings.TrimSpace(val)))
+		}
+		v.Set(m)
+	default:
+		return fmt.Errorf("config key '%s' cannot be overridden", field.Key)
+	}
+	return nil
+}
+
+// formatFieldValue renders a field the way setFieldValue parses it.
+func formatFieldValue(v reflect.Value) string {
+	if v.Kind() != reflect.Map {
+		return fmt.Sprint(v.Interface())
+	}
+	pairs := make([]string, 0, v.Len())
+	for _, k := range v.MapKeys() {
+		pairs = append(pairs, fmt.Sprintf("%v=%v", k.Interface(), v.MapIndex(k).Interface()))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// fileKeys returns every dotted key (intermediate and leaf) present in a YAML document.
+func fileKeys(data []byte) map[string]bool {
+	keys := make(map[string]bool)
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil || len(doc.Content) == 0 {
+		return keys
+	}
+	var walk func(n *yaml.Node, prefix string)
+	walk = func(n *yaml.Node, prefix string) {
+		if n.Kind != yaml.MappingNode {
+			return
+		}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key := n.Content[i].Value
+			if prefix != "" {
+				key = prefix + "." + key
+			}
+			keys[key] = true
+			walk(n.Content[i+1], key)
+		}
+	}
+	walk(doc.Content[0], "")
+	return keys
+}
+
+// applyOverrides records where each value of cfg came from and applies environment and flag
+// overrides (flags win). Keys missing from the file are left for applyDefaults.
+func applyOverrides(cfg *Config, path string, inFile map[string]bool) error {
+	cfg.Sources = make(map[string]string)
+	for _, field := range configFields(cfg) {
+		if inFile[field.Key] {
+			cfg.Sources[field.Key] = fmt.Sprintf("%s '%s'", SourceFile, path)
+		}
+		if !field.overridable() {
+			continue
+		}
+		if raw, ok := os.LookupEnv(field.Env); ok {
+			if err := setFieldValue(field, raw); err != nil {
+				return fmt.Errorf("environment variable %s: %w", field.Env, err)
+			}
+			cfg.Sources[field.Key] = SourceEnv + " " + field.Env
+		}
+		if raw, ok := flagOverrides[field.Key]; ok {
+			if err := setFieldValue(field, raw); err != nil {
+				return fmt.Errorf("--set %s: %w", field.Key, err)
+			}
+			cfg.Sources[field.Key] = SourceFlag + " --set"
+		}
+	}
+	return nil
+}
+
+// applyDefaultsTracked runs applyDefaults and marks every value it filled in or replaced
+// as coming from the defaults.
+func applyDefaultsTracked(cfg *Config) {
+	before := make(map[string]string)
+	for _, field := range configFields(cfg) {
+		before[field.Key] = formatFieldValue(field.value)
+	}
+	applyDefaults(cfg)
+	for _, field := range configFields(cfg) {
+		if _, ok := cfg.Sources[field.Key]; !ok || before[field.Key] != formatFieldValue(field.value) {
+			cfg.Sources[field.Key] = SourceDefault
+		}
+	}
+}
+
+// EffectiveValue is one key of the merged configuration.
+type EffectiveValue struct {
+	Key    string
+	Value  string
+	Source string // e.g. "default", "file 'configs/config.yaml'", "env PANELBASE_SERVER_PORT", "flag --set"
+}
+
+// EffectiveValues lists every key of cfg with its value and source, in declaration order.
+func EffectiveValues(cfg *Config) []EffectiveValue {
+	var values []EffectiveValue
+	for _, field := range configFields(cfg) {
+		source := cfg.Sources[field.Key]
+		if source == "" {
+			source = SourceDefault
+		}
+		values = append(values, EffectiveValue{Key: field.Key, Value: formatFieldValue(field.value), Source: source})
+	}
+	return values
+}
@@ -0,0 +1,48 @@
+package configuration
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfigOverridePrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	data := "version: v3\nserver:\n    host: 10.0.0.1\n    port: 2000\n    admin_port: 3000\n"
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PANELBASE_SERVER_PORT", "4000")
+	t.Setenv("PANELBASE_SERVER_ADMIN_PORT", "5000")
+	overrides, err := ParseOverrides([]string{"server.admin_port=6000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetFlagOverrides(overrides)
+	defer SetFlagOverrides(nil)
+
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if cfg.Server.Host != "10.0.0.1" || cfg.Server.Port != 4000 || cfg.Server.AdminPort != 6000 {
+		t.Fatalf("server = %+v; want host from file, port from env, admin_port from flag", cfg.Server)
+	}
+	want := map[string]string{
+		"server.host":                      SourceFile,
+		"server.port":                      SourceEnv,
+		"server.admin_port":                SourceFlag,
+		"security.secrets.length":          SourceDefault,
+		"logging.plugins.rate_limit.burst": SourceDefault,
+	}
+	for key, source := range want {
+		if got := cfg.Sources[key]; !strings.HasPrefix(got, source) {
+			t.Errorf("source of %s = %q; want %s", key, got, source)
+		}
+	}
+
+	if _, err := ParseOverrides([]string{"server.nope=1"}); err == nil {
+		t.Error("expected an error for an unknown key")
+	}
+}
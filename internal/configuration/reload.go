@@ -0,0 +1,200 @@
+package configuration
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultReloadInterval is how often a Reloader checks the config file for changes.
+const DefaultReloadInterval = 2 * time.Second
+
+// runtimeReloadableKeys lists the keys (or key prefixes ending in '.') whose new values can be
+// applied while the server runs. Every other key needs a restart.
+var runtimeReloadableKeys = []string{
+	"server.host",                  // Applies to containers started afterwards
+	"server.template_poll_seconds", // Applies to containers started afterwards
+	"security.secrets.",
+	"logging.",
+}
+
+// Change is one key whose effective value differs between two configs.
+type Change struct {
+	Key      string
+	OldValue string
+	NewValue string
+}
+
+// NeedsRestart reports whether the change only takes effect after a server restart.
+func (c Change) NeedsRestart() bool {
+	for _, key := range runtimeReloadableKeys {
+		if c.Key == key || (strings.HasSuffix(key, ".") && strings.HasPrefix(c.Key, key)) {
+			return false
+		}
+	}
+	return true
+}
+
+// Diff lists the keys whose effective values differ between old and new, in declaration order.
+// Keys defaulted in both configs are skipped: the only non-deterministic default (the random
+// server.port) would otherwise show up as a change on every reload.
+func Diff(old, new *Config) []Change {
+	oldValues := EffectiveValues(old)
+	var changes []Change
+	for i, value := range EffectiveValues(new) {
+		before := oldValues[i]
+		if before.Value == value.Value || (before.Source == SourceDefault && value.Source == SourceDefault) {
+			continue
+		}
+		changes = append(changes, Change{Key: value.Key, OldValue: before.Value, NewValue: value.Value})
+	}
+	return changes
+}
+
+// Reloader reloads the config file when its contents change (checked by polling the
+// modification time, then the SHA-256 of the contents) or when Reload is called, e.g. on SIGHUP.
+// A config that fails to load or has validation errors is rejected and the current one is kept.
+type Reloader struct {
+	path     string
+	interval time.Duration
+
+	applyMu sync.Mutex // Held from loading a config until its callback returns, so callbacks run in order
+
+	mu       sync.Mutex // Guards the fields below
+	current  *Config
+	modTime  time.Time
+	size     int64
+	hash     [sha256.Size]byte
+	onReload func(old, new *Config, changes []Change)
+	onError  func(err error)
+}
+
+// NewReloader creates a Reloader for the config file at path, currently loaded as current.
+// interval <= 0 uses DefaultReloadInterval.
+func NewReloader(path string, current *Config, interval time.Duration) (*Reloader, error) {
+	if current == nil {
+		return nil, fmt.Errorf("current config cannot be nil")
+	}
+	if interval <= 0 {
+		interval = DefaultReloadInterval
+	}
+	r := &Reloader{path: path, interval: interval, current: current}
+	if info, err := os.Stat(path); err == nil {
+		r.modTime, r.size = info.ModTime(), info.Size()
+	}
+	if data, err := os.ReadFile(path); err == nil {
+		r.hash = sha256.Sum256(data)
+	}
+	return r, nil
+}
+
+// OnReload sets the function called after a new config was loaded. changes is empty when the
+// file changed without changing any effective value. Must be called before Start.
+func (r *Reloader) OnReload(fn func(old, new *Config, changes []Change)) {
+	r.onReload = fn
+}
+
+// OnError sets the function called when a changed config file is rejected. Must be called before Start.
+func (r *Reloader) OnError(fn func(err error)) {
+	r.onError = fn
+}
+
+// Current returns the config in effect.
+func (r *Reloader) Current() *Config {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.current
+}
+
+// Start polls the config file until stop is closed.
+func (r *Reloader) Start(stop <-chan struct{}) {
+	go func() {
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				r.poll()
+			}
+		}
+	}()
+}
+
+// poll reloads the config if the file's contents changed since the last check.
+func (r *Reloader) poll() {
+	r.mu.Lock()
+	info, err := os.Stat(r.path)
+	if err != nil || (info.ModTime().Equal(r.modTime) && info.Size() == r.size) {
+		r.mu.Unlock()
+		return // Missing files are reported by an explicit Reload only; editors may briefly remove them
+	}
+	r.modTime, r.size = info.ModTime(), info.Size()
+	data, err := os.ReadFile(r.path)
+	if err != nil || sha256.Sum256(data) == r.hash {
+		r.mu.Unlock()
+		return // Touched but not changed
+	}
+	r.mu.Unlock()
+	r.Reload()
+}
+
+// Reload loads the config file now. On failure the current config is kept, the OnError
+// function is called and the error is returned. Concurrent reloads (a poll and a SIGHUP) run
+// one after the other, so OnReload never sees two configs at once or an older one last.
+func (r *Reloader) Reload() error {
+	r.applyMu.Lock()
+	defer r.applyMu.Unlock()
+	r.mu.Lock()
+	old, newCfg, changes, err := r.reloadLocked()
+	onReload, onError := r.onReload, r.onError
+	r.mu.Unlock()
+
+	if err != nil {
+		if onError != nil {
+			onError(err)
+		}
+		return err
+	}
+	if onReload != nil {
+		onReload(old, newCfg, changes)
+	}
+	return nil
+}
+
+func (r *Reloader) reloadLocked() (old, newCfg *Config, changes []Change, err error) {
+	data, err := os.ReadFile(r.path)
+	if err != nil {
+		// Checked first: LoadConfig would write a fresh default config in place of a missing default file.
+		return nil, nil, nil, fmt.Errorf("failed to read config file '%s': %w", r.path, err)
+	}
+	r.hash = sha256.Sum256(data)
+
+	// A running server is never switched to a config with errors, strict mode or not.
+	issues, err := ValidateConfigFile(r.path)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("config reload rejected, keeping the current config: %w", err)
+	}
+	if HasErrors(issues) {
+		return nil, nil, nil, fmt.Errorf("config reload rejected, keeping the current config: %w", &ValidationError{Path: r.path, Issues: issues})
+	}
+
+	newCfg, err = LoadConfig(r.path)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("config reload rejected, keeping the current config: %w", err)
+	}
+	if data, err := os.ReadFile(r.path); err == nil {
+		r.hash = sha256.Sum256(data) // LoadConfig may have migrated the file
+	}
+	if info, err := os.Stat(r.path); err == nil {
+		r.modTime, r.size = info.ModTime(), info.Size()
+	}
+	old = r.current
+	changes = Diff(old, newCfg)
+	r.current = newCfg
+	return old, newCfg, changes, nil
+}
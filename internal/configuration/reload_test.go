@@ -0,0 +1,87 @@
+package configuration
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestReloaderAppliesValidChangesOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	write := func(data string) {
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("version: v3\nserver:\n    port: 2000\nlogging:\n    plugins:\n        default_level: info\n")
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewReloader(path, cfg, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []Change
+	r.OnReload(func(old, new *Config, changes []Change) { got = changes })
+
+	write("version: v3\nserver:\n    port: 3000\nlogging:\n    plugins:\n        default_level: debug\n")
+	if err := r.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if len(got) != 2 || got[0].Key != "server.port" || !got[0].NeedsRestart() ||
+		got[1].Key != "logging.plugins.default_level" || got[1].NeedsRestart() {
+		t.Fatalf("changes = %+v; want server.port (restart) and logging.plugins.default_level (runtime)", got)
+	}
+
+	write("version: v3\nserver:\n    port: 3000\nlogging:\n    plugins:\n        default_level: loud\n")
+	if err := r.Reload(); err == nil {
+		t.Fatal("expected an invalid config to be rejected")
+	}
+	if level := r.Current().Logging.Plugins.DefaultLevel; level != "debug" {
+		t.Errorf("current default_level = %s; want the last valid value 'debug'", level)
+	}
+}
+
+func TestReloaderRunsCallbacksOneAtATime(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("version: v3\nstrict: false\nserver:\n    port: 2000\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewReloader(path, cfg, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var active, overlaps atomic.Int32
+	r.OnReload(func(old, new *Config, changes []Change) {
+		if active.Add(1) > 1 {
+			overlaps.Add(1)
+		}
+		time.Sleep(10 * time.Millisecond)
+		active.Add(-1)
+	})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.Reload()
+		}()
+	}
+	wg.Wait()
+	if n := overlaps.Load(); n != 0 {
+		t.Errorf("%d reload callbacks overlapped", n)
+	}
+
+	// strict only affects how the config is loaded at startup.
+	if change := (Change{Key: "strict"}); !change.NeedsRestart() {
+		t.Errorf("strict is reported as applied at runtime")
+	}
+}
@@ -0,0 +1,357 @@
+package configuration
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/OG-Open-Source/PanelBase/internal/atomicfile"
+	"github.com/OG-Open-Source/PanelBase/internal/logger"
+	"github.com/OG-Open-Source/PanelBase/internal/store"
+)
+
+// --- Extension State (backed by the embedded state store) ---
+
+// Typed buckets of the state store holding installed extensions, keyed by local ID (themes,
+// plugins) or script filename (commands).
+var (
+	ThemesBucket   = store.Bucket[InstalledThemeEntry]{Name: store.BucketThemes}
+	PluginsBucket  = store.Bucket[InstalledPluginEntry]{Name: store.BucketPlugins}
+	CommandsBucket = store.Bucket[InstalledCommandEntry]{Name: store.BucketCommands}
+)
+
+// legacyMigratedKey is set in the meta bucket once the legacy JSON state files were imported.
+const legacyMigratedKey = "legacy_json_migrated"
+
+var (
+	stateStoresMu    sync.Mutex
+	stateStores      = make(map[string]*store.Store) // Open stores keyed by path, shared by all managers of the process
+	defaultStorePath = store.DefaultPath             // Changed by SetStateDir
+
+	stateLogger  *logger.Logger // Set by SetStateLogger; receives notices from opening the store
+	stateNotices []string       // Notices produced before a logger was set
+)
+
+// SetStateDir sets the directory of the default state store (paths.state_dir).
+// It must be called before the store is first used.
+func SetStateDir(dir string) {
+	stateStoresMu.Lock()
+	defer stateStoresMu.Unlock()
+	defaultStorePath = filepath.Join(dir, filepath.Base(store.DefaultPath))
+}
+
+// SetStateLogger sets the logger that receives notices from opening the state store, such as
+// migrated or restored legacy state files, and logs the notices collected before it was set.
+func SetStateLogger(l *logger.Logger) {
+	stateStoresMu.Lock()
+	defer stateStoresMu.Unlock()
+	stateLogger = l
+	if l == nil {
+		return
+	}
+	for _, notice := range stateNotices {
+		l.Logf("State: %s", notice)
+	}
+	stateNotices = nil
+}
+
+// logStateNotices logs notices through the state logger, or keeps them for SetStateLogger.
+// The caller must hold stateStoresMu.
+func logStateNotices(notices []string) {
+	if stateLogger == nil {
+		stateNotices = append(stateNotices, notices...)
+		return
+	}
+	for _, notice := range notices {
+		stateLogger.Logf("State: %s", notice)
+	}
+}
+
+// OpenStateStore returns the process-wide state store at storePath (default: state.db in the
+// state directory). The first call for a path opens the store and imports legacy themes.json,
+// plugins.json and commands.json files found next to it, renaming them to *.migrated.
+func OpenStateStore(storePath ...string) (*store.Store, error) {
+	stateStoresMu.Lock()
+	path := defaultStorePath
+	stateStoresMu.Unlock()
+	if len(storePath) > 0 && storePath[0] != "" {
+		path = storePath[0]
+	}
+	stateStoresMu.Lock()
+	defer stateStoresMu.Unlock()
+	if st, ok := stateStores[path]; ok {
+		return st, nil
+	}
+	st, err := store.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open state store '%s': %w", path, err)
+	}
+	notices, err := migrateLegacyState(st, filepath.Dir(path))
+	logStateNotices(notices)
+	if err != nil {
+		return nil, err
+	}
+	if err := migrateStateSchema(st); err != nil {
+		return nil, err
+	}
+	stateStores[path] = st
+	return st, nil
+}
+
+// UpdateState runs fn in a single store transaction, so changes to several buckets
+// (e.g., an extension and the containers using it) are committed together or not at all.
+func UpdateState(fn func(tx *store.Tx) error, storePath ...string) error {
+	st, err := OpenStateStore(storePath...)
+	if err != nil {
+		return err
+	}
+	return st.Update(fn)
+}
+
+// LoadThemesState loads the installed themes from the state store.
+func LoadThemesState(storePath ...string) (map[string]InstalledThemeEntry, error) {
+	return loadBucket(ThemesBucket, storePath...)
+}
+
+// SaveThemesState replaces the installed themes in the state store.
+func SaveThemesState(themes map[string]InstalledThemeEntry, storePath ...string) error {
+	return saveBucket(ThemesBucket, themes, storePath...)
+}
+
+// UpdateThemesState runs a transactional load-modify-save cycle on the installed themes, so
+// concurrent CLI invocations and the server cannot overwrite each other's changes. fn modifies
+// the map in place; if it returns an error nothing is saved.
+func UpdateThemesState(fn func(themes map[string]InstalledThemeEntry) error, storePath ...string) error {
+	return updateBucket(ThemesBucket, fn, storePath...)
+}
+
+// LoadPluginsState loads the installed plugins from the state store.
+func LoadPluginsState(storePath ...string) (map[string]InstalledPluginEntry, error) {
+	return loadBucket(PluginsBucket, storePath...)
+}
+
+// SavePluginsState replaces the installed plugins in the state store.
+func SavePluginsState(plugins map[string]InstalledPluginEntry, storePath ...string) error {
+	return saveBucket(PluginsBucket, plugins, storePath...)
+}
+
+// UpdatePluginsState runs a transactional load-modify-save cycle on the installed plugins.
+func UpdatePluginsState(fn func(plugins map[string]InstalledPluginEntry) error, storePath ...string) error {
+	return updateBucket(PluginsBucket, fn, storePath...)
+}
+
+// LoadCommandsState loads the installed commands from the state store.
+func LoadCommandsState(storePath ...string) (map[string]InstalledCommandEntry, error) {
+	return loadBucket(CommandsBucket, storePath...)
+}
+
+// SaveCommandsState replaces the installed commands in the state store.
+func SaveCommandsState(commands map[string]InstalledCommandEntry, storePath ...string) error {
+	return saveBucket(CommandsBucket, commands, storePath...)
+}
+
+// UpdateCommandsState runs a transactional load-modify-save cycle on the installed commands.
+func UpdateCommandsState(fn func(commands map[string]InstalledCommandEntry) error, storePath ...string) error {
+	return updateBucket(CommandsBucket, fn, storePath...)
+}
+
+func loadBucket[T any](bucket store.Bucket[T], storePath ...string) (map[string]T, error) {
+	st, err := OpenStateStore(storePath...)
+	if err != nil {
+		return nil, err
+	}
+	var result map[string]T
+	err = st.View(func(tx *store.Tx) error {
+		var viewErr error
+		result, viewErr = bucket.All(tx)
+		return viewErr
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %s state: %w", bucket.Name, err)
+	}
+	return result, nil
+}
+
+func saveBucket[T any](bucket store.Bucket[T], values map[string]T, storePath ...string) error {
+	return UpdateState(func(tx *store.Tx) error {
+		return bucket.Replace(tx, values)
+	}, storePath...)
+}
+
+func updateBucket[T any](bucket store.Bucket[T], fn func(map[string]T) error, storePath ...string) error {
+	return UpdateState(func(tx *store.Tx) error {
+		values, err := bucket.All(tx)
+		if err != nil {
+			return err
+		}
+		if err := fn(values); err != nil {
+			return err
+		}
+		return bucket.Replace(tx, values)
+	}, storePath...)
+}
+
+// --- Legacy JSON state migration ---
+
+// migrateLegacyState imports the pre-store JSON state files from dir into st in one transaction,
+// then renames them to *.migrated so they are not imported again. The returned notices
+// describe what was migrated or restored, for the caller to log.
+func migrateLegacyState(st *store.Store, dir string) ([]string, error) {
+	themesPath := filepath.Join(dir, legacyThemesStateFile)
+	pluginsPath := filepath.Join(dir, legacyPluginsStateFile)
+	commandsPath := filepath.Join(dir, legacyCommandsStateFile)
+
+	var present []string
+	for _, p := range []string{themesPath, pluginsPath, commandsPath} {
+		if _, err := os.Stat(p); err == nil {
+			present = append(present, p)
+		}
+	}
+	if len(present) == 0 {
+		return nil, nil
+	}
+
+	var notices []string
+	imported := 0
+	err := st.Update(func(tx *store.Tx) error {
+		var done bool
+		if _, err := tx.Get(store.BucketMeta, legacyMigratedKey, &done); err != nil {
+			return err
+		}
+		if done {
+			return nil // Imported earlier; only the rename below was interrupted
+		}
+		// Entries are imported as raw JSON so keys from older schemas (e.g., last_updated_at)
+		// survive for migrateStateSchema to convert.
+		themes, recovered, err := loadState[json.RawMessage](themesPath)
+		if err != nil {
+			return err
+		}
+		if recovered {
+			notices = append(notices, restoredNotice(themesPath))
+		}
+		plugins, recovered, err := loadState[json.RawMessage](pluginsPath)
+		if err != nil {
+			return err
+		}
+		if recovered {
+			notices = append(notices, restoredNotice(pluginsPath))
+		}
+		commands, recovered, err := loadState[json.RawMessage](commandsPath)
+		if err != nil {
+			return err
+		}
+		if recovered {
+			notices = append(notices, restoredNotice(commandsPath))
+		}
+		if err := importEntries(tx, store.BucketThemes, themes); err != nil {
+			return err
+		}
+		if err := importEntries(tx, store.BucketPlugins, plugins); err != nil {
+			return err
+		}
+		if err := importEntries(tx, store.BucketCommands, commands); err != nil {
+			return err
+		}
+		imported = len(themes) + len(plugins) + len(commands)
+		return tx.Put(store.BucketMeta, legacyMigratedKey, true)
+	})
+	if err != nil {
+		return notices, fmt.Errorf("failed to migrate legacy state files in '%s': %w", dir, err)
+	}
+
+	for _, p := range present {
+		if err := os.Rename(p, p+".migrated"); err != nil && !os.IsNotExist(err) {
+			return notices, fmt.Errorf("failed to rename migrated state file '%s': %w", p, err)
+		}
+		os.Remove(p + atomicfile.BackupSuffix)
+		os.Remove(p + ".lock")
+	}
+	notices = append(notices, fmt.Sprintf("Migrated %d entries from legacy state files in '%s' into '%s'.", imported, dir, st.Path()))
+	return notices, nil
+}
+
+// restoredNotice describes a damaged legacy state file that was restored from its backup.
+func restoredNotice(path string) string {
+	return fmt.Sprintf("State file '%s' was damaged and has been restored from '%s%s'.", path, path, atomicfile.BackupSuffix)
+}
+
+// importEntries adds legacy entries without overwriting entries already in the store.
+func importEntries(tx *store.Tx, bucket string, entries map[string]json.RawMessage) error {
+	for key, entry := range entries {
+		if _, exists := tx.GetRaw(bucket, key); exists {
+			continue
+		}
+		if err := tx.Put(bucket, key, entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadState reads a legacy JSON state file into a map[string]T. It is only used for migration.
+// It expects the JSON to have a top-level key (e.g., "themes", "plugins") whose value is the map.
+// recovered reports whether the file was damaged and restored from its backup.
+func loadState[T any](path string) (stateMap map[string]T, recovered bool, err error) {
+	stateMap = make(map[string]T)
+
+	// A damaged file (e.g., truncated by a crash under an older version) is restored from its .bak copy.
+	data, recovered, err := atomicfile.ReadFile(path, validateStateJSON)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// Return an empty map if the file doesn't exist
+			return stateMap, false, nil
+		}
+		if errors.Is(err, errEmptyState) {
+			// Return an empty map if the file is empty and no backup could replace it
+			return stateMap, false, nil
+		}
+		return nil, false, fmt.Errorf("failed to read state file '%s': %w", path, err)
+	}
+
+	// We need to unmarshal into a temporary structure to extract the nested map
+	var tempStore map[string]map[string]T
+	err = json.Unmarshal(data, &tempStore)
+	if err != nil {
+		// Attempt to unmarshal directly into the map if the top-level key is missing (e.g., old format or direct map save)
+		errDirect := json.Unmarshal(data, &stateMap)
+		if errDirect == nil {
+			// If direct unmarshal works, return the result but maybe log a warning about format?
+			// fmt.Printf("Warning: State file '%s' might be missing the top-level key. Loaded directly.\n", path)
+			return stateMap, recovered, nil
+		}
+		// If both fail, return the original error from unmarshalling the expected structure
+		return nil, false, fmt.Errorf("failed to unmarshal state file '%s': %w", path, err)
+	}
+
+	// Extract the actual map from the first key found in the temp store
+	// This assumes there's only one top-level key (like "themes", "plugins")
+	for _, v := range tempStore {
+		stateMap = v
+		break // Only take the first map found
+	}
+
+	// Ensure the map is not nil even if the file contained an empty object under the key
+	if stateMap == nil {
+		stateMap = make(map[string]T)
+	}
+
+	return stateMap, recovered, nil
+}
+
+// errEmptyState marks an empty state file, which is treated as an empty map when no backup exists.
+var errEmptyState = errors.New("state file is empty")
+
+// validateStateJSON rejects empty and syntactically invalid state files so they are recovered from backup.
+func validateStateJSON(data []byte) error {
+	if len(data) == 0 {
+		return errEmptyState
+	}
+	if !json.Valid(data) {
+		return fmt.Errorf("state file is not valid JSON")
+	}
+	return nil
+}
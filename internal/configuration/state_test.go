@@ -0,0 +1,45 @@
+package configuration
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/OG-Open-Source/PanelBase/internal/atomicfile"
+	"github.com/OG-Open-Source/PanelBase/internal/store"
+)
+
+func TestMigrateLegacyStateReturnsNotices(t *testing.T) {
+	dir := t.TempDir()
+	themesPath := filepath.Join(dir, legacyThemesStateFile)
+	pluginsPath := filepath.Join(dir, legacyPluginsStateFile)
+	if err := os.WriteFile(themesPath, []byte(`{"themes": {"thm_a": {"name": "A"}}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// A truncated plugins.json is restored from its backup.
+	if err := os.WriteFile(pluginsPath, []byte(`{"plugins": {`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(pluginsPath+atomicfile.BackupSuffix, []byte(`{"plugins": {"plg_a": {"name": "P"}}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	st, err := store.Open(filepath.Join(dir, "state.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	notices, err := migrateLegacyState(st, dir)
+	if err != nil {
+		t.Fatalf("migrateLegacyState: %v", err)
+	}
+	if len(notices) != 2 || !strings.Contains(notices[0], "'"+pluginsPath+"' was damaged") || !strings.Contains(notices[1], "Migrated 2 entries") {
+		t.Errorf("notices = %q; want the restored plugins file, then 2 migrated entries", notices)
+	}
+	if _, err := os.Stat(themesPath + ".migrated"); err != nil {
+		t.Errorf("themes.json was not renamed: %v", err)
+	}
+	if notices, err := migrateLegacyState(st, dir); err != nil || len(notices) != 0 {
+		t.Errorf("second migrateLegacyState = %q, %v; want nothing to report", notices, err)
+	}
+}
@@ -0,0 +1,364 @@
+package configuration
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Issue severities. Errors make strict mode refuse the config; warnings never do.
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// Issue is one problem found in a configuration.
+type Issue struct {
+	Line     int    // Line in the config file, 0 when the value does not come from the file
+	Key      string // Dotted key, e.g. server.port
+	Severity string // SeverityError or SeverityWarning
+	Message  string
+}
+
+func (i Issue) String() string {
+	if i.Line > 0 {
+		return fmt.Sprintf("line %d: %s: %s", i.Line, i.Key, i.Message)
+	}
+	return fmt.Sprintf("%s: %s", i.Key, i.Message)
+}
+
+// ValidationError reports the errors of a rejected config (strict mode, or a hot reload).
+type ValidationError struct {
+	Path   string
+	Issues []Issue
+}
+
+func (e *ValidationError) Error() string {
+	var lines []string
+	for _, issue := range e.Issues {
+		if issue.Severity == SeverityError {
+			lines = append(lines, "  "+issue.String())
+		}
+	}
+	return fmt.Sprintf("config '%s' is invalid:\n%s", e.Path, strings.Join(lines, "\n"))
+}
+
+// HasErrors reports whether any issue has error severity.
+func HasErrors(issues []Issue) bool {
+	for _, issue := range issues {
+		if issue.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// deprecatedKeys maps keys that older versions used to a hint about their replacement.
+// They are reported as warnings instead of unknown keys.
+var deprecatedKeys = func() map[string]string {
+	keys := make(map[string]string)
+	for oldKey, newKey := range legacyPathKeys {
+		keys["paths."+oldKey] = fmt.Sprintf("deprecated; renamed to paths.%s", newKey)
+	}
+	return keys
+}()
+
+var (
+	validLogLevels = map[string]bool{"debug": true, "info": true, "warn": true, "warning": true, "error": true}
+	hostnameRE     = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?(\.[A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?)*$`)
+)
+
+// ValidateConfigFile checks the config file at path (as it would be loaded, including
+// environment and flag overrides) and returns every issue found. The file is not migrated or
+// modified; an error is returned only when it cannot be read or parsed at all.
+func ValidateConfigFile(path string) ([]Issue, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file '%s': %w", path, err)
+	}
+	return validateConfigData(data, path, true)
+}
+
+// validateConfigData checks config file contents, with or without the environment and flag
+// overrides (config set validates only what it writes to the file).
+func validateConfigData(data []byte, path string, withOverrides bool) ([]Issue, error) {
+	var cfg Config
+	lines, issues, err := checkConfigNodes(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config file '%s': %w", path, err)
+	}
+	if HasErrors(issues) {
+		// Values of the wrong type make Unmarshal fail; decode what can be decoded.
+		cfg = decodeValidFields(data)
+	} else if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal config file '%s': %w", path, err)
+	}
+	if withOverrides {
+		if err := applyOverrides(&cfg, path, fileKeys(data)); err != nil {
+			issues = append(issues, Issue{Key: "override", Severity: SeverityError, Message: err.Error()})
+		}
+	}
+	// A value with the wrong type was left zero; do not report it a second time as missing.
+	reported := make(map[string]bool)
+	for _, issue := range issues {
+		reported[issue.Key] = true
+	}
+	for _, issue := range checkConfigValues(&cfg, lines) {
+		if !reported[issue.Key] {
+			issues = append(issues, issue)
+		}
+	}
+	sortIssues(issues)
+	return issues, nil
+}
+
+// validateLoaded checks a config during LoadConfig, after overrides and before defaults.
+func validateLoaded(cfg *Config, data []byte) []Issue {
+	lines, issues, err := checkConfigNodes(data)
+	if err != nil {
+		return nil // Unmarshal already succeeded, so this cannot happen
+	}
+	issues = append(issues, checkConfigValues(cfg, lines)...)
+	sortIssues(issues)
+	return issues
+}
+
+// checkConfigNodes walks the YAML node tree against the Config struct. It reports unknown and
+// deprecated keys and values of the wrong type, and returns the line of every key it saw.
+func checkConfigNodes(data []byte) (map[string]int, []Issue, error) {
+	lines := make(map[string]int)
+	var issues []Issue
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, nil, err
+	}
+	if len(doc.Content) == 0 {
+		return lines, nil, nil // Empty file: everything is defaulted
+	}
+
+	var walk func(n *yaml.Node, t reflect.Type, prefix string)
+	walk = func(n *yaml.Node, t reflect.Type, prefix string) {
+		if n.Kind != yaml.MappingNode {
+			issues = append(issues, Issue{Line: n.Line, Key: prefix, Severity: SeverityError, Message: fmt.Sprintf("expected a mapping, got %s", nodeKindName(n))})
+			return
+		}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			keyNode, valueNode := n.Content[i], n.Content[i+1]
+			key := keyNode.Value
+			if prefix != "" {
+				key = prefix + "." + key
+			}
+			lines[key] = keyNode.Line
+
+			if t.Kind() == reflect.Map { // Free-form entries, e.g. logging.plugins.levels.<plugin ID>
+				if valueNode.Kind != yaml.ScalarNode {
+					issues = append(issues, Issue{Line: keyNode.Line, Key: key, Severity: SeverityError, Message: fmt.Sprintf("expected a string, got %s", nodeKindName(valueNode))})
+				}
+				continue
+			}
+			field, ok := fieldByYAMLName(t, keyNode.Value)
+			if !ok {
+				if hint, deprecated := deprecatedKeys[key]; deprecated {
+					issues = append(issues, Issue{Line: keyNode.Line, Key: key, Severity: SeverityWarning, Message: hint})
+				} else {
+					issues = append(issues, Issue{Line: keyNode.Line, Key: key, Severity: SeverityError, Message: "unknown key"})
+				}
+				continue
+			}
+			switch field.Type.Kind() {
+			case reflect.Struct, reflect.Map:
+				if valueNode.Tag == "!!null" {
+					continue // An empty section: defaults apply
+				}
+				walk(valueNode, field.Type, key)
+			default:
+				if err := valueNode.Decode(reflect.New(field.Type).Interface()); err != nil {
+					issues = append(issues, Issue{Line: keyNode.Line, Key: key, Severity: SeverityError, Message: fmt.Sprintf("expected %s, got '%s'", typeName(field.Type), valueNode.Value)})
+				}
+			}
+		}
+	}
+	walk(doc.Content[0], reflect.TypeOf(Config{}), "")
+	return lines, issues, nil
+}
+
+// decodeValidFields decodes a config whose file has type errors, skipping the bad values.
+func decodeValidFields(data []byte) Config {
+	var cfg Config
+	var doc yaml.Node
+	if yaml.Unmarshal(data, &doc) != nil || len(doc.Content) == 0 {
+		return cfg
+	}
+	var walk func(n *yaml.Node, v reflect.Value)
+	walk = func(n *yaml.Node, v reflect.Value) {
+		if n.Kind != yaml.MappingNode {
+			return
+		}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			field, ok := fieldByYAMLName(v.Type(), n.Content[i].Value)
+			if !ok {
+				continue
+			}
+			target := v.FieldByIndex(field.Index)
+			if field.Type.Kind() == reflect.Struct {
+				walk(n.Content[i+1], target)
+				continue
+			}
+			n.Content[i+1].Decode(target.Addr().Interface()) // Bad values stay zero
+		}
+	}
+	walk(doc.Content[0], reflect.ValueOf(&cfg).Elem())
+	return cfg
+}
+
+// checkConfigValues checks the values of cfg (before defaults are applied). lines maps keys
+// to their line in the file; values that came from overrides are reported with their source.
+func checkConfigValues(cfg *Config, lines map[string]int) []Issue {
+	var issues []Issue
+	add := func(key, severity, format string, args ...interface{}) {
+		message := fmt.Sprintf(format, args...)
+		if source := cfg.Sources[fieldKeyOf(key)]; isOverride(source) {
+			issues = append(issues, Issue{Key: key, Severity: severity, Message: message + " (from " + source + ")"})
+			return
+		}
+		issues = append(issues, Issue{Line: lines[key], Key: key, Severity: severity, Message: message})
+	}
+	// isSet reports whether a key was given in the file or by an override (zero values that were
+	// simply left out are defaulted silently).
+	isSet := func(key string) bool {
+		_, inFile := lines[key]
+		return inFile || isOverride(cfg.Sources[key])
+	}
+	// Missing values fall back to defaults; that is only a problem where the default is surprising.
+	missingSeverity := SeverityWarning
+	if cfg.Strict {
+		missingSeverity = SeverityError
+	}
+
+	if cfg.Version != "" {
+		switch cmp := compareVersions(cfg.Version, CurrentConfigVersion); {
+		case cmp > 0:
+			add("version", SeverityError, "version '%s' is newer than the supported version '%s'", cfg.Version, CurrentConfigVersion)
+		case cmp < 0:
+			add("version", SeverityWarning, "version '%s' is migrated to '%s' on the next load", cfg.Version, CurrentConfigVersion)
+		}
+	}
+
+	if host := cfg.Server.Host; host != "" && net.ParseIP(strings.Trim(host, "[]")) == nil && !hostnameRE.MatchString(host) {
+		add("server.host", SeverityError, "'%s' is not a valid IP address or host name", host)
+	}
+	switch port := cfg.Server.Port; {
+	case port == 0:
+		add("server.port", missingSeverity, "not set; a random port between %d and %d is chosen on every start", minPort, maxPort)
+	case port < minPort || port > maxPort:
+		add("server.port", SeverityError, "%d is outside %d-%d", port, minPort, maxPort)
+	}
+	if port := cfg.Server.AdminPort; port != 0 && (port < minPort || port > maxPort) {
+		add("server.admin_port", SeverityError, "%d is outside %d-%d (use 0 to disable the metrics endpoint)", port, minPort, maxPort)
+	}
+	if seconds := cfg.Server.TemplatePollSeconds; seconds < 0 {
+		add("server.template_poll_seconds", SeverityError, "%d is negative (use 0 to check templates on every request)", seconds)
+	}
+
+	if alphabet := cfg.Security.Secrets.Alphabet; alphabet != "" && distinctRunes(alphabet) < 2 {
+		add("security.secrets.alphabet", SeverityError, "must contain at least 2 distinct characters")
+	}
+	if isSet("security.secrets.length") && cfg.Security.Secrets.Length <= 0 {
+		add("security.secrets.length", SeverityError, "%d must be positive", cfg.Security.Secrets.Length)
+	}
+
+	plugins := cfg.Logging.Plugins
+	if plugins.DefaultLevel != "" && !validLogLevels[strings.ToLower(plugins.DefaultLevel)] {
+		add("logging.plugins.default_level", SeverityError, "unknown log level '%s' (expected debug, info, warn or error)", plugins.DefaultLevel)
+	}
+	for id, level := range plugins.Levels {
+		if !validLogLevels[strings.ToLower(level)] {
+			add("logging.plugins.levels."+id, SeverityError, "unknown log level '%s' (expected debug, info, warn or error)", level)
+		}
+	}
+	if isSet("logging.plugins.rate_limit.per_second") && plugins.RateLimit.PerSecond <= 0 {
+		add("logging.plugins.rate_limit.per_second", SeverityError, "%g must be positive", plugins.RateLimit.PerSecond)
+	}
+	if isSet("logging.plugins.rate_limit.burst") && plugins.RateLimit.Burst <= 0 {
+		add("logging.plugins.rate_limit.burst", SeverityError, "%d must be positive", plugins.RateLimit.Burst)
+	}
+
+	for _, field := range configFields(cfg) {
+		if strings.HasPrefix(field.Key, "paths.") && field.value.String() == "" && isSet(field.Key) {
+			add(field.Key, SeverityError, "must not be empty")
+		}
+	}
+	return issues
+}
+
+// isOverride reports whether a source (see Config.Sources) is an environment or flag override.
+func isOverride(source string) bool {
+	return strings.HasPrefix(source, SourceEnv) || strings.HasPrefix(source, SourceFlag)
+}
+
+// fieldKeyOf maps a key to the config field holding it (map entries belong to the map field).
+func fieldKeyOf(key string) string {
+	if strings.HasPrefix(key, "logging.plugins.levels.") {
+		return "logging.plugins.levels"
+	}
+	return key
+}
+
+// fieldByYAMLName finds the struct field whose yaml tag is name.
+func fieldByYAMLName(t reflect.Type, name string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		if tag := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]; tag == name && tag != "-" {
+			return t.Field(i), true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+func typeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Int:
+		return "an integer"
+	case reflect.Float64:
+		return "a number"
+	case reflect.Bool:
+		return "true or false"
+	default:
+		return "a " + t.Kind().String()
+	}
+}
+
+func nodeKindName(n *yaml.Node) string {
+	switch n.Kind {
+	case yaml.MappingNode:
+		return "a mapping"
+	case yaml.SequenceNode:
+		return "a list"
+	default:
+		return fmt.Sprintf("'%s'", n.Value)
+	}
+}
+
+func distinctRunes(s string) int {
+	seen := make(map[rune]bool)
+	for _, r := range s {
+		seen[r] = true
+	}
+	return len(seen)
+}
+
+// sortIssues orders issues by line (override issues, which have no line, last).
+func sortIssues(issues []Issue) {
+	sort.SliceStable(issues, func(i, j int) bool {
+		li, lj := issues[i].Line, issues[j].Line
+		if li == 0 || lj == 0 {
+			return li != 0 && lj == 0
+		}
+		return li < lj
+	})
+}
@@ -0,0 +1,68 @@
+package configuration
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidateConfigFileReportsLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	data := "version: v3\nserver:\n    port: 80\n    typo: 1\npaths:\n    themesDir: ext/themes\n"
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	issues, err := ValidateConfigFile(path)
+	if err != nil {
+		t.Fatalf("ValidateConfigFile: %v", err)
+	}
+	want := []Issue{
+		{Line: 3, Key: "server.port", Severity: SeverityError},
+		{Line: 4, Key: "server.typo", Severity: SeverityError},
+		{Line: 6, Key: "paths.themesDir", Severity: SeverityWarning},
+	}
+	if len(issues) != len(want) {
+		t.Fatalf("issues = %v; want %d issues", issues, len(want))
+	}
+	for i, w := range want {
+		if issues[i].Line != w.Line || issues[i].Key != w.Key || issues[i].Severity != w.Severity {
+			t.Errorf("issue %d = %+v; want %+v", i, issues[i], w)
+		}
+	}
+
+	// Strict mode refuses the same file instead of picking a random port.
+	if err := os.WriteFile(path, []byte("version: v3\nstrict: true\nserver:\n    port: 80\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var validationErr *ValidationError
+	if _, err := LoadConfig(path); !errors.As(err, &validationErr) {
+		t.Fatalf("LoadConfig in strict mode = %v; want a *ValidationError", err)
+	}
+}
+
+func TestValidateConfigVersionSeverity(t *testing.T) {
+	// An older version is only a warning because the next load migrates it; a newer one is an error.
+	for version, want := range map[string]string{"v1": SeverityWarning, "v99": SeverityError} {
+		path := filepath.Join(t.TempDir(), "config.yaml")
+		if err := os.WriteFile(path, []byte("version: "+version+"\nserver:\n    port: 40082\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		issues, err := ValidateConfigFile(path)
+		if err != nil {
+			t.Fatalf("ValidateConfigFile: %v", err)
+		}
+		found := false
+		for _, issue := range issues {
+			if issue.Key == "version" {
+				found = true
+				if issue.Severity != want {
+					t.Errorf("version %s: severity = %s; want %s", version, issue.Severity, want)
+				}
+			}
+		}
+		if !found {
+			t.Errorf("version %s: no issue reported", version)
+		}
+	}
+}
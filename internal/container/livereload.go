@@ -0,0 +1,232 @@
+package container
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// liveReloadPath is the URL of the server-sent events stream that tells pages to reload.
+const liveReloadPath = "/__panelbase/livereload"
+
+// liveReloadScript is injected into every HTML response. EventSource reconnects on its own, so
+// pages also survive a restart of the development server.
+const liveReloadScript = `<script>(function(){var s=new EventSource("` + liveReloadPath + `");` +
+	`s.addEventListener("reload",function(){location.reload()});})();</script>`
+
+// LiveReloader wraps a handler for theme development: HTML responses get a script that listens
+// on an SSE stream, and Reload makes every listening page reload itself.
+type LiveReloader struct {
+	next    http.Handler
+	mu      sync.Mutex
+	clients map[chan struct{}]struct{} // One channel per open event stream
+	done    chan struct{}              // Closed by Close to end all event streams
+	once    sync.Once
+}
+
+// NewLiveReloader creates a LiveReloader serving next.
+func NewLiveReloader(next http.Handler) *LiveReloader {
+	return &LiveReloader{
+		next:    next,
+		clients: make(map[chan struct{}]struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+// Reload tells every connected page to reload.
+func (l *LiveReloader) Reload() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for client := range l.clients {
+		select {
+		case client <- struct{}{}:
+		default: // A reload is already pending for this page
+		}
+	}
+}
+
+// Close ends all event streams, so http.Server.Shutdown does not wait for them.
+func (l *LiveReloader) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return nil
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (l *LiveReloader) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == liveReloadPath {
+		l.serveEvents(w, r)
+		return
+	}
+	// Files change all the time during development
+	w.Header().Set("Cache-Control", "no-store")
+
+	iw := &injectingWriter{ResponseWriter: w}
+	l.next.ServeHTTP(iw, r)
+	iw.finish()
+}
+
+// serveEvents streams a "reload" event whenever Reload is called.
+func (l *LiveReloader) serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	client := make(chan struct{}, 1)
+	l.mu.Lock()
+	l.clients[client] = struct{}{}
+	l.mu.Unlock()
+	defer func() {
+		l.mu.Lock()
+		delete(l.clients, client)
+		l.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "retry: 1000\n\n") // Reconnect quickly after a restart
+	flusher.Flush()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-l.done:
+			return
+		case <-client:
+			fmt.Fprint(w, "event: reload\ndata: {}\n\n")
+			flusher.Flush()
+		}
+	}
+}
+
+// injectingWriter buffers HTML responses to add liveReloadScript before </body> (or at the end
+// when there is none); other responses pass through unchanged.
+type injectingWriter struct {
+	http.ResponseWriter
+	status  int
+	decided bool // Set once the response is known to be HTML or not
+	html    bool
+	buf     bytes.Buffer
+}
+
+// decide inspects the Content-Type, sniffing it from the first bytes when unset.
+func (w *injectingWriter) decide(first []byte) {
+	if w.decided {
+		return
+	}
+	w.decided = true
+	contentType := w.Header().Get("Content-Type")
+	if contentType == "" {
+		contentType = http.DetectContentType(first)
+	}
+	w.html = strings.HasPrefix(contentType, "text/html")
+	if w.html {
+		w.Header().Del("Content-Length") // The script changes the length
+	} else if w.status != 0 {
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+}
+
+// WriteHeader implements http.ResponseWriter.
+func (w *injectingWriter) WriteHeader(statusCode int) {
+	if w.status != 0 {
+		return
+	}
+	w.status = statusCode
+	if w.Header().Get("Content-Type") != "" || statusCode == http.StatusNotModified {
+		w.decide(nil)
+	}
+}
+
+// Write implements http.ResponseWriter.
+func (w *injectingWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.decide(b)
+	if w.html {
+		return w.buf.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// finish writes a buffered HTML response with the script injected.
+func (w *injectingWriter) finish() {
+	if !w.decided {
+		if w.status != 0 {
+			w.ResponseWriter.WriteHeader(w.status) // Header without body, e.g. a redirect
+		}
+		return
+	}
+	if !w.html {
+		return
+	}
+	body := w.buf.Bytes()
+	if i := bytes.LastIndex(bytes.ToLower(body), []byte("</body>")); i >= 0 {
+		body = append(body[:i:i], append([]byte(liveReloadScript), body[i:]...)...)
+	} else {
+		body = append(body, liveReloadScript...)
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.ResponseWriter.WriteHeader(w.status)
+	w.ResponseWriter.Write(body)
+}
+
+// WatchFiles polls the files below roots every interval until stop is closed and calls onChange
+// with the sorted paths that were created, modified or removed. roots is called on every poll,
+// so a changed theme.yaml may add or drop parent layers. Polling needs no platform support and
+// copes with editors that replace files instead of writing them.
+func WatchFiles(roots func() []string, interval time.Duration, stop <-chan struct{}, onChange func(changed []string)) {
+	previous := snapshotFiles(roots())
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		current := snapshotFiles(roots())
+		var changed []string
+		for path, stamp := range current {
+			if old, ok := previous[path]; !ok || old.size != stamp.size || !old.modTime.Equal(stamp.modTime) {
+				changed = append(changed, path)
+			}
+		}
+		for path := range previous {
+			if _, ok := current[path]; !ok {
+				changed = append(changed, path)
+			}
+		}
+		previous = current
+		if len(changed) > 0 {
+			sort.Strings(changed)
+			onChange(changed)
+		}
+	}
+}
+
+// snapshotFiles stamps every regular file below roots. Unreadable entries are skipped.
+func snapshotFiles(roots []string) map[string]fileStamp {
+	files := make(map[string]fileStamp)
+	for _, root := range roots {
+		filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || !d.Type().IsRegular() {
+				return nil
+			}
+			if info, err := d.Info(); err == nil {
+				files[path] = fileStamp{path: path, exists: true, size: info.Size(), modTime: info.ModTime()}
+			}
+			return nil
+		})
+	}
+	return files
+}
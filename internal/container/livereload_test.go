@@ -0,0 +1,127 @@
+package container
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLiveReloaderInjectsScriptAndStreamsReloads(t *testing.T) {
+	reloader := NewLiveReloader(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/site.css" {
+			w.Header().Set("Content-Type", "text/css")
+			io.WriteString(w, "body {}")
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, "<html><BODY>missing</BODY></html>") // Content-Type is sniffed
+	}))
+	server := httptest.NewServer(reloader)
+	defer server.Close()
+	defer reloader.Close()
+
+	get := func(path string) (*http.Response, string) {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp, string(body)
+	}
+	resp, body := get("/page")
+	if want := "<html><BODY>missing" + liveReloadScript + "</BODY></html>"; resp.StatusCode != http.StatusNotFound || body != want {
+		t.Errorf("GET /page = %d %q; want 404 %q", resp.StatusCode, body, want)
+	}
+	if resp, body := get("/site.css"); body != "body {}" || resp.Header.Get("Cache-Control") != "no-store" {
+		t.Errorf("GET /site.css = %q (Cache-Control %q); want it unchanged and uncached", body, resp.Header.Get("Cache-Control"))
+	}
+
+	events, err := http.Get(server.URL + liveReloadPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer events.Body.Close()
+	lines := bufio.NewReader(events.Body)
+	if line, _ := lines.ReadString('\n'); !strings.HasPrefix(line, "retry:") { // Sent once the client is registered
+		t.Fatalf("first event line = %q", line)
+	}
+	lines.ReadString('\n')
+	reloader.Reload()
+	if line, _ := lines.ReadString('\n'); line != "event: reload\n" {
+		t.Errorf("event line = %q; want a reload event", line)
+	}
+}
+
+func TestWatchFilesReportsChangesAcrossRoots(t *testing.T) {
+	child, parent := t.TempDir(), t.TempDir()
+	page := filepath.Join(child, "index.html")
+	old := filepath.Join(child, "old.css")
+	for _, p := range []string{page, old, filepath.Join(parent, "base.css")} {
+		if err := os.WriteFile(p, []byte("v1"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var mu sync.Mutex
+	roots := []string{child}
+	currentRoots := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return roots
+	}
+	changes := make(chan []string, 16)
+	stop := make(chan struct{})
+	defer close(stop)
+	go WatchFiles(currentRoots, 5*time.Millisecond, stop, func(changed []string) { changes <- changed })
+	time.Sleep(20 * time.Millisecond) // Let the first snapshot be taken
+
+	// expect collects reported changes until they add up to want. Changes made together may be
+	// reported by separate polls.
+	expect := func(what string, want ...string) {
+		t.Helper()
+		seen := make(map[string]bool)
+		for len(seen) < len(want) {
+			select {
+			case got := <-changes:
+				for _, p := range got {
+					seen[p] = true
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("%s: changed = %v; want %q", what, seen, want)
+			}
+		}
+		for _, p := range want {
+			if !seen[p] {
+				t.Errorf("%s: changed = %v; want %q", what, seen, want)
+				break
+			}
+		}
+	}
+
+	if err := os.WriteFile(page, []byte("v2 longer"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	expect("modified file", page)
+
+	added := filepath.Join(child, "new.css")
+	if err := os.WriteFile(added, []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(old); err != nil {
+		t.Fatal(err)
+	}
+	expect("created and removed files", added, old)
+
+	// A parent layer that appears (e.g. after theme.yaml changed) reports its files.
+	mu.Lock()
+	roots = []string{child, parent}
+	mu.Unlock()
+	expect("added root", filepath.Join(parent, "base.css"))
+}
@@ -2,39 +2,78 @@ package container
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"math/rand"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"gopkg.in/yaml.v3"
 
+	"github.com/OG-Open-Source/PanelBase/internal/atomicfile"
+	"github.com/OG-Open-Source/PanelBase/internal/configuration"
+	"github.com/OG-Open-Source/PanelBase/internal/events"
 	"github.com/OG-Open-Source/PanelBase/internal/logger"
+	"github.com/OG-Open-Source/PanelBase/internal/store"
 	"github.com/OG-Open-Source/PanelBase/internal/utils"
 )
 
 const (
-	containersDir     = "containers"
-	containerMetaFile = "container.yaml" // Metadata filename
-	minPort           = 1024
-	maxPort           = 49151
+	defaultContainersDir = "containers"
+	containerMetaFile    = "container.yaml" // Legacy per-container metadata file, migrated into the state store
+	minPort              = 1024
+	maxPort              = 49151
 )
 
+// ContainersBucket holds the persistent ContainerMetadata of every container, keyed by container ID.
+var ContainersBucket = store.Bucket[ContainerMetadata]{Name: store.BucketContainers}
+
 // ContainerManager manages the lifecycle and state of containers.
 type ContainerManager struct {
 	idGen      *utils.IDGenerator
-	containers map[string]*ContainerInfo // Map container ID to its runtime info
-	mu         sync.RWMutex              // Mutex for thread-safe map access
-	globalHost string                    // Global host from main config
-	logger     *logger.Logger            // Added logger instance
+	containers map[string]*ContainerInfo        // Map container ID to its runtime info
+	mu         sync.RWMutex                     // Mutex for thread-safe map access
+	globalHost string                           // Global host from main config
+	logger     *logger.Logger                   // Added logger instance
+	state      *store.Store                     // State store holding container metadata
+	baseDir    string                           // Directory holding one subdirectory per container
+	bus        atomic.Pointer[events.Bus]       // Optional event bus; set after construction, read from server goroutines
+	themes     atomic.Pointer[ThemeResolver]    // Optional; resolves applied themes to their layer directories
+	schemas    atomic.Pointer[SettingsResolver] // Optional; resolves applied themes to their settings schema
+	settingsMu sync.Mutex                       // Serializes read-modify-write cycles of ui_settings.json files
+	pollNanos  atomic.Int64                     // Template watcher interval for web servers started afterwards; 0 checks on every request
+	themeSub   *events.Subscription             // Theme events that invalidate layerCache; replaced by SetEventBus
+
+	layerMu    sync.Mutex          // Guards layerCache and layerGen
+	layerCache map[string][]string // Container ID -> layers of its applied theme (nil: none or unresolvable)
+	layerGen   uint64              // Incremented by every invalidation, so a resolve racing with one is not cached
+}
+
+// ThemeResolver returns the directories of a theme's merged view, child first (see themes.Layers).
+type ThemeResolver func(themeID string) ([]string, error)
+
+// SettingsSchema describes the settings a theme reads from ui_settings.json (see themes.SettingsSchema).
+type SettingsSchema interface {
+	Keys() []string                             // Sorted setting keys
+	Defaults() map[string]interface{}           // Default value of every setting
+	Valid(key string, value interface{}) bool   // Whether value has the declared type of key
+	Parse(key, raw string) (interface{}, error) // Converts a command line value to the declared type
+	Describe(key string) string                 // Type and description of key, for listings
 }
 
+// SettingsResolver returns the settings schema of a theme, including its parents' settings.
+type SettingsResolver func(themeID string) (SettingsSchema, error)
+
 // NewContainerManager creates a new ContainerManager instance.
-func NewContainerManager(idGen *utils.IDGenerator, globalHost string, log *logger.Logger) (*ContainerManager, error) {
+// containersDir overrides the default "containers" base directory.
+func NewContainerManager(idGen *utils.IDGenerator, globalHost string, log *logger.Logger, containersDir ...string) (*ContainerManager, error) {
 	if idGen == nil {
 		return nil, fmt.Errorf("IDGenerator cannot be nil")
 	}
@@ -46,9 +85,19 @@ func NewContainerManager(idGen *utils.IDGenerator, globalHost string, log *logge
 		log.Log("Warning: Global host for containers is empty, defaulting to 0.0.0.0")
 	}
 
+	baseDir := defaultContainersDir
+	if len(containersDir) > 0 && containersDir[0] != "" {
+		baseDir = containersDir[0]
+	}
+
 	// Ensure the base containers directory exists
-	if err := os.MkdirAll(containersDir, 0755); err != nil {
-		return nil, fmt.Errorf("failed to create base containers directory '%s': %w", containersDir, err)
+	if err := os.MkdirAll(baseDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create base containers directory '%s': %w", baseDir, err)
+	}
+
+	state, err := configuration.OpenStateStore()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open state store for containers: %w", err)
 	}
 
 	cm := &ContainerManager{
@@ -56,49 +105,45 @@ func NewContainerManager(idGen *utils.IDGenerator, globalHost string, log *logge
 		containers: make(map[string]*ContainerInfo),
 		globalHost: globalHost,
 		logger:     log,
+		state:      state,
+		baseDir:    baseDir,
+		layerCache: make(map[string][]string),
 	}
 	// Load existing containers on startup
 	cm.LoadExistingContainers()
 	return cm, nil
 }
 
-// LoadExistingContainers scans the containers directory and loads metadata.
+// LoadExistingContainers loads container metadata from the state store, importing legacy
+// container.yaml files first. Every container starts out stopped in memory; the server restarts
+// those persisted as running with StartPersistedContainers, while CLI processes never serve them.
 func (cm *ContainerManager) LoadExistingContainers() {
 	cm.mu.Lock() // Lock for writing to the map
 	defer cm.mu.Unlock()
 
-	cm.logger.Logf("Scanning for existing containers in '%s'...", containersDir)
-	dirs, err := os.ReadDir(containersDir)
+	cm.logger.Logf("Loading containers from state store '%s'...", cm.state.Path())
+	cm.migrateLegacyMetadata()
+
+	var metas map[string]ContainerMetadata
+	err := cm.state.View(func(tx *store.Tx) error {
+		var viewErr error
+		metas, viewErr = ContainersBucket.All(tx)
+		return viewErr
+	})
 	if err != nil {
-		cm.logger.Logf("Error reading containers directory '%s': %v", containersDir, err)
+		cm.logger.Logf("Error loading container metadata: %v", err)
 		return
 	}
 
 	loadedCount := 0
-	startAttemptCount := 0
-	for _, dirEntry := range dirs {
-		if !dirEntry.IsDir() {
-			continue
-		}
-		containerID := dirEntry.Name()
-		metaFilePath := filepath.Join(containersDir, containerID, containerMetaFile)
-
-		metaData, err := os.ReadFile(metaFilePath)
-		if err != nil {
-			cm.logger.Logf("Skipping container '%s': failed to read %s: %v", containerID, containerMetaFile, err)
-			continue
-		}
-
-		var meta ContainerMetadata
-		err = yaml.Unmarshal(metaData, &meta)
-		if err != nil {
-			cm.logger.Logf("Skipping container '%s': failed to parse %s: %v", containerID, containerMetaFile, err)
-			continue
-		}
-
+	for containerID, meta := range metas {
 		// Validate metadata
 		if !meta.IsValid() || meta.ID != containerID {
-			cm.logger.Logf("Skipping container '%s': invalid metadata in %s (ID mismatch or invalid fields)", containerID, containerMetaFile)
+			cm.logger.Logf("Skipping container '%s': invalid metadata in state store (ID mismatch or invalid fields)", containerID)
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(cm.baseDir, containerID)); err != nil {
+			cm.logger.Logf("Skipping container '%s': directory missing: %v", containerID, err)
 			continue
 		}
 
@@ -107,62 +152,433 @@ func (cm *ContainerManager) LoadExistingContainers() {
 			ID:     meta.ID,
 			Status: StatusStopped, // Start as stopped, attempt start later if needed
 			Port:   meta.Port,
-			WebDir: filepath.Join(containersDir, containerID, "web"),
+			WebDir: filepath.Join(cm.baseDir, containerID, "web"),
+			Theme:  meta.AppliedTheme,
 		}
 		cm.containers[containerID] = info
 		loadedCount++
+	}
+
+	cm.logger.Logf("Finished loading containers. Loaded %d containers.", loadedCount)
+}
 
-		// If metadata indicates it should be running, attempt to start it (outside the lock later)
-		if meta.Status == StatusRunning {
-			startAttemptCount++
-			// Need to call StartWebServer outside the lock to avoid deadlock
-			// We'll collect IDs to start after the loop.
+// StartPersistedContainers starts the web servers of loaded containers whose persisted status is
+// running. Only the server process calls it, once the event bus and theme resolvers are attached;
+// failures are logged and leave the other containers unaffected.
+func (cm *ContainerManager) StartPersistedContainers() {
+	var metas map[string]ContainerMetadata
+	err := cm.state.View(func(tx *store.Tx) error {
+		var viewErr error
+		metas, viewErr = ContainersBucket.All(tx)
+		return viewErr
+	})
+	if err != nil {
+		cm.logger.Logf("Error loading container metadata: %v", err)
+		return
+	}
+
+	var idsToStart []string
+	cm.mu.RLock()
+	for id, meta := range metas {
+		if _, loaded := cm.containers[id]; loaded && meta.Status == StatusRunning {
+			idsToStart = append(idsToStart, id)
 		}
 	}
+	cm.mu.RUnlock()
+	if len(idsToStart) == 0 {
+		return
+	}
+	sort.Strings(idsToStart)
+
+	cm.logger.Logf("Attempting to restart %d containers marked as running...", len(idsToStart))
+	for _, id := range idsToStart {
+		// StartWebServer only binds the port before returning, so starting one after another is quick
+		if err := cm.StartWebServer(id); err != nil {
+			cm.logger.Logf("Failed to auto-start web server for container '%s': %v", id, err)
+		}
+	}
+}
 
-	cm.logger.Logf("Finished scanning containers. Loaded %d containers.", loadedCount)
+// SetPersistedStatus records whether a container should be served when the server starts,
+// without starting or stopping a web server. CLI processes use it when no server is running.
+func (cm *ContainerManager) SetPersistedStatus(id string, status ContainerStatus) error {
+	if status != StatusRunning && status != StatusStopped {
+		return fmt.Errorf("invalid persisted status '%s' for container '%s'", status, id)
+	}
+	cm.mu.RLock()
+	_, exists := cm.containers[id]
+	cm.mu.RUnlock()
+	if !exists {
+		return fmt.Errorf("container '%s' not found in memory", id)
+	}
+	if err := cm.updateMetadataStatus(id, status); err != nil {
+		return fmt.Errorf("failed to store status of container '%s': %w", id, err)
+	}
+	cm.logger.Logf("Marked container %s as %s.", id, status)
+	return nil
+}
 
-	// Attempt to start containers marked as running (outside the initial lock)
-	// This part needs careful consideration regarding locking if StartWebServer modifies the map.
-	// For simplicity now, let's assume StartWebServer handles its own locking correctly.
-	// We need to re-read the directory or store IDs to start. Let's store IDs.
-	idsToStart := []string{}
+// migrateLegacyMetadata imports container.yaml files that are not yet in the state store and
+// renames them to container.yaml.migrated. Damaged files are recovered from their .bak copy.
+func (cm *ContainerManager) migrateLegacyMetadata() {
+	dirs, err := os.ReadDir(cm.baseDir)
+	if err != nil {
+		cm.logger.Logf("Error reading containers directory '%s': %v", cm.baseDir, err)
+		return
+	}
 	for _, dirEntry := range dirs {
 		if !dirEntry.IsDir() {
 			continue
 		}
 		containerID := dirEntry.Name()
-		metaFilePath := filepath.Join(containersDir, containerID, containerMetaFile)
-		metaData, err := os.ReadFile(metaFilePath)
+		metaFilePath := filepath.Join(cm.baseDir, containerID, containerMetaFile)
+		if _, err := os.Stat(metaFilePath); err != nil {
+			continue // Already migrated or never had a metadata file
+		}
+		meta, recovered, err := readMetadata(metaFilePath)
 		if err != nil {
+			cm.logger.Logf("  Skipping legacy %s of container '%s': %v", containerMetaFile, containerID, err)
 			continue
 		}
-		var meta ContainerMetadata
-		if yaml.Unmarshal(metaData, &meta) != nil {
+		if recovered {
+			cm.logger.Logf("  Container '%s': %s was damaged and has been restored from its backup.", containerID, containerMetaFile)
+		}
+		err = cm.state.Update(func(tx *store.Tx) error {
+			if _, exists, err := ContainersBucket.Get(tx, containerID); err != nil || exists {
+				return err // Never overwrite metadata already in the store
+			}
+			return ContainersBucket.Put(tx, containerID, *meta)
+		})
+		if err != nil {
+			cm.logger.Logf("  Failed to migrate %s of container '%s': %v", containerMetaFile, containerID, err)
 			continue
 		}
-		if meta.IsValid() && meta.ID == containerID && meta.Status == StatusRunning {
-			idsToStart = append(idsToStart, containerID)
+		if err := os.Rename(metaFilePath, metaFilePath+".migrated"); err != nil {
+			cm.logger.Logf("  Warning: Migrated container '%s' but could not rename %s: %v", containerID, containerMetaFile, err)
+			continue
 		}
+		os.Remove(metaFilePath + atomicfile.BackupSuffix)
+		cm.logger.Logf("  Migrated %s of container '%s' into the state store.", containerMetaFile, containerID)
 	}
+}
 
-	if len(idsToStart) > 0 {
-		cm.logger.Logf("Attempting to restart %d containers marked as running...", len(idsToStart))
-		for _, id := range idsToStart {
-			// Use a goroutine to avoid blocking startup? Or start sequentially?
-			// Starting sequentially for now.
-			go func(containerID string) { // Use goroutine to avoid blocking main startup
-				cm.logger.Logf("Attempting to start web server for container '%s' based on persisted status...", containerID)
-				err := cm.StartWebServer(containerID) // StartWebServer needs to handle locking
-				if err != nil {
-					cm.logger.Logf("Failed to auto-start web server for container '%s': %v", containerID, err)
-					// Update status to error in memory? Metadata update happens in Start/Stop
-				}
-			}(id)
+// SetEventBus attaches the bus that container lifecycle events are published to. The manager
+// also subscribes to theme events, which invalidate the cached layers of applied themes: an
+// installed, updated, rolled back or removed theme may be a parent of any applied theme.
+func (cm *ContainerManager) SetEventBus(bus *events.Bus) {
+	if old := cm.bus.Swap(bus); old != nil && cm.themeSub != nil {
+		old.Unsubscribe(cm.themeSub) // Ends the previous invalidation goroutine
+	}
+	cm.themeSub = nil
+	if bus == nil {
+		return
+	}
+	cm.themeSub = bus.Subscribe("theme.*")
+	go func(sub *events.Subscription) {
+		for range sub.C {
+			cm.invalidateThemeLayers()
 		}
+	}(cm.themeSub)
+	cm.invalidateThemeLayers() // Changes published before the subscription are not seen
+}
+
+// SetThemeResolver attaches the resolver used to serve applied themes. Without one, containers
+// serve only their own web directory. A nil resolver is ignored.
+func (cm *ContainerManager) SetThemeResolver(resolve ThemeResolver) {
+	if resolve != nil {
+		cm.themes.Store(&resolve)
+		cm.invalidateThemeLayers()
+	}
+}
+
+// SetSettingsResolver attaches the resolver used to validate UI settings against the schema of
+// the applied theme. A nil resolver is ignored.
+func (cm *ContainerManager) SetSettingsResolver(resolve SettingsResolver) {
+	if resolve != nil {
+		cm.schemas.Store(&resolve)
 	}
 }
 
+// ApplyTheme sets the theme served beneath a container's own web directory; an empty themeID
+// removes it. The theme's settings are merged into the container's ui_settings.json: missing
+// keys get their defaults, values of the wrong type are reset and other values are kept.
+// Running web servers pick up the change with their next request.
+func (cm *ContainerManager) ApplyTheme(id, themeID string) error {
+	cm.mu.RLock()
+	_, exists := cm.containers[id]
+	cm.mu.RUnlock()
+	if !exists {
+		return fmt.Errorf("container '%s' not found in memory", id)
+	}
+	if themeID != "" {
+		resolve := cm.themes.Load()
+		if resolve == nil {
+			return fmt.Errorf("cannot apply theme '%s' to container '%s': no theme resolver configured", themeID, id)
+		}
+		if _, err := (*resolve)(themeID); err != nil {
+			return fmt.Errorf("cannot apply theme '%s' to container '%s': %w", themeID, id, err)
+		}
+	}
+
+	err := cm.state.Update(func(tx *store.Tx) error {
+		meta, exists, err := ContainersBucket.Get(tx, id)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("no metadata stored for container '%s'", id)
+		}
+		meta.AppliedTheme = themeID
+		return ContainersBucket.Put(tx, id, meta)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store applied theme of container '%s': %w", id, err)
+	}
+
+	cm.mu.Lock()
+	if info, exists := cm.containers[id]; exists {
+		info.Theme = themeID
+	}
+	cm.mu.Unlock()
+	cm.invalidateThemeLayers()
+
+	// Settings are merged once the theme is stored as applied: if merging fails, the container
+	// runs the theme with incomplete settings, which 'containers settings' can still edit, instead
+	// of settings for a theme that was never applied.
+	var mergeErr error
+	if themeID != "" {
+		if err := cm.mergeThemeSettings(id, themeID); err != nil {
+			mergeErr = fmt.Errorf("applied theme '%s' to container '%s' but failed to merge its settings: %w", themeID, id, err)
+		}
+	}
+
+	if themeID == "" {
+		cm.logger.Logf("Removed the applied theme of container %s.", id)
+	} else {
+		cm.logger.Logf("Applied theme %s to container %s.", themeID, id)
+	}
+	cm.bus.Load().Publish(events.TopicContainerTheme, map[string]string{"container_id": id, "theme_id": themeID})
+	return mergeErr
+}
+
+// settingsSchema returns the settings schema of the theme applied to a container.
+func (cm *ContainerManager) settingsSchema(id, themeID string) (SettingsSchema, error) {
+	resolve := cm.schemas.Load()
+	if resolve == nil {
+		return nil, fmt.Errorf("no settings resolver configured")
+	}
+	schema, err := (*resolve)(themeID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load settings schema of theme '%s' for container '%s': %w", themeID, id, err)
+	}
+	return schema, nil
+}
+
+// uiSettingsPath returns the path of a container's ui_settings.json.
+func (cm *ContainerManager) uiSettingsPath(id string) string {
+	return filepath.Join(cm.baseDir, id, uiSettingsFile)
+}
+
+// writeUISettings replaces a container's ui_settings.json.
+func (cm *ContainerManager) writeUISettings(id string, settings map[string]interface{}) error {
+	data, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode UI settings of container '%s': %w", id, err)
+	}
+	if err := atomicfile.WriteFile(cm.uiSettingsPath(id), append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write UI settings of container '%s': %w", id, err)
+	}
+	return nil
+}
+
+// mergeThemeSettings completes a container's ui_settings.json with the settings of themeID.
+// Keys the schema does not declare are kept, since templates of the container may use them.
+func (cm *ContainerManager) mergeThemeSettings(id, themeID string) error {
+	if cm.schemas.Load() == nil {
+		return nil // Settings are not managed without a resolver
+	}
+	schema, err := cm.settingsSchema(id, themeID)
+	if err != nil {
+		return err
+	}
+
+	cm.settingsMu.Lock()
+	defer cm.settingsMu.Unlock()
+	settings, err := readUISettings(cm.uiSettingsPath(id))
+	if err != nil {
+		return err
+	}
+	added, reset := 0, 0
+	for key, value := range schema.Defaults() {
+		current, exists := settings[key]
+		switch {
+		case !exists:
+			added++
+		case !schema.Valid(key, current):
+			cm.logger.Logf("  Setting '%s' of container %s has a value of the wrong type (%v); resetting it to %v.", key, id, current, value)
+			reset++
+		default:
+			continue
+		}
+		settings[key] = value
+	}
+	if added == 0 && reset == 0 {
+		return nil
+	}
+	if err := cm.writeUISettings(id, settings); err != nil {
+		return err
+	}
+	cm.logger.Logf("  Updated UI settings of container %s: %d added, %d reset.", id, added, reset)
+	return nil
+}
+
+// UISettings returns the contents of a container's ui_settings.json and the settings schema of
+// its applied theme. The schema is nil when no theme is applied or no resolver is configured.
+func (cm *ContainerManager) UISettings(id string) (map[string]interface{}, SettingsSchema, error) {
+	cm.mu.RLock()
+	info, exists := cm.containers[id]
+	themeID := ""
+	if exists {
+		themeID = info.Theme
+	}
+	cm.mu.RUnlock()
+	if !exists {
+		return nil, nil, fmt.Errorf("container '%s' not found in memory", id)
+	}
+
+	settings, err := readUISettings(cm.uiSettingsPath(id))
+	if err != nil {
+		return nil, nil, err
+	}
+	if themeID == "" || cm.schemas.Load() == nil {
+		return settings, nil, nil
+	}
+	schema, err := cm.settingsSchema(id, themeID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return settings, schema, nil
+}
+
+// SetUISettings validates raw key=value assignments against the settings schema of the
+// container's applied theme and stores them in its ui_settings.json. Either all values are
+// stored or none.
+func (cm *ContainerManager) SetUISettings(id string, values map[string]string) error {
+	cm.mu.RLock()
+	info, exists := cm.containers[id]
+	themeID := ""
+	if exists {
+		themeID = info.Theme
+	}
+	cm.mu.RUnlock()
+	if !exists {
+		return fmt.Errorf("container '%s' not found in memory", id)
+	}
+	if themeID == "" {
+		return fmt.Errorf("container '%s' has no theme applied, so there is no settings schema to validate against", id)
+	}
+	schema, err := cm.settingsSchema(id, themeID)
+	if err != nil {
+		return err
+	}
+
+	parsed := make(map[string]interface{}, len(values))
+	for key, raw := range values {
+		value, err := schema.Parse(key, raw)
+		if err != nil {
+			return fmt.Errorf("invalid value for theme '%s': %w", themeID, err)
+		}
+		parsed[key] = value
+	}
+
+	cm.settingsMu.Lock()
+	defer cm.settingsMu.Unlock()
+	settings, err := readUISettings(cm.uiSettingsPath(id))
+	if err != nil {
+		return err
+	}
+	for key, value := range parsed {
+		settings[key] = value
+	}
+	if err := cm.writeUISettings(id, settings); err != nil {
+		return err
+	}
+	cm.logger.Logf("Updated %d UI setting(s) of container %s.", len(parsed), id)
+	return nil
+}
+
+// themeLayers returns the directories of the theme applied to a container, child first, or nil
+// when no theme is applied or it cannot be resolved (e.g. its parent was removed). It is called
+// for every request, so the result is cached until ApplyTheme or a theme event invalidates it;
+// resolving takes the theme manager's lock, which installs and updates hold while downloading.
+func (cm *ContainerManager) themeLayers(id string) []string {
+	cm.layerMu.Lock()
+	layers, cached := cm.layerCache[id]
+	gen := cm.layerGen
+	cm.layerMu.Unlock()
+	if cached {
+		return layers
+	}
+
+	layers = cm.resolveThemeLayers(id)
+	cm.layerMu.Lock()
+	if cm.layerGen == gen {
+		cm.layerCache[id] = layers
+	}
+	cm.layerMu.Unlock()
+	return layers
+}
+
+// invalidateThemeLayers drops the cached layers of every container.
+func (cm *ContainerManager) invalidateThemeLayers() {
+	cm.layerMu.Lock()
+	cm.layerGen++
+	cm.layerCache = make(map[string][]string)
+	cm.layerMu.Unlock()
+}
+
+// resolveThemeLayers looks up the layers of the theme applied to a container (see themeLayers).
+func (cm *ContainerManager) resolveThemeLayers(id string) []string {
+	cm.mu.RLock()
+	info, exists := cm.containers[id]
+	themeID := ""
+	if exists {
+		themeID = info.Theme
+	}
+	cm.mu.RUnlock()
+
+	resolve := cm.themes.Load()
+	if themeID == "" || resolve == nil {
+		return nil
+	}
+	layers, err := (*resolve)(themeID)
+	if err != nil {
+		return nil
+	}
+	return layers
+}
+
+// SetTemplatePollInterval sets how often web servers check their cached templates for changes.
+// With 0 (the default) every request checks the files of its template instead. Servers that are
+// already running keep their interval; the new one applies to containers started afterwards.
+func (cm *ContainerManager) SetTemplatePollInterval(interval time.Duration) {
+	if interval < 0 {
+		interval = 0
+	}
+	cm.pollNanos.Store(int64(interval))
+}
+
+// SetGlobalHost changes the host that container web servers bind to. Servers that are already
+// running keep their address; the new host applies to containers started afterwards.
+func (cm *ContainerManager) SetGlobalHost(host string) {
+	if host == "" {
+		host = "0.0.0.0"
+	}
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.globalHost = host
+}
+
 // CreateContainer creates a new container instance, directory structure, and metadata file.
 func (cm *ContainerManager) CreateContainer(name string, port int) (*ContainerInfo, error) {
 	cm.mu.Lock()
@@ -178,7 +594,7 @@ func (cm *ContainerManager) CreateContainer(name string, port int) (*ContainerIn
 	}
 
 	// 2. Create directory structure
-	containerBasePath := filepath.Join(containersDir, ctrID)
+	containerBasePath := filepath.Join(cm.baseDir, ctrID)
 	dirsToCreate := []string{
 		filepath.Join(containerBasePath, "configs"),
 		filepath.Join(containerBasePath, "plugins"),
@@ -200,18 +616,18 @@ func (cm *ContainerManager) CreateContainer(name string, port int) (*ContainerIn
 		cm.logger.Logf("Port %d invalid or not specified for new container %s. Assigned random port: %d", port, ctrID, assignedPort)
 	}
 
-	// 4. Create and write metadata file
+	// 4. Store metadata
 	meta := ContainerMetadata{
 		ID:     ctrID,
 		Name:   name, // Use provided name
 		Port:   assignedPort,
 		Status: StatusStopped, // Initial status is stopped
 	}
-	metaFilePath := filepath.Join(containerBasePath, containerMetaFile)
-	if err := writeMetadata(metaFilePath, &meta); err != nil {
-		// Attempt cleanup? Remove created directories?
+	if err := cm.state.Update(func(tx *store.Tx) error {
+		return ContainersBucket.Put(tx, ctrID, meta)
+	}); err != nil {
 		os.RemoveAll(containerBasePath)
-		return nil, fmt.Errorf("failed to write container metadata file '%s': %w", metaFilePath, err)
+		return nil, fmt.Errorf("failed to store metadata for container '%s': %w", ctrID, err)
 	}
 
 	// 5. Register the new container in memory
@@ -224,20 +640,29 @@ func (cm *ContainerManager) CreateContainer(name string, port int) (*ContainerIn
 	cm.containers[ctrID] = info
 
 	cm.logger.Logf("Created container '%s' (ID: %s) with port %d. Metadata saved.", name, ctrID, assignedPort)
+	cm.bus.Load().Publish(events.TopicContainerCreated, map[string]string{"container_id": ctrID, "name": name, "port": strconv.Itoa(assignedPort)})
 	return info, nil
 }
 
-// writeMetadata marshals metadata to YAML and writes it to the specified path.
-func writeMetadata(path string, meta *ContainerMetadata) error {
-	data, err := yaml.Marshal(meta)
-	if err != nil {
-		return fmt.Errorf("failed to marshal metadata: %w", err)
+// readMetadata reads and parses a legacy container.yaml file. If the file is missing, unparsable or
+// invalid, it is restored from its .bak copy when that copy is usable, and recovered is true.
+func readMetadata(path string) (meta *ContainerMetadata, recovered bool, err error) {
+	var parsed ContainerMetadata
+	validate := func(data []byte) error {
+		var candidate ContainerMetadata
+		if err := yaml.Unmarshal(data, &candidate); err != nil {
+			return fmt.Errorf("failed to parse metadata: %w", err)
+		}
+		if !candidate.IsValid() {
+			return fmt.Errorf("metadata is missing required fields")
+		}
+		parsed = candidate
+		return nil
 	}
-	err = os.WriteFile(path, data, 0644)
-	if err != nil {
-		return fmt.Errorf("failed to write metadata file: %w", err)
+	if _, recovered, err = atomicfile.ReadFile(path, validate); err != nil {
+		return nil, false, err
 	}
-	return nil
+	return &parsed, recovered, nil
 }
 
 // GetContainerInfo retrieves runtime information about a specific container.
@@ -293,12 +718,16 @@ func (cm *ContainerManager) StartWebServer(id string) error {
 	addr := cm.globalHost + ":" + strconv.Itoa(info.Port)
 
 	// Create the specific handler for this container
-	handler, err := NewContainerWebHandler(info.WebDir) // Remove logger argument
+	handler, err := NewContainerWebHandler(info.WebDir, WebHandlerOptions{ // Remove logger argument
+		ThemeLayers:          func() []string { return cm.themeLayers(id) },
+		TemplatePollInterval: time.Duration(cm.pollNanos.Load()),
+	})
 	if err != nil {
 		info.Status = StatusError
 		info.LastError = fmt.Sprintf("Failed to create web handler: %v", err)
 		// Update metadata status to error? Or just keep runtime error? Keep runtime for now.
 		cm.mu.Unlock()
+		cm.bus.Load().Publish(events.TopicContainerError, map[string]string{"container_id": id, "error": info.LastError})
 		return fmt.Errorf("failed to create web handler for container '%s': %w", id, err)
 	}
 
@@ -308,6 +737,20 @@ func (cm *ContainerManager) StartWebServer(id string) error {
 		Handler: handler,
 		// TODO: Add timeouts
 	}
+	closeHandler := func() { handler.Close() } // Stops the template watcher
+	server.RegisterOnShutdown(closeHandler)
+
+	// Bind before reporting the container as running, so a port that is in use fails the start
+	// instead of surfacing later from the server goroutine.
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		closeHandler()
+		info.Status = StatusError
+		info.LastError = fmt.Sprintf("Failed to listen on %s: %v", addr, err)
+		cm.mu.Unlock()
+		cm.bus.Load().Publish(events.TopicContainerError, map[string]string{"container_id": id, "error": info.LastError})
+		return fmt.Errorf("failed to start web server for container '%s': %w", id, err)
+	}
 	info.webServer = server
 	info.Status = StatusRunning // Update runtime status
 	info.LastError = ""
@@ -315,16 +758,16 @@ func (cm *ContainerManager) StartWebServer(id string) error {
 	cm.mu.Unlock()           // Unlock before blocking/goroutine and metadata write
 
 	// Update persistent metadata status
-	metaFilePath := filepath.Join(containersDir, id, containerMetaFile)
-	if err := updateMetadataStatus(metaFilePath, StatusRunning); err != nil {
+	if err := cm.updateMetadataStatus(id, StatusRunning); err != nil {
 		// Log the error, but the server is already starting/started in memory.
 		cm.logger.Logf("Warning: Failed to update container metadata status to running for '%s': %v", id, err)
 	}
 
 	cm.logger.Logf("Starting web server for container %s on %s", id, addr)
 	go func() {
-		err := server.ListenAndServe()
+		err := server.Serve(listener)
 		if err != nil && err != http.ErrServerClosed {
+			closeHandler()
 			cm.logger.Logf("Error: Web server for container %s failed: %v", id, err)
 			// Update runtime status on error
 			cm.mu.Lock()
@@ -336,13 +779,15 @@ func (cm *ContainerManager) StartWebServer(id string) error {
 				cm.containers[id] = info
 			}
 			cm.mu.Unlock()
+			cm.bus.Load().Publish(events.TopicContainerError, map[string]string{"container_id": id, "error": err.Error()})
 			// Also update persistent status to error? Or stopped? Let's set to stopped.
-			updateMetadataStatus(metaFilePath, StatusStopped) // Or StatusError if we add it to metadata
+			cm.updateMetadataStatus(id, StatusStopped) // Or StatusError if we add it to metadata
 		} else {
 			cm.logger.Logf("Info: Web server for container %s stopped gracefully.", id)
 		}
 	}()
 
+	cm.bus.Load().Publish(events.TopicContainerStarted, map[string]string{"container_id": id, "address": addr}) // The listener is bound
 	return nil
 }
 
@@ -371,8 +816,7 @@ func (cm *ContainerManager) StopWebServer(id string) error {
 	cm.mu.Unlock() // Unlock before blocking shutdown and metadata write
 
 	// Update persistent metadata status
-	metaFilePath := filepath.Join(containersDir, id, containerMetaFile)
-	if err := updateMetadataStatus(metaFilePath, StatusStopped); err != nil {
+	if err := cm.updateMetadataStatus(id, StatusStopped); err != nil {
 		// Log the error, but proceed with shutdown.
 		cm.logger.Logf("Warning: Failed to update container metadata status to stopped for '%s': %v", id, err)
 	}
@@ -385,30 +829,28 @@ func (cm *ContainerManager) StopWebServer(id string) error {
 	if err != nil {
 		cm.logger.Logf("Error: Graceful shutdown for container %s failed: %v", id, err)
 		// Runtime status is already Stopped. Metadata status is already Stopped.
+		cm.bus.Load().Publish(events.TopicContainerError, map[string]string{"container_id": id, "error": err.Error()})
 		return fmt.Errorf("graceful shutdown failed for container '%s': %w", id, err)
 	}
 
 	cm.logger.Logf("Web server for container %s stopped successfully.", id)
+	cm.bus.Load().Publish(events.TopicContainerStopped, map[string]string{"container_id": id})
 	return nil
 }
 
-// updateMetadataStatus reads, updates status, and writes back metadata.
-func updateMetadataStatus(metaFilePath string, newStatus ContainerStatus) error {
-	// Read existing metadata
-	metaData, err := os.ReadFile(metaFilePath)
-	if err != nil {
-		return fmt.Errorf("failed to read metadata file '%s' for update: %w", metaFilePath, err)
-	}
-
-	var meta ContainerMetadata
-	err = yaml.Unmarshal(metaData, &meta)
-	if err != nil {
-		return fmt.Errorf("failed to parse metadata file '%s' for update: %w", metaFilePath, err)
-	}
-
-	// Update status and write back
-	meta.Status = newStatus
-	return writeMetadata(metaFilePath, &meta)
+// updateMetadataStatus updates the persisted status of a container in one store transaction.
+func (cm *ContainerManager) updateMetadataStatus(id string, newStatus ContainerStatus) error {
+	return cm.state.Update(func(tx *store.Tx) error {
+		meta, exists, err := ContainersBucket.Get(tx, id)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("no metadata stored for container '%s'", id)
+		}
+		meta.Status = newStatus
+		return ContainersBucket.Put(tx, id, meta)
+	})
 }
 
 // TODO: Implement functions for deleting containers (needs to stop server first, remove dir).
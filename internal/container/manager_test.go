@@ -0,0 +1,140 @@
+package container
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/OG-Open-Source/PanelBase/internal/configuration"
+	"github.com/OG-Open-Source/PanelBase/internal/events"
+	"github.com/OG-Open-Source/PanelBase/internal/logger"
+	"github.com/OG-Open-Source/PanelBase/internal/utils"
+)
+
+func TestThemeLayersAreCachedUntilThemeEvents(t *testing.T) {
+	log, err := logger.NewLogger(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+	idGen, err := utils.NewIDGenerator(&configuration.SecurityConfig{Secrets: configuration.SecretsConfig{Alphabet: "abcdef0123456789", Length: 12}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	configuration.SetStateDir(t.TempDir())
+	cm, err := NewContainerManager(idGen, "127.0.0.1", log, filepath.Join(t.TempDir(), "containers"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := cm.CreateContainer("demo", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var resolves atomic.Int32
+	var parent atomic.Value
+	parent.Store("parent-v1")
+	cm.SetThemeResolver(func(themeID string) ([]string, error) {
+		resolves.Add(1)
+		return []string{themeID, parent.Load().(string)}, nil
+	})
+	bus := events.NewBus()
+	cm.SetEventBus(bus)
+	if err := cm.ApplyTheme(info.ID, "thm_child"); err != nil {
+		t.Fatalf("ApplyTheme: %v", err)
+	}
+
+	resolves.Store(0)
+	for i := 0; i < 3; i++ {
+		if layers := cm.themeLayers(info.ID); len(layers) != 2 || layers[1] != "parent-v1" {
+			t.Fatalf("themeLayers = %q", layers)
+		}
+	}
+	if n := resolves.Load(); n != 1 {
+		t.Errorf("resolved %d times for 3 requests; want 1", n)
+	}
+
+	// Updating any theme (here the parent) invalidates the cache.
+	parent.Store("parent-v2")
+	bus.Publish(events.TopicThemeUpdated, map[string]string{"theme_id": "thm_parent"})
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if layers := cm.themeLayers(info.ID); len(layers) == 2 && layers[1] == "parent-v2" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("cached layers were not invalidated by the theme event")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := cm.ApplyTheme(info.ID, ""); err != nil {
+		t.Fatalf("ApplyTheme: %v", err)
+	}
+	if layers := cm.themeLayers(info.ID); layers != nil {
+		t.Errorf("themeLayers after removing the theme = %q; want none", layers)
+	}
+}
+
+func TestPersistedRunningContainersStartOnlyWhenAsked(t *testing.T) {
+	log, err := logger.NewLogger(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+	idGen, err := utils.NewIDGenerator(&configuration.SecurityConfig{Secrets: configuration.SecretsConfig{Alphabet: "abcdef0123456789", Length: 12}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	configuration.SetStateDir(t.TempDir())
+	containersDir := filepath.Join(t.TempDir(), "containers")
+	cm, err := NewContainerManager(idGen, "127.0.0.1", log, containersDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	free, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := free.Addr().(*net.TCPAddr).Port
+	free.Close()
+	info, err := cm.CreateContainer("demo", port)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// What a CLI process without a server does: only the persisted status changes.
+	if err := cm.SetPersistedStatus(info.ID, StatusRunning); err != nil {
+		t.Fatalf("SetPersistedStatus: %v", err)
+	}
+	if err := cm.SetPersistedStatus(info.ID, ContainerStatus("paused")); err == nil {
+		t.Error("SetPersistedStatus accepted an unknown status")
+	}
+
+	// Loading the containers again, as every CLI process does, must not serve them.
+	reloaded, err := NewContainerManager(idGen, "127.0.0.1", log, containersDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := reloaded.GetContainerInfo(info.ID); got == nil || got.Status != StatusStopped {
+		t.Fatalf("container after loading = %+v; want it loaded and stopped", got)
+	}
+	if conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", fmt.Sprint(port))); err == nil {
+		conn.Close()
+		t.Fatal("loading the containers started a web server")
+	}
+
+	reloaded.StartPersistedContainers()
+	defer reloaded.StopWebServer(info.ID)
+	if got, _ := reloaded.GetContainerInfo(info.ID); got == nil || got.Status != StatusRunning {
+		t.Fatalf("container after StartPersistedContainers = %+v; want it running", got)
+	}
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", fmt.Sprint(port)))
+	if err != nil {
+		t.Fatalf("web server not reachable: %v", err)
+	}
+	conn.Close()
+}
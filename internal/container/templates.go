@@ -0,0 +1,400 @@
+package container
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/OG-Open-Source/PanelBase/internal/utils"
+)
+
+const (
+	layoutsDir    = "layouts"  // Page skeletons, e.g. layouts/base.html with {{block "content" .}}
+	partialsDir   = "partials" // Shared fragments, e.g. partials/nav.html
+	i18nDir       = "i18n"     // Message catalogs, e.g. i18n/en.json with {"nav.home": "Home"}
+	defaultLocale = "en"
+	localeSetting = "locale" // UI setting that overrides the browser's Accept-Language
+)
+
+// dateLayouts are the named layouts accepted by the date template helper.
+var dateLayouts = map[string]string{
+	"date":     "2006-01-02",
+	"datetime": "2006-01-02 15:04",
+	"time":     "15:04",
+	"rfc3339":  time.RFC3339,
+}
+
+// isSharedTemplatePath reports whether a request path points into layouts/ or partials/. Those
+// files are fragments and are only rendered as part of a page.
+func isSharedTemplatePath(reqPath string) bool {
+	first, _, _ := strings.Cut(strings.TrimPrefix(reqPath, "/"), "/")
+	return first == layoutsDir || first == partialsDir
+}
+
+// sharedTemplates returns the layout and partial files visible through roots, keyed by their
+// slash-separated path relative to the root (e.g. "partials/nav.html"). A file in an earlier
+// root overrides one with the same name in a later root. The scanned directories, including
+// missing ones, are added to deps so that added or removed files are noticed.
+func sharedTemplates(roots []string, deps *fileStamps) (map[string]string, error) {
+	files := make(map[string]string)
+	for _, root := range roots {
+		for _, dir := range []string{layoutsDir, partialsDir} {
+			base := filepath.Join(root, dir)
+			deps.add(base)
+			err := filepath.WalkDir(base, func(p string, d fs.DirEntry, err error) error {
+				if err != nil {
+					if os.IsNotExist(err) {
+						return nil
+					}
+					return err
+				}
+				if d.IsDir() {
+					if p != base {
+						deps.add(p)
+					}
+					return nil
+				}
+				ext := strings.ToLower(filepath.Ext(p))
+				if ext != ".html" && ext != ".htm" {
+					return nil
+				}
+				rel, err := filepath.Rel(root, p)
+				if err != nil {
+					return err
+				}
+				name := filepath.ToSlash(rel)
+				if _, exists := files[name]; !exists {
+					files[name] = p
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to scan '%s': %w", base, err)
+			}
+		}
+	}
+	return files, nil
+}
+
+// parsePage parses an HTML page together with the layouts and partials visible through roots.
+// Layouts are parsed first, so a page can call {{template "layouts/base.html" .}} and fill the
+// layout's {{block}}s with its own {{define}}s. Every file read is added to deps.
+func parsePage(pagePath string, roots []string, funcs template.FuncMap, deps *fileStamps) (*template.Template, error) {
+	shared, err := sharedTemplates(roots, deps)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(shared))
+	for name := range shared {
+		names = append(names, name)
+	}
+	sort.Strings(names) // "layouts/" sorts before "partials/"
+
+	tmpl := template.New(filepath.Base(pagePath)).Funcs(funcs)
+	for _, name := range names {
+		deps.add(shared[name]) // Stamped before reading, so a concurrent edit invalidates the result
+		content, err := os.ReadFile(shared[name])
+		if err != nil {
+			return nil, fmt.Errorf("failed to read template '%s': %w", shared[name], err)
+		}
+		if _, err := tmpl.New(name).Parse(string(content)); err != nil {
+			return nil, fmt.Errorf("failed to parse template '%s': %w", name, err)
+		}
+	}
+	deps.add(pagePath)
+	content, err := os.ReadFile(pagePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read page '%s': %w", pagePath, err)
+	}
+	if _, err := tmpl.Parse(string(content)); err != nil {
+		return nil, fmt.Errorf("failed to parse page '%s': %w", pagePath, err)
+	}
+	return tmpl, nil
+}
+
+// assetHash is a cached content hash of an asset file, valid while its size and mtime are unchanged.
+type assetHash struct {
+	size    int64
+	modTime time.Time
+	sum     string
+}
+
+// assetURL returns the URL of an asset with a content hash query, e.g. "/css/site.css?v=1a2b3c4d",
+// so browsers may cache it until it changes. Unknown assets are returned unchanged.
+func (h *ContainerWebHandler) assetURL(roots []string, asset string) string {
+	cleaned := path.Clean("/" + asset)
+	fsPath, info, err := lookup(roots, cleaned)
+	if err != nil || info.IsDir() {
+		return asset
+	}
+	if cached, ok := h.assetHashes.Load(fsPath); ok {
+		c := cached.(assetHash)
+		if c.size == info.Size() && c.modTime.Equal(info.ModTime()) {
+			return cleaned + "?v=" + c.sum
+		}
+	}
+	sum, err := utils.CalculateFileSHA256(fsPath)
+	if err != nil {
+		return asset
+	}
+	h.assetHashes.Store(fsPath, assetHash{size: info.Size(), modTime: info.ModTime(), sum: sum[:8]})
+	return cleaned + "?v=" + sum[:8]
+}
+
+// localePattern matches language tags such as "en", "zh-CN" or "sr-Latn-RS". Anything else is
+// ignored, since locales name catalog files.
+var localePattern = regexp.MustCompile(`^[A-Za-z]{1,8}(-[A-Za-z0-9]{1,8})*$`)
+
+// requestLocale picks the locale of a request: the "locale" UI setting when set, otherwise the
+// first language of Accept-Language, otherwise defaultLocale.
+func requestLocale(r *http.Request, settings map[string]interface{}) string {
+	if locale, ok := settings[localeSetting].(string); ok && localePattern.MatchString(strings.TrimSpace(locale)) {
+		return strings.TrimSpace(locale)
+	}
+	if r != nil {
+		first, _, _ := strings.Cut(r.Header.Get("Accept-Language"), ",")
+		tag, _, _ := strings.Cut(first, ";")
+		if tag = strings.TrimSpace(tag); localePattern.MatchString(tag) {
+			return tag
+		}
+	}
+	return defaultLocale
+}
+
+// loadMessages merges the message catalogs for locale found in roots. Lookups fall back from
+// e.g. "zh-CN" to "zh" and then to defaultLocale; within a locale, earlier roots (the child
+// theme) override later ones. Unreadable catalogs are skipped. Every catalog path, including
+// missing ones, is added to deps.
+func loadMessages(roots []string, locale string, deps *fileStamps) map[string]string {
+	candidates := []string{defaultLocale}
+	if base, _, found := strings.Cut(locale, "-"); found && base != defaultLocale {
+		candidates = append(candidates, base)
+	}
+	if locale != defaultLocale {
+		candidates = append(candidates, locale)
+	}
+	messages := make(map[string]string)
+	for _, candidate := range candidates { // Least specific first
+		for i := len(roots) - 1; i >= 0; i-- { // Parents first
+			catalogPath := filepath.Join(roots[i], i18nDir, candidate+".json")
+			deps.add(catalogPath)
+			data, err := os.ReadFile(catalogPath)
+			if err != nil {
+				continue
+			}
+			var catalog map[string]string
+			if json.Unmarshal(data, &catalog) != nil {
+				continue
+			}
+			for key, message := range catalog {
+				messages[key] = message
+			}
+		}
+	}
+	return messages
+}
+
+// formatDate formats value, which may be a time.Time, an RFC 3339 string or Unix seconds, with
+// a Go time layout or one of the names in dateLayouts. An empty value formats as "".
+func formatDate(layout string, value interface{}) (string, error) {
+	if named, ok := dateLayouts[layout]; ok {
+		layout = named
+	}
+	var t time.Time
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case time.Time:
+		t = v
+	case string:
+		if v == "" {
+			return "", nil
+		}
+		parsed, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return "", fmt.Errorf("date: '%s' is not an RFC 3339 time", v)
+		}
+		t = parsed
+	case int:
+		t = time.Unix(int64(v), 0)
+	case int64:
+		t = time.Unix(v, 0)
+	case float64: // Numbers from ui_settings.json
+		t = time.Unix(int64(v), 0)
+	default:
+		return "", fmt.Errorf("date: unsupported value of type %T", value)
+	}
+	return t.Format(layout), nil
+}
+
+// templateFuncs returns the helpers available to pages and error templates:
+//
+//	{{asset "css/site.css"}}         URL with a content hash, e.g. /css/site.css?v=1a2b3c4d
+//	{{t "nav.home"}}                 message from i18n/<locale>.json, or the key itself
+//	{{t "greeting" .name}}           message used as a fmt format with the given arguments
+//	{{locale}}                       locale of the request, e.g. for <html lang="...">
+//	{{date "date" .installed_at}}    formatted time; also "datetime", "time", "rfc3339" or a Go layout
+//	{{now}}                          current time
+//
+// The helpers depend only on roots, locale and messages, so a compiled template can be shared
+// by all requests for the same locale.
+func (h *ContainerWebHandler) templateFuncs(roots []string, locale string, messages map[string]string) template.FuncMap {
+	return template.FuncMap{
+		"asset": func(asset string) string { return h.assetURL(roots, asset) },
+		"t": func(key string, args ...interface{}) string {
+			message, ok := messages[key]
+			if !ok {
+				message = key
+			}
+			if len(args) > 0 {
+				return fmt.Sprintf(message, args...)
+			}
+			return message
+		},
+		"locale": func() string { return locale },
+		"date":   formatDate,
+		"now":    time.Now,
+	}
+}
+
+// maxCachedTemplates bounds the template cache; locales come from request headers, so the number
+// of distinct keys is not under the theme's control.
+const maxCachedTemplates = 256
+
+// fileStamp is the size and modification time of a file or directory when it was read.
+type fileStamp struct {
+	path    string
+	exists  bool
+	size    int64
+	modTime time.Time
+}
+
+// fileStamps lists the files and directories a compiled template was built from.
+type fileStamps []fileStamp
+
+// add records the current state of path.
+func (s *fileStamps) add(path string) {
+	*s = append(*s, stampFile(path))
+}
+
+// changed reports whether any recorded path was modified, created or removed since it was recorded.
+func (s fileStamps) changed() bool {
+	for _, stamp := range s {
+		if !stampFile(stamp.path).same(stamp) {
+			return true
+		}
+	}
+	return false
+}
+
+// same reports whether two stamps of a file describe the same state.
+func (s fileStamp) same(other fileStamp) bool {
+	return s.path == other.path && s.exists == other.exists && s.size == other.size && s.modTime.Equal(other.modTime)
+}
+
+// stampFile returns the current fileStamp of path.
+func stampFile(path string) fileStamp {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{path: path}
+	}
+	return fileStamp{path: path, exists: true, size: info.Size(), modTime: info.ModTime()}
+}
+
+// cachedTemplate is a compiled page with the files it was compiled from.
+type cachedTemplate struct {
+	tmpl *template.Template
+	deps fileStamps
+}
+
+// templateCache holds compiled pages keyed by locale, page path and roots. Without a watcher,
+// entries are checked against their files on every use; with one, they are trusted and the
+// watcher evicts changed entries every poll interval.
+type templateCache struct {
+	mu      sync.Mutex
+	entries map[string]*cachedTemplate
+	watched bool // Set when the polling watcher runs
+}
+
+// template returns the compiled page at pagePath for the given roots and locale, compiling it
+// when it is not cached or its files have changed.
+func (h *ContainerWebHandler) template(pagePath string, roots []string, locale string) (*template.Template, error) {
+	key := locale + "\x00" + pagePath + "\x00" + strings.Join(roots, "\x00")
+	h.templates.mu.Lock()
+	entry, watched := h.templates.entries[key], h.templates.watched
+	h.templates.mu.Unlock()
+	if entry != nil && (watched || !entry.deps.changed()) {
+		return entry.tmpl, nil
+	}
+
+	var deps fileStamps
+	messages := loadMessages(roots, locale, &deps)
+	tmpl, err := parsePage(pagePath, roots, h.templateFuncs(roots, locale, messages), &deps)
+	if err != nil {
+		return nil, err
+	}
+
+	h.templates.mu.Lock()
+	defer h.templates.mu.Unlock()
+	if h.templates.entries == nil {
+		h.templates.entries = make(map[string]*cachedTemplate)
+	}
+	if _, exists := h.templates.entries[key]; !exists && len(h.templates.entries) >= maxCachedTemplates {
+		for evict := range h.templates.entries { // Evict an arbitrary entry
+			delete(h.templates.entries, evict)
+			break
+		}
+	}
+	h.templates.entries[key] = &cachedTemplate{tmpl: tmpl, deps: deps}
+	return tmpl, nil
+}
+
+// watchTemplates evicts cached pages whose files have changed every interval until stop is
+// closed. While it runs, requests use cached pages without checking their files.
+func (h *ContainerWebHandler) watchTemplates(interval time.Duration, stop <-chan struct{}) {
+	h.templates.mu.Lock()
+	h.templates.watched = true
+	h.templates.mu.Unlock()
+	defer func() { // Fall back to checking files on use once stopped
+		h.templates.mu.Lock()
+		h.templates.watched = false
+		h.templates.mu.Unlock()
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		h.templates.mu.Lock()
+		entries := make(map[string]*cachedTemplate, len(h.templates.entries))
+		for key, entry := range h.templates.entries {
+			entries[key] = entry
+		}
+		h.templates.mu.Unlock()
+
+		for key, entry := range entries { // Stat outside the lock
+			if !entry.deps.changed() {
+				continue
+			}
+			h.templates.mu.Lock()
+			if h.templates.entries[key] == entry {
+				delete(h.templates.entries, key)
+			}
+			h.templates.mu.Unlock()
+		}
+	}
+}
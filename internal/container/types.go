@@ -19,18 +19,22 @@ type ContainerInfo struct {
 	Status    ContainerStatus `json:"status"`              // Current runtime status
 	Port      int             `json:"port"`                // Port the web server listens on
 	WebDir    string          `json:"webDir"`              // Path to the container's web root
+	Theme     string          `json:"theme,omitempty"`     // ID of the applied theme, empty for none
 	webServer *http.Server    `json:"-"`                   // Instance of the running web server
 	LastError string          `json:"lastError,omitempty"` // Last error message at runtime
 }
 
-// ContainerMetadata represents the persistent configuration stored in container.yaml.
+// ContainerMetadata represents the persistent configuration of a container, stored in the
+// containers bucket of the state store (formerly in container.yaml).
 type ContainerMetadata struct {
-	ID     string          `yaml:"id"`             // Mandatory: Should match the directory name
-	Name   string          `yaml:"name,omitempty"` // Optional: User-friendly name
-	Port   int             `yaml:"port"`           // Mandatory: Port for the web server
-	Status ContainerStatus `yaml:"status"`         // Mandatory: Desired/last known status (running/stopped)
+	ID     string          `yaml:"id" json:"id"`                         // Mandatory: Should match the directory name
+	Name   string          `yaml:"name,omitempty" json:"name,omitempty"` // Optional: User-friendly name
+	Port   int             `yaml:"port" json:"port"`                     // Mandatory: Port for the web server
+	Status ContainerStatus `yaml:"status" json:"status"`                 // Mandatory: Desired/last known status (running/stopped)
+	// AppliedTheme is the ID of the theme whose files (merged with its parents') are served
+	// beneath the container's own web directory. Empty for none.
+	AppliedTheme string `yaml:"applied_theme,omitempty" json:"applied_theme,omitempty"`
 	// Add other persistent config fields here, e.g.:
-	// AppliedTheme   string            `yaml:"applied_theme,omitempty"`
 	// EnabledPlugins []string          `yaml:"enabled_plugins,omitempty"`
 	// CustomEnv      map[string]string `yaml:"custom_env,omitempty"`
 }
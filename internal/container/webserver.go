@@ -4,13 +4,16 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
-	"html/template"
 	"net/http"
 	"os"
 	"path"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
+
+	"github.com/OG-Open-Source/PanelBase/internal/atomicfile"
 	// "github.com/OG-Open-Source/PanelBase/internal/logger" // TODO: Inject logger later
 )
 
@@ -48,18 +51,101 @@ func (rec *responseRecorder) flush() {
 	rec.ResponseWriter.Write(rec.body.Bytes())
 }
 
-// containerWebHandler serves static files for a specific container's web directory,
+// hiddenFiles are written into every installed theme directory by the theme manager. They
+// record where the theme came from and are neither served nor listed.
+var hiddenFiles = map[string]bool{
+	"theme.json":                           true,
+	"theme.json" + atomicfile.BackupSuffix: true,
+}
+
+// layeredFS is an http.FileSystem that opens each name from the first root that has it.
+// Names in hiddenFiles do not exist in it.
+type layeredFS []string
+
+// Open implements http.FileSystem.
+func (roots layeredFS) Open(name string) (http.File, error) {
+	if hiddenFiles[path.Base(name)] {
+		return nil, os.ErrNotExist
+	}
+	var firstErr error
+	for _, root := range roots {
+		f, err := http.Dir(root).Open(name)
+		if err == nil {
+			return hidingFile{f}, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr == nil {
+		firstErr = os.ErrNotExist
+	}
+	return nil, firstErr
+}
+
+// hidingFile leaves hiddenFiles out of directory listings.
+type hidingFile struct {
+	http.File
+}
+
+// Readdir implements http.File.
+func (f hidingFile) Readdir(count int) ([]os.FileInfo, error) {
+	infos, err := f.File.Readdir(count)
+	visible := infos[:0]
+	for _, info := range infos {
+		if !hiddenFiles[info.Name()] {
+			visible = append(visible, info)
+		}
+	}
+	return visible, err
+}
+
+// lookup stats the slash-separated relative path in each root and returns the first match.
+// Missing files fall through to the next root; other errors (e.g. permission denied) are returned.
+func lookup(roots []string, relPath string) (string, os.FileInfo, error) {
+	for _, root := range roots {
+		p := filepath.Join(root, filepath.FromSlash(relPath))
+		info, err := os.Stat(p)
+		if err == nil {
+			return p, info, nil
+		}
+		if os.IsPermission(err) {
+			return "", nil, err
+		}
+	}
+	return "", nil, os.ErrNotExist
+}
+
+// ContainerWebHandler serves static files for a specific container's web directory,
 // applying URL rewriting rules.
-type containerWebHandler struct {
-	webRootDir    string
-	templatesPath string // Path to the templates directory (e.g., /path/to/container/web/templates)
-	fileServer    http.Handler
-	uiSettings    map[string]interface{} // Added field for UI settings
+type ContainerWebHandler struct {
+	webRootDir     string
+	themeLayers    func() []string        // Optional; directories of the applied theme, child first
+	uiSettingsPath string                 // Path to the container's ui_settings.json
+	settingsMu     sync.Mutex             // Guards settings and settingsStamp
+	settings       map[string]interface{} // Last parsed ui_settings.json; shared by requests, never modified
+	settingsStamp  fileStamp              // State of ui_settings.json when settings was parsed
+	assetHashes    sync.Map               // Absolute asset path -> assetHash, for the asset template helper
+	templates      templateCache          // Compiled pages and error templates
+	stopWatcher    chan struct{}          // Closed by Close; nil without a polling watcher
+	closeOnce      sync.Once
 	// logger *logger.Logger // TODO: Add logger
 }
 
+// WebHandlerOptions configures a container web handler.
+type WebHandlerOptions struct {
+	// ThemeLayers returns the directories of the applied theme (child first); they are served
+	// beneath the web root, so files in the container's own web directory override the theme's.
+	ThemeLayers func() []string
+	// TemplatePollInterval > 0 starts a watcher that evicts changed templates from the cache at
+	// that interval; requests then use cached templates without checking their files. With 0,
+	// every request checks the files of its cached template (one stat per file).
+	TemplatePollInterval time.Duration
+}
+
 // NewContainerWebHandler creates a new handler for serving a container's web content.
-func NewContainerWebHandler(webRootDir string /*, logger *logger.Logger*/) (http.Handler, error) {
+// Close stops its template watcher.
+func NewContainerWebHandler(webRootDir string, opts ...WebHandlerOptions /*, logger *logger.Logger*/) (*ContainerWebHandler, error) {
 	// Ensure the web root directory exists
 	webRootStat, err := os.Stat(webRootDir)
 	if err != nil {
@@ -73,71 +159,127 @@ func NewContainerWebHandler(webRootDir string /*, logger *logger.Logger*/) (http
 		return nil, fmt.Errorf("web root '%s' is not a directory", webRootDir)
 	}
 
-	// Determine container root directory (parent of webRootDir)
-	containerRootDir := filepath.Dir(webRootDir)
+	// ui_settings.json lives in the container root directory (parent of webRootDir). It is checked
+	// for changes on every rendered page, so 'containers settings set' applies without a restart.
+	uiSettingsPath := filepath.Join(filepath.Dir(webRootDir), uiSettingsFile)
 
-	// Load UI settings
-	uiSettingsPath := filepath.Join(containerRootDir, uiSettingsFile)
-	var loadedSettings map[string]interface{}
+	handler := &ContainerWebHandler{
+		webRootDir:     webRootDir,
+		uiSettingsPath: uiSettingsPath,
+		// logger: logger,
+	}
+	if len(opts) > 0 {
+		handler.themeLayers = opts[0].ThemeLayers
+		if opts[0].TemplatePollInterval > 0 {
+			handler.stopWatcher = make(chan struct{})
+			go handler.watchTemplates(opts[0].TemplatePollInterval, handler.stopWatcher)
+		}
+	}
+	return handler, nil
+}
 
-	settingsData, err := os.ReadFile(uiSettingsPath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			// logger.Warnf("ui_settings.json not found at %s, proceeding without UI settings.", uiSettingsPath) // TODO: Add logging
-			loadedSettings = make(map[string]interface{}) // Use empty map if not found
-		} else {
-			// logger.Errorf("Error reading ui_settings.json at %s: %v. Proceeding without UI settings.", uiSettingsPath, err) // TODO: Add logging
-			loadedSettings = make(map[string]interface{}) // Use empty map on other read errors
-			// Optionally return error here if settings are critical: return nil, fmt.Errorf("error reading ui settings '%s': %w", uiSettingsPath, err)
+// Close stops the template watcher, if any.
+func (h *ContainerWebHandler) Close() error {
+	h.closeOnce.Do(func() {
+		if h.stopWatcher != nil {
+			close(h.stopWatcher)
+		}
+	})
+	return nil
+}
+
+// uiSettings returns the contents of ui_settings.json, or an empty map when the file is missing.
+// The parsed file is reused while its size and mtime are unchanged. A damaged file is not
+// restored from its backup here (see readUISettings); the last contents that parsed are served
+// until the file is fixed. The returned map must not be modified.
+func (h *ContainerWebHandler) uiSettings() map[string]interface{} {
+	stamp := stampFile(h.uiSettingsPath)
+	h.settingsMu.Lock()
+	defer h.settingsMu.Unlock()
+	if h.settings != nil && stamp.same(h.settingsStamp) {
+		return h.settings
+	}
+
+	var settings map[string]interface{}
+	if stamp.exists {
+		data, err := os.ReadFile(h.uiSettingsPath)
+		if err == nil {
+			err = json.Unmarshal(data, &settings)
 		}
-	} else {
-		err = json.Unmarshal(settingsData, &loadedSettings)
 		if err != nil {
-			// logger.Errorf("Error parsing ui_settings.json at %s: %v. Proceeding with empty UI settings.", uiSettingsPath, err) // TODO: Add logging
-			loadedSettings = make(map[string]interface{}) // Use empty map on unmarshal error
-			// Optionally return error here: return nil, fmt.Errorf("error parsing ui settings '%s': %w", uiSettingsPath, err)
+			// logger.Errorf("Error reading ui_settings.json at %s: %v. Proceeding with the last valid UI settings.", h.uiSettingsPath, err) // TODO: Add logging
+			if h.settings != nil {
+				return h.settings
+			}
+			return make(map[string]interface{})
 		}
-		// logger.Infof("Successfully loaded UI settings from %s", uiSettingsPath) // TODO: Add logging
 	}
+	if settings == nil {
+		settings = make(map[string]interface{}) // Missing, or the file contained "null"
+	}
+	h.settings, h.settingsStamp = settings, stamp
+	return settings
+}
 
-	templatesPath := filepath.Join(webRootDir, templatesDir)
-	// Ensure templates directory exists (optional, handleErrorPage checks files)
-	// os.MkdirAll(templatesPath, 0755)
+// readUISettings parses a ui_settings.json file; a missing file yields an empty map. A damaged
+// file is restored from its backup when the backup parses.
+func readUISettings(path string) (map[string]interface{}, error) {
+	var settings map[string]interface{}
+	_, _, err := atomicfile.ReadFile(path, func(data []byte) error {
+		settings = nil
+		return json.Unmarshal(data, &settings)
+	})
+	if err != nil {
+		if os.IsNotExist(err) {
+			return make(map[string]interface{}), nil
+		}
+		return nil, fmt.Errorf("failed to read UI settings '%s': %w", path, err)
+	}
+	if settings == nil {
+		settings = make(map[string]interface{}) // The file contained "null"
+	}
+	return settings, nil
+}
 
-	return &containerWebHandler{
-		webRootDir:    webRootDir,
-		templatesPath: templatesPath,
-		fileServer:    http.FileServer(http.Dir(webRootDir)), // Base file server
-		uiSettings:    loadedSettings,                        // Assign loaded settings
-		// logger: logger,
-	}, nil
+// roots returns the directories making up the served content in lookup order: the container's
+// own web directory, then the layers of the applied theme.
+func (h *ContainerWebHandler) roots() []string {
+	roots := []string{h.webRootDir}
+	if h.themeLayers != nil {
+		roots = append(roots, h.themeLayers()...)
+	}
+	return roots
 }
 
 // ServeHTTP implements the http.Handler interface.
-func (h *containerWebHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+func (h *ContainerWebHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// Get the clean path (removes '..' etc.)
 	reqPath := path.Clean(r.URL.Path)
-	fsPath := filepath.Join(h.webRootDir, filepath.FromSlash(reqPath)) // Map URL path to filesystem path
+	roots := h.roots() // Resolved once per request, so theme changes apply without a restart
+
+	// Layouts and partials are only rendered as part of a page
+	if isSharedTemplatePath(reqPath) {
+		if !h.handleErrorPage(w, r, roots, http.StatusNotFound) {
+			http.NotFound(w, r)
+		}
+		return
+	}
 
 	// --- Check if the request targets an HTML/HTM file (directly or implicitly) ---
 	servePath := "" // The actual filesystem path to serve (potentially with .html/.htm added)
 	isHTML := false
 
-	// 1. Check if the direct path exists
-	info, err := os.Stat(fsPath)
+	// 1. Check if the direct path exists in any root
+	fsPath, info, err := lookup(roots, reqPath)
 	if err == nil {
 		if info.IsDir() {
-			// If it's a directory, check for index.html or index.htm
-			indexPath := filepath.Join(fsPath, "index.html")
-			if _, err := os.Stat(indexPath); err == nil {
+			// If it's a directory, check for index.html or index.htm (in any root)
+			if indexPath, _, err := lookup(roots, path.Join(reqPath, "index.html")); err == nil {
+				servePath = indexPath
+				isHTML = true
+			} else if indexPath, _, err := lookup(roots, path.Join(reqPath, "index.htm")); err == nil {
 				servePath = indexPath
 				isHTML = true
-			} else {
-				indexPath = filepath.Join(fsPath, "index.htm")
-				if _, err := os.Stat(indexPath); err == nil {
-					servePath = indexPath
-					isHTML = true
-				}
 			}
 			// If neither index file exists, let the file server handle directory listing (or 403) later
 		} else {
@@ -153,16 +295,12 @@ func (h *containerWebHandler) ServeHTTP(w http.ResponseWriter, r *http.Request)
 		}
 	} else if os.IsNotExist(err) {
 		// 2. If direct path doesn't exist, try adding .html/.htm (URL rewriting)
-		htmlPath := fsPath + ".html"
-		if _, err := os.Stat(htmlPath); err == nil {
+		if htmlPath, _, err := lookup(roots, reqPath+".html"); err == nil {
 			servePath = htmlPath
 			isHTML = true
-		} else {
-			htmPath := fsPath + ".htm"
-			if _, err := os.Stat(htmPath); err == nil {
-				servePath = htmPath
-				isHTML = true
-			}
+		} else if htmPath, _, err := lookup(roots, reqPath+".htm"); err == nil {
+			servePath = htmPath
+			isHTML = true
 		}
 		// If still not found after adding extensions, servePath remains empty, will result in 404 later
 	} else {
@@ -177,22 +315,13 @@ func (h *containerWebHandler) ServeHTTP(w http.ResponseWriter, r *http.Request)
 	if isHTML && servePath != "" {
 		// Render HTML template
 		// h.logger.Infof("Rendering HTML template %s for request %s", servePath, reqPath) // TODO: Add logging
-		content, err := os.ReadFile(servePath)
+		uiSettings := h.uiSettings()
+		// The page is parsed together with the layouts and partials of all roots, and cached
+		tmpl, err := h.template(servePath, roots, requestLocale(r, uiSettings))
 		if err != nil {
-			// h.logger.Errorf("Error reading HTML file %s: %v", servePath, err) // TODO: Add logging
+			// h.logger.Errorf("Error reading or parsing HTML template %s: %v", servePath, err) // TODO: Add logging
 			// Try serving a 500 error page template
-			if !h.handleErrorPage(w, http.StatusInternalServerError) {
-				// Fallback if error page fails
-				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-			}
-			return
-		}
-
-		tmplName := filepath.Base(servePath)
-		tmpl, err := template.New(tmplName).Parse(string(content))
-		if err != nil {
-			// h.logger.Errorf("Error parsing HTML template %s: %v", servePath, err) // TODO: Add logging
-			if !h.handleErrorPage(w, http.StatusInternalServerError) {
+			if !h.handleErrorPage(w, r, roots, http.StatusInternalServerError) {
 				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 			}
 			return
@@ -200,10 +329,8 @@ func (h *containerWebHandler) ServeHTTP(w http.ResponseWriter, r *http.Request)
 
 		data := make(map[string]interface{})
 		// Merge uiSettings into data
-		if h.uiSettings != nil {
-			for key, value := range h.uiSettings {
-				data[key] = value
-			}
+		for key, value := range uiSettings {
+			data[key] = value
 		}
 		// Add other context data if needed
 		// data["request_path"] = r.URL.Path
@@ -227,12 +354,12 @@ func (h *containerWebHandler) ServeHTTP(w http.ResponseWriter, r *http.Request)
 	}
 
 	// Let the file server handle non-HTML files, directories without index.html, or if servePath is empty (original 404 case)
-	h.fileServer.ServeHTTP(recorder, r)
+	http.FileServer(layeredFS(roots)).ServeHTTP(recorder, r)
 
 	// If the file server returned an error status code (e.g., 404, 403), try handling it with custom error pages
 	if recorder.statusCode >= 400 {
 		// h.logger.Warnf("File server returned status %d for %s, attempting error page.", recorder.statusCode, reqPath) // TODO: Add logging
-		if h.handleErrorPage(w, recorder.statusCode) {
+		if h.handleErrorPage(w, r, roots, recorder.statusCode) {
 			return // Custom error page was served
 		}
 	}
@@ -241,9 +368,9 @@ func (h *containerWebHandler) ServeHTTP(w http.ResponseWriter, r *http.Request)
 	recorder.flush()
 }
 
-// handleErrorPage attempts to serve a custom error page template.
-// Returns true if a custom page was successfully served, false otherwise.
-func (h *containerWebHandler) handleErrorPage(w http.ResponseWriter, code int) bool { // Removed unused 'r' parameter
+// handleErrorPage attempts to serve a custom error page template from the templates directory
+// of the first root that has one. Returns true if a custom page was successfully served, false otherwise.
+func (h *ContainerWebHandler) handleErrorPage(w http.ResponseWriter, r *http.Request, roots []string, code int) bool {
 	// Define template search order
 	baseName := strconv.Itoa(code)
 	candidates := []string{
@@ -262,10 +389,19 @@ func (h *containerWebHandler) handleErrorPage(w http.ResponseWriter, code int) b
 	errHtmlExists := false
 	errHtmExists := false
 
-	for _, candidate := range candidates {
-		p := filepath.Join(h.templatesPath, candidate)
-		_, err := os.Stat(p)
-		if err == nil { // File exists
+	// The first root with any candidate template is used on its own, so a theme's error.html
+	// never conflicts with a container's error.htm.
+	for _, root := range roots {
+		if foundTemplatePath != "" {
+			break
+		}
+		for _, candidate := range candidates {
+			p := filepath.Join(root, templatesDir, candidate)
+			_, err := os.Stat(p)
+			if err != nil {
+				continue
+			}
+			// File exists
 			if foundTemplatePath == "" { // Found the first one in order of preference
 				foundTemplatePath = p
 			}
@@ -288,7 +424,7 @@ func (h *containerWebHandler) handleErrorPage(w http.ResponseWriter, code int) b
 
 	// Check for conflicts
 	if (htmlExists && htmExists) || (errHtmlExists && errHtmExists) {
-		// conflictMsg := fmt.Sprintf("Conflicting error templates found for status %d or generic error in %s", code, h.templatesPath) // Commented out as unused for now
+		// conflictMsg := fmt.Sprintf("Conflicting error templates found for status %d or generic error in %s", code, templatesDir) // Commented out as unused for now
 		// h.logger.Errorf(conflictMsg) // TODO: Add logging
 		http.Error(w, "Internal Server Error: Conflicting error page templates.", http.StatusInternalServerError)
 		return true // We handled it by serving a 500
@@ -296,7 +432,8 @@ func (h *containerWebHandler) handleErrorPage(w http.ResponseWriter, code int) b
 
 	// If a template was found, try to parse and execute it
 	if foundTemplatePath != "" {
-		tmpl, err := template.ParseFiles(foundTemplatePath)
+		uiSettings := h.uiSettings()
+		tmpl, err := h.template(foundTemplatePath, roots, requestLocale(r, uiSettings))
 		if err != nil {
 			// h.logger.Errorf("Error parsing error template %s: %v", foundTemplatePath, err) // TODO: Add logging
 			// Fall through to default plain text error
@@ -306,11 +443,9 @@ func (h *containerWebHandler) handleErrorPage(w http.ResponseWriter, code int) b
 				"http_status_message": http.StatusText(code),
 			}
 			// Merge uiSettings into data, ensuring system variables are not overwritten by uiSettings
-			if h.uiSettings != nil {
-				for key, value := range h.uiSettings {
-					if _, exists := data[key]; !exists { // Only add if key doesn't already exist (e.g. http_status_code)
-						data[key] = value
-					}
+			for key, value := range uiSettings {
+				if _, exists := data[key]; !exists { // Only add if key doesn't already exist (e.g. http_status_code)
+					data[key] = value
 				}
 			}
 			w.Header().Set("Content-Type", "text/html; charset=utf-8")
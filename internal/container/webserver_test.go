@@ -0,0 +1,226 @@
+package container
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/OG-Open-Source/PanelBase/internal/atomicfile"
+)
+
+func TestWebHandlerServesMergedThemeLayers(t *testing.T) {
+	base := t.TempDir()
+	webDir := filepath.Join(base, "ctr", "web")
+	child := filepath.Join(base, "themes", "child")
+	parent := filepath.Join(base, "themes", "parent")
+	files := map[string]string{
+		filepath.Join(webDir, "own.txt"):                  "container",
+		filepath.Join(child, "index.html"):                "child {{.title}}",
+		filepath.Join(child, "css", "site.css"):           "child css",
+		filepath.Join(parent, "index.html"):               "parent",
+		filepath.Join(parent, "css", "site.css"):          "parent css",
+		filepath.Join(parent, "css", "base.css"):          "parent base",
+		filepath.Join(parent, "about.html"):               "about",
+		filepath.Join(parent, templatesDir, "404.html"):   "missing {{.http_status_code}}",
+		filepath.Join(base, "ctr", uiSettingsFile):        `{"title": "T"}`,
+		filepath.Join(webDir, templatesDir, "ignore.txt"): "",
+		filepath.Join(child, "theme.json"):                `{"name": "child"}`,
+		filepath.Join(parent, "theme.json.bak"):           `{"name": "parent"}`,
+		filepath.Join(child, "css", "theme.json"):         `{}`,
+	}
+	for p, content := range files {
+		os.MkdirAll(filepath.Dir(p), 0755)
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	handler, err := NewContainerWebHandler(webDir, WebHandlerOptions{ThemeLayers: func() []string { return []string{child, parent} }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		path, want string
+		code       int
+	}{
+		{"/", "child T", http.StatusOK},                         // Child overrides the parent's index and is rendered
+		{"/css/site.css", "child css", http.StatusOK},           // Child override
+		{"/css/base.css", "parent base", http.StatusOK},         // Inherited from the parent
+		{"/about", "about", http.StatusOK},                      // .html rewriting works across layers
+		{"/own.txt", "container", http.StatusOK},                // The container's own files are served too
+		{"/nope", "missing 404", http.StatusNotFound},           // Error template inherited from the parent
+		{"/theme.json", "missing 404", http.StatusNotFound},     // Install metadata is never served
+		{"/theme.json.bak", "missing 404", http.StatusNotFound}, // Nor its backup
+	} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tc.path, nil))
+		body, _ := io.ReadAll(rec.Result().Body)
+		if rec.Code != tc.code || string(body) != tc.want {
+			t.Errorf("GET %s = %d %q; want %d %q", tc.path, rec.Code, body, tc.code, tc.want)
+		}
+	}
+
+	// Directory listings leave the metadata out as well.
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/css/", nil))
+	if body := rec.Body.String(); rec.Code != http.StatusOK || !strings.Contains(body, "site.css") || strings.Contains(body, "theme.json") {
+		t.Errorf("GET /css/ = %d %q; want a listing without theme.json", rec.Code, body)
+	}
+}
+
+func TestWebHandlerLayoutsPartialsAndHelpers(t *testing.T) {
+	base := t.TempDir()
+	webDir := filepath.Join(base, "ctr", "web")
+	theme := filepath.Join(base, "theme")
+	files := map[string]string{
+		filepath.Join(theme, layoutsDir, "base.html"):  `<html lang="{{locale}}">{{template "partials/nav.html" .}}{{block "content" .}}empty{{end}}</html>`,
+		filepath.Join(theme, partialsDir, "nav.html"):  `<nav>{{t "nav.home"}} {{t "nav.missing"}}</nav>`,
+		filepath.Join(theme, i18nDir, "en.json"):       `{"nav.home": "Home", "greeting": "Hi %s"}`,
+		filepath.Join(theme, i18nDir, "de.json"):       `{"nav.home": "Start"}`,
+		filepath.Join(theme, "site.css"):               "body {}",
+		filepath.Join(webDir, partialsDir, "nav.html"): `<nav>{{t "greeting" "you"}}</nav>`, // Container overrides the theme's partial
+		filepath.Join(webDir, "index.html"): `{{template "layouts/base.html" .}}` +
+			`{{define "content"}}<link href="{{asset "site.css"}}">{{date "date" .since}}{{end}}`,
+		filepath.Join(base, "ctr", uiSettingsFile): `{"since": "2024-05-06T07:08:09Z"}`,
+	}
+	for p, content := range files {
+		os.MkdirAll(filepath.Dir(p), 0755)
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	handler, err := NewContainerWebHandler(webDir, WebHandlerOptions{ThemeLayers: func() []string { return []string{theme} }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func(path, language string) (int, string) {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Accept-Language", language)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		body, _ := io.ReadAll(rec.Result().Body)
+		return rec.Code, string(body)
+	}
+
+	want := `<html lang="en-US"><nav>Hi you</nav><link href="/site.css?v=62368a1a">2024-05-06</html>`
+	if code, body := get("/", "en-US,en;q=0.9"); code != http.StatusOK || body != want {
+		t.Errorf("GET / = %d %q; want %q", code, body, want)
+	}
+
+	// Without the container's override, the theme's partial is used with the German catalog,
+	// falling back to the key for missing messages.
+	os.Remove(filepath.Join(webDir, partialsDir, "nav.html"))
+	want = `<html lang="de-AT"><nav>Start nav.missing</nav><link href="/site.css?v=62368a1a">2024-05-06</html>`
+	if code, body := get("/", "de-AT"); code != http.StatusOK || body != want {
+		t.Errorf("GET / (de-AT) = %d %q; want %q", code, body, want)
+	}
+
+	// A language tag that would name a file outside the i18n directory falls back to English.
+	if err := os.WriteFile(filepath.Join(base, "evil.json"), []byte(`{"nav.home": "Pwned"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	want = `<html lang="en"><nav>Home nav.missing</nav><link href="/site.css?v=62368a1a">2024-05-06</html>`
+	for _, language := range []string{"../../evil", "..%2F..%2Fevil", `..\..\evil`} {
+		if code, body := get("/", language); code != http.StatusOK || body != want {
+			t.Errorf("GET / (%s) = %d %q; want %q", language, code, body, want)
+		}
+	}
+
+	if code, _ := get("/partials/nav.html", ""); code != http.StatusNotFound {
+		t.Errorf("GET /partials/nav.html = %d; want 404", code)
+	}
+}
+
+func TestWebHandlerTemplateCache(t *testing.T) {
+	for _, interval := range []time.Duration{0, 10 * time.Millisecond} {
+		webDir := t.TempDir()
+		page := filepath.Join(webDir, "index.html")
+		partial := filepath.Join(webDir, partialsDir, "nav.html")
+		os.MkdirAll(filepath.Dir(partial), 0755)
+		os.WriteFile(page, []byte(`{{template "partials/nav.html"}} v1`), 0644)
+		os.WriteFile(partial, []byte("nav"), 0644)
+
+		handler, err := NewContainerWebHandler(webDir, WebHandlerOptions{TemplatePollInterval: interval})
+		if err != nil {
+			t.Fatal(err)
+		}
+		get := func() string {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+			body, _ := io.ReadAll(rec.Result().Body)
+			return string(body)
+		}
+		// waitFor retries until the watcher (if any) has evicted the changed entry.
+		waitFor := func(want string) {
+			t.Helper()
+			deadline := time.Now().Add(2 * time.Second)
+			for got := get(); got != want; got = get() {
+				if time.Now().After(deadline) {
+					t.Fatalf("interval %v: GET / = %q; want %q", interval, got, want)
+				}
+				time.Sleep(5 * time.Millisecond)
+			}
+		}
+
+		waitFor("nav v1")
+		first, _ := handler.template(page, handler.roots(), defaultLocale)
+		if again, _ := handler.template(page, handler.roots(), defaultLocale); again != first {
+			t.Errorf("interval %v: unchanged page was compiled again", interval)
+		}
+		// A changed size invalidates the page, a changed mtime the partial it includes.
+		os.WriteFile(page, []byte(`{{template "partials/nav.html"}} v22`), 0644)
+		waitFor("nav v22")
+		os.WriteFile(partial, []byte("NAV"), 0644)
+		os.Chtimes(partial, time.Now(), time.Now().Add(time.Hour))
+		waitFor("NAV v22")
+		// Removing the included partial is noticed too; the page then fails to execute.
+		os.Remove(partial)
+		waitFor("")
+		handler.Close()
+	}
+}
+
+func TestWebHandlerCachesUISettingsByStamp(t *testing.T) {
+	base := t.TempDir()
+	webDir := filepath.Join(base, "web")
+	os.MkdirAll(webDir, 0755)
+	settingsPath := filepath.Join(base, uiSettingsFile)
+	if err := atomicfile.WriteFile(settingsPath, []byte(`{"title": "one"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	h, err := NewContainerWebHandler(webDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := h.uiSettings()
+	first["probe"] = true // Only visible if the parsed map is reused
+	if again := h.uiSettings(); again["title"] != "one" || again["probe"] != true {
+		t.Errorf("second read = %v; want the cached settings", again)
+	}
+
+	// A replaced file is parsed again; its size differs even if the mtime tick does not.
+	if err := atomicfile.WriteFile(settingsPath, []byte(`{"title": "two!"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := h.uiSettings(); got["title"] != "two!" {
+		t.Errorf("after rewrite = %v; want the new title", got)
+	}
+
+	// A damaged file keeps the last valid settings and is left alone for the manager to repair.
+	if err := os.WriteFile(settingsPath, []byte(`{"title": `), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := h.uiSettings(); got["title"] != "two!" {
+		t.Errorf("with a damaged file = %v; want the last valid settings", got)
+	}
+	if data, _ := os.ReadFile(settingsPath); string(data) != `{"title": ` {
+		t.Errorf("handler rewrote the damaged file to %q", data)
+	}
+}
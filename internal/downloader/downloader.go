@@ -0,0 +1,665 @@
+package downloader
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/OG-Open-Source/PanelBase/internal/atomicfile"
+	"github.com/OG-Open-Source/PanelBase/internal/blobstore"
+	"github.com/OG-Open-Source/PanelBase/internal/logger"
+	"github.com/OG-Open-Source/PanelBase/internal/utils"
+)
+
+const (
+	DefaultWorkers     = 4
+	DefaultRetries     = 3                      // Attempts after the first one
+	DefaultBackoff     = 500 * time.Millisecond // Delay before the first retry; doubled for each further retry
+	DefaultIdleTimeout = 60 * time.Second       // An attempt is aborted when no data arrives for this long
+
+	partSuffix       = ".part"                // Incomplete downloads, resumed with a Range request
+	progressInterval = 200 * time.Millisecond // Minimum delay between progress line updates
+)
+
+// Options configures a Downloader. Zero values select the defaults.
+type Options struct {
+	Workers     int
+	Retries     int // Negative disables retries
+	Backoff     time.Duration
+	IdleTimeout time.Duration
+	// CacheDir, if set, keeps a copy of every file that was served with an ETag or Last-Modified
+	// header, so later downloads of the same URL send a conditional request and reuse the copy
+	// on 304 Not Modified. Partial files of downloads that failed for good are parked there too,
+	// together with their validator, so the next run resumes them with a Range request.
+	CacheDir string
+	// Blobs, if set, is consulted before any request for jobs with a Sum: an intact blob is
+	// copied to Dest without touching the network, and every verified download is added to it.
+	Blobs *blobstore.Store
+}
+
+// Job is one file to download.
+type Job struct {
+	Name string // Label for progress and errors, usually the path inside the extension
+	URL  string
+	Dest string // Local file path; parent directories are created
+	Sum  string // Optional SHA256 as computed by utils.CalculateFileSHA256; checked before Dest is written
+}
+
+// Stats summarizes a Download call.
+type Stats struct {
+	Files     int
+	Bytes     int64 // Bytes received over the network
+	FromCache int   // Files served from the cache after a 304 Not Modified
+	Reused    int   // Files taken from the blob store without a request
+	Resumed   int   // Files completed with a Range request after an interrupted attempt
+	Retries   int
+	Duration  time.Duration
+}
+
+// Downloader fetches files over HTTP with a bounded worker pool, per-file retries with
+// exponential backoff, Range resume of interrupted transfers and conditional requests
+// against a local cache. It is safe for concurrent use.
+type Downloader struct {
+	client *http.Client
+	opts   Options
+	logger *logger.Logger
+}
+
+// New creates a Downloader that reports progress through log. An optional Options
+// overrides the defaults.
+func New(log *logger.Logger, opts ...Options) (*Downloader, error) {
+	if log == nil {
+		return nil, fmt.Errorf("logger cannot be nil")
+	}
+	o := Options{}
+	if len(opts) > 0 {
+		o = opts[0]
+	}
+	if o.Workers <= 0 {
+		o.Workers = DefaultWorkers
+	}
+	if o.Retries == 0 {
+		o.Retries = DefaultRetries
+	} else if o.Retries < 0 {
+		o.Retries = 0
+	}
+	if o.Backoff <= 0 {
+		o.Backoff = DefaultBackoff
+	}
+	if o.IdleTimeout <= 0 {
+		o.IdleTimeout = DefaultIdleTimeout
+	}
+	if o.CacheDir != "" {
+		if err := os.MkdirAll(o.CacheDir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create download cache directory '%s': %w", o.CacheDir, err)
+		}
+	}
+	// No overall client timeout: large files may legitimately take long. Stalls are caught by
+	// the response header timeout and the per-read idle timeout instead.
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = o.IdleTimeout
+	return &Downloader{client: &http.Client{Transport: transport}, opts: o, logger: log}, nil
+}
+
+// statusError is a non-success HTTP status.
+type statusError struct {
+	code   int
+	status string
+}
+
+func (e *statusError) Error() string { return "status " + e.status }
+
+// checksumError is a downloaded file whose SHA256 differs from Job.Sum.
+type checksumError struct {
+	expected, actual string
+	retryable        bool // The file was resumed or taken from the cache, so a fresh download may fix it
+}
+
+func (e *checksumError) Error() string {
+	return fmt.Sprintf("checksum mismatch: expected %s, got %s", e.expected, e.actual)
+}
+
+// retryable reports whether another attempt may succeed.
+func retryable(err error) bool {
+	var se *statusError
+	if errors.As(err, &se) {
+		return se.code >= 500 || se.code == http.StatusRequestTimeout || se.code == http.StatusTooManyRequests
+	}
+	var ce *checksumError
+	if errors.As(err, &ce) {
+		return ce.retryable
+	}
+	return true // Network errors, idle timeouts and file errors; the caller stops when the whole download is cancelled
+}
+
+// progress aggregates the state of all jobs of a Download call into one overwritable line.
+type progress struct {
+	d          *Downloader
+	prefix     string
+	total      int
+	done       atomic.Int64
+	bytes      atomic.Int64
+	mu         sync.Mutex
+	lastPrint  time.Time
+	printedAny bool
+}
+
+func (p *progress) print(force bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !force && time.Since(p.lastPrint) < progressInterval {
+		return
+	}
+	p.lastPrint = time.Now()
+	p.printedAny = true
+	p.d.logger.PrintOverwritable(fmt.Sprintf("%sDownloading: %d/%d files, %s", p.prefix, p.done.Load(), p.total, FormatBytes(p.bytes.Load())))
+}
+
+// logf writes a regular log line, first clearing a pending progress line.
+func (p *progress) logf(format string, v ...interface{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.printedAny {
+		p.d.logger.ClearLine()
+	}
+	p.d.logger.Logf(p.prefix+format, v...)
+	p.lastPrint = time.Time{} // Redraw the progress line on the next update
+}
+
+// Download fetches all jobs with the worker pool. It stops at the first job that fails after
+// its retries and returns that error; files already completed are left in place. logPrefix
+// indents the log lines to match the caller's.
+func (d *Downloader) Download(logPrefix string, jobs []Job) (Stats, error) {
+	started := time.Now()
+	stats := Stats{Files: len(jobs)}
+	if len(jobs) == 0 {
+		return stats, nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p := &progress{d: d, prefix: logPrefix, total: len(jobs)}
+	var (
+		mu       sync.Mutex
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	queue := make(chan Job)
+	workers := d.opts.Workers
+	if workers > len(jobs) {
+		workers = len(jobs)
+	}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range queue {
+				result, err := d.downloadWithRetries(ctx, job, p)
+				mu.Lock()
+				stats.Retries += result.retries
+				if result.fromCache {
+					stats.FromCache++
+				}
+				if result.reused {
+					stats.Reused++
+				}
+				if result.resumed {
+					stats.Resumed++
+				}
+				if err != nil && firstErr == nil {
+					firstErr = fmt.Errorf("failed to download '%s' from '%s': %w", job.Name, job.URL, err)
+					cancel() // Abort the other workers; the caller discards the partial result anyway
+				}
+				mu.Unlock()
+				if err == nil {
+					p.done.Add(1)
+					p.print(false)
+				}
+			}
+		}()
+	}
+	for _, job := range jobs {
+		select {
+		case queue <- job:
+		case <-ctx.Done():
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	stats.Bytes = p.bytes.Load()
+	stats.Duration = time.Since(started)
+	if firstErr != nil {
+		p.logf("Download failed: %v", firstErr)
+		return stats, firstErr
+	}
+	p.print(true)
+	p.logf("Downloaded %d files (%s, %d reused, %d from cache, %d resumed, %d retries) in %s.",
+		stats.Files, FormatBytes(stats.Bytes), stats.Reused, stats.FromCache, stats.Resumed, stats.Retries, stats.Duration.Round(time.Millisecond))
+	return stats, nil
+}
+
+// jobResult describes how a job completed.
+type jobResult struct {
+	retries   int
+	fromCache bool
+	resumed   bool
+	reused    bool
+}
+
+// downloadWithRetries runs attempts for one job until it succeeds, fails permanently or runs
+// out of retries. The partial file is kept between attempts so they can resume.
+func (d *Downloader) downloadWithRetries(ctx context.Context, job Job, p *progress) (jobResult, error) {
+	var result jobResult
+	if err := os.MkdirAll(filepath.Dir(job.Dest), 0755); err != nil {
+		return result, fmt.Errorf("failed to create directory for '%s': %w", job.Dest, err)
+	}
+	if job.Sum != "" && d.opts.Blobs != nil {
+		reused, err := d.opts.Blobs.Get(job.Sum, job.Dest)
+		if err != nil {
+			p.logf("Warning: blob store unusable for '%s', downloading: %v", job.Name, err)
+		} else if reused {
+			result.reused = true
+			return result, nil
+		}
+	}
+
+	partPath := job.Dest + partSuffix
+	defer os.Remove(partPath) // Gone after a successful rename or once parked in the cache
+
+	// ETag or Last-Modified of the first response, for If-Range; taken over from an earlier run
+	// if its partial file was parked in the cache.
+	validator := d.claimPartial(job.URL, partPath)
+	for attempt := 0; ; attempt++ {
+		fromCache, resumed, err := d.attempt(ctx, job, partPath, &validator, p)
+		if err == nil {
+			result.fromCache, result.resumed = fromCache, resumed
+			if job.Sum != "" && d.opts.Blobs != nil {
+				if putErr := d.opts.Blobs.Put(job.Sum, job.Dest); putErr != nil {
+					p.logf("Warning: failed to add '%s' to the blob store: %v", job.Name, putErr) // Only costs a future download
+				}
+			}
+			return result, nil
+		}
+		if attempt >= d.opts.Retries || !retryable(err) || ctx.Err() != nil {
+			d.parkPartial(job.URL, partPath, validator)
+			return result, err
+		}
+		delay := d.opts.Backoff << attempt
+		p.logf("Retrying '%s' in %s (attempt %d of %d): %v", job.Name, delay, attempt+2, d.opts.Retries+1, err)
+		result.retries++
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			d.parkPartial(job.URL, partPath, validator)
+			return result, ctx.Err()
+		}
+	}
+}
+
+// partialEntry is the sidecar of a partial file parked in the cache between runs.
+type partialEntry struct {
+	URL       string `json:"url"`
+	Validator string `json:"validator"`
+}
+
+// claimPartial moves the partial file an earlier run parked for rawURL to partPath and returns
+// its validator, or "" if there is none. Moving it away also keeps a concurrent download of
+// the same URL from appending to it.
+func (d *Downloader) claimPartial(rawURL, partPath string) string {
+	if d.opts.CacheDir == "" {
+		return ""
+	}
+	parked := d.cacheBlobPath(rawURL) + partSuffix
+	data, err := os.ReadFile(parked + ".json")
+	if err != nil {
+		return ""
+	}
+	os.Remove(parked + ".json")
+	var entry partialEntry
+	if json.Unmarshal(data, &entry) != nil || entry.URL != rawURL || entry.Validator == "" {
+		os.Remove(parked)
+		return ""
+	}
+	if err := moveFile(parked, partPath); err != nil {
+		return "" // Claimed by another download, or unusable; start over
+	}
+	return entry.Validator
+}
+
+// parkPartial keeps the partial file of a download that failed for good in the cache, so a
+// later run can resume it. Without a cache or a validator the file is left to be removed.
+func (d *Downloader) parkPartial(rawURL, partPath, validator string) {
+	if d.opts.CacheDir == "" || validator == "" {
+		return
+	}
+	if info, err := os.Stat(partPath); err != nil || info.Size() == 0 {
+		return
+	}
+	parked := d.cacheBlobPath(rawURL) + partSuffix
+	if err := moveFile(partPath, parked); err != nil {
+		d.logger.Logf("Warning: failed to keep the partial download of '%s': %v", rawURL, err)
+		return
+	}
+	data, _ := json.Marshal(partialEntry{URL: rawURL, Validator: validator})
+	if err := os.WriteFile(parked+".json", data, 0644); err != nil {
+		os.Remove(parked) // Useless without its validator
+		d.logger.Logf("Warning: failed to keep the partial download of '%s': %v", rawURL, err)
+	}
+}
+
+// attempt performs one request for job. A non-empty partial file from an earlier attempt is
+// resumed with a Range request guarded by If-Range, otherwise a cached copy is revalidated
+// with If-None-Match / If-Modified-Since.
+func (d *Downloader) attempt(ctx context.Context, job Job, partPath string, validator *string, p *progress) (fromCache, resumed bool, err error) {
+	reqCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	req, err := http.NewRequestWithContext(reqCtx, http.MethodGet, job.URL, nil)
+	if err != nil {
+		return false, false, err
+	}
+
+	var offset int64
+	if info, statErr := os.Stat(partPath); statErr == nil && info.Size() > 0 && *validator != "" {
+		offset = info.Size()
+		req.Header.Set("Range", "bytes="+strconv.FormatInt(offset, 10)+"-")
+		req.Header.Set("If-Range", *validator)
+	}
+	cached := d.lookupCache(job.URL)
+	if offset == 0 && cached != nil {
+		if cached.ETag != "" {
+			req.Header.Set("If-None-Match", cached.ETag)
+		}
+		if cached.LastModified != "" {
+			req.Header.Set("If-Modified-Since", cached.LastModified)
+		}
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return false, false, err
+	}
+	defer resp.Body.Close()
+
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	switch {
+	case resp.StatusCode == http.StatusNotModified && cached != nil:
+		if err := atomicfile.CopyFile(d.cacheBlobPath(job.URL), partPath, 0644); err != nil {
+			return false, false, fmt.Errorf("failed to copy cached file: %w", err)
+		}
+		now := time.Now()
+		os.Chtimes(d.cacheBlobPath(job.URL), now, now) // Marks the entry as used for PruneCache
+		fromCache = true
+	case resp.StatusCode == http.StatusPartialContent && offset > 0:
+		if start, ok := contentRangeStart(resp.Header.Get("Content-Range")); !ok || start != offset {
+			os.Remove(partPath) // Unexpected range; start over on the next attempt
+			return false, false, fmt.Errorf("server returned range starting at %d, requested %d", start, offset)
+		}
+		flags = os.O_WRONLY | os.O_APPEND
+		resumed = true
+	case resp.StatusCode == http.StatusOK:
+		// Full body: either a fresh download or the resource changed since the partial file was written.
+		*validator = resp.Header.Get("ETag")
+		if *validator == "" {
+			*validator = resp.Header.Get("Last-Modified")
+		}
+	default:
+		return false, false, &statusError{code: resp.StatusCode, status: resp.Status}
+	}
+
+	if !fromCache {
+		out, err := os.OpenFile(partPath, flags, 0644)
+		if err != nil {
+			return false, false, fmt.Errorf("failed to open '%s': %w", partPath, err)
+		}
+		body := &idleReader{r: resp.Body, timeout: d.opts.IdleTimeout, cancel: cancel, onRead: func(n int) {
+			p.bytes.Add(int64(n))
+			p.print(false)
+		}}
+		_, copyErr := io.Copy(out, body)
+		closeErr := out.Close()
+		body.stop()
+		if copyErr != nil {
+			if reqCtx.Err() != nil && ctx.Err() == nil {
+				copyErr = fmt.Errorf("no data received for %s", d.opts.IdleTimeout)
+			}
+			return false, false, copyErr // Partial file kept for the next attempt
+		}
+		if closeErr != nil {
+			return false, false, closeErr
+		}
+	}
+
+	if job.Sum != "" {
+		actual, err := utils.CalculateFileSHA256(partPath)
+		if err != nil {
+			return false, false, fmt.Errorf("failed to hash '%s': %w", partPath, err)
+		}
+		if !strings.EqualFold(actual, job.Sum) {
+			os.Remove(partPath)
+			if fromCache {
+				d.dropCache(job.URL)
+			}
+			return false, false, &checksumError{expected: job.Sum, actual: actual, retryable: fromCache || resumed}
+		}
+	}
+	if err := moveFile(partPath, job.Dest); err != nil {
+		return false, false, fmt.Errorf("failed to move '%s' into place: %w", partPath, err)
+	}
+	if !fromCache && (job.Sum == "" || d.opts.Blobs == nil) { // Files with a sum are kept in the blob store instead
+		d.storeCache(job.URL, job.Dest, resp.Header)
+	}
+	return fromCache, resumed, nil
+}
+
+// idleReader cancels the request when no data arrives within timeout.
+type idleReader struct {
+	r       io.Reader
+	timeout time.Duration
+	cancel  context.CancelFunc
+	onRead  func(n int)
+	timer   *time.Timer
+}
+
+func (ir *idleReader) Read(b []byte) (int, error) {
+	if ir.timer == nil {
+		ir.timer = time.AfterFunc(ir.timeout, ir.cancel)
+	} else {
+		ir.timer.Reset(ir.timeout)
+	}
+	n, err := ir.r.Read(b)
+	if n > 0 {
+		ir.onRead(n)
+	}
+	return n, err
+}
+
+func (ir *idleReader) stop() {
+	if ir.timer != nil {
+		ir.timer.Stop()
+	}
+}
+
+// contentRangeStart parses the first byte position of a "bytes start-end/size" header.
+func contentRangeStart(header string) (int64, bool) {
+	spec, ok := strings.CutPrefix(header, "bytes ")
+	if !ok {
+		return 0, false
+	}
+	start, _, ok := strings.Cut(spec, "-")
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(start, 10, 64)
+	return n, err == nil
+}
+
+// cacheEntry is the metadata stored next to a cached file.
+type cacheEntry struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+func (d *Downloader) cacheKey(rawURL string) string {
+	sum := sha256.Sum256([]byte(rawURL))
+	return hex.EncodeToString(sum[:])
+}
+
+func (d *Downloader) cacheBlobPath(rawURL string) string {
+	return filepath.Join(d.opts.CacheDir, d.cacheKey(rawURL))
+}
+
+// lookupCache returns the cache entry for a URL, or nil if caching is off or nothing is cached.
+func (d *Downloader) lookupCache(rawURL string) *cacheEntry {
+	if d.opts.CacheDir == "" {
+		return nil
+	}
+	data, err := os.ReadFile(d.cacheBlobPath(rawURL) + ".json")
+	if err != nil {
+		return nil
+	}
+	var entry cacheEntry
+	if json.Unmarshal(data, &entry) != nil || entry.URL != rawURL || (entry.ETag == "" && entry.LastModified == "") {
+		return nil
+	}
+	if _, err := os.Stat(d.cacheBlobPath(rawURL)); err != nil {
+		return nil
+	}
+	return &entry
+}
+
+// storeCache keeps a copy of a downloaded file if the response carried a validator.
+// Failures only cost a future re-download, so they are logged and otherwise ignored.
+func (d *Downloader) storeCache(rawURL, path string, header http.Header) {
+	entry := cacheEntry{URL: rawURL, ETag: header.Get("ETag"), LastModified: header.Get("Last-Modified")}
+	if d.opts.CacheDir == "" || (entry.ETag == "" && entry.LastModified == "") {
+		return
+	}
+	blobPath := d.cacheBlobPath(rawURL)
+	if err := atomicfile.CopyFile(path, blobPath, 0644); err != nil {
+		d.logger.Logf("Warning: failed to cache '%s': %v", rawURL, err)
+		return
+	}
+	data, _ := json.Marshal(entry)
+	if err := os.WriteFile(blobPath+".json", data, 0644); err != nil { // A torn write only makes the entry unreadable
+		d.logger.Logf("Warning: failed to cache '%s': %v", rawURL, err)
+	}
+}
+
+// dropCache removes a cached copy that turned out to be wrong.
+func (d *Downloader) dropCache(rawURL string) {
+	blobPath := d.cacheBlobPath(rawURL)
+	os.Remove(blobPath + ".json")
+	os.Remove(blobPath)
+}
+
+// CacheUsage counts the entries of a download cache directory (Options.CacheDir) and their size.
+func CacheUsage(cacheDir string) (entries int, bytes int64, err error) {
+	err = walkCache(cacheDir, func(blobPath string, info os.FileInfo) error {
+		entries++
+		bytes += info.Size()
+		return nil
+	})
+	return entries, bytes, err
+}
+
+// PruneCache removes the entries of a download cache directory that were not stored or reused
+// within maxAge; a zero maxAge removes all of them. Parked partial downloads older than maxAge
+// are removed as well. It returns the number of removed entries and their size.
+func PruneCache(cacheDir string, maxAge time.Duration) (entries int, bytes int64, err error) {
+	err = walkCache(cacheDir, func(blobPath string, info os.FileInfo) error {
+		if maxAge > 0 && time.Since(info.ModTime()) < maxAge {
+			return nil
+		}
+		os.Remove(blobPath + ".json")
+		if err := os.Remove(blobPath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		entries++
+		bytes += info.Size()
+		return nil
+	})
+	if err != nil {
+		return entries, bytes, err
+	}
+	parked, _ := filepath.Glob(filepath.Join(cacheDir, "*"+partSuffix))
+	for _, partPath := range parked {
+		info, statErr := os.Stat(partPath)
+		if statErr != nil || (maxAge > 0 && time.Since(info.ModTime()) < maxAge) {
+			continue
+		}
+		os.Remove(partPath + ".json")
+		if os.Remove(partPath) == nil {
+			entries++
+			bytes += info.Size()
+		}
+	}
+	return entries, bytes, nil
+}
+
+// walkCache calls fn for the cached file of every entry in cacheDir. A missing directory has
+// no entries.
+func walkCache(cacheDir string, fn func(blobPath string, info os.FileInfo) error) error {
+	dirEntries, err := os.ReadDir(cacheDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read download cache '%s': %w", cacheDir, err)
+	}
+	for _, entry := range dirEntries {
+		name := entry.Name()
+		if entry.IsDir() || len(name) != sha256.Size*2 || strings.Contains(name, ".") {
+			continue // Sidecars and temporary files
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue // Removed concurrently
+		}
+		if err := fn(filepath.Join(cacheDir, name), info); err != nil {
+			return fmt.Errorf("failed to process download cache '%s': %w", cacheDir, err)
+		}
+	}
+	return nil
+}
+
+// moveFile renames src to dst, copying it when they are on different filesystems.
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	if _, err := os.Stat(src); err != nil {
+		return err
+	}
+	if err := atomicfile.CopyFile(src, dst, 0644); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+// FormatBytes renders a byte count for progress lines and reports, e.g. "1.5 MB".
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
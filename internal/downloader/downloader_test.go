@@ -0,0 +1,176 @@
+package downloader
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/OG-Open-Source/PanelBase/internal/logger"
+)
+
+func TestDownloadRetriesResumesAndRevalidates(t *testing.T) {
+	large := strings.Repeat("0123456789", 10000)
+	var (
+		mu           sync.Mutex
+		flakyCalls   int
+		largeCalls   int
+		rangeHeaders []string
+		notModified  int
+	)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/flaky.txt", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		flakyCalls++
+		first := flakyCalls == 1
+		mu.Unlock()
+		if first {
+			http.Error(w, "try again", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("flaky"))
+	})
+	mux.HandleFunc("/large.bin", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		largeCalls++
+		first := largeCalls == 1
+		rangeHeaders = append(rangeHeaders, r.Header.Get("Range"))
+		mu.Unlock()
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			mu.Lock()
+			notModified++
+			mu.Unlock()
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		if first {
+			// Promise the whole body, send half of it and drop the connection.
+			w.Header().Set("Content-Length", "100000")
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(large[:50000]))
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+			return
+		}
+		http.ServeContent(w, r, "large.bin", time.Time{}, strings.NewReader(large))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	log, err := logger.NewLogger(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+	d, err := New(log, Options{Backoff: time.Millisecond, CacheDir: filepath.Join(t.TempDir(), "cache")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dest := t.TempDir()
+	jobs := []Job{
+		{Name: "flaky.txt", URL: server.URL + "/flaky.txt", Dest: filepath.Join(dest, "flaky.txt")},
+		{Name: "sub/large.bin", URL: server.URL + "/large.bin", Dest: filepath.Join(dest, "sub", "large.bin")},
+	}
+	stats, err := d.Download("", jobs)
+	if err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	if stats.Retries != 2 || stats.Resumed != 1 {
+		t.Errorf("stats = %+v; want 2 retries and 1 resumed file", stats)
+	}
+	if got, _ := os.ReadFile(jobs[1].Dest); string(got) != large {
+		t.Fatalf("large.bin has %d bytes; want the full %d", len(got), len(large))
+	}
+	if len(rangeHeaders) != 2 || rangeHeaders[1] != "bytes=50000-" {
+		t.Errorf("Range headers = %q; want the second request to resume at byte 50000", rangeHeaders)
+	}
+
+	// A second download of the same URL is revalidated and served from the cache.
+	again := []Job{{Name: "large.bin", URL: server.URL + "/large.bin", Dest: filepath.Join(dest, "again.bin")}}
+	stats, err = d.Download("", again)
+	if err != nil {
+		t.Fatalf("second Download: %v", err)
+	}
+	if stats.FromCache != 1 || notModified != 1 {
+		t.Errorf("stats = %+v, 304 responses = %d; want the file from the cache", stats, notModified)
+	}
+	if got, _ := os.ReadFile(again[0].Dest); string(got) != large {
+		t.Errorf("cached copy has %d bytes; want %d", len(got), len(large))
+	}
+}
+
+func TestDownloadResumesPartialFileOfEarlierRun(t *testing.T) {
+	large := strings.Repeat("abcdefghij", 10000)
+	var (
+		mu       sync.Mutex
+		calls    int
+		ranges   []string
+		ifRanges []string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls++
+		first := calls == 1
+		ranges = append(ranges, r.Header.Get("Range"))
+		ifRanges = append(ifRanges, r.Header.Get("If-Range"))
+		mu.Unlock()
+		w.Header().Set("ETag", `"v1"`)
+		if first {
+			w.Header().Set("Content-Length", "100000")
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(large[:30000]))
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+			return
+		}
+		http.ServeContent(w, r, "large.bin", time.Time{}, strings.NewReader(large))
+	}))
+	defer server.Close()
+
+	log, err := logger.NewLogger(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+	cacheDir := filepath.Join(t.TempDir(), "cache")
+	job := Job{Name: "large.bin", URL: server.URL + "/large.bin", Dest: filepath.Join(t.TempDir(), "large.bin")}
+
+	// Without retries the first run fails and parks its partial file in the cache.
+	first, err := New(log, Options{Retries: -1, CacheDir: cacheDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := first.Download("", []Job{job}); err == nil {
+		t.Fatal("first Download succeeded; want the dropped connection to fail it")
+	}
+	if _, err := os.Stat(job.Dest + partSuffix); !os.IsNotExist(err) {
+		t.Errorf("partial file left next to the destination: %v", err)
+	}
+
+	second, err := New(log, Options{Retries: -1, CacheDir: cacheDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats, err := second.Download("", []Job{job})
+	if err != nil {
+		t.Fatalf("second Download: %v", err)
+	}
+	if stats.Resumed != 1 {
+		t.Errorf("stats = %+v; want 1 resumed file", stats)
+	}
+	if len(ranges) != 2 || ranges[1] != "bytes=30000-" || ifRanges[1] != `"v1"` {
+		t.Errorf("Range = %q, If-Range = %q; want the second run to resume at byte 30000", ranges, ifRanges)
+	}
+	if got, _ := os.ReadFile(job.Dest); string(got) != large {
+		t.Fatalf("large.bin has %d bytes; want the full %d", len(got), len(large))
+	}
+	if parked, _ := filepath.Glob(filepath.Join(cacheDir, "*"+partSuffix+"*")); len(parked) != 0 {
+		t.Errorf("parked partial files left after the resume: %q", parked)
+	}
+}
@@ -0,0 +1,141 @@
+package events
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// Lifecycle topics published by the core managers.
+// Attributes carried by each event are listed next to the topic.
+const (
+	TopicContainerCreated = "container.created" // container_id, name, port
+	TopicContainerStarted = "container.started" // container_id, address
+	TopicContainerStopped = "container.stopped" // container_id
+	TopicContainerError   = "container.error"   // container_id, error
+	TopicContainerTheme   = "container.theme"   // container_id, theme_id (empty when cleared)
+
+	TopicThemeInstalled  = "theme.installed"   // theme_id, name, version
+	TopicThemeUpdated    = "theme.updated"     // theme_id, name, version
+	TopicThemeRemoved    = "theme.removed"     // theme_id, name
+	TopicThemeRolledBack = "theme.rolled_back" // theme_id, name, version, previous_version
+
+	TopicPluginInstalled = "plugin.installed" // plugin_id, name, version
+	TopicPluginUpdated   = "plugin.updated"   // plugin_id, name, version
+	TopicPluginRemoved   = "plugin.removed"   // plugin_id, name
+
+	TopicCommandInstalled = "command.installed" // command, version
+	TopicCommandUpdated   = "command.updated"   // command, version
+	TopicCommandRemoved   = "command.removed"   // command
+	TopicCommandFinished  = "command.finished"  // command, exit_code, duration_ms
+)
+
+const defaultSubscriptionBuffer = 256 // Events queued per subscriber before new ones are dropped
+
+// Event is a single lifecycle notification.
+type Event struct {
+	Seq        uint64            // Monotonically increasing sequence number assigned by the bus
+	Topic      string            // One of the Topic* constants
+	Time       time.Time         // UTC publish time
+	Attributes map[string]string // Topic-specific details
+}
+
+// MatchTopic reports whether topic matches a filter. A filter is either an exact topic,
+// a prefix ending in ".*" (e.g., "container.*"), or "*" for every topic.
+func MatchTopic(filter, topic string) bool {
+	if filter == "*" || filter == topic {
+		return true
+	}
+	if strings.HasSuffix(filter, ".*") {
+		return strings.HasPrefix(topic, strings.TrimSuffix(filter, "*"))
+	}
+	return false
+}
+
+// Subscription receives the events matching its filters on C.
+// Events are never blocked on a slow subscriber; they are dropped and counted instead.
+type Subscription struct {
+	C       <-chan Event
+	ch      chan Event
+	filters []string
+	mu      sync.Mutex
+	dropped int
+}
+
+// Dropped returns and resets the number of events dropped because C was full.
+func (s *Subscription) Dropped() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := s.dropped
+	s.dropped = 0
+	return n
+}
+
+func (s *Subscription) matches(topic string) bool {
+	if len(s.filters) == 0 {
+		return true // No filters subscribes to everything
+	}
+	for _, f := range s.filters {
+		if MatchTopic(f, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+// Bus is an in-process publish/subscribe event bus.
+// A nil *Bus is valid and discards everything, so managers can publish unconditionally.
+type Bus struct {
+	mu   sync.RWMutex
+	seq  uint64
+	subs map[*Subscription]struct{}
+}
+
+// NewBus creates an empty event bus.
+func NewBus() *Bus {
+	return &Bus{subs: make(map[*Subscription]struct{})}
+}
+
+// Publish delivers an event to every matching subscriber without blocking.
+func (b *Bus) Publish(topic string, attributes map[string]string) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	b.seq++
+	event := Event{Seq: b.seq, Topic: topic, Time: time.Now().UTC(), Attributes: attributes}
+	for sub := range b.subs {
+		if !sub.matches(topic) {
+			continue
+		}
+		select {
+		case sub.ch <- event:
+		default:
+			sub.mu.Lock()
+			sub.dropped++
+			sub.mu.Unlock()
+		}
+	}
+	b.mu.Unlock()
+}
+
+// Subscribe registers a subscriber for the given topic filters (see MatchTopic).
+// An empty filter list receives every event.
+func (b *Bus) Subscribe(filters ...string) *Subscription {
+	ch := make(chan Event, defaultSubscriptionBuffer)
+	sub := &Subscription{C: ch, ch: ch, filters: append([]string(nil), filters...)}
+	b.mu.Lock()
+	b.subs[sub] = struct{}{}
+	b.mu.Unlock()
+	return sub
+}
+
+// Unsubscribe removes a subscriber and closes its channel. It is safe to call more than once.
+func (b *Bus) Unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subs[sub]; ok {
+		delete(b.subs, sub)
+		close(sub.ch)
+	}
+}
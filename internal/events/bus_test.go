@@ -0,0 +1,58 @@
+package events
+
+import "testing"
+
+func TestBusDeliversMatchingEventsWithoutBlocking(t *testing.T) {
+	var nilBus *Bus
+	nilBus.Publish(TopicThemeInstalled, nil) // A nil bus discards events
+
+	bus := NewBus()
+	containers := bus.Subscribe("container.*")
+	everything := bus.Subscribe()
+
+	bus.Publish(TopicContainerStarted, map[string]string{"container_id": "ctr_a"})
+	bus.Publish(TopicThemeInstalled, map[string]string{"theme_id": "thm_a"})
+
+	if event := <-containers.C; event.Topic != TopicContainerStarted || event.Attributes["container_id"] != "ctr_a" || event.Seq != 1 {
+		t.Errorf("container subscriber got %+v", event)
+	}
+	if len(containers.C) != 0 {
+		t.Errorf("container subscriber received a theme event")
+	}
+	if first, second := <-everything.C, <-everything.C; first.Seq != 1 || second.Seq != 2 || second.Topic != TopicThemeInstalled {
+		t.Errorf("unfiltered subscriber got %+v, %+v", first, second)
+	}
+
+	// A full queue drops new events and counts them instead of blocking the publisher.
+	for i := 0; i < defaultSubscriptionBuffer+3; i++ {
+		bus.Publish(TopicContainerStopped, nil)
+	}
+	if dropped := containers.Dropped(); dropped != 3 {
+		t.Errorf("Dropped() = %d; want 3", dropped)
+	}
+	if dropped := containers.Dropped(); dropped != 0 {
+		t.Errorf("Dropped() after reset = %d; want 0", dropped)
+	}
+
+	bus.Unsubscribe(containers)
+	bus.Unsubscribe(containers) // Safe to repeat
+	for range containers.C {    // Drains the queue, then ends because the channel is closed
+	}
+}
+
+func TestMatchTopic(t *testing.T) {
+	for _, tc := range []struct {
+		filter, topic string
+		want          bool
+	}{
+		{"*", "theme.removed", true},
+		{"theme.removed", "theme.removed", true},
+		{"theme.*", "theme.rolled_back", true},
+		{"theme.*", "themes.removed", false},
+		{"container.started", "container.stopped", false},
+	} {
+		if got := MatchTopic(tc.filter, tc.topic); got != tc.want {
+			t.Errorf("MatchTopic(%q, %q) = %v; want %v", tc.filter, tc.topic, got, tc.want)
+		}
+	}
+}
@@ -2,18 +2,22 @@ package commands
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
-
-	// "time" // Removed unused import
+	"sync/atomic"
+	"time"
 
 	"github.com/OG-Open-Source/PanelBase/internal/configuration"
+	"github.com/OG-Open-Source/PanelBase/internal/events"
 	"github.com/OG-Open-Source/PanelBase/internal/logger"
 	"github.com/OG-Open-Source/PanelBase/internal/utils"
 )
@@ -42,12 +46,28 @@ type CommandManager struct {
 	commands   map[string]*CommandMetadata // Map command name (from metadata) to its full metadata (including FilePath)
 	mu         sync.RWMutex
 	logger     *logger.Logger
-	idGen      *utils.IDGenerator // Keep for potential future use (e.g., generating IDs for command instances?)
+	idGen      *utils.IDGenerator         // Keep for potential future use (e.g., generating IDs for command instances?)
+	bus        atomic.Pointer[events.Bus] // Optional; nil discards lifecycle events
+
+	secretsProvider func(containerID string) ([]string, error) // Optional; returns NAME=value pairs for RunCommand
+}
+
+// SetEventBus attaches the bus that command lifecycle events are published to.
+func (cm *CommandManager) SetEventBus(bus *events.Bus) {
+	cm.bus.Store(bus)
+}
+
+// SetSecretsProvider sets the function RunCommand uses to look up the secrets (as NAME=value
+// environment entries) of the container a command runs for.
+func (cm *CommandManager) SetSecretsProvider(provider func(containerID string) ([]string, error)) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.secretsProvider = provider
 }
 
 // NewCommandManager creates a new CommandManager instance and discovers commands from the state file.
-// Requires logger and IDGenerator.
-func NewCommandManager(log *logger.Logger, idGen *utils.IDGenerator) (*CommandManager, error) { // Removed commandDir ...string
+// Requires logger and IDGenerator. commandDir overrides the default ext/commands directory.
+func NewCommandManager(log *logger.Logger, idGen *utils.IDGenerator, commandDir ...string) (*CommandManager, error) {
 	if log == nil {
 		return nil, fmt.Errorf("logger cannot be nil")
 	}
@@ -55,8 +75,11 @@ func NewCommandManager(log *logger.Logger, idGen *utils.IDGenerator) (*CommandMa
 		return nil, fmt.Errorf("IDGenerator cannot be nil")
 	}
 
-	dir := defaultCommandDir                                               // Hardcoded path
-	log.Logf("CommandManager using hardcoded commands directory: %s", dir) // Log the used path
+	dir := defaultCommandDir
+	if len(commandDir) > 0 && commandDir[0] != "" {
+		dir = commandDir[0]
+	}
+	log.Logf("CommandManager using commands directory: %s", dir) // Log the used path
 
 	// Ensure command source directory exists
 	if err := os.MkdirAll(dir, 0755); err != nil {
@@ -78,7 +101,12 @@ func NewCommandManager(log *logger.Logger, idGen *utils.IDGenerator) (*CommandMa
 func (cm *CommandManager) discoverCommands() {
 	cm.mu.Lock()
 	defer cm.mu.Unlock()
+	cm.discoverCommandsLocked()
+}
 
+// discoverCommandsLocked does the work of discoverCommands. The caller must hold cm.mu
+// for writing; Install/Update/Remove call it directly since they already hold the lock.
+func (cm *CommandManager) discoverCommandsLocked() {
 	cm.commands = make(map[string]*CommandMetadata) // Reset internal map
 
 	// Load state from commands.json
@@ -199,25 +227,58 @@ func (cm *CommandManager) GetCommandCount() int {
 	return len(cm.commands) // Return the length of the map
 }
 
-/*
-// ExecuteCommand runs a specific command identified by its name.
-// IMPORTANT: This needs to be refactored. It should likely take a container context,
-// copy the script from meta.FilePath to the container, and execute it there.
-func (cm *CommandManager) ExecuteCommand(commandName string, args ...string) ([]byte, error) {
+// RunCommand runs a discovered command script with sh, passing args through. The script's
+// environment is the server's environment plus PANELBASE_CONTAINER_ID and the secrets the
+// secrets provider returns for containerID (global secrets when containerID is empty).
+// A non-zero exit status is returned as exitCode with a nil error; err is only set when the
+// command could not be run at all.
+func (cm *CommandManager) RunCommand(commandName, containerID string, args []string, stdout, stderr io.Writer) (exitCode int, err error) {
 	cm.mu.RLock()
 	meta, exists := cm.commands[commandName]
+	secretsProvider := cm.secretsProvider
 	cm.mu.RUnlock()
 
 	if !exists {
-		return nil, fmt.Errorf("command '%s' not found or invalid", commandName)
+		return -1, fmt.Errorf("command '%s' not found or invalid", commandName)
 	}
 
-	cm.logger.Logf("Executing command '%s' from path '%s' with args: %v", commandName, meta.FilePath, args)
+	env := os.Environ()
+	if containerID != "" {
+		env = append(env, "PANELBASE_CONTAINER_ID="+containerID)
+	}
+	if secretsProvider != nil {
+		secretEnv, err := secretsProvider(containerID)
+		if err != nil {
+			return -1, fmt.Errorf("failed to load secrets for command '%s': %w", commandName, err)
+		}
+		env = append(env, secretEnv...) // Later entries win, so secrets override inherited variables
+	}
+
+	cm.logger.Logf("Running command '%s' from path '%s' (container: '%s') with args: %v", commandName, meta.FilePath, containerID, args)
+	cmd := exec.Command("sh", append([]string{meta.FilePath}, args...)...)
+	cmd.Env = env
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	started := time.Now()
+	runErr := cmd.Run()
+	duration := time.Since(started)
+	if runErr != nil {
+		exitErr, ok := runErr.(*exec.ExitError)
+		if !ok {
+			return -1, fmt.Errorf("failed to run command '%s': %w", commandName, runErr)
+		}
+		exitCode = exitErr.ExitCode()
+	}
 
-	// ... execution logic needs complete rewrite for container context ...
-	return nil, fmt.Errorf("ExecuteCommand is currently disabled pending refactoring for container execution")
+	cm.logger.Logf("Command '%s' finished with exit code %d in %s", commandName, exitCode, duration)
+	cm.bus.Load().Publish(events.TopicCommandFinished, map[string]string{
+		"command":     commandName,
+		"exit_code":   strconv.Itoa(exitCode),
+		"duration_ms": strconv.FormatInt(duration.Milliseconds(), 10),
+	})
+	return exitCode, nil
 }
-*/
 
 /*
 // ListCommands returns the names of all discovered and validated commands.
@@ -397,14 +458,27 @@ func (cm *CommandManager) InstallCommand(source string, force bool) (*CommandMet
 	}
 
 	// --- 5. Update State ---
-	commandsState[targetFilename] = configuration.InstalledCommandEntry{
+	// Re-read and modify the state under the cross-process lock so concurrent changes are kept.
+	newEntry := configuration.InstalledCommandEntry{
 		Filename:   targetFilename,
 		Name:       meta.Command,
 		Version:    meta.Version,
 		SourceLink: meta.SourceLink,
 	}
 
-	if err := configuration.SaveCommandsState(commandsState); err != nil {
+	errInstalledMeanwhile := fmt.Errorf("filename conflict for '%s': installed by another process in the meantime", targetFilename)
+	if err := configuration.UpdateCommandsState(func(state map[string]configuration.InstalledCommandEntry) error {
+		// Repeat the step 2 check on the locked state, so a concurrent install of the same
+		// filename is reported instead of silently replacing the other entry.
+		if _, exists := state[targetFilename]; exists && action == ActionInstallNew {
+			return errInstalledMeanwhile
+		}
+		state[targetFilename] = newEntry
+		return nil
+	}); errors.Is(err, errInstalledMeanwhile) {
+		cm.logger.Logf("Installation failed for command '%s': %v.", meta.Command, err)
+		return nil, err
+	} else if err != nil {
 		cm.logger.Logf("CRITICAL: Command script '%s' installed, but FAILED TO SAVE STATE to commands.json: %v. Manual correction may be needed.", targetFilename, err)
 		// Log failure before returning error
 		cm.logger.Logf("Installation failed for command '%s'.", meta.Command)
@@ -421,17 +495,16 @@ func (cm *CommandManager) InstallCommand(source string, force bool) (*CommandMet
 	}
 
 	// --- 6. Refresh Internal State ---
-	cm.discoverCommands()
+	cm.discoverCommandsLocked()
 
 	// Return the metadata parsed from the script (includes FilePath implicitly via discovery)
 	// Need to find the potentially updated metadata from the internal map after discovery
-	cm.mu.RLock()
-	finalMeta, found := cm.commands[meta.Command]
-	cm.mu.RUnlock()
+	finalMeta, found := cm.commands[meta.Command] // Write lock is still held
 	if !found {
 		// This shouldn't happen if discovery worked correctly after saving state
 		return nil, fmt.Errorf("internal error: command '%s' installed but not found in manager after discovery", meta.Command)
 	}
+	cm.bus.Load().Publish(events.TopicCommandInstalled, map[string]string{"command": finalMeta.Command, "version": finalMeta.Version})
 
 	return finalMeta, nil
 }
@@ -634,22 +707,22 @@ func (cm *CommandManager) UpdateCommand(commandName string) (*CommandMetadata, e
 		return nil, fmt.Errorf("failed to write updated command script file '%s': %w. Command may be broken.", targetFilePath, err)
 	}
 
-	// 6. Update state file with new version
-	commandsState, err := configuration.LoadCommandsState()
-	if err != nil {
-		cm.logger.Logf("Warning: Failed to load commands state before updating version for '%s': %v. State file may become inconsistent.", commandName, err)
-		commandsState = make(map[string]configuration.InstalledCommandEntry)
-	}
-
-	if entry, ok := commandsState[currentFilename]; ok {
-		entry.Version = latestMeta.Version
-		commandsState[currentFilename] = entry
-
-		if err := configuration.SaveCommandsState(commandsState); err != nil {
-			cm.logger.Logf("Warning: Failed to save updated commands state after update for command '%s' (file: %s): %v", commandName, currentFilename, err)
-		} else {
-			cm.logger.Logf("Command state for '%s' updated successfully.", commandName)
+	// 6. Update state file with new version (locked load-modify-save)
+	foundInState := false
+	err = configuration.UpdateCommandsState(func(state map[string]configuration.InstalledCommandEntry) error {
+		entry, ok := state[currentFilename]
+		if !ok {
+			return nil // Nothing to update; reported below
 		}
+		foundInState = true
+		entry.Version = latestMeta.Version
+		state[currentFilename] = entry
+		return nil
+	})
+	if err != nil {
+		cm.logger.Logf("Warning: Failed to save updated commands state after update for command '%s' (file: %s): %v", commandName, currentFilename, err)
+	} else if foundInState {
+		cm.logger.Logf("Command state for '%s' updated successfully.", commandName)
 	} else {
 		cm.logger.Logf("Warning: Command '%s' (file: %s) was found in manager but not in state file during update. State file not updated.", commandName, currentFilename)
 	}
@@ -658,16 +731,15 @@ func (cm *CommandManager) UpdateCommand(commandName string) (*CommandMetadata, e
 	cm.logger.Logf("Command '%s' updated at '%s'.", commandName, targetFilePath) // Simplified success log
 
 	// 7. Refresh internal cache
-	cm.discoverCommands()
+	cm.discoverCommandsLocked()
 
 	// Return the newly updated and discovered metadata
-	cm.mu.RLock() // Need RLock again as discoverCommands unlocks/relocks
-	finalMeta, found := cm.commands[commandName]
-	cm.mu.RUnlock()
+	finalMeta, found := cm.commands[commandName] // Write lock is still held
 	if !found {
 		// This would be an unexpected internal error
 		return nil, fmt.Errorf("internal error: command '%s' updated but not found in manager after rediscovery", commandName)
 	}
+	cm.bus.Load().Publish(events.TopicCommandUpdated, map[string]string{"command": finalMeta.Command, "version": finalMeta.Version})
 	return finalMeta, nil
 }
 
@@ -739,10 +811,11 @@ func (cm *CommandManager) RemoveCommand(commandName string) error {
 
 	// 6. Delete the entry from the state map (only if it existed)
 	if stateExists {
-		delete(commandsState, targetFilename)
-
-		// 7. Save the updated state map
-		if err := configuration.SaveCommandsState(commandsState); err != nil {
+		// 7. Save the updated state map (locked re-read so concurrent changes are kept)
+		if err := configuration.UpdateCommandsState(func(state map[string]configuration.InstalledCommandEntry) error {
+			delete(state, targetFilename)
+			return nil
+		}); err != nil {
 			cm.logger.Logf("Warning: Command file for '%s' removed, but failed to save updated commands state: %v", commandName, err)
 			// Log failure before returning error
 			cm.logger.Logf("Removal failed for command '%s'.", commandName)
@@ -757,8 +830,9 @@ func (cm *CommandManager) RemoveCommand(commandName string) error {
 	cm.logger.Logf("Command '%s' removed.", commandName)
 
 	// 8. Refresh internal cache
-	cm.discoverCommands()
+	cm.discoverCommandsLocked()
 
+	cm.bus.Load().Publish(events.TopicCommandRemoved, map[string]string{"command": commandName})
 	return nil
 }
 
@@ -1,6 +1,7 @@
 package plugins
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -9,9 +10,14 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/OG-Open-Source/PanelBase/internal/atomicfile"
 	"github.com/OG-Open-Source/PanelBase/internal/configuration"
+	"github.com/OG-Open-Source/PanelBase/internal/downloader"
+	"github.com/OG-Open-Source/PanelBase/internal/events"
+	"github.com/OG-Open-Source/PanelBase/internal/kvstore"
 	"github.com/OG-Open-Source/PanelBase/internal/logger"
 	"github.com/OG-Open-Source/PanelBase/internal/utils"
 	"gopkg.in/yaml.v3"
@@ -24,6 +30,10 @@ const (
 	maxYAMLSize       = 1 << 20 // 1MB limit for plugin.yaml
 )
 
+// CredentialFile is written to each plugin directory by a running server and holds the token
+// the plugin logs in to the RPC server with. It is only readable by the server's user.
+const CredentialFile = "plugin.token"
+
 // ActionType defines the type of installation action to take.
 type ActionType int
 
@@ -38,12 +48,18 @@ type PluginManager struct {
 	pluginDir string
 	logger    *logger.Logger
 	idGen     *utils.IDGenerator
+	kvStore   *kvstore.Store             // Optional; plugin kv data is removed together with the plugin when set
+	bus       atomic.Pointer[events.Bus] // Optional; nil discards lifecycle events
+	// downloader fetches plugin files; NewPluginManager sets one without a cache
+	downloader *downloader.Downloader
+	// issueToken returns the RPC token of a plugin; nil when no server runs in this process
+	issueToken func(pluginID string) string
 	// stateFilePath string // No longer needed as path is passed to Load/Save functions
 	mu sync.RWMutex
 }
 
-// NewPluginManager creates a new PluginManager.
-func NewPluginManager(log *logger.Logger, idGen *utils.IDGenerator) (*PluginManager, error) { // Removed pluginDir ...string
+// NewPluginManager creates a new PluginManager for the given plugins directory (default ext/plugins).
+func NewPluginManager(log *logger.Logger, idGen *utils.IDGenerator, pluginDir ...string) (*PluginManager, error) {
 	if log == nil {
 		return nil, fmt.Errorf("logger cannot be nil for PluginManager")
 	}
@@ -51,22 +67,94 @@ func NewPluginManager(log *logger.Logger, idGen *utils.IDGenerator) (*PluginMana
 		return nil, fmt.Errorf("IDGenerator cannot be nil for PluginManager")
 	}
 
-	dir := defaultPluginsDir                                             // Hardcoded path
-	log.Logf("PluginManager using hardcoded plugins directory: %s", dir) // Log the used path
+	dir := defaultPluginsDir
+	if len(pluginDir) > 0 && pluginDir[0] != "" {
+		dir = pluginDir[0]
+	}
+	log.Logf("PluginManager using plugins directory: %s", dir) // Log the used path
 
 	if err := os.MkdirAll(dir, 0755); err != nil {
 		return nil, fmt.Errorf("failed to create plugins directory '%s': %w", dir, err)
 	}
 
+	dl, err := downloader.New(log)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create plugin downloader: %w", err)
+	}
 	pm := &PluginManager{
-		pluginDir: dir,
-		logger:    log,
-		idGen:     idGen,
+		pluginDir:  dir,
+		logger:     log,
+		idGen:      idGen,
+		downloader: dl,
 	}
 	// pm.discoverPlugins() // TODO: Implement plugin discovery if needed (e.g., for listing)
 	return pm, nil
 }
 
+// SetKVStore attaches the key-value store whose plugin namespaces are deleted by RemovePlugin.
+func (pm *PluginManager) SetKVStore(store *kvstore.Store) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.kvStore = store
+}
+
+// SetEventBus attaches the bus that plugin lifecycle events are published to.
+func (pm *PluginManager) SetEventBus(bus *events.Bus) {
+	pm.bus.Store(bus)
+}
+
+// SetCredentialIssuer makes the manager write each plugin's RPC token to CredentialFile in the
+// plugin directory after installs and updates. A nil issuer is ignored.
+func (pm *PluginManager) SetCredentialIssuer(issue func(pluginID string) string) {
+	if issue == nil {
+		return
+	}
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.issueToken = issue
+}
+
+// IssueCredentials writes the token file of every installed plugin, e.g. when the server
+// starts and the tokens of its previous run became invalid.
+func (pm *PluginManager) IssueCredentials() error {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pluginsState, err := configuration.LoadPluginsState()
+	if err != nil {
+		return fmt.Errorf("failed to load plugins state: %w", err)
+	}
+	var errs []error
+	for pluginID := range pluginsState {
+		if err := pm.writeCredential(pluginID); err != nil {
+			errs = append(errs, err) // Keep going; one broken directory should not lock out the others
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// writeCredential writes the token file of a plugin, if an issuer is set. The caller must hold pm.mu.
+func (pm *PluginManager) writeCredential(pluginID string) error {
+	if pm.issueToken == nil {
+		return nil
+	}
+	tokenPath := filepath.Join(pm.pluginDir, pluginID, CredentialFile)
+	os.Remove(tokenPath) // os.WriteFile keeps the mode of an existing file
+	if err := os.WriteFile(tokenPath, []byte(pm.issueToken(pluginID)+"\n"), 0600); err != nil {
+		return fmt.Errorf("failed to write credential of plugin '%s': %w", pluginID, err)
+	}
+	return nil
+}
+
+// SetDownloader replaces the downloader used for plugin files, e.g. with one that caches.
+func (pm *PluginManager) SetDownloader(d *downloader.Downloader) {
+	if d == nil {
+		return
+	}
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.downloader = d
+}
+
 // InstallPlugin installs a plugin from a given source (URL or local path).
 // It handles fetching, validation, version checking, and file placement.
 func (pm *PluginManager) InstallPlugin(source string, force bool) (*PluginMetadata, error) {
@@ -105,7 +193,6 @@ func (pm *PluginManager) InstallPlugin(source string, force bool) (*PluginMetada
 	pm.logger.Logf("  Force installation: %v", force)
 
 	totalFiles := countFilesInStructure(meta.Structure)
-	totalSteps := totalFiles // Match log example where steps are asset downloads
 	// Assets to download log moved to later, after status check
 
 	// --- 2. Check State and Determine Action ---
@@ -218,17 +305,14 @@ func (pm *PluginManager) InstallPlugin(source string, force bool) (*PluginMetada
 	if !isLocalSource {
 		baseURLForStructure = parsedSourceURL
 	}
-	// totalFiles and totalSteps calculated earlier
-	downloadedFiles := 0
-
 	// Log download start (common for new install and overwrite)
 	pm.logger.Logf("Downloading %d assets:", totalFiles)
-	if err := pm.downloadAndSavePluginStructure(targetPluginPath, "", meta.Structure, baseURLForStructure, totalSteps, &downloadedFiles, isLocalSource); err != nil {
+	if err := pm.downloadPluginStructure(targetPluginPath, meta.Structure, baseURLForStructure, isLocalSource); err != nil {
 		// Cleanup partially downloaded files/dirs if it was a new install
 		if action == ActionInstallNew {
 			os.RemoveAll(targetPluginPath)
 		}
-		// Log failure before returning error (downloadAndSavePluginStructure logs specifics)
+		// Log failure before returning error (the downloader logs specifics)
 		pm.logger.Logf("Installation failed for plugin '%s'.", meta.Name)
 		return nil, fmt.Errorf("failed to download plugin structure for '%s': %w", meta.Name, err)
 	}
@@ -237,7 +321,7 @@ func (pm *PluginManager) InstallPlugin(source string, force bool) (*PluginMetada
 
 	// --- 6. Save Metadata Locally ---
 	localMetaPath := filepath.Join(targetPluginPath, pluginMetaFile)
-	if err := os.WriteFile(localMetaPath, yamlData, 0644); err != nil {
+	if err := atomicfile.WriteFile(localMetaPath, yamlData, 0644); err != nil {
 		if action == ActionInstallNew {
 			os.RemoveAll(targetPluginPath) // Cleanup
 		}
@@ -246,13 +330,32 @@ func (pm *PluginManager) InstallPlugin(source string, force bool) (*PluginMetada
 	}
 
 	// --- 7. Update State ---
-	pluginsState[targetDirName] = configuration.InstalledPluginEntry{
+	// Re-read and modify the state under the cross-process lock; pluginsState was loaded before the
+	// download and may be stale if another process changed plugins.json in the meantime.
+	newEntry := configuration.InstalledPluginEntry{
 		PlgID:      targetDirName,
 		Name:       meta.Name,
 		Version:    meta.Version,
 		SourceLink: canonicalSourceLink,
 	}
-	if err := configuration.SavePluginsState(pluginsState); err != nil {
+	errInstalledMeanwhile := fmt.Errorf("plugin '%s' version '%s' was installed by another process in the meantime", meta.Name, meta.Version)
+	if err := configuration.UpdatePluginsState(func(state map[string]configuration.InstalledPluginEntry) error {
+		// Repeat the step 2 check on the locked state: a new install must not duplicate an entry
+		// another process added while this one was downloading.
+		if action == ActionInstallNew {
+			for _, entry := range state {
+				if entry.SourceLink == canonicalSourceLink && entry.Version == meta.Version {
+					return errInstalledMeanwhile
+				}
+			}
+		}
+		state[targetDirName] = newEntry
+		return nil
+	}); errors.Is(err, errInstalledMeanwhile) {
+		os.RemoveAll(targetPluginPath) // Cleanup; the other installation is kept
+		pm.logger.Logf("Installation failed for plugin '%s': %v.", meta.Name, err)
+		return nil, err
+	} else if err != nil {
 		pm.logger.Logf("CRITICAL: Plugin '%s' files installed to '%s', but FAILED TO SAVE STATE to plugins.json: %v. Manual correction may be needed.", meta.Name, targetPluginPath, err)
 		// Log failure before returning error
 		pm.logger.Logf("Installation failed for plugin '%s'.", meta.Name)
@@ -269,6 +372,11 @@ func (pm *PluginManager) InstallPlugin(source string, force bool) (*PluginMetada
 		pm.logger.Logf("Plugin '%s' (v%s) installed to '%s'.", meta.Name, meta.Version, targetPluginPath)
 	}
 
+	if err := pm.writeCredential(targetDirName); err != nil {
+		pm.logger.Logf("Warning: %v. The plugin cannot log in to the RPC server until the server restarts.", err)
+	}
+	pm.bus.Load().Publish(events.TopicPluginInstalled, map[string]string{"plugin_id": targetDirName, "name": meta.Name, "version": meta.Version})
+
 	// Refresh internal cache (if discoverPlugins is implemented)
 	// pm.discoverPlugins()
 
@@ -290,17 +398,20 @@ func countFilesInStructure(structure map[string]interface{}) int {
 	return count
 }
 
-// downloadAndSavePluginStructure downloads files and creates directories based on the plugin's Structure.
+// collectPluginDownloadJobs creates the directories of the plugin's Structure and turns its files
+// into download jobs. Entries whose URL cannot be resolved are logged and skipped, as are paths
+// escaping the plugin directory. Unlike theme assets, plugin files carry no SHA256 sum, so the
+// jobs cannot be served from the blob store and are only revalidated through the HTTP cache.
 // isLocalSource: Boolean indicating if the original plugin source was a local file path.
-func (pm *PluginManager) downloadAndSavePluginStructure(baseSavePath string, currentRelativePath string, structure map[string]interface{}, baseURL *url.URL, totalSteps int, downloadedFiles *int, isLocalSource bool) error {
-	httpClient := http.Client{Timeout: 60 * time.Second} // Consider making timeout configurable
-
-	// Get the absolute path of the base save directory once
+func (pm *PluginManager) collectPluginDownloadJobs(baseSavePath string, currentRelativePath string, structure map[string]interface{}, baseURL *url.URL, isLocalSource bool, jobs *[]downloader.Job) error {
 	// Get the absolute path of the base save directory once
-	// Get the absolute path of the base save directory once
-	absBaseSavePath, pathErr := filepath.Abs(baseSavePath) // Correctly assign to pathErr
+	absBaseSavePath, pathErr := filepath.Abs(baseSavePath)
 	if pathErr != nil {
-		return fmt.Errorf("failed to get absolute path for base save directory '%s': %w", baseSavePath, pathErr) // Use pathErr here
+		return fmt.Errorf("failed to get absolute path for base save directory '%s': %w", baseSavePath, pathErr)
+	}
+	insideBase := func(p string) bool {
+		absPath, err := filepath.Abs(p)
+		return err == nil && (strings.HasPrefix(absPath, absBaseSavePath+string(os.PathSeparator)) || absPath == absBaseSavePath)
 	}
 
 	for name, item := range structure {
@@ -311,108 +422,44 @@ func (pm *PluginManager) downloadAndSavePluginStructure(baseSavePath string, cur
 
 		switch v := item.(type) {
 		case string: // It's a file URL
-			(*downloadedFiles)++
-			pm.logger.Logf("  [%d/%d] Downloading '%s'...", *downloadedFiles, totalSteps, itemPathForLogAndURL)
-
-			fileURLString := v
+			if !insideBase(currentLocalItemSavePath) {
+				pm.logger.Logf("Warning: File path '%s' resolves outside base directory '%s'. Skipping.", currentLocalItemSavePath, absBaseSavePath)
+				continue
+			}
 			var finalFileURL *url.URL
 			var err error
-
 			if baseURL != nil { // If plugin source was a URL, resolve relative file paths
-				finalFileURL, err = baseURL.Parse(fileURLString)
+				finalFileURL, err = baseURL.Parse(v)
 				if err != nil {
-					pm.logger.Logf("  [%d/%d] Downloading '%s'... Error parsing relative URL: %v. Skipping.", *downloadedFiles, totalSteps, itemPathForLogAndURL, err)
+					pm.logger.Logf("  Skipping '%s': error parsing relative URL: %v", itemPathForLogAndURL, err)
 					continue
 				}
 			} else {
-				// If plugin source was local, fileURLString should be a relative or absolute path
-				// For now, assume 'v' must be a resolvable URL on its own if baseURL is nil.
-				// This logic might need enhancement for local YAMLs with relative file paths in structure.
-				finalFileURL, err = url.ParseRequestURI(fileURLString)
+				// If plugin source was local, v must be a resolvable URL on its own.
+				// Local relative paths in the structure of a local plugin.yaml are not implemented.
+				finalFileURL, err = url.ParseRequestURI(v)
 				if err != nil {
-					// If it's a local source and not a valid URI, it might be a local relative path.
-					// This part of the logic for local-source-relative-paths in structure is not fully implemented.
-					// For now, we log and skip if it's not a clear URL.
 					if isLocalSource {
-						pm.logger.Logf("  [%d/%d] Downloading '%s'... Path is not a URL and local relative path handling is not fully implemented. Skipping: %s", *downloadedFiles, totalSteps, itemPathForLogAndURL, fileURLString)
+						pm.logger.Logf("  Skipping '%s': path is not a URL and local relative path handling is not implemented: %s", itemPathForLogAndURL, v)
 						continue
 					}
-					pm.logger.Logf("  [%d/%d] Downloading '%s'... Error parsing URL: %v. Skipping.", *downloadedFiles, totalSteps, itemPathForLogAndURL, err)
+					pm.logger.Logf("  Skipping '%s': error parsing URL: %v", itemPathForLogAndURL, err)
 					continue
 				}
 			}
-
-			if finalFileURL != nil { // Proceed with download if we have a URL
-				resp, err := httpClient.Get(finalFileURL.String())
-				if err != nil {
-					pm.logger.Logf("  [%d/%d] Downloading '%s'... Error: %v", *downloadedFiles, totalSteps, itemPathForLogAndURL, err)
-					continue // Or return error based on desired strictness
-				}
-				defer resp.Body.Close()
-
-				if resp.StatusCode != http.StatusOK {
-					pm.logger.Logf("  [%d/%d] Downloading '%s'... Error: status %s", *downloadedFiles, totalSteps, itemPathForLogAndURL, resp.Status)
-					continue // Or return error
-				}
-
-				dirPath := filepath.Dir(currentLocalItemSavePath)
-				if err := os.MkdirAll(dirPath, 0755); err != nil {
-					pm.logger.Logf("  [%d/%d] Downloading '%s'... Error creating directory %s: %v", *downloadedFiles, totalSteps, itemPathForLogAndURL, dirPath, err)
-					continue // Or return error
-				}
-
-				out, err := os.Create(currentLocalItemSavePath)
-				if err != nil {
-					pm.logger.Logf("  [%d/%d] Downloading '%s'... Error creating file %s: %v", *downloadedFiles, totalSteps, itemPathForLogAndURL, currentLocalItemSavePath, err)
-					continue // Or return error
-				}
-
-				_, copyErr := io.Copy(out, resp.Body)
-				closeErr := out.Close()
-
-				if copyErr != nil {
-					pm.logger.Logf("  [%d/%d] Downloading '%s'... Error saving file: %v", *downloadedFiles, totalSteps, itemPathForLogAndURL, copyErr)
-					os.Remove(currentLocalItemSavePath) // Clean up partial file
-					continue                            // Or return error
-				}
-				if closeErr != nil {
-					pm.logger.Logf("  [%d/%d] Downloading '%s'... Error closing file: %v", *downloadedFiles, totalSteps, itemPathForLogAndURL, closeErr)
-					// Continue even if close fails, as data is written.
-				}
-				pm.logger.Logf("  [%d/%d] Downloading '%s'... Done.", *downloadedFiles, totalSteps, itemPathForLogAndURL)
-			} else if isLocalSource {
-				// This block is for handling files when the plugin.yaml itself was loaded from a local path,
-				// and the 'v' in structure is a relative path to a local file.
-				// This requires knowing the directory of the original local plugin.yaml.
-				// For now, this specific local-to-local copy is not fully implemented here.
-				// The current fetchPluginYAML handles fetching the initial YAML.
-				// If 'v' is a relative path, it should ideally be resolved against the directory of the source plugin.yaml.
-				pm.logger.Logf("  [%d/%d] Downloading '%s'... Skipped (local file copy from structure not fully implemented for local plugin.yaml).", *downloadedFiles, totalSteps, itemPathForLogAndURL)
-			}
+			*jobs = append(*jobs, downloader.Job{Name: itemPathForLogAndURL, URL: finalFileURL.String(), Dest: currentLocalItemSavePath})
 
 		case map[string]interface{}: // It's a subdirectory
 			// Security Check: Ensure the subdirectory path doesn't escape the base plugin directory
-			absSubDirPath, pathErr := filepath.Abs(currentLocalItemSavePath)
-			if pathErr != nil {
-				pm.logger.Logf("Warning: Could not get absolute path for subdirectory '%s': %v. Skipping.", currentLocalItemSavePath, pathErr)
-				continue // Skip this subdirectory
-			}
-			// Use the previously calculated absBaseSavePath for the check
-			if !strings.HasPrefix(absSubDirPath, absBaseSavePath+string(os.PathSeparator)) && absSubDirPath != absBaseSavePath {
+			if !insideBase(currentLocalItemSavePath) {
 				pm.logger.Logf("Warning: Subdirectory path '%s' resolves outside base directory '%s'. Skipping.", currentLocalItemSavePath, absBaseSavePath)
 				continue // Skip this subdirectory
 			}
-
-			pm.logger.Logf("Ensuring sub-directory '%s' exists at '%s'", name, currentLocalItemSavePath)
 			if err := os.MkdirAll(currentLocalItemSavePath, 0755); err != nil {
-				// Log the error but attempt to continue with other items? Or return error?
-				// Returning error seems safer.
 				return fmt.Errorf("failed to create sub-directory '%s': %w", currentLocalItemSavePath, err)
 			}
-			// Recursively process the sub-directory
-			// Pass the updated relative path for logging and URL resolution
-			// Pass isLocalSource down correctly in the recursive call
-			if err := pm.downloadAndSavePluginStructure(baseSavePath, itemPathForLogAndURL, v, baseURL, totalSteps, downloadedFiles, isLocalSource); err != nil {
+			// Recursively process the sub-directory with the updated relative path
+			if err := pm.collectPluginDownloadJobs(baseSavePath, itemPathForLogAndURL, v, baseURL, isLocalSource, jobs); err != nil {
 				return err // Propagate error up
 			}
 		default:
@@ -422,6 +469,17 @@ func (pm *PluginManager) downloadAndSavePluginStructure(baseSavePath string, cur
 	return nil
 }
 
+// downloadPluginStructure downloads the files of a plugin's Structure into baseSavePath.
+// A file that cannot be downloaded after its retries fails the whole download. The caller holds pm.mu.
+func (pm *PluginManager) downloadPluginStructure(baseSavePath string, structure map[string]interface{}, baseURL *url.URL, isLocalSource bool) error {
+	var jobs []downloader.Job
+	if err := pm.collectPluginDownloadJobs(baseSavePath, "", structure, baseURL, isLocalSource, &jobs); err != nil {
+		return err
+	}
+	_, err := pm.downloader.Download("  ", jobs)
+	return err
+}
+
 // fetchPluginYAML fetches plugin.yaml content from a URL or local path.
 // Similar to ThemeManager.fetchThemeYAML but handles plugin specifics.
 func (pm *PluginManager) fetchPluginYAML(source string) (yamlData []byte, parsedSourceURL *url.URL, isLocalSource bool, sourceNameForLog string, err error) {
@@ -583,14 +641,10 @@ func (pm *PluginManager) UpdatePlugin(pluginID string) (*PluginMetadata, error)
 	if !latestIsLocalSource {
 		baseURLForStructure = latestParsedSourceURL
 	}
-	totalFiles := countFilesInStructure(latestMeta.Structure)
-	totalSteps := totalFiles + 1
-	downloadedFiles := 0
-
 	// Scenario 5 Log: Downloading assets
 	pm.logger.Logf("Downloading assets for plugin '%s' (v%s):", latestMeta.Name, latestMeta.Version)
-	if err := pm.downloadAndSavePluginStructure(targetPluginPath, "", latestMeta.Structure, baseURLForStructure, totalSteps, &downloadedFiles, latestIsLocalSource); err != nil {
-		// Log failure before returning error (downloadAndSavePluginStructure logs specifics)
+	if err := pm.downloadPluginStructure(targetPluginPath, latestMeta.Structure, baseURLForStructure, latestIsLocalSource); err != nil {
+		// Log failure before returning error (the downloader logs specifics)
 		pm.logger.Logf("Update failed for plugin '%s'.", currentEntry.Name)
 		return nil, fmt.Errorf("failed to download updated plugin structure for '%s': %w. Plugin may be in a broken state.", latestMeta.Name, err)
 	}
@@ -598,19 +652,22 @@ func (pm *PluginManager) UpdatePlugin(pluginID string) (*PluginMetadata, error)
 	pm.logger.Logf("All assets downloaded for plugin '%s' (v%s).", latestMeta.Name, latestMeta.Version)
 
 	localMetaPath := filepath.Join(targetPluginPath, pluginMetaFile)
-	if err := os.WriteFile(localMetaPath, latestYAMLData, 0644); err != nil { // Save the latest YAML
+	if err := atomicfile.WriteFile(localMetaPath, latestYAMLData, 0644); err != nil { // Save the latest YAML (previous kept as .bak)
 		pm.logger.Logf("Update failed for plugin '%s'.", currentEntry.Name)
 		return nil, fmt.Errorf("failed to write updated local %s for plugin '%s': %w", pluginMetaFile, latestMeta.Name, err)
 	}
 
-	// 6. Update state file with new version
-	pluginsState[pluginID] = configuration.InstalledPluginEntry{
+	// 6. Update state file with new version (locked re-read so concurrent changes are kept)
+	updatedEntry := configuration.InstalledPluginEntry{
 		PlgID:      pluginID,
 		Name:       latestMeta.Name,
 		Version:    latestMeta.Version,
 		SourceLink: currentEntry.SourceLink,
 	}
-	if err := configuration.SavePluginsState(pluginsState); err != nil {
+	if err := configuration.UpdatePluginsState(func(state map[string]configuration.InstalledPluginEntry) error {
+		state[pluginID] = updatedEntry
+		return nil
+	}); err != nil {
 		pm.logger.Logf("Warning: Failed to save updated plugins state after update for plugin ID '%s': %v", pluginID, err)
 		// Log failure before returning error? Or just warn? Warn for now.
 		// pm.logger.Logf("Update failed for plugin '%s'.", currentEntry.Name)
@@ -618,6 +675,10 @@ func (pm *PluginManager) UpdatePlugin(pluginID string) (*PluginMetadata, error)
 
 	// Scenario 5 Log: Final success
 	pm.logger.Logf("Plugin '%s' updated to v%s, installed to '%s'.", latestMeta.Name, latestMeta.Version, targetPluginPath)
+	if err := pm.writeCredential(pluginID); err != nil {
+		pm.logger.Logf("Warning: %v. The plugin cannot log in to the RPC server until the server restarts.", err)
+	}
+	pm.bus.Load().Publish(events.TopicPluginUpdated, map[string]string{"plugin_id": pluginID, "name": latestMeta.Name, "version": latestMeta.Version})
 
 	// 7. Refresh internal cache (if discoverPlugins is implemented)
 	// pm.discoverPlugins()
@@ -684,21 +745,29 @@ func (pm *PluginManager) RemovePlugin(pluginID string) error {
 		}
 	}
 
-	// 5. Delete the entry from the state map
-	delete(pluginsState, pluginID)
-
-	// 6. Save the updated state map
-	if err := configuration.SavePluginsState(pluginsState); err != nil {
+	// 5-6. Delete the entry from the state file (locked re-read so concurrent changes are kept)
+	if err := configuration.UpdatePluginsState(func(state map[string]configuration.InstalledPluginEntry) error {
+		delete(state, pluginID)
+		return nil
+	}); err != nil {
 		pm.logger.Logf("Warning: Plugin directory for '%s' (ID: %s) removed, but failed to save updated plugins state: %v", pluginName, pluginID, err)
 		// Log failure before returning error
 		pm.logger.Logf("Removal failed for plugin '%s'.", pluginName)
 		return fmt.Errorf("plugin directory removed, but failed to save state: %w", err)
 	}
 
+	// 7. Delete the plugin's kv data (kept outside the plugin directory so updates preserve it)
+	if pm.kvStore != nil {
+		if err := pm.kvStore.RemovePlugin(pluginID); err != nil {
+			pm.logger.Logf("Warning: Plugin '%s' removed, but failed to delete its kv data: %v", pluginName, err)
+		}
+	}
+
 	// Final success log
 	pm.logger.Logf("Plugin '%s' (v%s) removed.", pluginName, pluginVersion)
+	pm.bus.Load().Publish(events.TopicPluginRemoved, map[string]string{"plugin_id": pluginID, "name": pluginName})
 
-	// 7. Refresh internal cache (if discoverPlugins is implemented)
+	// 8. Refresh internal cache (if discoverPlugins is implemented)
 	// pm.discoverPlugins()
 
 	return nil
@@ -0,0 +1,399 @@
+package themes
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/OG-Open-Source/PanelBase/internal/downloader"
+)
+
+// A theme archive holds theme.yaml at its root and the theme files at their structure paths.
+// The embedded theme.yaml is the same manifest 'themes create' writes; its URLs are only used
+// for later updates, while the sums verify the packed files.
+const (
+	archiveFormatZip   = "zip"
+	archiveFormatTarGz = "tar.gz"
+
+	maxArchiveBytes  = 1 << 30 // Upper bound for the extracted size of an archive
+	maxManifestBytes = 1 << 20 // 1MB limit for the embedded theme.yaml
+)
+
+// archiveFormat returns the archive format implied by a file name or URL path, or "" when the
+// name does not look like a theme archive.
+func archiveFormat(name string) string {
+	lower := strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		return archiveFormatZip
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return archiveFormatTarGz
+	}
+	return ""
+}
+
+// isThemeArchive reports whether an install source (local path or http(s) URL) is a theme archive.
+func isThemeArchive(source string) bool {
+	if u, err := url.Parse(source); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+		return archiveFormat(u.Path) != ""
+	}
+	return archiveFormat(source) != ""
+}
+
+// Pack bundles the theme in directoryPath into a single archive at outputPath (.zip, .tar.gz or
+// .tgz). The directory must contain a theme.yaml, e.g. from Create, whose sums match the files;
+// files not listed in its structure are left out. It uses the provided ThemeManager instance.
+func Pack(tm *ThemeManager, directoryPath string, outputPath string) (*ThemeMetadata, error) {
+	format := archiveFormat(outputPath)
+	if format == "" {
+		return nil, fmt.Errorf("unsupported archive name '%s': use a .zip, .tar.gz or .tgz file name", outputPath)
+	}
+	absDirectoryPath, err := filepath.Abs(directoryPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get absolute path for theme directory '%s': %w", directoryPath, err)
+	}
+	tm.logger.Logf("Packing theme in '%s' into '%s'...", absDirectoryPath, outputPath)
+
+	meta, yamlData, err := readManifest(absDirectoryPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// The archive is only useful if its files match the sums it carries.
+	result, err := verifyThemeFiles(absDirectoryPath, meta.Structure)
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Modified) > 0 || len(result.Missing) > 0 {
+		return nil, fmt.Errorf("files in '%s' do not match %s (%d modified, %d missing); run 'themes create' again", absDirectoryPath, themeMetaFile, len(result.Modified), len(result.Missing))
+	}
+
+	sums := make(map[string]string)
+	structureSums(meta.Structure, "", sums)
+	files := make([]string, 0, len(sums))
+	for relPath := range sums {
+		files = append(files, relPath)
+	}
+	sort.Strings(files)
+
+	absOutputPath, err := filepath.Abs(outputPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get absolute path for archive '%s': %w", outputPath, err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(absOutputPath), filepath.Base(absOutputPath)+".tmp*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create archive '%s': %w", outputPath, err)
+	}
+	defer os.Remove(tmp.Name()) // No-op after the rename below
+	writeErr := writeThemeArchive(tmp, format, absDirectoryPath, yamlData, files)
+	if closeErr := tmp.Close(); writeErr == nil {
+		writeErr = closeErr
+	}
+	if writeErr != nil {
+		return nil, fmt.Errorf("failed to write archive '%s': %w", outputPath, writeErr)
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return nil, fmt.Errorf("failed to set permissions on archive '%s': %w", outputPath, err)
+	}
+	if err := os.Rename(tmp.Name(), absOutputPath); err != nil {
+		return nil, fmt.Errorf("failed to move archive into '%s': %w", outputPath, err)
+	}
+
+	tm.logger.Logf("Theme '%s' (v%s) packed into '%s' (%d files).", meta.Name, meta.Version, absOutputPath, len(files))
+	return meta, nil
+}
+
+// ReadManifest reads and validates the theme.yaml in a theme source directory.
+func ReadManifest(directoryPath string) (*ThemeMetadata, error) {
+	meta, _, err := readManifest(directoryPath)
+	return meta, err
+}
+
+// readManifest is ReadManifest that also returns the raw file content.
+func readManifest(directoryPath string) (*ThemeMetadata, []byte, error) {
+	manifestPath := filepath.Join(directoryPath, themeMetaFile)
+	yamlData, err := os.ReadFile(manifestPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read theme manifest '%s' (create it with 'themes create'): %w", manifestPath, err)
+	}
+	var meta ThemeMetadata
+	if err := yaml.Unmarshal(yamlData, &meta); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse theme manifest '%s': %w", manifestPath, err)
+	}
+	if err := meta.Validate(); err != nil {
+		return nil, nil, fmt.Errorf("invalid theme manifest '%s': %w", manifestPath, err)
+	}
+	return &meta, yamlData, nil
+}
+
+// ArchiveName returns the default archive file name for a theme, e.g. "my-theme-1.0.0.tar.gz".
+func ArchiveName(meta *ThemeMetadata) string {
+	slug := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '.', r == '_', r == '-':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		}
+		return '-'
+	}, strings.TrimSpace(meta.Name)+"-"+strings.TrimSpace(meta.Version))
+	return slug + "." + archiveFormatTarGz
+}
+
+// writeThemeArchive writes theme.yaml followed by the listed files (relative to dir, with forward
+// slashes) to out in the given format.
+func writeThemeArchive(out io.Writer, format string, dir string, manifest []byte, files []string) error {
+	addFile := func(add func(name string, info fs.FileInfo, r io.Reader) error, relPath string) error {
+		f, err := os.Open(filepath.Join(dir, filepath.FromSlash(relPath)))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		return add(relPath, info, f)
+	}
+
+	switch format {
+	case archiveFormatZip:
+		zw := zip.NewWriter(out)
+		if w, err := zw.Create(themeMetaFile); err != nil {
+			return err
+		} else if _, err := w.Write(manifest); err != nil {
+			return err
+		}
+		add := func(name string, info fs.FileInfo, r io.Reader) error {
+			header, err := zip.FileInfoHeader(info)
+			if err != nil {
+				return err
+			}
+			header.Name, header.Method = name, zip.Deflate
+			w, err := zw.CreateHeader(header)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(w, r)
+			return err
+		}
+		for _, relPath := range files {
+			if err := addFile(add, relPath); err != nil {
+				return err
+			}
+		}
+		return zw.Close()
+
+	case archiveFormatTarGz:
+		gz := gzip.NewWriter(out)
+		tw := tar.NewWriter(gz)
+		if err := tw.WriteHeader(&tar.Header{Name: themeMetaFile, Mode: 0644, Size: int64(len(manifest)), Typeflag: tar.TypeReg}); err != nil {
+			return err
+		}
+		if _, err := tw.Write(manifest); err != nil {
+			return err
+		}
+		add := func(name string, info fs.FileInfo, r io.Reader) error {
+			header, err := tar.FileInfoHeader(info, "")
+			if err != nil {
+				return err
+			}
+			header.Name = name
+			header.Uname, header.Gname = "", "" // Keep archives independent of the packing machine
+			if err := tw.WriteHeader(header); err != nil {
+				return err
+			}
+			_, err = io.Copy(tw, r)
+			return err
+		}
+		for _, relPath := range files {
+			if err := addFile(add, relPath); err != nil {
+				return err
+			}
+		}
+		if err := tw.Close(); err != nil {
+			return err
+		}
+		return gz.Close()
+	}
+	return fmt.Errorf("unsupported archive format '%s'", format)
+}
+
+// errStopArchiveWalk ends walkThemeArchive early without an error.
+var errStopArchiveWalk = errors.New("stop")
+
+// walkThemeArchive calls fn for every entry of the archive at archivePath. name is the cleaned
+// entry path with forward slashes; entries that would land outside the extraction directory
+// are rejected.
+func walkThemeArchive(archivePath string, fn func(name string, mode fs.FileMode, r io.Reader) error) error {
+	visit := func(rawName string, mode fs.FileMode, r io.Reader) error {
+		name := path.Clean(strings.TrimPrefix(rawName, "./"))
+		if strings.Contains(rawName, "\\") || path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return fmt.Errorf("archive entry '%s' has an unsafe path", rawName)
+		}
+		return fn(name, mode, r)
+	}
+
+	var err error
+	switch archiveFormat(archivePath) {
+	case archiveFormatZip:
+		zr, openErr := zip.OpenReader(archivePath)
+		if openErr != nil {
+			return fmt.Errorf("failed to open zip archive '%s': %w", archivePath, openErr)
+		}
+		defer zr.Close()
+		for _, f := range zr.File {
+			rc, openErr := f.Open()
+			if openErr != nil {
+				return fmt.Errorf("failed to read '%s' from archive '%s': %w", f.Name, archivePath, openErr)
+			}
+			err = visit(f.Name, f.Mode(), rc)
+			rc.Close()
+			if err != nil {
+				break
+			}
+		}
+	case archiveFormatTarGz:
+		file, openErr := os.Open(archivePath)
+		if openErr != nil {
+			return fmt.Errorf("failed to open archive '%s': %w", archivePath, openErr)
+		}
+		defer file.Close()
+		gz, gzErr := gzip.NewReader(file)
+		if gzErr != nil {
+			return fmt.Errorf("failed to open gzip archive '%s': %w", archivePath, gzErr)
+		}
+		tr := tar.NewReader(gz)
+		for {
+			header, nextErr := tr.Next()
+			if nextErr == io.EOF {
+				break
+			}
+			if nextErr != nil {
+				return fmt.Errorf("failed to read archive '%s': %w", archivePath, nextErr)
+			}
+			if err = visit(header.Name, header.FileInfo().Mode(), tr); err != nil {
+				break
+			}
+		}
+	default:
+		return fmt.Errorf("'%s' is not a .zip, .tar.gz or .tgz archive", archivePath)
+	}
+	if err == errStopArchiveWalk {
+		return nil
+	}
+	return err
+}
+
+// readArchiveManifest returns the theme.yaml at the root of a theme archive.
+func readArchiveManifest(archivePath string) ([]byte, error) {
+	var manifest []byte
+	err := walkThemeArchive(archivePath, func(name string, mode fs.FileMode, r io.Reader) error {
+		if name != themeMetaFile || !mode.IsRegular() {
+			return nil
+		}
+		data, err := io.ReadAll(io.LimitReader(r, maxManifestBytes+1))
+		if err != nil {
+			return fmt.Errorf("failed to read %s from archive: %w", themeMetaFile, err)
+		}
+		if len(data) > maxManifestBytes {
+			return fmt.Errorf("%s in archive is larger than %d bytes", themeMetaFile, maxManifestBytes)
+		}
+		manifest = data
+		return errStopArchiveWalk
+	})
+	if err != nil {
+		return nil, err
+	}
+	if manifest == nil {
+		return nil, fmt.Errorf("archive '%s' has no %s at its root", archivePath, themeMetaFile)
+	}
+	return manifest, nil
+}
+
+// _extractThemeArchive extracts the files of a theme archive into the staging directory. The
+// embedded theme.yaml is not extracted; _verifyDownloadedAssets afterwards checks the files
+// against its sums and rejects undeclared ones.
+func (tm *ThemeManager) _extractThemeArchive(archivePath string, stagingPath string, meta *ThemeMetadata) error {
+	indent1 := "  "
+	indent2 := "    "
+	tm.logger.Logf(indent1+"Extracting %d assets for theme '%s' (v%s) from archive:", countFilesInStructure(meta.Structure), meta.Name, meta.Version)
+
+	var files int
+	var total int64
+	err := walkThemeArchive(archivePath, func(name string, mode fs.FileMode, r io.Reader) error {
+		switch {
+		case mode.IsDir() || name == ".":
+			return nil
+		case !mode.IsRegular():
+			return fmt.Errorf("archive entry '%s' is not a regular file", name)
+		case name == themeMetaFile:
+			return nil
+		}
+		dest := filepath.Join(stagingPath, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return fmt.Errorf("failed to create directory for '%s': %w", name, err)
+		}
+		out, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to create '%s': %w", dest, err)
+		}
+		n, copyErr := io.Copy(out, io.LimitReader(r, maxArchiveBytes-total+1))
+		closeErr := out.Close()
+		total += n
+		if copyErr != nil {
+			return fmt.Errorf("failed to extract '%s': %w", name, copyErr)
+		}
+		if closeErr != nil {
+			return fmt.Errorf("failed to extract '%s': %w", name, closeErr)
+		}
+		if total > maxArchiveBytes {
+			return fmt.Errorf("archive extracts to more than %d bytes", int64(maxArchiveBytes))
+		}
+		files++
+		return nil
+	})
+	if err != nil {
+		tm.logger.Logf(indent2+"Extraction failed for theme '%s' (v%s): %v", meta.Name, meta.Version, err)
+		return fmt.Errorf("failed to extract theme archive for '%s': %w", meta.Name, err)
+	}
+	tm.logger.Logf(indent2+"Extracted %d files (%s).", files, downloader.FormatBytes(total))
+	return nil
+}
+
+// _resolveThemeArchive returns a local path for an install source. Archive URLs are downloaded
+// into the staging area first; cleanup removes that copy. Other sources are returned unchanged.
+func (tm *ThemeManager) _resolveThemeArchive(source string) (localSource string, cleanup func(), err error) {
+	cleanup = func() {}
+	u, parseErr := url.Parse(source)
+	if parseErr != nil || (u.Scheme != "http" && u.Scheme != "https") || archiveFormat(u.Path) == "" {
+		return source, cleanup, nil
+	}
+
+	indentPrefix := "    " // This is indent2
+	tm.logger.Logf(indentPrefix+"Downloading theme archive '%s'...", source)
+	tmpDir, err := tm._newStagingDir("archive")
+	if err != nil {
+		return "", cleanup, err
+	}
+	cleanup = func() { os.RemoveAll(tmpDir) }
+	archivePath := filepath.Join(tmpDir, "theme."+archiveFormat(u.Path)) // Keeps the suffix for archiveFormat
+	job := downloader.Job{Name: path.Base(u.Path), URL: source, Dest: archivePath}
+	if _, err := tm.downloader.Download(indentPrefix, []downloader.Job{job}); err != nil {
+		cleanup()
+		return "", func() {}, fmt.Errorf("failed to download theme archive '%s': %w", source, err)
+	}
+	return archivePath, cleanup, nil
+}
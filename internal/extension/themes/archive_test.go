@@ -0,0 +1,85 @@
+package themes
+
+import (
+	"archive/zip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/OG-Open-Source/PanelBase/internal/logger"
+)
+
+func TestPackAndExtractThemeArchive(t *testing.T) {
+	log, err := logger.NewLogger(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+	tm := &ThemeManager{logger: log}
+
+	src := t.TempDir()
+	files := map[string]string{"index.html": "<html></html>", "css/style.css": "body {}"}
+	for rel, content := range files {
+		p := filepath.Join(src, filepath.FromSlash(rel))
+		os.MkdirAll(filepath.Dir(p), 0755)
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	os.WriteFile(filepath.Join(src, "notes.txt"), []byte("not part of the theme"), 0644)
+	manifest := fmt.Sprintf(`name: Demo Theme
+authors: [{name: t}]
+version: 1.0.0
+description: d
+source_link: https://example.com/demo/theme.yaml
+structure:
+  index.html: {url: https://example.com/demo/index.html, sum: %s}
+  css:
+    style.css: {url: https://example.com/demo/css/style.css, sum: %s}
+`, sha256Hex(files["index.html"]), sha256Hex(files["css/style.css"]))
+	if err := os.WriteFile(filepath.Join(src, themeMetaFile), []byte(manifest), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"demo.zip", "demo.tar.gz"} {
+		archivePath := filepath.Join(t.TempDir(), name)
+		meta, err := Pack(tm, src, archivePath)
+		if err != nil {
+			t.Fatalf("Pack(%s): %v", name, err)
+		}
+		if got, err := readArchiveManifest(archivePath); err != nil || string(got) != manifest {
+			t.Fatalf("%s: embedded manifest = %q, %v", name, got, err)
+		}
+
+		staging := t.TempDir()
+		if err := tm._extractThemeArchive(archivePath, staging, meta); err != nil {
+			t.Fatalf("%s: extract: %v", name, err)
+		}
+		result, err := verifyThemeFiles(staging, meta.Structure)
+		if err != nil || !result.OK() {
+			t.Errorf("%s: extracted files do not verify: %+v, %v", name, result, err)
+		}
+	}
+	if got := ArchiveName(&ThemeMetadata{Name: "Demo Theme", Version: "1.0.0"}); got != "demo-theme-1.0.0.tar.gz" {
+		t.Errorf("ArchiveName = %q", got)
+	}
+
+	// Entries must not escape the staging directory.
+	evil := filepath.Join(t.TempDir(), "evil.zip")
+	f, _ := os.Create(evil)
+	zw := zip.NewWriter(f)
+	w, _ := zw.Create("../escaped.txt")
+	w.Write([]byte("x"))
+	zw.Close()
+	f.Close()
+	staging := t.TempDir()
+	err = tm._extractThemeArchive(evil, staging, &ThemeMetadata{Name: "evil"})
+	if err == nil || !strings.Contains(err.Error(), "unsafe path") {
+		t.Errorf("extracting ../escaped.txt: err = %v; want an unsafe path error", err)
+	}
+	if _, statErr := os.Stat(filepath.Join(filepath.Dir(staging), "escaped.txt")); statErr == nil {
+		t.Errorf("archive entry escaped the staging directory")
+	}
+}
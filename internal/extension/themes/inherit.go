@@ -0,0 +1,192 @@
+package themes
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/OG-Open-Source/PanelBase/internal/configuration"
+)
+
+// maxInheritanceDepth limits how many parents a theme may have, so a misconfigured chain fails
+// quickly instead of being walked on every request.
+const maxInheritanceDepth = 8
+
+// resolveParentID returns the ID of the installed theme a parent reference points to. The
+// reference is either a thm_id or a source link; when several installed versions share the
+// source link, the most recently updated one is used.
+func resolveParentID(parent string, themesState map[string]configuration.InstalledThemeEntry) (string, bool) {
+	parent = strings.TrimSpace(parent)
+	if _, ok := themesState[parent]; ok {
+		return parent, true
+	}
+	var candidates []configuration.InstalledThemeEntry
+	for id, entry := range themesState {
+		if entry.SourceLink == parent {
+			entry.ThmID = id
+			candidates = append(candidates, entry)
+		}
+	}
+	if len(candidates) == 0 {
+		return "", false
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].LastUpdatedAt != candidates[j].LastUpdatedAt {
+			return candidates[i].LastUpdatedAt > candidates[j].LastUpdatedAt // RFC 3339 sorts chronologically
+		}
+		return candidates[i].ThmID < candidates[j].ThmID
+	})
+	return candidates[0].ThmID, true
+}
+
+// Layers returns the directories that make up the merged view of an installed theme, child
+// first: a file in an earlier directory overrides the file with the same relative path in a
+// later one. Themes without a parent have a single layer.
+// Each theme keeps its own directory, so updating a parent never touches a child's overrides.
+// It uses the provided ThemeManager instance.
+func Layers(tm *ThemeManager, themeID string) ([]string, error) {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+
+	layers, _, err := tm._themeChain(themeID)
+	return layers, err
+}
+
+// Settings returns the settings schema of an installed theme merged with those of its parents;
+// a child's declaration of a key replaces its parent's.
+// It uses the provided ThemeManager instance.
+func Settings(tm *ThemeManager, themeID string) (SettingsSchema, error) {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+
+	_, metas, err := tm._themeChain(themeID)
+	if err != nil {
+		return nil, err
+	}
+	schema := make(SettingsSchema)
+	for i := len(metas) - 1; i >= 0; i-- { // Parents first, so children override
+		for key, spec := range metas[i].Settings {
+			schema[key] = spec
+		}
+	}
+	return schema, nil
+}
+
+// SourceLayers returns the directories and merged settings schema of a theme source directory
+// (see 'themes create') as they would be once the theme is installed: the directory itself, then
+// the layers of its installed parent. A directory without a theme.yaml is a single layer without
+// settings; on error the directory alone is returned as well, so it can still be previewed.
+// It uses the provided ThemeManager instance.
+func SourceLayers(tm *ThemeManager, directoryPath string) ([]string, SettingsSchema, error) {
+	layers := []string{directoryPath}
+	schema := make(SettingsSchema)
+	if _, err := os.Stat(filepath.Join(directoryPath, themeMetaFile)); os.IsNotExist(err) {
+		return layers, schema, nil
+	}
+	meta, err := ReadManifest(directoryPath)
+	if err != nil {
+		return layers, schema, err
+	}
+	if meta.Parent != "" {
+		tm.mu.RLock()
+		defer tm.mu.RUnlock()
+
+		themesState, err := configuration.LoadThemesState()
+		if err != nil {
+			return layers, schema, fmt.Errorf("failed to load themes state: %w", err)
+		}
+		parentID, ok := resolveParentID(meta.Parent, themesState)
+		if !ok {
+			return layers, schema, fmt.Errorf("parent theme '%s' of theme '%s' is not installed", meta.Parent, meta.Name)
+		}
+		parentLayers, parentMetas, err := tm._themeChain(parentID)
+		if err != nil {
+			return layers, schema, err
+		}
+		layers = append(layers, parentLayers...)
+		for i := len(parentMetas) - 1; i >= 0; i-- { // Parents first, so children override
+			for key, spec := range parentMetas[i].Settings {
+				schema[key] = spec
+			}
+		}
+	}
+	for key, spec := range meta.Settings {
+		schema[key] = spec
+	}
+	return layers, schema, nil
+}
+
+// _themeChain returns the directories and local metadata of a theme and its parents, child
+// first. The caller must hold tm.mu.
+func (tm *ThemeManager) _themeChain(themeID string) ([]string, []*ThemeMetadata, error) {
+	themesState, err := configuration.LoadThemesState()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load themes state: %w", err)
+	}
+	if _, ok := themesState[themeID]; !ok {
+		return nil, nil, fmt.Errorf("theme with ID '%s' not found in state", themeID)
+	}
+
+	var layers []string
+	var metas []*ThemeMetadata
+	seen := make(map[string]bool)
+	for currentID := themeID; ; {
+		if seen[currentID] {
+			return nil, nil, fmt.Errorf("theme '%s' has a parent cycle at '%s'", themeID, currentID)
+		}
+		seen[currentID] = true
+
+		themePath := filepath.Join(tm.themeDir, currentID)
+		meta, err := tm._loadLocalThemeJSON(themePath)
+		if err != nil {
+			return nil, nil, err
+		}
+		layers = append(layers, themePath)
+		metas = append(metas, meta)
+		if len(layers)-1 > maxInheritanceDepth { // Every layer but the first is a parent
+			return nil, nil, fmt.Errorf("theme '%s' has more than %d parent themes", themeID, maxInheritanceDepth)
+		}
+		if meta.Parent == "" {
+			return layers, metas, nil
+		}
+		parentID, ok := resolveParentID(meta.Parent, themesState)
+		if !ok {
+			return nil, nil, fmt.Errorf("parent theme '%s' of theme '%s' (ID: %s) is not installed", meta.Parent, meta.Name, currentID)
+		}
+		currentID = parentID
+	}
+}
+
+// _logMissingParent warns when a theme being installed declares a parent that is not installed
+// yet. Installation still succeeds; the theme can be applied once its parent is installed.
+func (tm *ThemeManager) _logMissingParent(meta *ThemeMetadata, themesState map[string]configuration.InstalledThemeEntry, indentPrefix string) {
+	if meta.Parent == "" {
+		return
+	}
+	if parentID, ok := resolveParentID(meta.Parent, themesState); ok {
+		tm.logger.Logf(indentPrefix+"Parent theme: '%s' (ID: %s).", meta.Parent, parentID)
+		return
+	}
+	tm.logger.Logf(indentPrefix+"Warning: Parent theme '%s' is not installed. Install it before applying '%s'.", meta.Parent, meta.Name)
+}
+
+// _dependentThemes returns the IDs of installed themes whose parent resolves to themeID, sorted.
+func (tm *ThemeManager) _dependentThemes(themeID string, themesState map[string]configuration.InstalledThemeEntry) []string {
+	var children []string
+	for id := range themesState {
+		if id == themeID {
+			continue
+		}
+		meta, err := tm._loadLocalThemeJSON(filepath.Join(tm.themeDir, id))
+		if err != nil || meta.Parent == "" {
+			continue
+		}
+		if parentID, ok := resolveParentID(meta.Parent, themesState); ok && parentID == themeID {
+			children = append(children, id)
+		}
+	}
+	sort.Strings(children)
+	return children
+}
@@ -0,0 +1,105 @@
+package themes
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/OG-Open-Source/PanelBase/internal/configuration"
+	"github.com/OG-Open-Source/PanelBase/internal/logger"
+	"github.com/OG-Open-Source/PanelBase/internal/utils"
+)
+
+// writeTestManifest writes a one-file theme with the given extra theme.yaml lines into dir.
+func writeTestManifest(t *testing.T, dir, name, extra string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, "index.html"), []byte(name), 0644); err != nil {
+		t.Fatal(err)
+	}
+	manifest := fmt.Sprintf(`name: %s
+authors: [{name: t}]
+version: 1.0.0
+description: d
+source_link: https://example.com/%s/theme.yaml
+structure:
+  index.html: {url: https://example.com/%s/index.html, sum: %s}
+%s`, name, name, name, sha256Hex(name), extra)
+	if err := os.WriteFile(filepath.Join(dir, themeMetaFile), []byte(manifest), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSourceLayersMergesInstalledParent(t *testing.T) {
+	log, err := logger.NewLogger(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+	idGen, err := utils.NewIDGenerator(&configuration.SecurityConfig{Secrets: configuration.SecretsConfig{Alphabet: "abcdef0123456789", Length: 12}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	configuration.SetStateDir(t.TempDir())
+	themeDir := t.TempDir()
+	tm, err := NewThemeManager(log, idGen, themeDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A directory without theme.yaml is previewed on its own.
+	plain := t.TempDir()
+	if layers, schema, err := SourceLayers(tm, plain); err != nil || len(layers) != 1 || layers[0] != plain || len(schema) != 0 {
+		t.Errorf("SourceLayers(no manifest) = %q, %v, %v; want the directory alone", layers, schema, err)
+	}
+
+	child := t.TempDir()
+	writeTestManifest(t, child, "child", `parent: https://example.com/base/theme.yaml
+settings:
+  accent_color: {type: string, default: "#000000"}
+  columns: {type: integer, default: 2}
+`)
+	// Until the parent is installed, the directory is still returned along with the error.
+	if layers, _, err := SourceLayers(tm, child); err == nil || len(layers) != 1 || layers[0] != child {
+		t.Errorf("SourceLayers(missing parent) = %q, %v; want the directory and an error", layers, err)
+	}
+
+	parentSrc := t.TempDir()
+	writeTestManifest(t, parentSrc, "base", `settings:
+  accent_color: {type: string, default: "#336699"}
+  show_footer: {type: boolean, default: true}
+`)
+	archivePath := filepath.Join(t.TempDir(), "base.zip")
+	if _, err := Pack(tm, parentSrc, archivePath); err != nil {
+		t.Fatalf("Pack: %v", err)
+	}
+	if _, err := Install(tm, archivePath, false); err != nil {
+		t.Fatalf("Install: %v", err)
+	}
+	state, err := configuration.LoadThemesState()
+	if err != nil || len(state) != 1 {
+		t.Fatalf("themes state = %v, %v; want the parent", state, err)
+	}
+	var parentID string
+	for id := range state {
+		parentID = id
+	}
+
+	layers, schema, err := SourceLayers(tm, child)
+	if err != nil {
+		t.Fatalf("SourceLayers: %v", err)
+	}
+	if len(layers) != 2 || layers[0] != child || layers[1] != filepath.Join(themeDir, parentID) {
+		t.Errorf("layers = %q; want the directory, then the installed parent", layers)
+	}
+	defaults := schema.Defaults()
+	want := map[string]interface{}{"accent_color": "#000000", "columns": int64(2), "show_footer": true}
+	if len(defaults) != len(want) {
+		t.Errorf("defaults = %v; want %v", defaults, want)
+	}
+	for key, value := range want {
+		if defaults[key] != value {
+			t.Errorf("default of %s = %#v; want %#v (the child overrides its parent)", key, defaults[key], value)
+		}
+	}
+}
@@ -13,11 +13,15 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"gopkg.in/yaml.v3"
 
+	"github.com/OG-Open-Source/PanelBase/internal/atomicfile"
 	"github.com/OG-Open-Source/PanelBase/internal/configuration"
+	"github.com/OG-Open-Source/PanelBase/internal/downloader"
+	"github.com/OG-Open-Source/PanelBase/internal/events"
 	"github.com/OG-Open-Source/PanelBase/internal/logger"
 	"github.com/OG-Open-Source/PanelBase/internal/utils"
 )
@@ -45,28 +49,55 @@ type ThemeManager struct {
 	mu       sync.RWMutex
 	logger   *logger.Logger
 	idGen    *utils.IDGenerator
+	bus      atomic.Pointer[events.Bus] // Optional; nil discards lifecycle events
+	// downloader fetches theme assets; NewThemeManager sets one without a cache
+	downloader *downloader.Downloader
 }
 
-func NewThemeManager(log *logger.Logger, idGen *utils.IDGenerator) (*ThemeManager, error) { // Removed themeDir ...string
+// SetEventBus attaches the bus that theme lifecycle events are published to.
+func (tm *ThemeManager) SetEventBus(bus *events.Bus) {
+	tm.bus.Store(bus)
+}
+
+// SetDownloader replaces the downloader used for theme assets, e.g. with one that caches.
+func (tm *ThemeManager) SetDownloader(d *downloader.Downloader) {
+	if d == nil {
+		return
+	}
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	tm.downloader = d
+}
+
+// NewThemeManager creates a ThemeManager for the given themes directory (default ext/themes).
+func NewThemeManager(log *logger.Logger, idGen *utils.IDGenerator, themeDir ...string) (*ThemeManager, error) {
 	if log == nil {
 		return nil, fmt.Errorf("logger cannot be nil")
 	}
 	if idGen == nil {
 		return nil, fmt.Errorf("IDGenerator cannot be nil")
 	}
-	dir := defaultThemeDir // Hardcoded path
-	// log.Logf("ThemeManager using hardcoded themes directory: %s", dir) // Removed log
+	dir := defaultThemeDir
+	if len(themeDir) > 0 && themeDir[0] != "" {
+		dir = themeDir[0]
+	}
 
 	if err := os.MkdirAll(dir, 0755); err != nil {
 		return nil, fmt.Errorf("failed to create theme source directory '%s': %w", dir, err)
 	}
-	tm := &ThemeManager{
-		themeDir: dir,
-		themes:   make(map[string]*ThemeMetadata),
-		logger:   log,
-		idGen:    idGen,
+	dl, err := downloader.New(log)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create theme downloader: %w", err)
 	}
-	tm.discoverThemes() // Discover themes on initialization
+	tm := &ThemeManager{
+		themeDir:   dir,
+		themes:     make(map[string]*ThemeMetadata),
+		logger:     log,
+		idGen:      idGen,
+		downloader: dl,
+	}
+	tm._cleanStaleStaging() // Leftovers of installs interrupted by a crash
+	tm.discoverThemes()     // Discover themes on initialization
 	return tm, nil
 }
 
@@ -87,8 +118,8 @@ func (tm *ThemeManager) discoverThemesLocked() {
 	validCount := 0
 	for _, dirEntry := range dirs {
 		processedCount++
-		if !dirEntry.IsDir() {
-			continue
+		if !dirEntry.IsDir() || isInternalThemeDir(dirEntry.Name()) {
+			continue // Staging and backup areas are not themes
 		}
 		themeDirPath := filepath.Join(tm.themeDir, dirEntry.Name())
 		metaFilePath := filepath.Join(themeDirPath, themeMetaFile)
@@ -142,7 +173,8 @@ func (tm *ThemeManager) GetThemeCount() int {
 }
 
 // fetchThemeYAML gets the theme definition content from either a URL or a local path.
-// It expects the source to point to a YAML file for theme definition.
+// It expects the source to point to a YAML file for theme definition, or to a local theme
+// archive (see _resolveThemeArchive for archive URLs).
 func (tm *ThemeManager) fetchThemeYAML(source string) (yamlData []byte, parsedSourceURL *url.URL, isLocalSource bool, sourceNameForLog string, err error) {
 	if u, parseErr := url.Parse(source); parseErr == nil && (u.Scheme == "http" || u.Scheme == "https") {
 		isLocalSource = false
@@ -176,6 +208,10 @@ func (tm *ThemeManager) fetchThemeYAML(source string) (yamlData []byte, parsedSo
 			err = fmt.Errorf("local theme YAML file not found at '%s'", sourceNameForLog)
 			return
 		}
+		if archiveFormat(sourceNameForLog) != "" { // The definition is the theme.yaml inside the archive
+			yamlData, err = readArchiveManifest(sourceNameForLog)
+			return
+		}
 		yamlData, err = os.ReadFile(sourceNameForLog)
 		if err != nil {
 			err = fmt.Errorf("failed to read local theme YAML file '%s': %w", sourceNameForLog, err)
@@ -216,178 +252,49 @@ func countFilesInStructure(structure map[string]interface{}) int {
 }
 
 // Old ThemeManager.InstallTheme method removed as its functionality is now covered by the package-level themes.Install function.
-// downloadAndSaveStructure now takes totalFiles instead of totalSteps for clarity
-func (tm *ThemeManager) downloadAndSaveStructure(baseSavePath string, currentRelativePath string, structure map[string]interface{}, baseURL *url.URL, totalFiles int, downloadedFiles *int, indentPrefix string) error {
-	httpClient := http.Client{Timeout: 60 * time.Second}
+// collectDownloadJobs turns a theme structure into download jobs below baseSavePath, resolving
+// relative asset URLs against baseURL and creating the sub-directories. Assets may be AssetDetail
+// values or maps with string 'url' and 'sum' keys; other maps are sub-directories.
+func (tm *ThemeManager) collectDownloadJobs(baseSavePath string, currentRelativePath string, structure map[string]interface{}, baseURL *url.URL, jobs *[]downloader.Job, indentPrefix string) error {
+	addJob := func(name, itemRelativePath, savePath, assetURL, sum string) error {
+		resolvedFileURLString := assetURL
+		if baseURL != nil {
+			parsedItemURL, err := url.Parse(assetURL)
+			if err != nil {
+				return fmt.Errorf("error parsing asset URL '%s' for '%s': %w", assetURL, name, err)
+			}
+			if !parsedItemURL.IsAbs() {
+				resolvedFileURLString = baseURL.ResolveReference(parsedItemURL).String()
+			}
+		}
+		*jobs = append(*jobs, downloader.Job{Name: itemRelativePath, URL: resolvedFileURLString, Dest: savePath, Sum: sum})
+		return nil
+	}
+
 	for name, item := range structure {
 		currentSavePath := filepath.Join(baseSavePath, name)
 		itemRelativePath := path.Join(currentRelativePath, name)
 		switch v := item.(type) {
 		case AssetDetail: // It's an AssetDetail struct
-			asset := v
-			resolvedFileURLString := asset.URL
-			if baseURL != nil {
-				parsedItemURL, err := url.Parse(asset.URL)
-				if err != nil {
-					return fmt.Errorf("error parsing asset URL '%s' for '%s': %w", asset.URL, name, err)
-				}
-				if !parsedItemURL.IsAbs() {
-					resolvedFileURLString = baseURL.ResolveReference(parsedItemURL).String()
-				}
-			}
-
-			*downloadedFiles++
-			// tm.logger.Logf(indentPrefix+"[%d/%d] Downloading '%s'...", *downloadedFiles, totalFiles, itemRelativePath) // Removed this log
-			resp, err := httpClient.Get(resolvedFileURLString)
-			if err != nil {
-				tm.logger.Logf(indentPrefix+"[%d/%d] Downloading '%s'... Error: %v", *downloadedFiles, totalFiles, itemRelativePath, err)
-				return fmt.Errorf("failed to download file '%s' from '%s': %w", name, resolvedFileURLString, err)
-			}
-			defer resp.Body.Close()
-
-			if resp.StatusCode != http.StatusOK {
-				tm.logger.Logf(indentPrefix+"[%d/%d] Downloading '%s'... Error: status %s", *downloadedFiles, totalFiles, itemRelativePath, resp.Status)
-				return fmt.Errorf("failed to download file '%s' from '%s': status %s", name, resolvedFileURLString, resp.Status)
+			if err := addJob(name, itemRelativePath, currentSavePath, v.URL, v.Sum); err != nil {
+				return err
 			}
 
-			if err := os.MkdirAll(filepath.Dir(currentSavePath), 0755); err != nil {
-				tm.logger.Logf(indentPrefix+"[%d/%d] Downloading '%s'... Error creating directory: %v", *downloadedFiles, totalFiles, itemRelativePath, err)
-				return fmt.Errorf("failed to create parent directory for '%s': %w", currentSavePath, err)
-			}
-
-			out, err := os.Create(currentSavePath)
-			if err != nil {
-				tm.logger.Logf(indentPrefix+"[%d/%d] Downloading '%s'... Error creating file: %v", *downloadedFiles, totalFiles, itemRelativePath, err)
-				return fmt.Errorf("failed to create local file '%s': %w", currentSavePath, err)
-			}
-
-			// Write to file first
-			_, copyErr := io.Copy(out, resp.Body)
-			closeErr := out.Close() // Close the file before hashing
-
-			if copyErr != nil {
-				tm.logger.Logf(indentPrefix+"[%d/%d] Downloading '%s'... Error saving file: %v", *downloadedFiles, totalFiles, itemRelativePath, copyErr)
-				os.Remove(currentSavePath) // Attempt to clean up partially written file
-				return fmt.Errorf("failed to save downloaded file '%s' to '%s': %w", name, currentSavePath, copyErr)
-			}
-			if closeErr != nil {
-				tm.logger.Logf(indentPrefix+"[%d/%d] Downloading '%s'... Error closing file: %v", *downloadedFiles, totalFiles, itemRelativePath, closeErr)
-				// Only return closeErr if copyErr was nil, to preserve original copy error if it occurred.
-				// No need to remove file here as copy was successful.
-				return fmt.Errorf("failed to close local file '%s' after writing: %w", currentSavePath, closeErr)
-			}
-
-			// Now, verify checksum
-			// Read the entire file into a byte slice first
-			calculatedSum, checksumErr := utils.CalculateFileSHA256(currentSavePath)
-			if checksumErr != nil {
-				tm.logger.Logf(indentPrefix+"[%d/%d] Error calculating checksum for downloaded file '%s': %v", *downloadedFiles, totalFiles, itemRelativePath, checksumErr)
-				os.Remove(currentSavePath) // Clean up
-				return fmt.Errorf("failed to calculate checksum for downloaded file '%s': %w", currentSavePath, checksumErr)
-			}
-
-// ADDED LOGGING:
-			tm.logger.Logf(indentPrefix+"[%d/%d] Checksum verification for '%s': Expected (from theme.yaml): '%s', Calculated (from downloaded file): '%s', Source URL: '%s'", *downloadedFiles, totalFiles, itemRelativePath, asset.Sum, calculatedSum, resolvedFileURLString)
-			if !strings.EqualFold(calculatedSum, asset.Sum) {
-				tm.logger.Logf(indentPrefix+"[%d/%d] Checksum mismatch for '%s'. Expected: %s, Got: %s", *downloadedFiles, totalFiles, itemRelativePath, asset.Sum, calculatedSum)
-				os.Remove(currentSavePath) // Clean up
-				return fmt.Errorf("checksum mismatch for file '%s': expected %s, got %s", name, asset.Sum, calculatedSum)
-			}
-			tm.logger.Logf(indentPrefix+"[%d/%d] Downloaded and verified '%s'.", *downloadedFiles, totalFiles, itemRelativePath)
-
 		case map[string]interface{}: // Value is a map, could be an AssetDetail-like map or a sub-directory
-			mapItem := v
-			// Try to interpret as an AssetDetail-like map first
-			urlVal, urlOk := mapItem["url"]
-			sumVal, sumOk := mapItem["sum"]
-
-			if urlOk && sumOk { // It has 'url' and 'sum' keys, potentially an AssetDetail
-				urlString, urlIsString := urlVal.(string)
-				sumString, sumIsString := sumVal.(string)
-
-				if urlIsString && sumIsString { // Both 'url' and 'sum' are strings, treat as AssetDetail
-					// This logic is duplicated from the 'case AssetDetail:' block.
-					// Consider refactoring into a helper if this becomes too unwieldy.
-					resolvedFileURLString := urlString
-					if baseURL != nil {
-						parsedItemURL, err := url.Parse(urlString)
-						if err != nil {
-							return fmt.Errorf("error parsing asset URL '%s' for '%s': %w", urlString, name, err)
-						}
-						if !parsedItemURL.IsAbs() {
-							resolvedFileURLString = baseURL.ResolveReference(parsedItemURL).String()
-						}
-					}
-
-					*downloadedFiles++
-					// tm.logger.Logf(indentPrefix+"[%d/%d] Downloading '%s' (from map)...", *downloadedFiles, totalFiles, itemRelativePath) // Removed this log
-					resp, err := httpClient.Get(resolvedFileURLString)
-					if err != nil {
-						tm.logger.Logf(indentPrefix+"[%d/%d] Downloading '%s'... Error: %v", *downloadedFiles, totalFiles, itemRelativePath, err)
-						return fmt.Errorf("failed to download file '%s' from '%s': %w", name, resolvedFileURLString, err)
-					}
-					defer resp.Body.Close()
-
-					if resp.StatusCode != http.StatusOK {
-						tm.logger.Logf(indentPrefix+"[%d/%d] Downloading '%s'... Error: status %s", *downloadedFiles, totalFiles, itemRelativePath, resp.Status)
-						return fmt.Errorf("failed to download file '%s' from '%s': status %s", name, resolvedFileURLString, resp.Status)
-					}
-
-					if err := os.MkdirAll(filepath.Dir(currentSavePath), 0755); err != nil {
-						tm.logger.Logf(indentPrefix+"[%d/%d] Downloading '%s'... Error creating directory: %v", *downloadedFiles, totalFiles, itemRelativePath, err)
-						return fmt.Errorf("failed to create parent directory for '%s': %w", currentSavePath, err)
-					}
-
-					out, err := os.Create(currentSavePath)
-					if err != nil {
-						tm.logger.Logf(indentPrefix+"[%d/%d] Downloading '%s'... Error creating file: %v", *downloadedFiles, totalFiles, itemRelativePath, err)
-						return fmt.Errorf("failed to create local file '%s': %w", currentSavePath, err)
-					}
-
-					_, copyErr := io.Copy(out, resp.Body)
-					closeErr := out.Close()
-
-					if copyErr != nil {
-						tm.logger.Logf(indentPrefix+"[%d/%d] Downloading '%s'... Error saving file: %v", *downloadedFiles, totalFiles, itemRelativePath, copyErr)
-						os.Remove(currentSavePath)
-						return fmt.Errorf("failed to save downloaded file '%s' to '%s': %w", name, currentSavePath, copyErr)
-					}
-					if closeErr != nil {
-						tm.logger.Logf(indentPrefix+"[%d/%d] Downloading '%s'... Error closing file: %v", *downloadedFiles, totalFiles, itemRelativePath, closeErr)
-						return fmt.Errorf("failed to close local file '%s' after writing: %w", currentSavePath, closeErr)
-					}
-
-					// Now, verify checksum for the map item (AssetDetail-like map)
-					// Read the entire file into a byte slice first
-					calculatedSum, checksumErr := utils.CalculateFileSHA256(currentSavePath)
-					if checksumErr != nil {
-						tm.logger.Logf(indentPrefix+"[%d/%d] Error calculating checksum for downloaded file '%s' (from map): %v", *downloadedFiles, totalFiles, itemRelativePath, checksumErr)
-						os.Remove(currentSavePath) // Clean up
-						return fmt.Errorf("failed to calculate checksum for downloaded file '%s' (from map): %w", currentSavePath, checksumErr)
-					}
-
-					if !strings.EqualFold(calculatedSum, sumString) {
-						tm.logger.Logf(indentPrefix+"[%d/%d] Checksum mismatch for '%s'. Expected: %s, Got: %s", *downloadedFiles, totalFiles, itemRelativePath, sumString, calculatedSum)
-						os.Remove(currentSavePath) // Clean up
-						return fmt.Errorf("checksum mismatch for file '%s': expected %s, got %s", name, sumString, calculatedSum)
-					}
-					tm.logger.Logf(indentPrefix+"[%d/%d] Downloaded and verified '%s'.", *downloadedFiles, totalFiles, itemRelativePath)
-
-				} else { // Has 'url' and 'sum' keys, but types are wrong. Treat as subdirectory.
-					if err := os.MkdirAll(currentSavePath, 0755); err != nil {
-						return fmt.Errorf("failed to create sub-directory '%s' (malformed asset-like map): %w", currentSavePath, err)
-					}
-					if err := tm.downloadAndSaveStructure(currentSavePath, itemRelativePath, mapItem, baseURL, totalFiles, downloadedFiles, indentPrefix); err != nil {
-						return err
-					}
-				}
-			} else { // Does not have both 'url' and 'sum' keys, treat as sub-directory
-				if err := os.MkdirAll(currentSavePath, 0755); err != nil {
-					return fmt.Errorf("failed to create sub-directory '%s': %w", currentSavePath, err)
-				}
-				if err := tm.downloadAndSaveStructure(currentSavePath, itemRelativePath, mapItem, baseURL, totalFiles, downloadedFiles, indentPrefix); err != nil {
+			urlString, urlIsString := v["url"].(string)
+			sumString, sumIsString := v["sum"].(string)
+			if urlIsString && sumIsString { // Both 'url' and 'sum' are strings, treat as AssetDetail
+				if err := addJob(name, itemRelativePath, currentSavePath, urlString, sumString); err != nil {
 					return err
 				}
+				continue
+			}
+			// Anything else is a sub-directory, including maps whose 'url'/'sum' are not strings
+			if err := os.MkdirAll(currentSavePath, 0755); err != nil {
+				return fmt.Errorf("failed to create sub-directory '%s': %w", currentSavePath, err)
+			}
+			if err := tm.collectDownloadJobs(currentSavePath, itemRelativePath, v, baseURL, jobs, indentPrefix); err != nil {
+				return err
 			}
 		default:
 			errMsg := fmt.Sprintf("unknown type in structure for key '%s': expected AssetDetail or map[string]interface{}, got %T", name, item)
@@ -465,7 +372,12 @@ func Install(tm *ThemeManager, source string, force bool) (*ThemeMetadata, error
 
 	// 1. Fetch and validate the theme definition
 	tm.logger.Logf(indent1 + "Fetching and validating theme definition...")
-	meta, sourceNameForLog, parsedSourceURL, isLocalSource, err := tm._fetchAndValidateDefinition(source) // _fetchAndValidateDefinition will log its own sub-steps with indent2
+	definitionSource, cleanupArchive, err := tm._resolveThemeArchive(source) // Archive URLs are downloaded first
+	if err != nil {
+		return nil, err
+	}
+	defer cleanupArchive()
+	meta, sourceNameForLog, parsedSourceURL, isLocalSource, err := tm._fetchAndValidateDefinition(definitionSource) // _fetchAndValidateDefinition will log its own sub-steps with indent2
 	if err != nil {
 		return nil, err
 	}
@@ -488,6 +400,7 @@ func Install(tm *ThemeManager, source string, force bool) (*ThemeMetadata, error
 	if err != nil {
 		return nil, err
 	}
+	tm._logMissingParent(meta, themesState, indent2)
 	// Log the determined action and target name from Install function's perspective
 	logResolvedTargetDirName := targetDirName
 	if action == ActionInstallNew && targetDirName == "" {
@@ -507,37 +420,43 @@ func Install(tm *ThemeManager, source string, force bool) (*ThemeMetadata, error
 	currentTime := time.Now().UTC().Format(time.RFC3339)
 	meta.LastUpdatedAt = currentTime
 
-	themePath, actualTargetDirName, err := tm._prepareDirectoryForInstall(targetDirName, meta, action, themesState, currentTime) // _prepareDirectoryForInstall will log its own sub-steps with indent2
+	stagingPath, actualTargetDirName, err := tm._prepareDirectoryForInstall(targetDirName, meta, action, themesState, currentTime) // _prepareDirectoryForInstall will log its own sub-steps with indent2
 	if err != nil {
 		return nil, err
 	}
-	// Assuming _prepareDirectoryForInstall logs "Directory prepared: path (ID: id)" with indent2
+	themePath := filepath.Join(tm.themeDir, actualTargetDirName)
+	// Assuming _prepareDirectoryForInstall logs "Staging directory prepared: path (ID: id)" with indent2
 
-	// 4. Download assets
-	// _downloadThemeAssets will log "Downloading N assets..." with indent1 and per-file progress with indent2
-	if err = tm._downloadThemeAssets(themePath, meta, parsedSourceURL, isLocalSource); err != nil {
-		if action == ActionInstallNew {
-			tm.logger.Logf(indent2+"Cleaning up directory '%s' due to download error.", themePath)
-			if rmErr := os.RemoveAll(themePath); rmErr != nil {
-				tm.logger.Logf(indent2+"Error during cleanup of '%s': %v", themePath, rmErr)
-			}
-		}
+	// 4. Download assets (or extract them from the archive) into the staging directory; an installed
+	// version stays untouched until step 5.
+	// _downloadThemeAssets will log "Downloading N assets..." with indent1 and aggregated progress with indent2
+	if isThemeArchive(definitionSource) {
+		err = tm._extractThemeArchive(definitionSource, stagingPath, meta)
+	} else {
+		err = tm._downloadThemeAssets(stagingPath, meta, parsedSourceURL, isLocalSource)
+	}
+	if err != nil {
+		tm._removeStagingDir(stagingPath, "download error")
+		return nil, err
+	}
+	// Assuming _downloadThemeAssets logs the downloader summary with indent2
+	if err = tm._verifyDownloadedAssets(stagingPath, meta); err != nil {
+		tm._removeStagingDir(stagingPath, "checksum verification failure")
 		return nil, err
 	}
-	// Assuming _downloadThemeAssets logs "All N assets downloaded." with indent2
 
 	// 5. Finalizing installation
 	tm.logger.Logf(indent1 + "Finalizing installation...")
-	if err = tm._writeLocalThemeJSON(themePath, meta); err != nil { // _writeLocalThemeJSON will log its own sub-steps/errors with indent2
-		if action == ActionInstallNew {
-			tm.logger.Logf(indent2+"Cleaning up directory '%s' due to error writing local theme.json.", themePath)
-			if rmErr := os.RemoveAll(themePath); rmErr != nil {
-				tm.logger.Logf(indent2+"Error during cleanup of '%s': %v", themePath, rmErr)
-			}
-		}
+	if err = tm._writeLocalThemeJSON(stagingPath, meta); err != nil { // _writeLocalThemeJSON will log its own sub-steps/errors with indent2
+		tm._removeStagingDir(stagingPath, "error writing local theme.json")
 		return nil, err
 	}
 	// Assuming _writeLocalThemeJSON logs "Local theme.json written." with indent2
+	commitSwap, undoSwap, err := tm._swapInstall(actualTargetDirName, stagingPath) // Logs the move with indent2
+	if err != nil {
+		tm._removeStagingDir(stagingPath, "error moving staged files into place")
+		return nil, err
+	}
 
 	installedEntry := configuration.InstalledThemeEntry{
 		ThmID:         actualTargetDirName,
@@ -548,35 +467,47 @@ func Install(tm *ThemeManager, source string, force bool) (*ThemeMetadata, error
 		InstalledAt:   meta.InstalledAt,
 	}
 
-	if err = tm._updateGlobalThemesState(actualTargetDirName, installedEntry); err != nil { // _updateGlobalThemesState will log its own sub-steps/errors with indent2
-		return meta, fmt.Errorf("theme '%s' files installed/updated to '%s', but failed to save global state: %w", meta.Name, themePath, err)
+	if err = tm._updateGlobalThemesState(actualTargetDirName, installedEntry, action == ActionInstallNew); err != nil { // _updateGlobalThemesState will log its own sub-steps/errors with indent2
+		// Keep files and state consistent: put back whatever was installed before.
+		if undoErr := undoSwap(); undoErr != nil {
+			tm.logger.Logf(indent2+"CRITICAL: Failed to undo install of theme '%s' in '%s': %v. Manual correction may be needed.", meta.Name, themePath, undoErr)
+		}
+		return nil, fmt.Errorf("theme '%s' was not installed, failed to save global state: %w", meta.Name, err)
 	}
+	commitSwap()
 	// Assuming _updateGlobalThemesState logs "Global themes state updated (ID: id)." with indent2
 
 	// 7. Discover themes to refresh in-memory cache
 	tm.discoverThemesLocked()
 
 	tm.logger.Logf("Theme '%s' (v%s) installed to '%s'.", meta.Name, meta.Version, themePath) // Final success log, no indent
+	tm.bus.Load().Publish(events.TopicThemeInstalled, map[string]string{"theme_id": actualTargetDirName, "name": meta.Name, "version": meta.Version})
 	return meta, nil
 }
 
-// _updateGlobalThemesState loads the current themes state, updates a specific entry, and saves it back.
+// _updateGlobalThemesState updates a specific entry of the themes state file under the cross-process
+// state lock, so a concurrent CLI invocation or server cannot drop the change.
+// With newInstall set, the install check is repeated on the locked state and
+// ErrThemeAlreadyExistsNoForce is returned if another process installed the same version meanwhile.
 // This method assumes that necessary locks are handled by the caller if concurrent access to tm.themes cache is a concern
 // immediately after this state file change (e.g., caller should lock, call this, then call discoverThemesLocked).
-func (tm *ThemeManager) _updateGlobalThemesState(themeID string, entryData configuration.InstalledThemeEntry) error {
+func (tm *ThemeManager) _updateGlobalThemesState(themeID string, entryData configuration.InstalledThemeEntry, newInstall bool) error {
 	indentPrefix := "    " // This is indent2
 
-	currentThemesState, err := configuration.LoadThemesState()
+	err := configuration.UpdateThemesState(func(currentThemesState map[string]configuration.InstalledThemeEntry) error {
+		if newInstall {
+			for localDir, entry := range currentThemesState {
+				if entry.SourceLink == entryData.SourceLink && entry.Version == entryData.Version {
+					tm.logger.Logf(indentPrefix+"Install aborted: Theme '%s' (v%s) was installed by another process in the meantime (ID: %s).", entryData.Name, entryData.Version, localDir)
+					return ErrThemeAlreadyExistsNoForce
+				}
+			}
+		}
+		currentThemesState[themeID] = entryData
+		return nil
+	})
 	if err != nil {
-		// Log the original error from configuration.LoadThemesState
-		tm.logger.Logf(indentPrefix+"Failed to load themes state before update for theme ID '%s': %v", themeID, err)
-		return fmt.Errorf("failed to load themes state before update for theme ID '%s': %w", themeID, err)
-	}
-
-	currentThemesState[themeID] = entryData
-
-	if err := configuration.SaveThemesState(currentThemesState); err != nil {
-		// Log the original error from configuration.SaveThemesState
+		// Log the original error from configuration.UpdateThemesState (load, lock or save failure)
 		tm.logger.Logf(indentPrefix+"CRITICAL: Failed to save updated themes state for theme ID '%s' (Name: %s): %v. Manual correction may be needed.", themeID, entryData.Name, err)
 		return fmt.Errorf("failed to save updated themes state for theme ID '%s' (Name: %s): %w", themeID, entryData.Name, err)
 	}
@@ -596,8 +527,9 @@ func (tm *ThemeManager) _writeLocalThemeJSON(themePath string, meta *ThemeMetada
 	}
 
 	localMetaPath := filepath.Join(themePath, "theme.json") // Explicitly use theme.json
-	if err := os.WriteFile(localMetaPath, jsonData, 0644); err != nil {
-		// Log the original os.WriteFile error (err), not the wrapped writeErr for this specific log.
+	// Atomic replace; the previous theme.json is kept as theme.json.bak for _loadLocalThemeJSON.
+	if err := atomicfile.WriteFile(localMetaPath, jsonData, 0644); err != nil {
+		// Log the original write error (err), not the wrapped writeErr for this specific log.
 		tm.logger.Logf(indentPrefix+"Failed to write local theme.json for theme '%s': %v", meta.Name, err)
 		return fmt.Errorf("failed to write local theme.json for theme '%s' to '%s': %w", meta.Name, localMetaPath, err)
 	}
@@ -606,12 +538,11 @@ func (tm *ThemeManager) _writeLocalThemeJSON(themePath string, meta *ThemeMetada
 }
 
 // _downloadThemeAssets downloads all assets for the theme.
-// It uses helper tm.countFilesInStructure and tm.downloadAndSaveStructure.
+// It uses helper tm.countFilesInStructure and tm.collectDownloadJobs. The caller holds tm.mu.
 func (tm *ThemeManager) _downloadThemeAssets(themePath string, meta *ThemeMetadata, parsedSourceURL *url.URL, isLocalSource bool) error {
 	indent1 := "  " // As per plan for this step under Install
 	indent2 := "    "
 	totalFiles := countFilesInStructure(meta.Structure)
-	downloadedFiles := 0
 
 	tm.logger.Logf(indent1+"Downloading %d assets for theme '%s' (v%s):", totalFiles, meta.Name, meta.Version)
 
@@ -619,37 +550,40 @@ func (tm *ThemeManager) _downloadThemeAssets(themePath string, meta *ThemeMetada
 	if !isLocalSource && parsedSourceURL != nil {
 		baseURLForStructure = parsedSourceURL
 	}
-	// If isLocalSource is true, baseURLForStructure remains nil, and downloadAndSaveStructure should handle it
+	// If isLocalSource is true, baseURLForStructure remains nil, and collectDownloadJobs should handle it
 	// (e.g., by expecting absolute paths in structure or resolving relative to a base local path if applicable).
-	// Current downloadAndSaveStructure resolves relative to baseURL if baseURL is not nil.
+	// Current collectDownloadJobs resolves relative to baseURL if baseURL is not nil.
 	// For local sources, the 'structure' in theme.yaml should contain relative paths from the YAML's location,
 	// and fetchThemeYAML (when source is local) should ideally make these URLs absolute or provide a base for them.
 	// However, current fetchThemeYAML for local source doesn't set parsedSourceURL.
-	// This means downloadAndSaveStructure for local source will treat structure URLs as potentially absolute file paths or fail.
+	// This means collectDownloadJobs for local source will treat structure URLs as potentially absolute file paths or fail.
 	// This needs to be consistent with how theme.yaml for local themes defines structure URLs.
-	// For now, we assume downloadAndSaveStructure handles it.
+	// For now, we assume collectDownloadJobs handles it.
 
-	// TODO: downloadAndSaveStructure needs to accept indent2 for its per-file logs
-	err := tm.downloadAndSaveStructure(themePath, "", meta.Structure, baseURLForStructure, totalFiles, &downloadedFiles, indent2)
-	if err != nil {
+	var jobs []downloader.Job
+	if err := tm.collectDownloadJobs(themePath, "", meta.Structure, baseURLForStructure, &jobs, indent2); err != nil {
+		tm.logger.Logf(indent2+"Asset download failed for theme '%s' (v%s).", meta.Name, meta.Version)
+		return fmt.Errorf("failed to download theme structure for '%s': %w", meta.Name, err)
+	}
+	// The downloader checks each file's sum before moving it into place
+	if _, err := tm.downloader.Download(indent2, jobs); err != nil {
 		tm.logger.Logf(indent2+"Asset download failed for theme '%s' (v%s).", meta.Name, meta.Version)
 		return fmt.Errorf("failed to download theme structure for '%s': %w", meta.Name, err)
 	}
-
-	tm.logger.Logf(indent2+"All %d assets downloaded.", totalFiles)
 	return nil
 }
 
-// _prepareDirectoryForInstall handles the creation or cleanup of the theme directory.
+// _prepareDirectoryForInstall determines the theme directory and creates the staging directory
+// the new files are downloaded into. The installed directory itself is not touched.
 // It sets meta.InstalledAt appropriately.
-// It returns the full theme path, the actual directory name used (can be new ID), and any error.
+// It returns the staging path, the actual directory name used (can be new ID), and any error.
 func (tm *ThemeManager) _prepareDirectoryForInstall(
 	initialTargetDirName string, // Empty for new, existing ThmID for overwrite
 	meta *ThemeMetadata, // Will be modified with InstalledAt
 	action ActionType,
 	themesState map[string]configuration.InstalledThemeEntry, // Needed to get original InstalledAt for overwrites
 	currentTime string, // Pre-calculated current time string for consistency
-) (fullThemePath string, actualDirName string, err error) {
+) (stagingPath string, actualDirName string, err error) {
 	indentPrefix := "    " // This is indent2, caller (Install) uses indent1 for "Preparing directory..."
 
 	actualDirName = initialTargetDirName
@@ -674,12 +608,6 @@ func (tm *ThemeManager) _prepareDirectoryForInstall(
 			meta.InstalledAt = currentTime
 		}
 		tm.logger.Logf(indentPrefix+"Re-installing existing theme, Target ID: '%s'.", actualDirName)
-		fullThemePath = filepath.Join(tm.themeDir, actualDirName)
-		if err = os.RemoveAll(fullThemePath); err != nil {
-			err = fmt.Errorf("failed to remove existing theme directory '%s' for force reinstall: %w", fullThemePath, err)
-			tm.logger.Logf(indentPrefix+"Directory preparation failed for theme '%s' (cleanup error): %v", meta.Name, err)
-			return "", actualDirName, err
-		}
 	} else {
 		// Should not happen if _determineInstallAction is correct (ActionErrorExists is handled before)
 		err = fmt.Errorf("unexpected action type %v in _prepareDirectoryForInstall for theme '%s'", action, meta.Name)
@@ -687,7 +615,7 @@ func (tm *ThemeManager) _prepareDirectoryForInstall(
 		return "", actualDirName, err
 	}
 
-	fullThemePath = filepath.Join(tm.themeDir, actualDirName)
+	fullThemePath := filepath.Join(tm.themeDir, actualDirName)
 
 	// Security check: ensure we are not operating outside the intended base directory
 	absThemeDir, pathErr := filepath.Abs(tm.themeDir)
@@ -708,14 +636,13 @@ func (tm *ThemeManager) _prepareDirectoryForInstall(
 		return "", actualDirName, err
 	}
 
-	if err = os.MkdirAll(fullThemePath, 0755); err != nil {
-		err = fmt.Errorf("failed to create theme directory '%s': %w", fullThemePath, err)
+	if stagingPath, err = tm._newStagingDir(actualDirName); err != nil {
 		tm.logger.Logf(indentPrefix+"Directory preparation failed for theme '%s' (mkdir error): %v", meta.Name, err)
 		return "", actualDirName, err
 	}
 
-	tm.logger.Logf(indentPrefix+"Directory prepared: '%s' (ID: '%s').", fullThemePath, actualDirName)
-	return fullThemePath, actualDirName, nil
+	tm.logger.Logf(indentPrefix+"Staging directory prepared: '%s' (ID: '%s').", stagingPath, actualDirName)
+	return stagingPath, actualDirName, nil
 }
 
 // _determineInstallAction checks the current themes state and decides the installation action.
@@ -789,7 +716,13 @@ func Update(tm *ThemeManager, themeID string) (*ThemeMetadata, error) {
 	// 3. Fetch and validate the new theme definition from source
 	tm.logger.Logf(indent1 + "Fetching and validating remote definition...")
 	// _fetchAndValidateDefinition logs its sub-steps with indent2
-	latestMeta, _, parsedSourceURL, isLocalSource, err := tm._fetchAndValidateDefinition(existingStateEntry.SourceLink)
+	definitionSource, cleanupArchive, err := tm._resolveThemeArchive(existingStateEntry.SourceLink) // Archive URLs are downloaded first
+	if err != nil {
+		tm.logger.Logf(indent1+"Failed to fetch/validate definition: %v", err)
+		return nil, fmt.Errorf("failed to fetch/validate definition for theme '%s' (ID: %s): %w", existingStateEntry.Name, themeID, err)
+	}
+	defer cleanupArchive()
+	latestMeta, _, parsedSourceURL, isLocalSource, err := tm._fetchAndValidateDefinition(definitionSource)
 	if err != nil {
 		tm.logger.Logf(indent1+"Failed to fetch/validate definition: %v", err) // Context at indent1
 		return nil, fmt.Errorf("failed to fetch/validate definition for theme '%s' (ID: %s): %w", existingStateEntry.Name, themeID, err)
@@ -832,7 +765,7 @@ func Update(tm *ThemeManager, themeID string) (*ThemeMetadata, error) {
 
 	tm.logger.Logf(indent1+"Preparing local directory '%s' for update to v%s...", filepath.Join(tm.themeDir, themeID), latestMeta.Version)
 	// _prepareDirectoryForInstall logs its sub-steps with indent2, including "Directory prepared..."
-	themePath, _, err := tm._prepareDirectoryForInstall(themeID, latestMeta, ActionOverwrite, themesState, currentTime)
+	stagingPath, _, err := tm._prepareDirectoryForInstall(themeID, latestMeta, ActionOverwrite, themesState, currentTime)
 	if err != nil {
 		tm.logger.Logf(indent1+"Failed to prepare directory: %v", err) // Context at indent1
 		return nil, fmt.Errorf("failed to prepare directory for update of theme '%s' (ID: %s): %w", latestMeta.Name, themeID, err)
@@ -840,21 +773,38 @@ func Update(tm *ThemeManager, themeID string) (*ThemeMetadata, error) {
 	// "Directory prepared" log is now handled by _prepareDirectoryForInstall
 
 	// 6. Download assets for the new version
-	// _downloadThemeAssets logs "Downloading N assets..." with indent1, progress with indent2, and "All N assets downloaded." with indent2
-	if err = tm._downloadThemeAssets(themePath, latestMeta, parsedSourceURL, isLocalSource); err != nil {
+	// _downloadThemeAssets logs "Downloading N assets..." with indent1, progress with indent2, and the downloader summary with indent2
+	// The installed version keeps serving until the staged one is complete and verified.
+	if isThemeArchive(definitionSource) {
+		err = tm._extractThemeArchive(definitionSource, stagingPath, latestMeta)
+	} else {
+		err = tm._downloadThemeAssets(stagingPath, latestMeta, parsedSourceURL, isLocalSource)
+	}
+	if err != nil {
 		tm.logger.Logf(indent1+"Failed to download assets: %v", err) // Context at indent1
+		tm._removeStagingDir(stagingPath, "download error")
 		return nil, fmt.Errorf("failed to download assets for updated theme '%s' (ID: %s, Version: %s): %w", latestMeta.Name, themeID, latestMeta.Version, err)
 	}
 	// "Assets downloaded" log is now handled by _downloadThemeAssets
+	if err = tm._verifyDownloadedAssets(stagingPath, latestMeta); err != nil {
+		tm._removeStagingDir(stagingPath, "checksum verification failure")
+		return nil, fmt.Errorf("failed to verify assets for updated theme '%s' (ID: %s, Version: %s): %w", latestMeta.Name, themeID, latestMeta.Version, err)
+	}
 
 	// 7. Finalizing update
 	tm.logger.Logf(indent1 + "Finalizing update:")
 	// _writeLocalThemeJSON logs "Local theme.json written." with indent2
-	if err = tm._writeLocalThemeJSON(themePath, latestMeta); err != nil {
+	if err = tm._writeLocalThemeJSON(stagingPath, latestMeta); err != nil {
 		tm.logger.Logf(indent2+"Failed to write local theme.json: %v", err) // Context at indent2
+		tm._removeStagingDir(stagingPath, "error writing local theme.json")
 		return nil, fmt.Errorf("failed to write local theme.json for updated theme '%s' (ID: %s, Version: %s): %w", latestMeta.Name, themeID, latestMeta.Version, err)
 	}
 	// "Local theme.json written" log is now handled by _writeLocalThemeJSON
+	commitSwap, undoSwap, err := tm._swapInstall(themeID, stagingPath) // The replaced version is kept for 'themes rollback'
+	if err != nil {
+		tm._removeStagingDir(stagingPath, "error moving staged files into place")
+		return nil, fmt.Errorf("failed to activate updated theme '%s' (ID: %s, Version: %s): %w", latestMeta.Name, themeID, latestMeta.Version, err)
+	}
 
 	updatedGlobalEntry := configuration.InstalledThemeEntry{
 		ThmID:         themeID,
@@ -865,16 +815,21 @@ func Update(tm *ThemeManager, themeID string) (*ThemeMetadata, error) {
 		InstalledAt:   latestMeta.InstalledAt,
 	}
 	// _updateGlobalThemesState logs "Global themes state updated (ID: id)." with indent2
-	if err = tm._updateGlobalThemesState(themeID, updatedGlobalEntry); err != nil {
+	if err = tm._updateGlobalThemesState(themeID, updatedGlobalEntry, false); err != nil {
 		tm.logger.Logf(indent2+"Failed to update global themes state: %v", err) // Context at indent2
-		return latestMeta, fmt.Errorf("theme '%s' (ID: %s) files updated to version '%s', but failed to save global state: %w", latestMeta.Name, themeID, latestMeta.Version, err)
+		if undoErr := undoSwap(); undoErr != nil {
+			tm.logger.Logf(indent2+"CRITICAL: Failed to restore version '%s' of theme ID '%s': %v. Manual correction may be needed.", existingStateEntry.Version, themeID, undoErr)
+		}
+		return nil, fmt.Errorf("theme '%s' (ID: %s) was not updated to version '%s', failed to save global state: %w", latestMeta.Name, themeID, latestMeta.Version, err)
 	}
+	commitSwap()
 	// "Global themes state updated" log is now handled by _updateGlobalThemesState
 
 	// 8. Discover themes to refresh in-memory cache (was 9)
 	tm.discoverThemesLocked()
 
 	tm.logger.Logf("Theme '%s' (ID: %s) updated successfully to version '%s'.", latestMeta.Name, themeID, latestMeta.Version)
+	tm.bus.Load().Publish(events.TopicThemeUpdated, map[string]string{"theme_id": themeID, "name": latestMeta.Name, "version": latestMeta.Version})
 	return latestMeta, nil
 }
 
@@ -882,16 +837,20 @@ func Update(tm *ThemeManager, themeID string) (*ThemeMetadata, error) {
 // Assumes themePath is the root directory of the theme (e.g., ext/themes/thm_abc123).
 func (tm *ThemeManager) _loadLocalThemeJSON(themePath string) (*ThemeMetadata, error) {
 	metaFilePath := filepath.Join(themePath, "theme.json") // Explicitly use theme.json
-	if _, err := os.Stat(metaFilePath); os.IsNotExist(err) {
-		return nil, fmt.Errorf("local theme.json not found at '%s'", metaFilePath)
-	}
-	metaData, err := os.ReadFile(metaFilePath)
+	var meta ThemeMetadata
+	// A damaged theme.json is restored from theme.json.bak when the backup parses.
+	_, recovered, err := atomicfile.ReadFile(metaFilePath, func(data []byte) error {
+		meta = ThemeMetadata{}
+		return json.Unmarshal(data, &meta)
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to read local theme.json from '%s': %w", metaFilePath, err)
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("local theme.json not found at '%s'", metaFilePath)
+		}
+		return nil, fmt.Errorf("failed to load local theme.json from '%s': %w", metaFilePath, err)
 	}
-	var meta ThemeMetadata
-	if err = json.Unmarshal(metaData, &meta); err != nil {
-		return nil, fmt.Errorf("failed to parse local theme.json from '%s': %w", metaFilePath, err)
+	if recovered {
+		tm.logger.Logf("    Local theme.json at '%s' was damaged and has been restored from its backup.", metaFilePath)
 	}
 	// Optionally, validate it, though it should be valid if written by us.
 	// if err = meta.Validate(); err != nil {
@@ -911,6 +870,12 @@ func Remove(tm *ThemeManager, themeID string) error {
 	// themeNameForLog will be fetched by _removeEntryFromGlobalState
 	// We log the top-level action after successfully fetching the name.
 
+	// Themes extending this one are looked up before the entry disappears from the state file.
+	themesState, err := configuration.LoadThemesState()
+	if err != nil {
+		return fmt.Errorf("failed to load themes state: %w", err)
+	}
+
 	// 1. Remove from state file first.
 	tm.logger.Logf(indent1+"Removing theme (ID: %s) from state file...", themeID)
 	themeNameForLog, err := tm._removeEntryFromGlobalState(themeID) // _removeEntryFromGlobalState will log its own sub-steps/result with indent2
@@ -922,6 +887,9 @@ func Remove(tm *ThemeManager, themeID string) error {
 	}
 	// Top-level "Removing theme..." log after name is known
 	tm.logger.Logf("Removing theme '%s' (ID: %s)...", themeNameForLog, themeID)
+	if children := tm._dependentThemes(themeID, themesState); len(children) > 0 {
+		tm.logger.Logf(indent1+"Warning: Themes %s extend '%s' and cannot be applied until it is installed again.", strings.Join(children, ", "), themeNameForLog)
+	}
 	// Assuming _removeEntryFromGlobalState logs "Removed from state file." with indent2
 
 	// 2. Remove the theme directory
@@ -937,37 +905,36 @@ func Remove(tm *ThemeManager, themeID string) error {
 	tm.discoverThemesLocked()
 
 	tm.logger.Logf("Theme '%s' (ID: %s) removed.", themeNameForLog, themeID) // Final success log, no indent
+	tm.bus.Load().Publish(events.TopicThemeRemoved, map[string]string{"theme_id": themeID, "name": themeNameForLog})
 	return nil
 }
 
-// _removeEntryFromGlobalState removes the theme entry from the global themes.json state file.
+// _removeEntryFromGlobalState removes the theme entry from the global themes.json state file
+// under the cross-process state lock.
 // It returns the name of the theme that was removed (for logging) or an error.
 func (tm *ThemeManager) _removeEntryFromGlobalState(themeID string) (themeName string, err error) {
 	indentPrefix := "    " // This is indent2
 
-	currentThemesState, loadErr := configuration.LoadThemesState()
-	if loadErr != nil {
-		err = fmt.Errorf("failed to load themes state before removing entry for theme ID '%s': %w", themeID, loadErr)
-		tm.logger.Logf(indentPrefix+"Failed to load themes state before removing entry for theme ID '%s': %v", themeID, loadErr)
-		return "", err
-	}
-
-	entry, exists := currentThemesState[themeID]
-	if !exists {
-		err = fmt.Errorf("theme with ID '%s' not found in state, cannot remove", themeID)
+	notFound := false
+	updateErr := configuration.UpdateThemesState(func(currentThemesState map[string]configuration.InstalledThemeEntry) error {
+		entry, exists := currentThemesState[themeID]
+		if !exists {
+			notFound = true
+			return fmt.Errorf("theme with ID '%s' not found in state, cannot remove", themeID)
+		}
+		themeName = entry.Name
+		// "Attempting to remove..." log is covered by the caller's "Removing from state file..."
+		delete(currentThemesState, themeID)
+		return nil
+	})
+	if notFound {
 		tm.logger.Logf(indentPrefix+"Theme ID '%s' not found in state. Nothing to remove from state file.", themeID)
-		return "", err
+		return "", updateErr
 	}
-	themeName = entry.Name
-
-	// "Attempting to remove..." log is covered by the caller's "Removing from state file..."
-	delete(currentThemesState, themeID)
-
-	if saveErr := configuration.SaveThemesState(currentThemesState); saveErr != nil {
-		// Use saveErr for logging the direct error from SaveThemesState
-		tm.logger.Logf(indentPrefix+"CRITICAL: Failed to save themes state after removing entry for theme ID '%s' (Name: %s): %v. Manual correction may be needed.", themeID, themeName, saveErr)
+	if updateErr != nil {
+		tm.logger.Logf(indentPrefix+"CRITICAL: Failed to save themes state after removing entry for theme ID '%s' (Name: %s): %v. Manual correction may be needed.", themeID, themeName, updateErr)
 		// Return the wrapped error for the caller
-		err = fmt.Errorf("failed to save updated themes state after removing entry for theme ID '%s' (Name: %s): %w", themeID, themeName, saveErr)
+		err = fmt.Errorf("failed to save updated themes state after removing entry for theme ID '%s' (Name: %s): %w", themeID, themeName, updateErr)
 		return themeName, err
 	}
 	tm.logger.Logf(indentPrefix+"Entry for theme '%s' (ID: %s) removed from state file.", themeName, themeID)
@@ -1007,6 +974,9 @@ func (tm *ThemeManager) _deleteThemeDirectory(themeID string, themeNameForLog st
 		return fmt.Errorf("failed to remove theme directory '%s' for theme '%s' (ID: %s): %w", targetThemePath, themeNameForLog, themeID, err)
 	}
 	tm.logger.Logf(indentPrefix+"Directory '%s' deleted.", targetThemePath)
+	if err := os.RemoveAll(tm._backupPath(themeID)); err != nil {
+		tm.logger.Logf(indentPrefix+"Failed to delete previous version backup '%s': %v", tm._backupPath(themeID), err)
+	}
 	return nil
 }
 
@@ -0,0 +1,174 @@
+package themes
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Setting types a theme can declare.
+const (
+	SettingString  = "string"
+	SettingInteger = "integer"
+	SettingNumber  = "number"
+	SettingBoolean = "boolean"
+)
+
+// settingKeyPattern keeps setting keys usable as template fields, e.g. {{.accent_color}}.
+var settingKeyPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// SettingSpec declares one setting a theme reads from the container's ui_settings.json.
+type SettingSpec struct {
+	Type        string      `yaml:"type" json:"type"` // One of string, integer, number or boolean
+	Default     interface{} `yaml:"default,omitempty" json:"default,omitempty"`
+	Description string      `yaml:"description,omitempty" json:"description,omitempty"`
+}
+
+// SettingsSchema maps setting keys to their declarations.
+type SettingsSchema map[string]SettingSpec
+
+// Validate checks the keys, types and defaults of the schema.
+func (s SettingsSchema) Validate() error {
+	for _, key := range s.Keys() {
+		spec := s[key]
+		if !settingKeyPattern.MatchString(key) {
+			return fmt.Errorf("invalid setting key '%s': must start with a letter or '_' and contain only letters, digits and '_'", key)
+		}
+		switch spec.Type {
+		case SettingString, SettingInteger, SettingNumber, SettingBoolean:
+		default:
+			return fmt.Errorf("setting '%s' has unknown type '%s' (want string, integer, number or boolean)", key, spec.Type)
+		}
+		if spec.Default != nil {
+			if _, ok := normalizeSetting(spec.Type, spec.Default); !ok {
+				return fmt.Errorf("default of setting '%s' is not of type %s: %v", key, spec.Type, spec.Default)
+			}
+		}
+	}
+	return nil
+}
+
+// Keys returns the setting keys in sorted order.
+func (s SettingsSchema) Keys() []string {
+	keys := make([]string, 0, len(s))
+	for key := range s {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Defaults returns the declared default of every setting; settings without a default get the
+// zero value of their type, so templates can always reference them.
+func (s SettingsSchema) Defaults() map[string]interface{} {
+	defaults := make(map[string]interface{}, len(s))
+	for key, spec := range s {
+		value, ok := normalizeSetting(spec.Type, spec.Default)
+		if spec.Default == nil || !ok {
+			value, _ = normalizeSetting(spec.Type, zeroSetting(spec.Type))
+		}
+		defaults[key] = value
+	}
+	return defaults
+}
+
+// Valid reports whether value (e.g. read back from ui_settings.json) has the declared type of key.
+func (s SettingsSchema) Valid(key string, value interface{}) bool {
+	spec, ok := s[key]
+	if !ok {
+		return false
+	}
+	_, ok = normalizeSetting(spec.Type, value)
+	return ok
+}
+
+// Parse converts a value given on the command line to the declared type of key.
+func (s SettingsSchema) Parse(key, raw string) (interface{}, error) {
+	spec, ok := s[key]
+	if !ok {
+		return nil, fmt.Errorf("unknown setting '%s' (known settings: %s)", key, strings.Join(s.Keys(), ", "))
+	}
+	switch spec.Type {
+	case SettingString:
+		return raw, nil
+	case SettingInteger:
+		n, err := strconv.ParseInt(strings.TrimSpace(raw), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("setting '%s' must be an integer, got '%s'", key, raw)
+		}
+		return n, nil
+	case SettingNumber:
+		f, err := strconv.ParseFloat(strings.TrimSpace(raw), 64)
+		if err != nil || math.IsInf(f, 0) || math.IsNaN(f) {
+			return nil, fmt.Errorf("setting '%s' must be a number, got '%s'", key, raw)
+		}
+		return f, nil
+	case SettingBoolean:
+		b, err := strconv.ParseBool(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, fmt.Errorf("setting '%s' must be true or false, got '%s'", key, raw)
+		}
+		return b, nil
+	}
+	return nil, fmt.Errorf("setting '%s' has unknown type '%s'", key, spec.Type)
+}
+
+// Describe returns the declaration of key as "type: description" for listings.
+func (s SettingsSchema) Describe(key string) string {
+	spec := s[key]
+	if spec.Description == "" {
+		return spec.Type
+	}
+	return spec.Type + ": " + spec.Description
+}
+
+// zeroSetting returns the zero value of a setting type.
+func zeroSetting(settingType string) interface{} {
+	switch settingType {
+	case SettingInteger:
+		return int64(0)
+	case SettingNumber:
+		return float64(0)
+	case SettingBoolean:
+		return false
+	}
+	return ""
+}
+
+// normalizeSetting converts value to the canonical Go type of settingType (string, int64,
+// float64 or bool). YAML decodes integers as int and JSON as float64, so both are accepted
+// where they represent the declared type.
+func normalizeSetting(settingType string, value interface{}) (interface{}, bool) {
+	switch settingType {
+	case SettingString:
+		v, ok := value.(string)
+		return v, ok
+	case SettingBoolean:
+		v, ok := value.(bool)
+		return v, ok
+	case SettingInteger:
+		switch v := value.(type) {
+		case int:
+			return int64(v), true
+		case int64:
+			return v, true
+		case float64:
+			if v == math.Trunc(v) && math.Abs(v) <= 1<<53 {
+				return int64(v), true
+			}
+		}
+	case SettingNumber:
+		switch v := value.(type) {
+		case int:
+			return float64(v), true
+		case int64:
+			return float64(v), true
+		case float64:
+			return v, true
+		}
+	}
+	return nil, false
+}
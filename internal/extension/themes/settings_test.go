@@ -0,0 +1,63 @@
+package themes
+
+import (
+	"encoding/json"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestSettingsSchema(t *testing.T) {
+	var meta ThemeMetadata
+	err := yaml.Unmarshal([]byte(`
+settings:
+  accent_color: {type: string, default: "#336699", description: Primary color}
+  columns: {type: integer, default: 3}
+  ratio: {type: number, default: 1}
+  show_footer: {type: boolean}
+`), &meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := meta.Settings.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	// Defaults survive the round trip through theme.json, where integers become float64.
+	data, _ := json.Marshal(meta.Settings)
+	var schema SettingsSchema
+	if err := json.Unmarshal(data, &schema); err != nil {
+		t.Fatal(err)
+	}
+	defaults := schema.Defaults()
+	want := map[string]interface{}{"accent_color": "#336699", "columns": int64(3), "ratio": float64(1), "show_footer": false}
+	for key, value := range want {
+		if defaults[key] != value {
+			t.Errorf("default of %s = %#v; want %#v", key, defaults[key], value)
+		}
+	}
+
+	if v, err := schema.Parse("columns", "4"); err != nil || v != int64(4) {
+		t.Errorf("Parse(columns, 4) = %#v, %v", v, err)
+	}
+	if v, err := schema.Parse("show_footer", "true"); err != nil || v != true {
+		t.Errorf("Parse(show_footer, true) = %#v, %v", v, err)
+	}
+	for _, tc := range [][2]string{{"columns", "3.5"}, {"ratio", "wide"}, {"show_footer", "maybe"}, {"unknown", "x"}} {
+		if _, err := schema.Parse(tc[0], tc[1]); err == nil {
+			t.Errorf("Parse(%s, %s) succeeded; want an error", tc[0], tc[1])
+		}
+	}
+	if !schema.Valid("columns", float64(2)) || schema.Valid("columns", "2") {
+		t.Errorf("Valid accepts the wrong values for an integer setting")
+	}
+
+	for _, bad := range []SettingsSchema{
+		{"bad-key": {Type: SettingString}},
+		{"size": {Type: "color"}},
+		{"size": {Type: SettingInteger, Default: "large"}},
+	} {
+		if err := bad.Validate(); err == nil {
+			t.Errorf("Validate(%v) succeeded; want an error", bad)
+		}
+	}
+}
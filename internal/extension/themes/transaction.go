@@ -0,0 +1,271 @@
+package themes
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/OG-Open-Source/PanelBase/internal/configuration"
+	"github.com/OG-Open-Source/PanelBase/internal/events"
+)
+
+// Installs and updates are staged next to the installed themes (same filesystem, so the final
+// step is a rename) and the replaced version is kept as a backup for Rollback. Both directories
+// start with '.', so they are never mistaken for themes.
+const (
+	stagingDirName  = ".staging"
+	backupDirName   = ".backup"
+	staleStagingAge = time.Hour // Staging directories older than this are left over from a crash
+)
+
+// _stagingRoot returns the directory holding in-progress installs.
+func (tm *ThemeManager) _stagingRoot() string {
+	return filepath.Join(tm.themeDir, stagingDirName)
+}
+
+// _backupPath returns where the previous version of a theme is kept.
+func (tm *ThemeManager) _backupPath(themeID string) string {
+	return filepath.Join(tm.themeDir, backupDirName, themeID)
+}
+
+// _newStagingDir creates an empty staging directory for a theme ID.
+func (tm *ThemeManager) _newStagingDir(themeID string) (string, error) {
+	if err := os.MkdirAll(tm._stagingRoot(), 0755); err != nil {
+		return "", fmt.Errorf("failed to create staging directory '%s': %w", tm._stagingRoot(), err)
+	}
+	stagingPath, err := os.MkdirTemp(tm._stagingRoot(), themeID+"-")
+	if err != nil {
+		return "", fmt.Errorf("failed to create staging directory for theme '%s': %w", themeID, err)
+	}
+	if err := os.Chmod(stagingPath, 0755); err != nil { // MkdirTemp uses 0700; keep the usual theme permissions
+		os.RemoveAll(stagingPath)
+		return "", fmt.Errorf("failed to set permissions on staging directory '%s': %w", stagingPath, err)
+	}
+	return stagingPath, nil
+}
+
+// _removeStagingDir deletes the staging directory of a failed install or update.
+// The installed version, if any, is untouched.
+func (tm *ThemeManager) _removeStagingDir(stagingPath string, reason string) {
+	indentPrefix := "    " // This is indent2
+	tm.logger.Logf(indentPrefix+"Discarding staged files in '%s' due to %s.", stagingPath, reason)
+	if rmErr := os.RemoveAll(stagingPath); rmErr != nil {
+		tm.logger.Logf(indentPrefix+"Error during cleanup of '%s': %v", stagingPath, rmErr)
+	}
+}
+
+// _cleanStaleStaging removes staging directories left behind by interrupted installs.
+func (tm *ThemeManager) _cleanStaleStaging() {
+	entries, err := os.ReadDir(tm._stagingRoot())
+	if err != nil {
+		return // Usually missing: nothing was ever staged
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || time.Since(info.ModTime()) < staleStagingAge {
+			continue // Possibly in use by another process
+		}
+		stalePath := filepath.Join(tm._stagingRoot(), entry.Name())
+		if err := os.RemoveAll(stalePath); err != nil {
+			tm.logger.Logf("Failed to remove stale theme staging directory '%s': %v", stalePath, err)
+			continue
+		}
+		tm.logger.Logf("Removed stale theme staging directory '%s'.", stalePath)
+	}
+}
+
+// _swapInstall moves a staged theme into place. An installed version is first moved to the
+// backup location; an older backup is only set aside, so a failed swap or undo can put it back.
+// undo restores the state before the swap and is used when the themes state cannot be saved
+// afterwards; commit deletes the set-aside backup once the state is saved. One of them must be called.
+func (tm *ThemeManager) _swapInstall(themeID, stagingPath string) (commit func(), undo func() error, err error) {
+	indentPrefix := "    " // This is indent2
+	finalPath := filepath.Join(tm.themeDir, themeID)
+	backupPath := tm._backupPath(themeID)
+
+	// The old backup waits in the staging area, where a crash leaves it to _cleanStaleStaging.
+	oldBackupPath := ""
+	if _, err := os.Stat(backupPath); err == nil {
+		if err := os.MkdirAll(tm._stagingRoot(), 0755); err != nil {
+			return nil, nil, fmt.Errorf("failed to create staging directory '%s': %w", tm._stagingRoot(), err)
+		}
+		oldBackupPath = filepath.Join(tm._stagingRoot(), fmt.Sprintf("%s-backup-%d", themeID, time.Now().UnixNano()))
+		if err := os.Rename(backupPath, oldBackupPath); err != nil {
+			return nil, nil, fmt.Errorf("failed to set aside old backup '%s': %w", backupPath, err)
+		}
+		now := time.Now()
+		os.Chtimes(oldBackupPath, now, now) // Not stale while this swap runs
+	}
+	restoreOldBackup := func() error {
+		if oldBackupPath == "" {
+			return nil
+		}
+		if err := os.Rename(oldBackupPath, backupPath); err != nil {
+			return fmt.Errorf("failed to restore old backup '%s' from '%s': %w", backupPath, oldBackupPath, err)
+		}
+		return nil
+	}
+
+	_, statErr := os.Stat(finalPath)
+	hadPrevious := statErr == nil
+	if hadPrevious {
+		if err := os.MkdirAll(filepath.Dir(backupPath), 0755); err != nil {
+			if restoreErr := restoreOldBackup(); restoreErr != nil {
+				tm.logger.Logf(indentPrefix+"CRITICAL: %v. Manual correction may be needed.", restoreErr)
+			}
+			return nil, nil, fmt.Errorf("failed to create backup directory '%s': %w", filepath.Dir(backupPath), err)
+		}
+		if err := os.Rename(finalPath, backupPath); err != nil {
+			if restoreErr := restoreOldBackup(); restoreErr != nil {
+				tm.logger.Logf(indentPrefix+"CRITICAL: %v. Manual correction may be needed.", restoreErr)
+			}
+			return nil, nil, fmt.Errorf("failed to back up installed theme '%s' to '%s': %w", finalPath, backupPath, err)
+		}
+	}
+	if err := os.Rename(stagingPath, finalPath); err != nil {
+		if hadPrevious {
+			if restoreErr := os.Rename(backupPath, finalPath); restoreErr != nil {
+				tm.logger.Logf(indentPrefix+"CRITICAL: Failed to restore '%s' from '%s': %v. Manual correction may be needed.", finalPath, backupPath, restoreErr)
+			}
+		}
+		if restoreErr := restoreOldBackup(); restoreErr != nil {
+			tm.logger.Logf(indentPrefix+"CRITICAL: %v. Manual correction may be needed.", restoreErr)
+		}
+		return nil, nil, fmt.Errorf("failed to move staged theme '%s' into '%s': %w", stagingPath, finalPath, err)
+	}
+	if hadPrevious {
+		tm.logger.Logf(indentPrefix+"Staged files moved into '%s'; previous version kept in '%s'.", finalPath, backupPath)
+	} else {
+		tm.logger.Logf(indentPrefix+"Staged files moved into '%s'.", finalPath)
+	}
+
+	commit = func() {
+		if oldBackupPath == "" {
+			return
+		}
+		if err := os.RemoveAll(oldBackupPath); err != nil {
+			tm.logger.Logf(indentPrefix+"Warning: Failed to remove replaced backup '%s': %v", oldBackupPath, err)
+		}
+	}
+	undo = func() error {
+		if err := os.RemoveAll(finalPath); err != nil {
+			return fmt.Errorf("failed to remove '%s': %w", finalPath, err)
+		}
+		if hadPrevious {
+			if err := os.Rename(backupPath, finalPath); err != nil {
+				return fmt.Errorf("failed to restore '%s' from '%s': %w", finalPath, backupPath, err)
+			}
+		}
+		return restoreOldBackup()
+	}
+	return commit, undo, nil
+}
+
+// HasBackup reports whether a previous version of the theme is available for Rollback.
+func (tm *ThemeManager) HasBackup(themeID string) bool {
+	_, err := os.Stat(filepath.Join(tm._backupPath(themeID), "theme.json"))
+	return err == nil
+}
+
+// Rollback restores the version of a theme that was replaced by its last update or forced
+// re-install. The replaced version becomes the new backup, so a rollback can itself be undone
+// by rolling back again. It uses the provided ThemeManager instance.
+func Rollback(tm *ThemeManager, themeID string) (*ThemeMetadata, error) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	indent1 := "  "
+	indent2 := "    "
+
+	themesState, err := configuration.LoadThemesState()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load themes state for rollback of theme ID '%s': %w", themeID, err)
+	}
+	currentEntry, ok := themesState[themeID]
+	if !ok {
+		return nil, fmt.Errorf("theme with ID '%s' not found in state, cannot roll back", themeID)
+	}
+	backupPath := tm._backupPath(themeID)
+	if _, err := os.Stat(backupPath); err != nil {
+		return nil, fmt.Errorf("no previous version of theme '%s' (ID: %s) is available to roll back to", currentEntry.Name, themeID)
+	}
+
+	tm.logger.Logf("Rolling back theme '%s' (ID: %s) from version '%s'...", currentEntry.Name, themeID, currentEntry.Version)
+
+	// 1. Check the backup before touching the installed version
+	tm.logger.Logf(indent1 + "Verifying previous version...")
+	previousMeta, err := tm._loadLocalThemeJSON(backupPath)
+	if err != nil {
+		return nil, fmt.Errorf("previous version of theme ID '%s' is unusable: %w", themeID, err)
+	}
+	result, err := verifyThemeFiles(backupPath, previousMeta.Structure)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify previous version of theme ID '%s': %w", themeID, err)
+	}
+	if !result.OK() {
+		return nil, fmt.Errorf("previous version of theme ID '%s' does not match its theme.json (%s); not rolling back", themeID, result.Summary())
+	}
+	tm.logger.Logf(indent2+"Previous version '%s' verified (%d files).", previousMeta.Version, result.Checked)
+
+	// 2. Swap the installed version and the backup through the staging area
+	tm.logger.Logf(indent1 + "Restoring previous version...")
+	if err := os.MkdirAll(tm._stagingRoot(), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create staging directory '%s': %w", tm._stagingRoot(), err)
+	}
+	finalPath := filepath.Join(tm.themeDir, themeID)
+	parkedPath := filepath.Join(tm._stagingRoot(), themeID+"-rollback")
+	if err := os.RemoveAll(parkedPath); err != nil {
+		return nil, fmt.Errorf("failed to clear '%s': %w", parkedPath, err)
+	}
+	swap := func(from, to, via string) error {
+		if err := os.Rename(from, via); err != nil {
+			return err
+		}
+		if err := os.Rename(to, from); err != nil {
+			os.Rename(via, from)
+			return err
+		}
+		return os.Rename(via, to)
+	}
+	if err := swap(finalPath, backupPath, parkedPath); err != nil {
+		return nil, fmt.Errorf("failed to restore previous version of theme ID '%s': %w", themeID, err)
+	}
+
+	// 3. Record the restored version
+	previousMeta.LastUpdatedAt = time.Now().UTC().Format(time.RFC3339)
+	restoredEntry := configuration.InstalledThemeEntry{
+		ThmID:         themeID,
+		Name:          previousMeta.Name,
+		Version:       previousMeta.Version,
+		SourceLink:    currentEntry.SourceLink,
+		LastUpdatedAt: previousMeta.LastUpdatedAt,
+		InstalledAt:   currentEntry.InstalledAt,
+	}
+	if err := tm._updateGlobalThemesState(themeID, restoredEntry, false); err != nil {
+		if undoErr := swap(finalPath, backupPath, parkedPath); undoErr != nil {
+			tm.logger.Logf(indent2+"CRITICAL: Failed to undo rollback of theme ID '%s': %v. Manual correction may be needed.", themeID, undoErr)
+		}
+		return nil, fmt.Errorf("theme ID '%s' was not rolled back: %w", themeID, err)
+	}
+	if err := tm._writeLocalThemeJSON(finalPath, previousMeta); err != nil {
+		tm.logger.Logf(indent2+"Warning: restored theme.json could not be updated: %v", err) // Only LastUpdatedAt is lost
+	}
+
+	tm.discoverThemesLocked()
+
+	tm.logger.Logf("Theme '%s' (ID: %s) rolled back from version '%s' to '%s'.", previousMeta.Name, themeID, currentEntry.Version, previousMeta.Version)
+	tm.bus.Load().Publish(events.TopicThemeRolledBack, map[string]string{
+		"theme_id":         themeID,
+		"name":             previousMeta.Name,
+		"version":          previousMeta.Version,
+		"previous_version": currentEntry.Version,
+	})
+	return previousMeta, nil
+}
+
+// isInternalThemeDir reports whether a directory name under the themes directory belongs to
+// the staging or backup area rather than to a theme.
+func isInternalThemeDir(name string) bool {
+	return strings.HasPrefix(name, ".")
+}
@@ -0,0 +1,126 @@
+package themes
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/OG-Open-Source/PanelBase/internal/configuration"
+	"github.com/OG-Open-Source/PanelBase/internal/logger"
+	"github.com/OG-Open-Source/PanelBase/internal/utils"
+)
+
+// packTestTheme writes a one-file theme whose index.html holds content and packs it.
+func packTestTheme(t *testing.T, tm *ThemeManager, content string) string {
+	t.Helper()
+	src := t.TempDir()
+	if err := os.WriteFile(filepath.Join(src, "index.html"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	manifest := fmt.Sprintf(`name: Demo Theme
+authors: [{name: t}]
+version: 1.0.0
+description: d
+source_link: https://example.com/demo/theme.yaml
+structure:
+  index.html: {url: https://example.com/demo/index.html, sum: %s}
+`, sha256Hex(content))
+	if err := os.WriteFile(filepath.Join(src, themeMetaFile), []byte(manifest), 0644); err != nil {
+		t.Fatal(err)
+	}
+	archivePath := filepath.Join(t.TempDir(), "demo.zip")
+	if _, err := Pack(tm, src, archivePath); err != nil {
+		t.Fatalf("Pack: %v", err)
+	}
+	return archivePath
+}
+
+func TestInstallSwapUndoAndRollback(t *testing.T) {
+	log, err := logger.NewLogger(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+	idGen, err := utils.NewIDGenerator(&configuration.SecurityConfig{Secrets: configuration.SecretsConfig{Alphabet: "abcdef0123456789", Length: 12}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	configuration.SetStateDir(t.TempDir())
+	tm, err := NewThemeManager(log, idGen, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Install(tm, packTestTheme(t, tm, "one"), false); err != nil {
+		t.Fatalf("Install: %v", err)
+	}
+	state, err := configuration.LoadThemesState()
+	if err != nil || len(state) != 1 {
+		t.Fatalf("themes state = %v, %v; want one theme", state, err)
+	}
+	var themeID string
+	for id := range state {
+		themeID = id
+	}
+	read := func(dir string) string {
+		data, _ := os.ReadFile(filepath.Join(dir, "index.html"))
+		return string(data)
+	}
+	installed, backup := filepath.Join(tm.themeDir, themeID), tm._backupPath(themeID)
+	check := func(step, wantInstalled, wantBackup string) {
+		t.Helper()
+		if got := read(installed); got != wantInstalled {
+			t.Errorf("%s: installed index.html = %q; want %q", step, got, wantInstalled)
+		}
+		if got := read(backup); got != wantBackup {
+			t.Errorf("%s: backup index.html = %q; want %q", step, got, wantBackup)
+		}
+		if entries, _ := os.ReadDir(tm._stagingRoot()); len(entries) != 0 {
+			t.Errorf("%s: staging area not empty: %v", step, entries)
+		}
+	}
+	check("install", "one", "")
+
+	// Forced re-installs keep the replaced version as the backup and drop the older backup.
+	for _, content := range []string{"two", "three"} {
+		if _, err := Install(tm, packTestTheme(t, tm, content), true); err != nil {
+			t.Fatalf("re-install %s: %v", content, err)
+		}
+	}
+	check("re-install", "three", "two")
+
+	if _, err := Rollback(tm, themeID); err != nil {
+		t.Fatalf("Rollback: %v", err)
+	}
+	check("rollback", "two", "three")
+
+	// A swap that fails leaves the installed version and its backup untouched.
+	if _, _, err := tm._swapInstall(themeID, filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Fatal("_swapInstall of a missing staging directory succeeded")
+	}
+	check("failed swap", "two", "three")
+
+	// When the state cannot be saved, the swap is undone, including the older backup. A damaged
+	// record followed by a valid one makes the state store refuse writes.
+	st, err := configuration.OpenStateStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(st.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	firstRecord := data[:bytes.IndexByte(data, '\n')+1]
+	f, err := os.OpenFile(st.Path(), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write(append([]byte("garbage\n"), firstRecord...))
+	f.Close()
+	if _, err := Install(tm, packTestTheme(t, tm, "four"), true); err == nil {
+		t.Fatal("Install succeeded although the themes state could not be saved")
+	}
+	check("failed state save", "two", "three")
+}
@@ -27,8 +27,10 @@ type ThemeMetadata struct {
 	Name        string         `yaml:"name" json:"name"`
 	Authors     []AuthorDetail `yaml:"authors" json:"authors"`
 	Version     string         `yaml:"version" json:"version"`
-	Description string   `yaml:"description" json:"description"`
-	SourceLink  string   `yaml:"source_link" json:"source_link"`
+	Description string         `yaml:"description" json:"description"`
+	SourceLink  string         `yaml:"source_link" json:"source_link"`
+	Parent      string         `yaml:"parent,omitempty" json:"parent,omitempty"`     // Optional: source link or thm_id of the theme this one extends
+	Settings    SettingsSchema `yaml:"settings,omitempty" json:"settings,omitempty"` // Optional: settings the theme reads from ui_settings.json
 	// Directory field removed
 	Structure     map[string]interface{} `yaml:"structure" json:"structure"`
 	InstalledAt   string                 `yaml:"installed_at,omitempty" json:"installed_at,omitempty"`
@@ -72,6 +74,14 @@ func (m *ThemeMetadata) Validate() error {
 
 	// Directory validation removed
 
+	if strings.TrimSpace(m.Parent) != "" && strings.TrimSpace(m.Parent) == m.SourceLink {
+		return fmt.Errorf("theme parent '%s' cannot be the theme itself", m.Parent)
+	}
+
+	if err := m.Settings.Validate(); err != nil {
+		return fmt.Errorf("invalid theme settings: %w", err)
+	}
+
 	if len(m.Structure) == 0 {
 		return fmt.Errorf("theme structure is required and cannot be empty")
 	}
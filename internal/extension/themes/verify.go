@@ -0,0 +1,190 @@
+package themes
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/OG-Open-Source/PanelBase/internal/configuration"
+	"github.com/OG-Open-Source/PanelBase/internal/utils"
+)
+
+// themeOwnFiles are written into a theme directory by PanelBase itself, so they are not part of
+// the theme's structure and never reported as extra files.
+var themeOwnFiles = map[string]bool{
+	"theme.json":     true,
+	"theme.json.bak": true, // Kept by atomicfile when theme.json is replaced
+}
+
+// FileMismatch is an installed file whose SHA256 sum differs from the one declared in theme.json.
+type FileMismatch struct {
+	Path     string // Relative to the theme directory, with forward slashes
+	Expected string
+	Actual   string
+}
+
+// VerifyResult lists the differences between an installed theme's files and its local theme.json.
+type VerifyResult struct {
+	ThemeID  string
+	Name     string
+	Version  string
+	Checked  int            // Number of files declared in the structure
+	Modified []FileMismatch // Sorted by path
+	Missing  []string       // Declared in the structure but not on disk; sorted
+	Extra    []string       // On disk but not declared in the structure; sorted
+}
+
+// OK reports whether the installed files match the structure exactly.
+func (r *VerifyResult) OK() bool {
+	return len(r.Modified) == 0 && len(r.Missing) == 0 && len(r.Extra) == 0
+}
+
+// Summary describes the result in one line, e.g. "1 modified, 2 missing, 0 extra of 12 files".
+func (r *VerifyResult) Summary() string {
+	return fmt.Sprintf("%d modified, %d missing, %d extra of %d files", len(r.Modified), len(r.Missing), len(r.Extra), r.Checked)
+}
+
+// structureSums flattens a theme structure into relative file paths (with forward slashes) and
+// their declared sums. Assets may be AssetDetail values (freshly parsed metadata) or maps with
+// string 'url' and 'sum' keys (metadata read back from theme.json); other maps are directories.
+func structureSums(structure map[string]interface{}, prefix string, sums map[string]string) {
+	for name, item := range structure {
+		itemPath := path.Join(prefix, name)
+		switch v := item.(type) {
+		case AssetDetail:
+			sums[itemPath] = strings.ToLower(v.Sum)
+		case map[string]interface{}:
+			urlString, urlOk := v["url"].(string)
+			sumString, sumOk := v["sum"].(string)
+			if urlOk && sumOk && urlString != "" {
+				sums[itemPath] = strings.ToLower(sumString)
+			} else {
+				structureSums(v, itemPath, sums)
+			}
+		}
+	}
+}
+
+// verifyThemeFiles re-hashes the files under themePath and compares them with structure.
+func verifyThemeFiles(themePath string, structure map[string]interface{}) (*VerifyResult, error) {
+	sums := make(map[string]string)
+	structureSums(structure, "", sums)
+	result := &VerifyResult{Checked: len(sums)}
+
+	for relPath, expected := range sums {
+		actual, err := utils.CalculateFileSHA256(filepath.Join(themePath, filepath.FromSlash(relPath)))
+		if err != nil {
+			if os.IsNotExist(err) {
+				result.Missing = append(result.Missing, relPath)
+				continue
+			}
+			return nil, fmt.Errorf("failed to hash installed file '%s': %w", relPath, err)
+		}
+		if actual != expected {
+			result.Modified = append(result.Modified, FileMismatch{Path: relPath, Expected: expected, Actual: actual})
+		}
+	}
+
+	err := filepath.WalkDir(themePath, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(themePath, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if _, declared := sums[rel]; !declared && !themeOwnFiles[rel] {
+			result.Extra = append(result.Extra, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan theme directory '%s': %w", themePath, err)
+	}
+
+	sort.Slice(result.Modified, func(i, j int) bool { return result.Modified[i].Path < result.Modified[j].Path })
+	sort.Strings(result.Missing)
+	sort.Strings(result.Extra)
+	return result, nil
+}
+
+// _verifyDownloadedAssets checks a freshly downloaded theme against its metadata as a whole,
+// after the per-file checks of the downloader.
+func (tm *ThemeManager) _verifyDownloadedAssets(themePath string, meta *ThemeMetadata) error {
+	indentPrefix := "    " // This is indent2
+	result, err := verifyThemeFiles(themePath, meta.Structure)
+	if err != nil {
+		tm.logger.Logf(indentPrefix+"Verification failed for theme '%s': %v", meta.Name, err)
+		return fmt.Errorf("failed to verify downloaded files of theme '%s': %w", meta.Name, err)
+	}
+	if !result.OK() {
+		tm.logger.Logf(indentPrefix+"Downloaded files of theme '%s' do not match theme.yaml: %s.", meta.Name, result.Summary())
+		return fmt.Errorf("downloaded files of theme '%s' do not match theme.yaml: %s", meta.Name, result.Summary())
+	}
+	tm.logger.Logf(indentPrefix+"All %d files verified against their SHA256 sums.", result.Checked)
+	return nil
+}
+
+// Verify re-hashes the files of an installed theme and reports files that were modified, are
+// missing or were added compared to the structure recorded in its local theme.json.
+// It uses the provided ThemeManager instance.
+func Verify(tm *ThemeManager, themeID string) (*VerifyResult, error) {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+
+	themesState, err := configuration.LoadThemesState()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load themes state: %w", err)
+	}
+	if _, ok := themesState[themeID]; !ok {
+		return nil, fmt.Errorf("theme with ID '%s' not found in state", themeID)
+	}
+
+	themePath := filepath.Join(tm.themeDir, themeID)
+	meta, err := tm._loadLocalThemeJSON(themePath)
+	if err != nil {
+		return nil, err
+	}
+	result, err := verifyThemeFiles(themePath, meta.Structure)
+	if err != nil {
+		return nil, err
+	}
+	result.ThemeID, result.Name, result.Version = themeID, meta.Name, meta.Version
+	return result, nil
+}
+
+// InstalledSums returns the SHA256 sums of all files declared by installed themes and by their
+// rollback backups, e.g. to decide which cached downloads are still referenced.
+// It uses the provided ThemeManager instance.
+func InstalledSums(tm *ThemeManager) (map[string]bool, error) {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+
+	themesState, err := configuration.LoadThemesState()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load themes state: %w", err)
+	}
+	sums := make(map[string]string)
+	for themeID := range themesState {
+		for _, themePath := range []string{filepath.Join(tm.themeDir, themeID), tm._backupPath(themeID)} {
+			meta, err := tm._loadLocalThemeJSON(themePath)
+			if err != nil {
+				continue // No backup, or a broken install whose files are not worth keeping
+			}
+			structureSums(meta.Structure, "", sums)
+		}
+	}
+	referenced := make(map[string]bool, len(sums))
+	for _, sum := range sums {
+		referenced[sum] = true
+	}
+	return referenced, nil
+}
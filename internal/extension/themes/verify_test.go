@@ -0,0 +1,61 @@
+package themes
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestVerifyThemeFiles(t *testing.T) {
+	dir := t.TempDir()
+	write := func(rel, content string) {
+		p := filepath.Join(dir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("index.html", "<html></html>")
+	write("css/style.css", "body { color: red }") // Modified after install
+	write("js/extra.js", "alert(1)")              // Not declared
+	write("theme.json", "{}")                     // Written by PanelBase, never extra
+
+	// Mirrors theme.json after a JSON round trip: assets are maps with url and sum.
+	structure := map[string]interface{}{
+		"index.html": map[string]interface{}{"url": "https://example.com/index.html", "sum": sha256Hex("<html></html>")},
+		"css": map[string]interface{}{
+			"style.css": map[string]interface{}{"url": "https://example.com/css/style.css", "sum": sha256Hex("body {}")},
+		},
+		"img": map[string]interface{}{
+			"logo.png": AssetDetail{URL: "https://example.com/img/logo.png", Sum: sha256Hex("png")},
+		},
+	}
+
+	result, err := verifyThemeFiles(dir, structure)
+	if err != nil {
+		t.Fatalf("verifyThemeFiles: %v", err)
+	}
+	if result.OK() || result.Checked != 3 {
+		t.Fatalf("result = %+v; want 3 checked files with differences", result)
+	}
+	if len(result.Modified) != 1 || result.Modified[0].Path != "css/style.css" {
+		t.Errorf("Modified = %+v; want css/style.css", result.Modified)
+	}
+	if want := []string{"img/logo.png"}; !reflect.DeepEqual(result.Missing, want) {
+		t.Errorf("Missing = %v; want %v", result.Missing, want)
+	}
+	if want := []string{"js/extra.js"}; !reflect.DeepEqual(result.Extra, want) {
+		t.Errorf("Extra = %v; want %v", result.Extra, want)
+	}
+}
+
+// sha256Hex matches utils.CalculateFileSHA256 for content without CRLF line endings.
+func sha256Hex(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,91 @@
+package filelock
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultTimeout = 10 * time.Second      // Used by callers that do not need a specific timeout
+	retryInterval  = 50 * time.Millisecond // How often a busy lock is retried while waiting
+)
+
+// errWouldBlock is returned by the platform tryLock implementations when another process holds the lock.
+var errWouldBlock = errors.New("lock is held by another process")
+
+// LockedError is returned by Acquire when the lock could not be taken before the timeout.
+type LockedError struct {
+	Path string // Lock file path
+	PID  int    // Process holding the lock, 0 if unknown
+}
+
+func (e *LockedError) Error() string {
+	if e.PID > 0 {
+		return fmt.Sprintf("state locked by pid %d (lock file '%s')", e.PID, e.Path)
+	}
+	return fmt.Sprintf("state locked by another process (lock file '%s')", e.Path)
+}
+
+// Lock is an advisory, cross-process lock backed by a lock file.
+// The holder's pid is written into the file so waiting processes can report it.
+type Lock struct {
+	path string
+	file *os.File
+}
+
+// Acquire takes the lock at path, waiting up to timeout for another holder to release it.
+// On timeout it returns a *LockedError naming the holder's pid.
+// Locks are not reentrant: acquiring the same path twice in one process blocks until timeout.
+func Acquire(path string, timeout time.Duration) (*Lock, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create lock directory '%s': %w", filepath.Dir(path), err)
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		file, err := tryLock(path)
+		if err == nil {
+			// Record ourselves as the holder. Errors are ignored; the pid is informational only.
+			file.Truncate(0)
+			file.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+			return &Lock{path: path, file: file}, nil
+		}
+		if !errors.Is(err, errWouldBlock) {
+			return nil, fmt.Errorf("failed to lock '%s': %w", path, err)
+		}
+		if time.Now().After(deadline) {
+			return nil, &LockedError{Path: path, PID: readHolderPID(path)}
+		}
+		time.Sleep(retryInterval)
+	}
+}
+
+// Release unlocks and closes the lock file.
+func (l *Lock) Release() error {
+	if l == nil || l.file == nil {
+		return nil
+	}
+	err := unlock(l.path, l.file)
+	l.file = nil
+	if err != nil {
+		return fmt.Errorf("failed to unlock '%s': %w", l.path, err)
+	}
+	return nil
+}
+
+// readHolderPID returns the pid recorded in a lock file, or 0 if it cannot be read.
+func readHolderPID(path string) int {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0
+	}
+	return pid
+}
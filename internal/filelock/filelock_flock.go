@@ -0,0 +1,37 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package filelock
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// tryLock opens the lock file and takes a non-blocking exclusive flock on it.
+// The kernel drops the lock automatically if the holder dies.
+func tryLock(path string) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		file.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, errWouldBlock
+		}
+		return nil, err
+	}
+	return file, nil
+}
+
+// unlock releases the flock. The lock file itself is left in place: removing it would let
+// a waiter that already opened the old file and a newcomer creating a new one both "hold" the lock.
+func unlock(path string, file *os.File) error {
+	file.Truncate(0)
+	err := syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,25 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package filelock
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAcquireIgnoresLeftoverLockFile(t *testing.T) {
+	// A lock file left behind by a crashed process holds no flock and must not block.
+	path := filepath.Join(t.TempDir(), "state.lock")
+	if err := os.WriteFile(path, []byte("999999\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	lock, err := Acquire(path, 0)
+	if err != nil {
+		t.Fatalf("Acquire over leftover lock file: %v", err)
+	}
+	defer lock.Release()
+	if pid := readHolderPID(path); pid != os.Getpid() {
+		t.Errorf("holder pid = %d; want %d", pid, os.Getpid())
+	}
+}
@@ -0,0 +1,33 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package filelock
+
+import (
+	"os"
+	"time"
+)
+
+// staleLockAge is how old a lock file must be before it is assumed to belong to a crashed process.
+// Without flock the lock is not released automatically when its holder dies.
+const staleLockAge = 5 * time.Minute
+
+// tryLock creates the lock file exclusively; its existence means the lock is held.
+func tryLock(path string) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if err == nil {
+		return file, nil
+	}
+	if !os.IsExist(err) {
+		return nil, err
+	}
+	if info, statErr := os.Stat(path); statErr == nil && time.Since(info.ModTime()) > staleLockAge {
+		os.Remove(path) // Retried on the next attempt
+	}
+	return nil, errWouldBlock
+}
+
+// unlock closes and removes the lock file.
+func unlock(path string, file *os.File) error {
+	file.Close()
+	return os.Remove(path)
+}
@@ -0,0 +1,31 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package filelock
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAcquireRemovesStaleLockFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.lock")
+	if err := os.WriteFile(path, []byte("999999\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// A fresh lock file is respected...
+	if _, err := Acquire(path, 0); err == nil {
+		t.Fatal("Acquire succeeded over a fresh lock file")
+	}
+	// ...but one older than staleLockAge is assumed to belong to a crashed process.
+	old := time.Now().Add(-2 * staleLockAge)
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+	lock, err := Acquire(path, time.Second)
+	if err != nil {
+		t.Fatalf("Acquire over stale lock file: %v", err)
+	}
+	lock.Release()
+}
@@ -0,0 +1,61 @@
+package filelock
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAcquireWaitsForHolderAndTimesOut(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.lock")
+	held, err := Acquire(path, time.Second)
+	if err != nil {
+		t.Fatalf("Acquire: %v", err)
+	}
+
+	// While the lock is held, a second Acquire gives up at its timeout and names the holder.
+	start := time.Now()
+	_, err = Acquire(path, 150*time.Millisecond)
+	var locked *LockedError
+	if !errors.As(err, &locked) {
+		t.Fatalf("Acquire while held: err = %v; want *LockedError", err)
+	}
+	if locked.PID != os.Getpid() {
+		t.Errorf("LockedError.PID = %d; want %d", locked.PID, os.Getpid())
+	}
+	if waited := time.Since(start); waited < 150*time.Millisecond {
+		t.Errorf("Acquire returned after %v; want it to wait for the timeout", waited)
+	}
+
+	// A waiter gets the lock as soon as the holder releases it.
+	acquired := make(chan error, 1)
+	go func() {
+		lock, err := Acquire(path, 5*time.Second)
+		if err == nil {
+			err = lock.Release()
+		}
+		acquired <- err
+	}()
+	time.Sleep(2 * retryInterval)
+	select {
+	case err := <-acquired:
+		t.Fatalf("waiter got the lock while it was held: %v", err)
+	default:
+	}
+	if err := held.Release(); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+	select {
+	case err := <-acquired:
+		if err != nil {
+			t.Fatalf("waiter: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("waiter did not get the lock after it was released")
+	}
+	if err := held.Release(); err != nil {
+		t.Errorf("second Release: %v; want no-op", err)
+	}
+}
@@ -0,0 +1,272 @@
+package kvstore
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/OG-Open-Source/PanelBase/internal/atomicfile"
+)
+
+const (
+	defaultKVDir  = "data/kv"      // Kept outside ext/plugins so plugin updates (which recreate the plugin dir) keep their data
+	globalFile    = "_global.json" // Namespace file for plugin-wide keys (no container)
+	MaxKeyLength  = 256            // Maximum key length in bytes
+	MaxValueBytes = 1 << 20        // 1MB limit per value
+)
+
+// Namespace scopes keys to a plugin and, optionally, to one container.
+// An empty ContainerID addresses the plugin-wide namespace.
+type Namespace struct {
+	PluginID    string
+	ContainerID string
+}
+
+// Validate checks that the namespace IDs are usable as file names.
+func (ns Namespace) Validate() error {
+	if err := validateIDComponent("plugin ID", ns.PluginID); err != nil {
+		return err
+	}
+	if ns.ContainerID != "" {
+		if err := validateIDComponent("container ID", ns.ContainerID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateIDComponent(label, id string) error {
+	if strings.TrimSpace(id) == "" {
+		return fmt.Errorf("%s cannot be empty", label)
+	}
+	if strings.ContainsAny(id, "/\\") || id == "." || id == ".." || strings.HasPrefix(id, "_") {
+		return fmt.Errorf("invalid %s '%s'", label, id)
+	}
+	return nil
+}
+
+// Store is an on-disk key-value store with one JSON file per namespace:
+// <dir>/<plugin_id>/_global.json and <dir>/<plugin_id>/<container_id>.json.
+// Every mutation rewrites the namespace file atomically (temp file + rename).
+type Store struct {
+	dir   string
+	mu    sync.Mutex
+	cache map[Namespace]map[string][]byte // Loaded namespaces
+}
+
+// NewStore creates a Store rooted at the given directory, or data/kv by default.
+func NewStore(dir ...string) (*Store, error) {
+	root := defaultKVDir
+	if len(dir) > 0 && dir[0] != "" {
+		root = dir[0]
+	}
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create kv directory '%s': %w", root, err)
+	}
+	return &Store{dir: root, cache: make(map[Namespace]map[string][]byte)}, nil
+}
+
+// namespacePath returns the file backing a namespace.
+func (s *Store) namespacePath(ns Namespace) string {
+	name := globalFile
+	if ns.ContainerID != "" {
+		name = ns.ContainerID + ".json"
+	}
+	return filepath.Join(s.dir, ns.PluginID, name)
+}
+
+// load returns the namespace map, reading it from disk on first use. Caller must hold s.mu.
+func (s *Store) load(ns Namespace) (map[string][]byte, error) {
+	if err := ns.Validate(); err != nil {
+		return nil, err
+	}
+	if data, ok := s.cache[ns]; ok {
+		return data, nil
+	}
+	path := s.namespacePath(ns)
+	data := make(map[string][]byte)
+	// A file that fails to parse is restored from its .bak copy when possible.
+	_, _, err := atomicfile.ReadFile(path, func(raw []byte) error {
+		data = make(map[string][]byte)
+		return json.Unmarshal(raw, &data)
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to load kv file '%s': %w", path, err)
+	}
+	s.cache[ns] = data
+	return data, nil
+}
+
+// persist writes a namespace back to disk. An empty namespace removes its file. Caller must hold s.mu.
+func (s *Store) persist(ns Namespace, data map[string][]byte) error {
+	path := s.namespacePath(ns)
+	if len(data) == 0 {
+		if err := atomicfile.Remove(path); err != nil {
+			return fmt.Errorf("failed to remove empty kv file '%s': %w", path, err)
+		}
+		return nil
+	}
+	raw, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal kv namespace: %w", err)
+	}
+	return atomicfile.WriteFile(path, raw, 0600) // Plugin data stays private to the server user
+}
+
+func validateKey(key string) error {
+	if key == "" {
+		return fmt.Errorf("key cannot be empty")
+	}
+	if len(key) > MaxKeyLength {
+		return fmt.Errorf("key exceeds %d bytes", MaxKeyLength)
+	}
+	return nil
+}
+
+func validateValue(value []byte) error {
+	if len(value) > MaxValueBytes {
+		return fmt.Errorf("value exceeds %d bytes", MaxValueBytes)
+	}
+	return nil
+}
+
+// Get returns the value stored under key and whether it exists.
+func (s *Store) Get(ns Namespace, key string) ([]byte, bool, error) {
+	if err := validateKey(key); err != nil {
+		return nil, false, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, err := s.load(ns)
+	if err != nil {
+		return nil, false, err
+	}
+	value, ok := data[key]
+	if !ok {
+		return nil, false, nil
+	}
+	return append([]byte(nil), value...), true, nil
+}
+
+// Set stores value under key, replacing any previous value.
+func (s *Store) Set(ns Namespace, key string, value []byte) error {
+	if err := validateKey(key); err != nil {
+		return err
+	}
+	if err := validateValue(value); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, err := s.load(ns)
+	if err != nil {
+		return err
+	}
+	return s.apply(ns, data, key, value, true)
+}
+
+// Delete removes key. It reports whether the key existed.
+func (s *Store) Delete(ns Namespace, key string) (bool, error) {
+	if err := validateKey(key); err != nil {
+		return false, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, err := s.load(ns)
+	if err != nil {
+		return false, err
+	}
+	if _, ok := data[key]; !ok {
+		return false, nil
+	}
+	return true, s.apply(ns, data, key, nil, false)
+}
+
+// List returns the sorted keys of a namespace that start with prefix.
+func (s *Store) List(ns Namespace, prefix string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, err := s.load(ns)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// CompareAndSwap sets key to value only if its current value equals expected.
+// With expectAbsent, the swap only succeeds if the key does not exist yet (expected is ignored).
+// It reports whether the swap happened.
+func (s *Store) CompareAndSwap(ns Namespace, key string, expected []byte, expectAbsent bool, value []byte) (bool, error) {
+	if err := validateKey(key); err != nil {
+		return false, err
+	}
+	if err := validateValue(value); err != nil {
+		return false, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, err := s.load(ns)
+	if err != nil {
+		return false, err
+	}
+	current, exists := data[key]
+	if expectAbsent {
+		if exists {
+			return false, nil
+		}
+	} else if !exists || !bytes.Equal(current, expected) {
+		return false, nil
+	}
+	return true, s.apply(ns, data, key, value, true)
+}
+
+// apply mutates a namespace and persists it, rolling the in-memory copy back if the write fails.
+// Caller must hold s.mu.
+func (s *Store) apply(ns Namespace, data map[string][]byte, key string, value []byte, set bool) error {
+	previous, existed := data[key]
+	if set {
+		data[key] = append([]byte(nil), value...)
+	} else {
+		delete(data, key)
+	}
+	if err := s.persist(ns, data); err != nil {
+		if existed {
+			data[key] = previous
+		} else {
+			delete(data, key)
+		}
+		return err
+	}
+	return nil
+}
+
+// RemovePlugin deletes every namespace of a plugin, including container-scoped ones.
+func (s *Store) RemovePlugin(pluginID string) error {
+	if err := validateIDComponent("plugin ID", pluginID); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for ns := range s.cache {
+		if ns.PluginID == pluginID {
+			delete(s.cache, ns)
+		}
+	}
+	path := filepath.Join(s.dir, pluginID)
+	if err := os.RemoveAll(path); err != nil {
+		return fmt.Errorf("failed to remove kv data '%s': %w", path, err)
+	}
+	return nil
+}
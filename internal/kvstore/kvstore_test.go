@@ -0,0 +1,102 @@
+package kvstore
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestStoreOperations(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewStore(dir)
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	global := Namespace{PluginID: "plg_test"}
+	scoped := Namespace{PluginID: "plg_test", ContainerID: "ctr_one"}
+
+	if err := store.Set(global, "app/name", []byte("demo")); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err := store.Set(global, "app/mode", []byte("fast")); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err := store.Set(scoped, "app/name", []byte("scoped")); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+
+	// Namespaces are isolated from each other.
+	if value, ok, _ := store.Get(scoped, "app/name"); !ok || string(value) != "scoped" {
+		t.Errorf("Get(scoped) = %q, %v; want \"scoped\", true", value, ok)
+	}
+
+	keys, err := store.List(global, "app/")
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if want := []string{"app/mode", "app/name"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("List = %v, want %v", keys, want)
+	}
+
+	tests := []struct {
+		name         string
+		expected     string
+		expectAbsent bool
+		wantSwapped  bool
+	}{
+		{"mismatch", "other", false, false},
+		{"absent required but present", "", true, false},
+		{"match", "demo", false, true},
+	}
+	for _, tt := range tests {
+		swapped, err := store.CompareAndSwap(global, "app/name", []byte(tt.expected), tt.expectAbsent, []byte("swapped"))
+		if err != nil {
+			t.Fatalf("%s: CompareAndSwap failed: %v", tt.name, err)
+		}
+		if swapped != tt.wantSwapped {
+			t.Errorf("%s: swapped = %v, want %v", tt.name, swapped, tt.wantSwapped)
+		}
+	}
+
+	// Data survives a fresh store reading the same directory.
+	reopened, err := NewStore(dir)
+	if err != nil {
+		t.Fatalf("NewStore (reopen) failed: %v", err)
+	}
+	if value, ok, _ := reopened.Get(global, "app/name"); !ok || string(value) != "swapped" {
+		t.Errorf("Get after reopen = %q, %v; want \"swapped\", true", value, ok)
+	}
+
+	if existed, err := store.Delete(global, "app/mode"); err != nil || !existed {
+		t.Errorf("Delete = %v, %v; want true, nil", existed, err)
+	}
+
+	if err := store.RemovePlugin("plg_test"); err != nil {
+		t.Fatalf("RemovePlugin failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "plg_test")); !os.IsNotExist(err) {
+		t.Errorf("plugin kv directory still exists after RemovePlugin")
+	}
+	if _, ok, _ := store.Get(scoped, "app/name"); ok {
+		t.Errorf("scoped key still readable after RemovePlugin")
+	}
+}
+
+func TestNamespaceValidate(t *testing.T) {
+	tests := []struct {
+		ns      Namespace
+		wantErr bool
+	}{
+		{Namespace{PluginID: "plg_a"}, false},
+		{Namespace{PluginID: "plg_a", ContainerID: "ctr_b"}, false},
+		{Namespace{}, true},
+		{Namespace{PluginID: "../plg_a"}, true},
+		{Namespace{PluginID: "plg_a", ContainerID: "_global"}, true},
+	}
+	for _, tt := range tests {
+		if err := tt.ns.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("Validate(%+v) error = %v, wantErr %v", tt.ns, err, tt.wantErr)
+		}
+	}
+}
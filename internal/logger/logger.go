@@ -6,11 +6,14 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
+	"unicode"
 )
 
-const logDir = "logs" // Added constant
+const defaultLogDir = "logs" // Used when NewLogger is called without a directory
 
 // Logger defines the structure for our simplified logger.
 type Logger struct {
@@ -21,15 +24,19 @@ type Logger struct {
 // NewLogger creates and returns a new Logger instance.
 // It initializes loggers for different levels, writing to both stdout and a log file.
 // Returns an error if log directory or file cannot be created/opened.
-func NewLogger() (*Logger, error) { // Modified signature
+func NewLogger(logDir ...string) (*Logger, error) {
+	dir := defaultLogDir
+	if len(logDir) > 0 && logDir[0] != "" {
+		dir = logDir[0]
+	}
 	// Ensure log directory exists
-	if err := os.MkdirAll(logDir, 0755); err != nil {
-		return nil, fmt.Errorf("failed to create log directory '%s': %w", logDir, err)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create log directory '%s': %w", dir, err)
 	}
 
 	// Generate log filename (RFC3339 with underscores instead of colons)
 	logFilename := fmt.Sprintf("%s.log", strings.ReplaceAll(time.Now().UTC().Format(time.RFC3339), ":", "_"))
-	logFilePath := filepath.Join(logDir, logFilename)
+	logFilePath := filepath.Join(dir, logFilename)
 
 	// Open/Create log file for appending
 	logFile, err := os.OpenFile(logFilePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
@@ -77,6 +84,64 @@ func (l *Logger) Logf(format string, v ...interface{}) {
 	l.logger.Println(formatMessage(fmt.Sprintf(format, v...)))
 }
 
+// LogFields logs a message followed by structured key=value fields.
+// Keys are sorted so that the same fields always produce the same line.
+// Values containing spaces, quotes, '=' or control characters are quoted.
+func (l *Logger) LogFields(message string, fields map[string]string) {
+	l.logger.Println(formatMessage(message + FormatFields(fields)))
+}
+
+// FormatFields renders fields as " key=value key2=value2", or "" when there are none.
+func FormatFields(fields map[string]string) string {
+	if len(fields) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, k := range keys {
+		v := fields[k]
+		if v == "" || strings.ContainsAny(v, " \t\"=") || HasControl(v) {
+			v = strconv.Quote(v)
+		}
+		b.WriteString(" " + k + "=" + v)
+	}
+	return b.String()
+}
+
+// HasControl reports whether s contains a control or non-printable rune,
+// which could forge or break up log lines if written verbatim.
+func HasControl(s string) bool {
+	for _, r := range s {
+		if r < 0x20 || !unicode.IsPrint(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// EscapeControl replaces control and non-printable runes in s with their Go escape sequences
+// (e.g. a newline becomes `\n`), leaving everything else, quotes included, untouched.
+func EscapeControl(s string) string {
+	if !HasControl(s) {
+		return s
+	}
+	var b strings.Builder
+	for _, r := range s {
+		if r < 0x20 || !unicode.IsPrint(r) {
+			quoted := strconv.QuoteRune(r)
+			b.WriteString(quoted[1 : len(quoted)-1])
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
 // PrintProgress prints a message to the logger's writer without a timestamp or newline,
 // appending a carriage return to allow overwriting the current line.
 // This is intended for progress indicators.
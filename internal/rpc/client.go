@@ -0,0 +1,176 @@
+package rpc
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/rpc"
+	"strings"
+	"time"
+
+	"github.com/OG-Open-Source/PanelBase/internal/container"
+	"github.com/OG-Open-Source/PanelBase/internal/extension/themes"
+)
+
+const clientDialTimeout = 2 * time.Second // Short timeout; a stale runtime file should not stall the CLI
+
+// ErrServerNotRunning is returned by ConnectToRunningServer when no server can be reached.
+// Callers fall back to in-process managers in that case.
+var ErrServerNotRunning = errors.New("panelbase server is not running")
+
+// Client is a CLI-side connection to the management services of a running server.
+type Client struct {
+	rpcClient *rpc.Client
+	info      *RuntimeInfo
+}
+
+// ConnectToRunningServer reads the runtime info file and dials the server it describes.
+// It returns ErrServerNotRunning when the file is missing or the address does not answer.
+func ConnectToRunningServer(runtimePath ...string) (*Client, error) {
+	info, err := ReadRuntimeInfo(runtimePath...)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrServerNotRunning, err)
+	}
+	conn, err := net.DialTimeout("tcp", info.Address, clientDialTimeout)
+	if err != nil {
+		// The file is left behind when the server is killed without cleanup; treat it as stale.
+		return nil, fmt.Errorf("%w: cannot reach '%s' (pid %d): %v", ErrServerNotRunning, info.Address, info.PID, err)
+	}
+	return &Client{rpcClient: rpc.NewClient(conn), info: info}, nil
+}
+
+// Info returns the runtime info of the server this client is connected to.
+func (c *Client) Info() *RuntimeInfo {
+	return c.info
+}
+
+// Close closes the connection to the server.
+func (c *Client) Close() error {
+	return c.rpcClient.Close()
+}
+
+// call invokes a remote method and converts well-known error strings back into sentinels,
+// since net/rpc only transports the error text.
+func (c *Client) call(method string, args interface{}, reply interface{}) error {
+	err := c.rpcClient.Call(method, args, reply)
+	if err == nil {
+		return nil
+	}
+	if strings.Contains(err.Error(), themes.ErrThemeAlreadyExistsNoForce.Error()) {
+		return themes.ErrThemeAlreadyExistsNoForce
+	}
+	return err
+}
+
+// Status returns the server's process information and RPC call metrics.
+func (c *Client) Status() (*StatusReply, error) {
+	var reply StatusReply
+	if err := c.call("AdminService.Status", AuthArgs{Token: c.info.Token}, &reply); err != nil {
+		return nil, err
+	}
+	return &reply, nil
+}
+
+// StartContainer asks the server to start a container's web server.
+func (c *Client) StartContainer(id string) error {
+	return c.call("ContainerService.Start", IDArgs{Token: c.info.Token, ID: id}, &struct{}{})
+}
+
+// StopContainer asks the server to stop a container's web server.
+func (c *Client) StopContainer(id string) error {
+	return c.call("ContainerService.Stop", IDArgs{Token: c.info.Token, ID: id}, &struct{}{})
+}
+
+// ApplyContainerTheme asks the server to set the theme of a container; an empty themeID removes it.
+func (c *Client) ApplyContainerTheme(id, themeID string) error {
+	return c.call("ContainerService.ApplyTheme", ApplyThemeArgs{Token: c.info.Token, ID: id, ThemeID: themeID}, &struct{}{})
+}
+
+// SetContainerSettings asks the server to validate and store UI settings of a container.
+func (c *Client) SetContainerSettings(id string, values map[string]string) error {
+	return c.call("ContainerService.SetSettings", SettingsArgs{Token: c.info.Token, ID: id, Values: values}, &struct{}{})
+}
+
+// ListContainers returns the containers known to the server, sorted by ID.
+func (c *Client) ListContainers() ([]container.ContainerInfo, error) {
+	var infos []container.ContainerInfo
+	if err := c.call("ContainerService.List", AuthArgs{Token: c.info.Token}, &infos); err != nil {
+		return nil, err
+	}
+	return infos, nil
+}
+
+// InstallTheme asks the server to install a theme.
+func (c *Client) InstallTheme(source string, force bool) (*ExtensionReply, error) {
+	return c.install("ThemeService.Install", source, force)
+}
+
+// UpdateTheme asks the server to update a theme.
+func (c *Client) UpdateTheme(id string) (*ExtensionReply, error) {
+	return c.update("ThemeService.Update", id)
+}
+
+// RollbackTheme asks the server to restore the previous version of a theme.
+func (c *Client) RollbackTheme(id string) (*ExtensionReply, error) {
+	return c.update("ThemeService.Rollback", id)
+}
+
+// RemoveTheme asks the server to remove a theme.
+func (c *Client) RemoveTheme(id string) error {
+	return c.call("ThemeService.Remove", IDArgs{Token: c.info.Token, ID: id}, &struct{}{})
+}
+
+// InstallPlugin asks the server to install a plugin.
+func (c *Client) InstallPlugin(source string, force bool) (*ExtensionReply, error) {
+	return c.install("PluginService.Install", source, force)
+}
+
+// UpdatePlugin asks the server to update a plugin.
+func (c *Client) UpdatePlugin(id string) (*ExtensionReply, error) {
+	return c.update("PluginService.Update", id)
+}
+
+// RemovePlugin asks the server to remove a plugin.
+func (c *Client) RemovePlugin(id string) error {
+	return c.call("PluginService.Remove", IDArgs{Token: c.info.Token, ID: id}, &struct{}{})
+}
+
+// InstallCommand asks the server to install a command script.
+func (c *Client) InstallCommand(source string, force bool) (*ExtensionReply, error) {
+	return c.install("CommandService.Install", source, force)
+}
+
+// UpdateCommand asks the server to update a command script.
+func (c *Client) UpdateCommand(name string) (*ExtensionReply, error) {
+	return c.update("CommandService.Update", name)
+}
+
+// RemoveCommand asks the server to remove a command script.
+func (c *Client) RemoveCommand(name string) error {
+	return c.call("CommandService.Remove", IDArgs{Token: c.info.Token, ID: name}, &struct{}{})
+}
+
+// RunCommand asks the server to run a command script and returns its output and exit code.
+func (c *Client) RunCommand(name, containerID string, args []string) (*RunCommandReply, error) {
+	var reply RunCommandReply
+	if err := c.call("CommandService.Run", RunCommandArgs{Token: c.info.Token, Name: name, ContainerID: containerID, Args: args}, &reply); err != nil {
+		return nil, err
+	}
+	return &reply, nil
+}
+
+func (c *Client) install(method, source string, force bool) (*ExtensionReply, error) {
+	var reply ExtensionReply
+	if err := c.call(method, InstallArgs{Token: c.info.Token, Source: source, Force: force}, &reply); err != nil {
+		return nil, err
+	}
+	return &reply, nil
+}
+
+func (c *Client) update(method, id string) (*ExtensionReply, error) {
+	var reply ExtensionReply
+	if err := c.call(method, IDArgs{Token: c.info.Token, ID: id}, &reply); err != nil {
+		return nil, err
+	}
+	return &reply, nil
+}
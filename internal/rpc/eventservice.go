@@ -0,0 +1,224 @@
+package rpc
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/OG-Open-Source/PanelBase/internal/events"
+	"github.com/OG-Open-Source/PanelBase/internal/logger"
+	"github.com/OG-Open-Source/PanelBase/internal/utils"
+)
+
+const (
+	defaultPollTimeout      = 30 * time.Second // Used when a Poll call does not specify a timeout
+	maxPollTimeout          = 60 * time.Second // Upper bound so calls cannot hang forever
+	defaultPollMaxEvents    = 100              // Events returned per Poll when MaxEvents is not set
+	subscriptionIdleTimeout = 2 * time.Minute  // Subscriptions not polled for this long are removed
+	subscriptionSweepPeriod = 30 * time.Second // How often idle subscriptions are checked
+)
+
+// --- Event Service (lifecycle notifications for plugins) ---
+
+// rpcSubscription is a bus subscription owned by the plugin that created it.
+type rpcSubscription struct {
+	sub      *events.Subscription
+	pluginID string // Authenticated owner; only this plugin may poll or remove the subscription
+	lastPoll time.Time
+	polling  int // Number of Poll calls currently waiting; such subscriptions are never swept
+}
+
+// eventSubscriptions holds the subscriptions of all plugin connections. It is shared by
+// every connection's EventServiceRPC, so a plugin can reconnect and keep polling, and so
+// abandoned subscriptions are swept in one place.
+type eventSubscriptions struct {
+	bus       *events.Bus
+	idGen     *utils.IDGenerator
+	appLogger *logger.Logger
+	mu        sync.Mutex
+	subs      map[string]*rpcSubscription // Keyed by subscription ID
+}
+
+// EventServiceRPC lets plugins subscribe to lifecycle events with topic filters and
+// receive them by long-polling. The plugin is the one the connection logged in as
+// (see PluginAuthService.Login), and a subscription can only be used by the plugin that created it.
+type EventServiceRPC struct {
+	registry *eventSubscriptions
+	session  *pluginSession
+}
+
+// EventSubscribeArgs holds arguments for Subscribe.
+type EventSubscribeArgs struct {
+	Topics []string // Filters such as "container.*" or "theme.installed"; empty subscribes to everything
+}
+
+// EventPollArgs holds arguments for Poll.
+type EventPollArgs struct {
+	SubscriptionID string
+	TimeoutMillis  int // How long to wait for the first event; 0 uses the default
+	MaxEvents      int // Maximum events returned; 0 uses the default
+}
+
+// EventPollReply holds the events delivered by Poll.
+type EventPollReply struct {
+	Events  []events.Event
+	Dropped int // Events lost since the previous Poll because the subscription queue was full
+}
+
+// EventUnsubscribeArgs holds arguments for Unsubscribe.
+type EventUnsubscribeArgs struct {
+	SubscriptionID string
+}
+
+// Subscribe creates a subscription and returns its ID in reply.
+func (s *EventServiceRPC) Subscribe(args EventSubscribeArgs, reply *string) error {
+	pluginID, err := s.session.plugin()
+	if err != nil {
+		return err
+	}
+	for _, topic := range args.Topics {
+		if strings.TrimSpace(topic) == "" {
+			return fmt.Errorf("topic filters cannot be empty")
+		}
+	}
+	r := s.registry
+	id, err := r.idGen.Generate("sub")
+	if err != nil {
+		return fmt.Errorf("failed to generate subscription ID: %w", err)
+	}
+	sub := r.bus.Subscribe(args.Topics...)
+
+	r.mu.Lock()
+	r.subs[id] = &rpcSubscription{sub: sub, pluginID: pluginID, lastPoll: time.Now()}
+	r.mu.Unlock()
+
+	r.appLogger.Logf("Event subscription %s created for plugin '%s' (topics: %s).", id, pluginID, strings.Join(args.Topics, ", "))
+	*reply = id
+	return nil
+}
+
+// Poll waits until at least one event is available or the timeout elapses, then returns
+// every queued event up to MaxEvents. An empty reply means the timeout elapsed.
+func (s *EventServiceRPC) Poll(args EventPollArgs, reply *EventPollReply) error {
+	pluginID, err := s.session.plugin()
+	if err != nil {
+		return err
+	}
+	r := s.registry
+	r.mu.Lock()
+	rs, ok := r.subs[args.SubscriptionID]
+	if !ok || rs.pluginID != pluginID {
+		r.mu.Unlock()
+		return fmt.Errorf("subscription '%s' not found (it may have expired)", args.SubscriptionID)
+	}
+	rs.polling++
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		rs.polling--
+		rs.lastPoll = time.Now()
+		r.mu.Unlock()
+	}()
+
+	timeout := time.Duration(args.TimeoutMillis) * time.Millisecond
+	if timeout <= 0 {
+		timeout = defaultPollTimeout
+	}
+	if timeout > maxPollTimeout {
+		timeout = maxPollTimeout
+	}
+	maxEvents := args.MaxEvents
+	if maxEvents <= 0 {
+		maxEvents = defaultPollMaxEvents
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	var collected []events.Event
+	select {
+	case event, open := <-rs.sub.C:
+		if !open {
+			return fmt.Errorf("subscription '%s' was closed", args.SubscriptionID)
+		}
+		collected = append(collected, event)
+	case <-timer.C:
+	}
+	// Drain whatever else is already queued without waiting.
+drain:
+	for len(collected) > 0 && len(collected) < maxEvents {
+		select {
+		case event, open := <-rs.sub.C:
+			if !open {
+				break drain
+			}
+			collected = append(collected, event)
+		default:
+			break drain
+		}
+	}
+
+	*reply = EventPollReply{Events: collected, Dropped: rs.sub.Dropped()}
+	return nil
+}
+
+// Unsubscribe removes a subscription of the calling plugin.
+func (s *EventServiceRPC) Unsubscribe(args EventUnsubscribeArgs, reply *struct{}) error {
+	pluginID, err := s.session.plugin()
+	if err != nil {
+		return err
+	}
+	r := s.registry
+	r.mu.Lock()
+	rs, ok := r.subs[args.SubscriptionID]
+	if !ok || rs.pluginID != pluginID {
+		r.mu.Unlock()
+		return fmt.Errorf("subscription '%s' not found", args.SubscriptionID)
+	}
+	delete(r.subs, args.SubscriptionID)
+	r.mu.Unlock()
+	r.bus.Unsubscribe(rs.sub)
+	return nil
+}
+
+// sweepIdle removes subscriptions whose owners stopped polling, so abandoned
+// subscriptions do not keep queueing events forever.
+func (r *eventSubscriptions) sweepIdle(now time.Time) {
+	r.mu.Lock()
+	var expired []*rpcSubscription
+	for id, rs := range r.subs {
+		if rs.polling == 0 && now.Sub(rs.lastPoll) > subscriptionIdleTimeout {
+			expired = append(expired, rs)
+			delete(r.subs, id)
+			r.appLogger.Logf("Event subscription %s of plugin '%s' expired after %s without polling.", id, rs.pluginID, subscriptionIdleTimeout)
+		}
+	}
+	r.mu.Unlock()
+	for _, rs := range expired {
+		r.bus.Unsubscribe(rs.sub)
+	}
+}
+
+// RegisterEventService registers the EventService backed by bus. It must be called before StartRPCServer.
+func RegisterEventService(appLogger *logger.Logger, bus *events.Bus, idGen *utils.IDGenerator) error {
+	if appLogger == nil || bus == nil || idGen == nil {
+		return fmt.Errorf("logger, event bus and id generator must be provided to register EventService")
+	}
+	registry := &eventSubscriptions{bus: bus, idGen: idGen, appLogger: appLogger, subs: make(map[string]*rpcSubscription)}
+	err := registerPluginService("EventService", func(session *pluginSession) interface{} {
+		return &EventServiceRPC{registry: registry, session: session}
+	})
+	if err != nil {
+		return fmt.Errorf("failed to register EventService for RPC: %w", err)
+	}
+	go func() {
+		ticker := time.NewTicker(subscriptionSweepPeriod)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			registry.sweepIdle(now)
+		}
+	}()
+	appLogger.Log("Event RPC service registered.")
+	return nil
+}
@@ -0,0 +1,125 @@
+package rpc
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/OG-Open-Source/PanelBase/internal/configuration"
+	"github.com/OG-Open-Source/PanelBase/internal/events"
+	"github.com/OG-Open-Source/PanelBase/internal/logger"
+	"github.com/OG-Open-Source/PanelBase/internal/utils"
+)
+
+func TestEventServiceSubscribePollAndExpiry(t *testing.T) {
+	log, err := logger.NewLogger(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+	idGen, _ := utils.NewIDGenerator(&configuration.SecurityConfig{Secrets: configuration.SecretsConfig{Alphabet: "abc123", Length: 8}})
+	bus := events.NewBus()
+	registry := &eventSubscriptions{bus: bus, idGen: idGen, appLogger: log, subs: make(map[string]*rpcSubscription)}
+	service := &EventServiceRPC{registry: registry, session: &pluginSession{pluginID: "plg_a"}}
+
+	var id string
+	if err := service.Subscribe(EventSubscribeArgs{Topics: []string{"theme.*"}}, &id); err != nil {
+		t.Fatal(err)
+	}
+
+	// A poll without events returns empty once the timeout elapses.
+	var reply EventPollReply
+	start := time.Now()
+	if err := service.Poll(EventPollArgs{SubscriptionID: id, TimeoutMillis: 20}, &reply); err != nil || len(reply.Events) != 0 {
+		t.Fatalf("idle Poll = %+v, %v; want no events", reply, err)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("idle Poll returned after %s; want it to wait for the timeout", elapsed)
+	}
+
+	// A waiting poll is woken by a matching event and collects what is queued behind it.
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		bus.Publish(events.TopicContainerStarted, nil) // Filtered out
+		bus.Publish(events.TopicThemeInstalled, map[string]string{"theme_id": "thm_a"})
+		bus.Publish(events.TopicThemeRemoved, map[string]string{"theme_id": "thm_a"})
+	}()
+	reply = EventPollReply{}
+	deadline := time.Now().Add(2 * time.Second)
+	for len(reply.Events) < 2 && time.Now().Before(deadline) {
+		var more EventPollReply
+		if err := service.Poll(EventPollArgs{SubscriptionID: id, TimeoutMillis: 1000}, &more); err != nil {
+			t.Fatal(err)
+		}
+		reply.Events = append(reply.Events, more.Events...)
+	}
+	if len(reply.Events) != 2 || reply.Events[0].Topic != events.TopicThemeInstalled || reply.Events[1].Topic != events.TopicThemeRemoved {
+		t.Fatalf("Poll collected %+v; want theme.installed then theme.removed", reply.Events)
+	}
+
+	// Subscriptions that are not polled expire; polling them afterwards fails.
+	registry.sweepIdle(time.Now().Add(subscriptionIdleTimeout / 2))
+	if err := service.Poll(EventPollArgs{SubscriptionID: id, TimeoutMillis: 1}, &reply); err != nil {
+		t.Fatalf("Poll before expiry: %v", err)
+	}
+	registry.sweepIdle(time.Now().Add(subscriptionIdleTimeout + time.Second))
+	if err := service.Poll(EventPollArgs{SubscriptionID: id, TimeoutMillis: 1}, &reply); err == nil || !strings.Contains(err.Error(), "expired") {
+		t.Errorf("Poll after expiry: err = %v; want not found (expired)", err)
+	}
+}
+
+func TestEventServiceRequiresLoginAndOwnership(t *testing.T) {
+	log, err := logger.NewLogger(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+	idGen, _ := utils.NewIDGenerator(&configuration.SecurityConfig{Secrets: configuration.SecretsConfig{Alphabet: "abc123", Length: 8}})
+	registry := &eventSubscriptions{bus: events.NewBus(), idGen: idGen, appLogger: log, subs: make(map[string]*rpcSubscription)}
+	service := map[string]func(session *pluginSession) interface{}{
+		"EventService": func(session *pluginSession) interface{} {
+			return &EventServiceRPC{registry: registry, session: session}
+		},
+	}
+	alice := dialPluginConn(t, "plg_alice", service)
+	bob := dialPluginConn(t, "plg_bob", service)
+	anonymous := dialPluginConn(t, "", service)
+
+	var id string
+	if err := anonymous.Call("EventService.Subscribe", EventSubscribeArgs{}, &id); err == nil || !strings.Contains(err.Error(), "not authenticated") {
+		t.Errorf("Subscribe before login: err = %v; want not authenticated", err)
+	}
+	if err := alice.Call("EventService.Subscribe", EventSubscribeArgs{Topics: []string{"theme.*"}}, &id); err != nil {
+		t.Fatal(err)
+	}
+	registry.mu.Lock()
+	owner := registry.subs[id].pluginID
+	registry.mu.Unlock()
+	if owner != "plg_alice" {
+		t.Errorf("subscription owner = %q; want plg_alice", owner)
+	}
+
+	poll := EventPollArgs{SubscriptionID: id, TimeoutMillis: 1}
+	var reply EventPollReply
+	if err := anonymous.Call("EventService.Poll", poll, &reply); err == nil || !strings.Contains(err.Error(), "not authenticated") {
+		t.Errorf("Poll before login: err = %v; want not authenticated", err)
+	}
+	if err := bob.Call("EventService.Poll", poll, &reply); err == nil {
+		t.Errorf("Poll of another plugin's subscription succeeded")
+	}
+	unsubscribe := EventUnsubscribeArgs{SubscriptionID: id}
+	if err := anonymous.Call("EventService.Unsubscribe", unsubscribe, &struct{}{}); err == nil || !strings.Contains(err.Error(), "not authenticated") {
+		t.Errorf("Unsubscribe before login: err = %v; want not authenticated", err)
+	}
+	if err := bob.Call("EventService.Unsubscribe", unsubscribe, &struct{}{}); err == nil {
+		t.Errorf("Unsubscribe of another plugin's subscription succeeded")
+	}
+
+	// The rejected calls left the subscription intact for its owner.
+	if err := alice.Call("EventService.Poll", poll, &reply); err != nil {
+		t.Errorf("Poll by owner: %v", err)
+	}
+	if err := alice.Call("EventService.Unsubscribe", unsubscribe, &struct{}{}); err != nil {
+		t.Errorf("Unsubscribe by owner: %v", err)
+	}
+}
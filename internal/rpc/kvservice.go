@@ -0,0 +1,142 @@
+package rpc
+
+import (
+	"fmt"
+
+	"github.com/OG-Open-Source/PanelBase/internal/kvstore"
+	"github.com/OG-Open-Source/PanelBase/internal/logger"
+)
+
+// --- Key-Value Service (persistent plugin state) ---
+
+// KVServiceRPC exposes the key-value store to plugins. Keys are namespaced per plugin
+// and, when ContainerID is set, per container. The plugin is the one the connection logged in
+// as (see PluginAuthService.Login), so a plugin cannot reach another plugin's keys.
+type KVServiceRPC struct {
+	store   *kvstore.Store
+	session *pluginSession
+}
+
+// KVKeyArgs addresses a single key.
+type KVKeyArgs struct {
+	ContainerID string // Optional; empty for plugin-wide keys
+	Key         string
+}
+
+// KVSetArgs holds arguments for Set.
+type KVSetArgs struct {
+	ContainerID string
+	Key         string
+	Value       []byte
+}
+
+// KVListArgs holds arguments for List.
+type KVListArgs struct {
+	ContainerID string
+	Prefix      string // Empty lists every key in the namespace
+}
+
+// KVCompareAndSwapArgs holds arguments for CompareAndSwap.
+// gob cannot distinguish a nil slice from an empty one, so "key must not exist" is explicit.
+type KVCompareAndSwapArgs struct {
+	ContainerID  string
+	Key          string
+	Expected     []byte // Ignored when ExpectAbsent is true
+	ExpectAbsent bool
+	Value        []byte
+}
+
+// KVGetReply holds the result of Get.
+type KVGetReply struct {
+	Value []byte
+	Found bool
+}
+
+// namespace returns the namespace of the logged-in plugin for containerID.
+func (s *KVServiceRPC) namespace(containerID string) (kvstore.Namespace, error) {
+	pluginID, err := s.session.plugin()
+	if err != nil {
+		return kvstore.Namespace{}, err
+	}
+	return kvstore.Namespace{PluginID: pluginID, ContainerID: containerID}, nil
+}
+
+// Get returns the value stored under a key.
+func (s *KVServiceRPC) Get(args KVKeyArgs, reply *KVGetReply) error {
+	ns, err := s.namespace(args.ContainerID)
+	if err != nil {
+		return err
+	}
+	value, found, err := s.store.Get(ns, args.Key)
+	if err != nil {
+		return err
+	}
+	*reply = KVGetReply{Value: value, Found: found}
+	return nil
+}
+
+// Set stores a value under a key.
+func (s *KVServiceRPC) Set(args KVSetArgs, reply *struct{}) error {
+	ns, err := s.namespace(args.ContainerID)
+	if err != nil {
+		return err
+	}
+	return s.store.Set(ns, args.Key, args.Value)
+}
+
+// Delete removes a key; reply reports whether it existed.
+func (s *KVServiceRPC) Delete(args KVKeyArgs, reply *bool) error {
+	ns, err := s.namespace(args.ContainerID)
+	if err != nil {
+		return err
+	}
+	existed, err := s.store.Delete(ns, args.Key)
+	if err != nil {
+		return err
+	}
+	*reply = existed
+	return nil
+}
+
+// List returns the sorted keys of a namespace that start with a prefix.
+func (s *KVServiceRPC) List(args KVListArgs, reply *[]string) error {
+	ns, err := s.namespace(args.ContainerID)
+	if err != nil {
+		return err
+	}
+	keys, err := s.store.List(ns, args.Prefix)
+	if err != nil {
+		return err
+	}
+	*reply = keys
+	return nil
+}
+
+// CompareAndSwap sets a key only if it still holds the expected value; reply reports success.
+func (s *KVServiceRPC) CompareAndSwap(args KVCompareAndSwapArgs, reply *bool) error {
+	ns, err := s.namespace(args.ContainerID)
+	if err != nil {
+		return err
+	}
+	swapped, err := s.store.CompareAndSwap(ns, args.Key, args.Expected, args.ExpectAbsent, args.Value)
+	if err != nil {
+		return err
+	}
+	*reply = swapped
+	return nil
+}
+
+// RegisterKVService registers the KVService backed by store. It must be called before StartRPCServer.
+func RegisterKVService(appLogger *logger.Logger, store *kvstore.Store) error {
+	if appLogger == nil || store == nil {
+		return fmt.Errorf("logger and kv store must be provided to register KVService")
+	}
+	err := registerPluginService("KVService", func(session *pluginSession) interface{} {
+		return &KVServiceRPC{store: store, session: session}
+	})
+	if err != nil {
+		return fmt.Errorf("failed to register KVService for RPC: %w", err)
+	}
+	appLogger.Log("KV RPC service registered.")
+	return nil
+}
@@ -0,0 +1,75 @@
+package rpc
+
+import (
+	"net"
+	"net/rpc"
+	"testing"
+
+	"github.com/OG-Open-Source/PanelBase/internal/kvstore"
+	"github.com/OG-Open-Source/PanelBase/internal/logger"
+)
+
+// dialPluginConn serves one plugin connection with PluginAuthService and the given services, and
+// logs it in as pluginID unless pluginID is empty.
+func dialPluginConn(t *testing.T, pluginID string, services map[string]func(session *pluginSession) interface{}) *rpc.Client {
+	t.Helper()
+	log, err := logger.NewLogger(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { log.Close() })
+	credentials, err := NewPluginCredentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+	all := map[string]func(session *pluginSession) interface{}{
+		"PluginAuthService": func(session *pluginSession) interface{} {
+			return &PluginAuthServiceRPC{credentials: credentials, session: session, appLogger: log}
+		},
+	}
+	for name, build := range services {
+		all[name] = build
+	}
+	server, err := newPluginConnServer(all)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverConn, clientConn := net.Pipe()
+	go server.ServeConn(serverConn)
+	client := rpc.NewClient(clientConn)
+	t.Cleanup(func() { client.Close() })
+	if pluginID != "" {
+		login := PluginLoginArgs{PluginID: pluginID, Token: credentials.Token(pluginID)}
+		if err := client.Call("PluginAuthService.Login", login, &struct{}{}); err != nil {
+			t.Fatalf("Login as %s: %v", pluginID, err)
+		}
+	}
+	return client
+}
+
+func TestKVServiceNamespaceFollowsLogin(t *testing.T) {
+	store, err := kvstore.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	kv := map[string]func(session *pluginSession) interface{}{
+		"KVService": func(session *pluginSession) interface{} { return &KVServiceRPC{store: store, session: session} },
+	}
+	alice := dialPluginConn(t, "plg_alice", kv)
+	bob := dialPluginConn(t, "plg_bob", kv)
+	anonymous := dialPluginConn(t, "", kv)
+
+	if err := alice.Call("KVService.Set", KVSetArgs{Key: "token", Value: []byte("a")}, &struct{}{}); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	var reply KVGetReply
+	if err := bob.Call("KVService.Get", KVKeyArgs{Key: "token"}, &reply); err != nil || reply.Found {
+		t.Errorf("plg_bob sees plg_alice's key: found = %v, err = %v", reply.Found, err)
+	}
+	if err := anonymous.Call("KVService.Get", KVKeyArgs{Key: "token"}, &reply); err == nil {
+		t.Errorf("Get without login succeeded")
+	}
+	if value, found, _ := store.Get(kvstore.Namespace{PluginID: "plg_alice"}, "token"); !found || string(value) != "a" {
+		t.Errorf("value stored under %q, found = %v; want it in plg_alice's namespace", value, found)
+	}
+}
@@ -0,0 +1,397 @@
+package rpc
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/rpc"
+	"os"
+	"runtime"
+	"sort"
+	"time"
+
+	"github.com/OG-Open-Source/PanelBase/internal/configuration"
+	"github.com/OG-Open-Source/PanelBase/internal/container"
+	"github.com/OG-Open-Source/PanelBase/internal/extension/commands"
+	"github.com/OG-Open-Source/PanelBase/internal/extension/plugins"
+	"github.com/OG-Open-Source/PanelBase/internal/extension/themes"
+	"github.com/OG-Open-Source/PanelBase/internal/logger"
+)
+
+// --- Management Services (used by CLI invocations while the server is running) ---
+
+// Managers bundles the core managers whose mutations are exposed to the CLI over RPC.
+type Managers struct {
+	Containers *container.ContainerManager
+	Themes     *themes.ThemeManager
+	Plugins    *plugins.PluginManager
+	Commands   *commands.CommandManager
+}
+
+// errInvalidToken is returned when a management call does not carry the server's token.
+var errInvalidToken = fmt.Errorf("invalid or missing management token")
+
+// tokenChecker validates the shared management token carried by every management call.
+type tokenChecker struct {
+	token string
+}
+
+func (t tokenChecker) check(token string) error {
+	if t.token == "" || subtle.ConstantTimeCompare([]byte(t.token), []byte(token)) != 1 {
+		return errInvalidToken
+	}
+	return nil
+}
+
+// AuthArgs holds only the management token, for calls that take no other arguments.
+type AuthArgs struct {
+	Token string
+}
+
+// IDArgs identifies a single container or extension by its ID.
+type IDArgs struct {
+	Token string
+	ID    string // Container ID, theme ID, plugin ID or command name depending on the service
+}
+
+// InstallArgs holds arguments for the Install methods of the extension services.
+type InstallArgs struct {
+	Token  string
+	Source string // URL or local path; local paths are resolved by the server process
+	Force  bool
+}
+
+// ExtensionReply describes an extension after an install or update.
+// Changed is false when an update found nothing newer to install.
+type ExtensionReply struct {
+	Name    string
+	Version string
+	Changed bool
+}
+
+// ContainerServiceRPC exposes ContainerManager lifecycle operations.
+type ContainerServiceRPC struct {
+	tokenChecker
+	manager *container.ContainerManager
+}
+
+// Start starts the web server of a container inside the server process.
+func (s *ContainerServiceRPC) Start(args IDArgs, reply *struct{}) error {
+	if err := s.check(args.Token); err != nil {
+		return err
+	}
+	return s.manager.StartWebServer(args.ID)
+}
+
+// Stop stops the web server of a container inside the server process.
+func (s *ContainerServiceRPC) Stop(args IDArgs, reply *struct{}) error {
+	if err := s.check(args.Token); err != nil {
+		return err
+	}
+	return s.manager.StopWebServer(args.ID)
+}
+
+// ApplyThemeArgs selects the theme served by a container; an empty ThemeID removes it.
+type ApplyThemeArgs struct {
+	Token   string
+	ID      string // Container ID
+	ThemeID string
+}
+
+// ApplyTheme sets the theme of a container inside the server process.
+func (s *ContainerServiceRPC) ApplyTheme(args ApplyThemeArgs, reply *struct{}) error {
+	if err := s.check(args.Token); err != nil {
+		return err
+	}
+	return s.manager.ApplyTheme(args.ID, args.ThemeID)
+}
+
+// SettingsArgs holds UI setting assignments for a container, as given on the command line.
+type SettingsArgs struct {
+	Token  string
+	ID     string            // Container ID
+	Values map[string]string // Raw values; validated against the applied theme's settings schema
+}
+
+// SetSettings stores UI settings of a container inside the server process.
+func (s *ContainerServiceRPC) SetSettings(args SettingsArgs, reply *struct{}) error {
+	if err := s.check(args.Token); err != nil {
+		return err
+	}
+	return s.manager.SetUISettings(args.ID, args.Values)
+}
+
+// List returns the runtime info of every container known to the server, sorted by ID.
+func (s *ContainerServiceRPC) List(args AuthArgs, reply *[]container.ContainerInfo) error {
+	if err := s.check(args.Token); err != nil {
+		return err
+	}
+	ids := s.manager.ListContainers()
+	sort.Strings(ids)
+	infos := make([]container.ContainerInfo, 0, len(ids))
+	for _, id := range ids {
+		if info, ok := s.manager.GetContainerInfo(id); ok {
+			infos = append(infos, *info)
+		}
+	}
+	*reply = infos
+	return nil
+}
+
+// ThemeServiceRPC exposes theme installation, update and removal.
+type ThemeServiceRPC struct {
+	tokenChecker
+	manager *themes.ThemeManager
+}
+
+// Install installs a theme through the server's ThemeManager.
+func (s *ThemeServiceRPC) Install(args InstallArgs, reply *ExtensionReply) error {
+	if err := s.check(args.Token); err != nil {
+		return err
+	}
+	meta, err := themes.Install(s.manager, args.Source, args.Force)
+	if err != nil {
+		return err
+	}
+	*reply = ExtensionReply{Name: meta.Name, Version: meta.Version, Changed: true}
+	return nil
+}
+
+// Update updates an installed theme through the server's ThemeManager.
+func (s *ThemeServiceRPC) Update(args IDArgs, reply *ExtensionReply) error {
+	if err := s.check(args.Token); err != nil {
+		return err
+	}
+	// themes.Update returns the local metadata when nothing newer exists, so compare versions.
+	previousVersion := ""
+	if state, err := configuration.LoadThemesState(); err == nil {
+		previousVersion = state[args.ID].Version
+	}
+	meta, err := themes.Update(s.manager, args.ID)
+	if err != nil {
+		return err
+	}
+	if meta != nil {
+		*reply = ExtensionReply{Name: meta.Name, Version: meta.Version, Changed: meta.Version != previousVersion}
+	}
+	return nil
+}
+
+// Rollback restores the previous version of a theme through the server's ThemeManager.
+func (s *ThemeServiceRPC) Rollback(args IDArgs, reply *ExtensionReply) error {
+	if err := s.check(args.Token); err != nil {
+		return err
+	}
+	meta, err := themes.Rollback(s.manager, args.ID)
+	if err != nil {
+		return err
+	}
+	*reply = ExtensionReply{Name: meta.Name, Version: meta.Version, Changed: true}
+	return nil
+}
+
+// Remove removes an installed theme through the server's ThemeManager.
+func (s *ThemeServiceRPC) Remove(args IDArgs, reply *struct{}) error {
+	if err := s.check(args.Token); err != nil {
+		return err
+	}
+	return themes.Remove(s.manager, args.ID)
+}
+
+// PluginServiceRPC exposes plugin installation, update and removal.
+type PluginServiceRPC struct {
+	tokenChecker
+	manager *plugins.PluginManager
+}
+
+// Install installs a plugin through the server's PluginManager.
+func (s *PluginServiceRPC) Install(args InstallArgs, reply *ExtensionReply) error {
+	if err := s.check(args.Token); err != nil {
+		return err
+	}
+	meta, err := s.manager.InstallPlugin(args.Source, args.Force)
+	if err != nil {
+		return err
+	}
+	*reply = ExtensionReply{Name: meta.Name, Version: meta.Version, Changed: true}
+	return nil
+}
+
+// Update updates an installed plugin through the server's PluginManager.
+func (s *PluginServiceRPC) Update(args IDArgs, reply *ExtensionReply) error {
+	if err := s.check(args.Token); err != nil {
+		return err
+	}
+	meta, err := s.manager.UpdatePlugin(args.ID)
+	if err != nil {
+		return err
+	}
+	if meta != nil { // UpdatePlugin returns nil metadata when already up to date
+		*reply = ExtensionReply{Name: meta.Name, Version: meta.Version, Changed: true}
+	}
+	return nil
+}
+
+// Remove removes an installed plugin through the server's PluginManager.
+func (s *PluginServiceRPC) Remove(args IDArgs, reply *struct{}) error {
+	if err := s.check(args.Token); err != nil {
+		return err
+	}
+	return s.manager.RemovePlugin(args.ID)
+}
+
+// CommandServiceRPC exposes command script installation, update and removal.
+type CommandServiceRPC struct {
+	tokenChecker
+	manager *commands.CommandManager
+}
+
+// Install installs a command script through the server's CommandManager.
+func (s *CommandServiceRPC) Install(args InstallArgs, reply *ExtensionReply) error {
+	if err := s.check(args.Token); err != nil {
+		return err
+	}
+	meta, err := s.manager.InstallCommand(args.Source, args.Force)
+	if err != nil {
+		return err
+	}
+	*reply = ExtensionReply{Name: meta.Command, Version: meta.Version, Changed: true}
+	return nil
+}
+
+// Update updates an installed command script through the server's CommandManager.
+func (s *CommandServiceRPC) Update(args IDArgs, reply *ExtensionReply) error {
+	if err := s.check(args.Token); err != nil {
+		return err
+	}
+	// UpdateCommand returns the current metadata when nothing newer exists, so compare versions.
+	previousVersion := ""
+	for _, installed := range s.manager.ListInstalledCommands() {
+		if installed.Command == args.ID {
+			previousVersion = installed.Version
+		}
+	}
+	meta, err := s.manager.UpdateCommand(args.ID)
+	if err != nil {
+		return err
+	}
+	*reply = ExtensionReply{Name: meta.Command, Version: meta.Version, Changed: meta.Version != previousVersion}
+	return nil
+}
+
+// Remove removes an installed command script through the server's CommandManager.
+func (s *CommandServiceRPC) Remove(args IDArgs, reply *struct{}) error {
+	if err := s.check(args.Token); err != nil {
+		return err
+	}
+	return s.manager.RemoveCommand(args.ID)
+}
+
+// RunCommandArgs holds arguments for CommandService.Run.
+type RunCommandArgs struct {
+	Token       string
+	Name        string
+	ContainerID string // Optional; selects the container whose secrets are injected
+	Args        []string
+}
+
+// RunCommandReply holds the captured output and exit code of a command run.
+type RunCommandReply struct {
+	Stdout   []byte
+	Stderr   []byte
+	ExitCode int
+}
+
+// Run runs an installed command script in the server process, so it receives the server's
+// secrets and its completion is published on the event bus.
+func (s *CommandServiceRPC) Run(args RunCommandArgs, reply *RunCommandReply) error {
+	if err := s.check(args.Token); err != nil {
+		return err
+	}
+	var stdout, stderr bytes.Buffer
+	exitCode, err := s.manager.RunCommand(args.Name, args.ContainerID, args.Args, &stdout, &stderr)
+	if err != nil {
+		return err
+	}
+	*reply = RunCommandReply{Stdout: stdout.Bytes(), Stderr: stderr.Bytes(), ExitCode: exitCode}
+	return nil
+}
+
+// AdminServiceRPC exposes server introspection to the CLI.
+type AdminServiceRPC struct {
+	tokenChecker
+	startedAt time.Time
+}
+
+// StatusReply describes the running server and its RPC call statistics.
+type StatusReply struct {
+	PID        int
+	StartedAt  time.Time
+	Goroutines int
+	Methods    []MethodStats // Sorted by method name
+}
+
+// Status returns process information and per-method RPC metrics.
+func (s *AdminServiceRPC) Status(args AuthArgs, reply *StatusReply) error {
+	if err := s.check(args.Token); err != nil {
+		return err
+	}
+	*reply = StatusReply{
+		PID:        os.Getpid(),
+		StartedAt:  s.startedAt,
+		Goroutines: runtime.NumGoroutine(),
+		Methods:    serverMetrics.snapshot(),
+	}
+	return nil
+}
+
+// StartManagementServer serves the container and extension management services on their own
+// listener, bound to the loopback interface with a port chosen by the system, so they are never
+// reachable through the plugin listener on server.host. Every call must also carry authToken,
+// which the server publishes only through the runtime info file. It returns the address to dial.
+func StartManagementServer(appLogger *logger.Logger, mgrs *Managers, authToken string) (string, error) {
+	if appLogger == nil || mgrs == nil {
+		return "", fmt.Errorf("logger and managers must be provided to start the management server")
+	}
+	if mgrs.Containers == nil || mgrs.Themes == nil || mgrs.Plugins == nil || mgrs.Commands == nil {
+		return "", fmt.Errorf("all managers must be initialized to start the management server")
+	}
+	if authToken == "" {
+		return "", fmt.Errorf("management token cannot be empty")
+	}
+	checker := tokenChecker{token: authToken}
+
+	server := rpc.NewServer() // Not the default server, which the plugin listener serves
+	services := map[string]interface{}{
+		"ContainerService": &ContainerServiceRPC{tokenChecker: checker, manager: mgrs.Containers},
+		"ThemeService":     &ThemeServiceRPC{tokenChecker: checker, manager: mgrs.Themes},
+		"PluginService":    &PluginServiceRPC{tokenChecker: checker, manager: mgrs.Plugins},
+		"CommandService":   &CommandServiceRPC{tokenChecker: checker, manager: mgrs.Commands},
+		"AdminService":     &AdminServiceRPC{tokenChecker: checker, startedAt: time.Now().UTC()},
+	}
+	for name, service := range services {
+		if err := server.RegisterName(name, service); err != nil {
+			return "", fmt.Errorf("failed to register %s for RPC: %w", name, err)
+		}
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", fmt.Errorf("failed to listen on loopback address for management RPC: %w", err)
+	}
+	go func() {
+		defer listener.Close()
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				appLogger.Logf("Management RPC server stopped accepting connections: %v", err)
+				return
+			}
+			go server.ServeCodec(newMetricsServerCodec(newGobServerCodec(conn), serverMetrics))
+		}
+	}()
+	address := listener.Addr().String()
+	appLogger.Logf("Management RPC services (containers, themes, plugins, commands, admin) listening on %s.", address)
+	return address, nil
+}
@@ -0,0 +1,238 @@
+package rpc
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/OG-Open-Source/PanelBase/internal/configuration"
+	"github.com/OG-Open-Source/PanelBase/internal/container"
+	"github.com/OG-Open-Source/PanelBase/internal/extension/commands"
+	"github.com/OG-Open-Source/PanelBase/internal/extension/plugins"
+	"github.com/OG-Open-Source/PanelBase/internal/extension/themes"
+	"github.com/OG-Open-Source/PanelBase/internal/logger"
+	"github.com/OG-Open-Source/PanelBase/internal/utils"
+)
+
+// startTestManagement starts the management services over fresh managers and returns a client
+// connected through a runtime info file, as the CLI would be.
+func startTestManagement(t *testing.T) (*Client, *Managers) {
+	t.Helper()
+	log, err := logger.NewLogger(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { log.Close() })
+	idGen, err := utils.NewIDGenerator(&configuration.SecurityConfig{Secrets: configuration.SecretsConfig{Alphabet: "abcdef0123456789", Length: 12}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	configuration.SetStateDir(t.TempDir())
+	base := t.TempDir()
+	mgrs := &Managers{}
+	if mgrs.Containers, err = container.NewContainerManager(idGen, "127.0.0.1", log, filepath.Join(base, "containers")); err != nil {
+		t.Fatal(err)
+	}
+	if mgrs.Themes, err = themes.NewThemeManager(log, idGen, filepath.Join(base, "themes")); err != nil {
+		t.Fatal(err)
+	}
+	if mgrs.Plugins, err = plugins.NewPluginManager(log, idGen, filepath.Join(base, "plugins")); err != nil {
+		t.Fatal(err)
+	}
+	if mgrs.Commands, err = commands.NewCommandManager(log, idGen, filepath.Join(base, "commands")); err != nil {
+		t.Fatal(err)
+	}
+	mgrs.Containers.SetThemeResolver(func(id string) ([]string, error) { return themes.Layers(mgrs.Themes, id) })
+
+	address, err := StartManagementServer(log, mgrs, "management-token")
+	if err != nil {
+		t.Fatalf("StartManagementServer: %v", err)
+	}
+	runtimePath := filepath.Join(base, "runtime.json")
+	if err := WriteRuntimeInfo(&RuntimeInfo{PID: os.Getpid(), Address: address, Token: "management-token"}, runtimePath); err != nil {
+		t.Fatal(err)
+	}
+	client, err := ConnectToRunningServer(runtimePath)
+	if err != nil {
+		t.Fatalf("ConnectToRunningServer: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client, mgrs
+}
+
+// packManagementTheme packs a one-file theme and returns the archive path.
+func packManagementTheme(t *testing.T, tm *themes.ThemeManager, content string) string {
+	t.Helper()
+	src := t.TempDir()
+	if err := os.WriteFile(filepath.Join(src, "index.html"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte(content))
+	manifest := fmt.Sprintf(`name: Demo
+authors: [{name: t}]
+version: 1.0.0
+description: d
+source_link: https://example.com/demo/theme.yaml
+structure:
+  index.html: {url: https://example.com/demo/index.html, sum: %s}
+`, hex.EncodeToString(sum[:]))
+	if err := os.WriteFile(filepath.Join(src, "theme.yaml"), []byte(manifest), 0644); err != nil {
+		t.Fatal(err)
+	}
+	archivePath := filepath.Join(t.TempDir(), "demo.zip")
+	if _, err := themes.Pack(tm, src, archivePath); err != nil {
+		t.Fatalf("Pack: %v", err)
+	}
+	return archivePath
+}
+
+func TestManagementRejectsWrongToken(t *testing.T) {
+	client, mgrs := startTestManagement(t)
+	info, err := mgrs.Containers.CreateContainer("demo", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, token := range []string{"", "wrong-token"} {
+		impostor := &Client{rpcClient: client.rpcClient, info: &RuntimeInfo{Address: client.info.Address, Token: token}}
+		calls := map[string]func() error{
+			"Status":         func() error { _, err := impostor.Status(); return err },
+			"StartContainer": func() error { return impostor.StartContainer(info.ID) },
+			"ListContainers": func() error { _, err := impostor.ListContainers(); return err },
+			"RemoveTheme":    func() error { return impostor.RemoveTheme("thm_x") },
+			"RemovePlugin":   func() error { return impostor.RemovePlugin("plg_x") },
+			"RunCommand":     func() error { _, err := impostor.RunCommand("x", "", nil); return err },
+		}
+		for name, call := range calls {
+			if err := call(); err == nil || !strings.Contains(err.Error(), errInvalidToken.Error()) {
+				t.Errorf("%s with token %q = %v; want %v", name, token, err, errInvalidToken)
+			}
+		}
+	}
+	if got, _ := mgrs.Containers.GetContainerInfo(info.ID); got.Status != container.StatusStopped {
+		t.Errorf("container status after rejected calls = %s; want stopped", got.Status)
+	}
+}
+
+func TestManagementRoutesMutationsToManagers(t *testing.T) {
+	client, mgrs := startTestManagement(t)
+
+	// Containers
+	free, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := free.Addr().(*net.TCPAddr).Port
+	free.Close()
+	created, err := mgrs.Containers.CreateContainer("demo", port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	containerStatus := func() container.ContainerStatus {
+		info, _ := mgrs.Containers.GetContainerInfo(created.ID)
+		return info.Status
+	}
+	if err := client.StartContainer(created.ID); err != nil || containerStatus() != container.StatusRunning {
+		t.Fatalf("StartContainer = %v, status %s; want running", err, containerStatus())
+	}
+	if err := client.StopContainer(created.ID); err != nil || containerStatus() != container.StatusStopped {
+		t.Fatalf("StopContainer = %v, status %s; want stopped", err, containerStatus())
+	}
+	if infos, err := client.ListContainers(); err != nil || len(infos) != 1 || infos[0].ID != created.ID {
+		t.Errorf("ListContainers = %+v, %v; want the one container", infos, err)
+	}
+
+	// Themes, including the sentinel error restored from its text
+	archivePath := packManagementTheme(t, mgrs.Themes, "one")
+	if reply, err := client.InstallTheme(archivePath, false); err != nil || reply.Name != "Demo" || !reply.Changed {
+		t.Fatalf("InstallTheme = %+v, %v", reply, err)
+	}
+	if _, err := client.InstallTheme(archivePath, false); !errors.Is(err, themes.ErrThemeAlreadyExistsNoForce) {
+		t.Errorf("second InstallTheme = %v; want ErrThemeAlreadyExistsNoForce", err)
+	}
+	state, err := configuration.LoadThemesState()
+	if err != nil || len(state) != 1 {
+		t.Fatalf("themes state = %v, %v; want one theme", state, err)
+	}
+	var themeID string
+	for id := range state {
+		themeID = id
+	}
+	if err := client.ApplyContainerTheme(created.ID, themeID); err != nil {
+		t.Fatalf("ApplyContainerTheme: %v", err)
+	}
+	if info, _ := mgrs.Containers.GetContainerInfo(created.ID); info.Theme != themeID {
+		t.Errorf("applied theme = %q; want %q", info.Theme, themeID)
+	}
+	if err := client.SetContainerSettings(created.ID, map[string]string{"x": "1"}); err == nil {
+		t.Error("SetContainerSettings accepted a setting without a settings resolver")
+	}
+	if reply, err := client.InstallTheme(packManagementTheme(t, mgrs.Themes, "two"), true); err != nil || !reply.Changed {
+		t.Fatalf("forced InstallTheme = %+v, %v", reply, err)
+	}
+	if reply, err := client.RollbackTheme(themeID); err != nil || !reply.Changed {
+		t.Errorf("RollbackTheme = %+v, %v", reply, err)
+	}
+	if err := client.ApplyContainerTheme(created.ID, ""); err != nil {
+		t.Fatalf("ApplyContainerTheme(none): %v", err)
+	}
+	if err := client.RemoveTheme(themeID); err != nil {
+		t.Fatalf("RemoveTheme: %v", err)
+	}
+	if state, _ := configuration.LoadThemesState(); len(state) != 0 {
+		t.Errorf("themes state after RemoveTheme = %v; want empty", state)
+	}
+
+	// Commands
+	script := filepath.Join(t.TempDir(), "hello.sh")
+	if err := os.WriteFile(script, []byte(`#!/bin/sh
+# @@command: hello
+# @@pkg_managers: apt
+# @@dependencies:
+# @@authors: t
+# @@version: 1.0.0
+# @@description: d
+# @@source_link: https://example.com/hello.sh
+echo "hello $1"
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if reply, err := client.InstallCommand(script, false); err != nil || reply.Name != "hello" {
+		t.Fatalf("InstallCommand = %+v, %v", reply, err)
+	}
+	if run, err := client.RunCommand("hello", "", []string{"world"}); err != nil || string(run.Stdout) != "hello world\n" || run.ExitCode != 0 {
+		t.Errorf("RunCommand = %+v, %v", run, err)
+	}
+	if err := client.RemoveCommand("hello"); err != nil {
+		t.Fatalf("RemoveCommand: %v", err)
+	}
+	if installed := mgrs.Commands.ListInstalledCommands(); len(installed) != 0 {
+		t.Errorf("commands after RemoveCommand = %+v; want none", installed)
+	}
+
+	// Plugins: the managers' own errors come back, so the calls reached them
+	if err := client.RemovePlugin("plg_missing"); err == nil || !strings.Contains(err.Error(), "plg_missing") {
+		t.Errorf("RemovePlugin(missing) = %v; want the manager's error", err)
+	}
+	if _, err := client.UpdatePlugin("plg_missing"); err == nil || !strings.Contains(err.Error(), "plg_missing") {
+		t.Errorf("UpdatePlugin(missing) = %v; want the manager's error", err)
+	}
+
+	status, err := client.Status()
+	if err != nil || status.PID != os.Getpid() {
+		t.Fatalf("Status = %+v, %v", status, err)
+	}
+	found := false
+	for _, m := range status.Methods {
+		found = found || m.Method == "ContainerService.Start"
+	}
+	if !found {
+		t.Errorf("Status methods = %+v; want ContainerService.Start among them", status.Methods)
+	}
+}
@@ -0,0 +1,253 @@
+package rpc
+
+import (
+	"bufio"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/rpc"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// latencyBuckets are the upper bounds (in seconds) of the call latency histogram.
+var latencyBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+const (
+	maxTrackedMethods = 256     // Unknown method names beyond this are folded into otherMethodLabel
+	otherMethodLabel  = "other" // Label for calls that exceed maxTrackedMethods
+)
+
+// serverMetrics collects statistics for every call served by StartRPCServer.
+var serverMetrics = newCallMetrics()
+
+// methodStats holds the counters for one RPC method.
+type methodStats struct {
+	calls   uint64
+	errors  uint64
+	sum     time.Duration
+	max     time.Duration
+	buckets []uint64 // Non-cumulative counts per latencyBuckets entry; the extra last slot is +Inf
+}
+
+// callMetrics aggregates per-method call counts, error counts and latency histograms.
+type callMetrics struct {
+	mu      sync.Mutex
+	methods map[string]*methodStats
+}
+
+func newCallMetrics() *callMetrics {
+	return &callMetrics{methods: make(map[string]*methodStats)}
+}
+
+// record adds one finished call.
+func (m *callMetrics) record(method string, elapsed time.Duration, failed bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats, ok := m.methods[method]
+	if !ok {
+		if len(m.methods) >= maxTrackedMethods {
+			method = otherMethodLabel
+			stats = m.methods[method]
+		}
+		if stats == nil {
+			stats = &methodStats{buckets: make([]uint64, len(latencyBuckets)+1)}
+			m.methods[method] = stats
+		}
+	}
+	stats.calls++
+	if failed {
+		stats.errors++
+	}
+	stats.sum += elapsed
+	if elapsed > stats.max {
+		stats.max = elapsed
+	}
+	seconds := elapsed.Seconds()
+	idx := sort.SearchFloat64s(latencyBuckets, seconds) // First bucket whose bound >= seconds
+	stats.buckets[idx]++
+}
+
+// MethodStats is a point-in-time copy of the statistics of one RPC method.
+type MethodStats struct {
+	Method     string
+	Calls      uint64
+	Errors     uint64
+	TotalTime  time.Duration
+	MaxTime    time.Duration
+	Buckets    []uint64  // Cumulative counts per BoundsSecs entry (Prometheus "le" semantics)
+	BoundsSecs []float64 // Upper bounds in seconds, shared by every method
+}
+
+// AvgTime returns the mean call latency.
+func (s MethodStats) AvgTime() time.Duration {
+	if s.Calls == 0 {
+		return 0
+	}
+	return s.TotalTime / time.Duration(s.Calls)
+}
+
+// snapshot returns the statistics of every method, sorted by method name.
+func (m *callMetrics) snapshot() []MethodStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	result := make([]MethodStats, 0, len(m.methods))
+	for method, stats := range m.methods {
+		cumulative := make([]uint64, len(latencyBuckets))
+		var running uint64
+		for i := range latencyBuckets {
+			running += stats.buckets[i]
+			cumulative[i] = running
+		}
+		result = append(result, MethodStats{
+			Method:     method,
+			Calls:      stats.calls,
+			Errors:     stats.errors,
+			TotalTime:  stats.sum,
+			MaxTime:    stats.max,
+			Buckets:    cumulative,
+			BoundsSecs: latencyBuckets,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Method < result[j].Method })
+	return result
+}
+
+// writePrometheus writes the metrics in the Prometheus text exposition format.
+func (m *callMetrics) writePrometheus(w io.Writer) error {
+	snapshot := m.snapshot()
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintln(bw, "# HELP panelbase_rpc_calls_total Total number of RPC calls by method.")
+	fmt.Fprintln(bw, "# TYPE panelbase_rpc_calls_total counter")
+	for _, s := range snapshot {
+		fmt.Fprintf(bw, "panelbase_rpc_calls_total{method=%q} %d\n", s.Method, s.Calls)
+	}
+
+	fmt.Fprintln(bw, "# HELP panelbase_rpc_errors_total Total number of RPC calls that returned an error, by method.")
+	fmt.Fprintln(bw, "# TYPE panelbase_rpc_errors_total counter")
+	for _, s := range snapshot {
+		fmt.Fprintf(bw, "panelbase_rpc_errors_total{method=%q} %d\n", s.Method, s.Errors)
+	}
+
+	fmt.Fprintln(bw, "# HELP panelbase_rpc_call_duration_seconds RPC call latency by method.")
+	fmt.Fprintln(bw, "# TYPE panelbase_rpc_call_duration_seconds histogram")
+	for _, s := range snapshot {
+		for i, bound := range s.BoundsSecs {
+			fmt.Fprintf(bw, "panelbase_rpc_call_duration_seconds_bucket{method=%q,le=%q} %d\n", s.Method, strconv.FormatFloat(bound, 'g', -1, 64), s.Buckets[i])
+		}
+		fmt.Fprintf(bw, "panelbase_rpc_call_duration_seconds_bucket{method=%q,le=\"+Inf\"} %d\n", s.Method, s.Calls)
+		fmt.Fprintf(bw, "panelbase_rpc_call_duration_seconds_sum{method=%q} %s\n", s.Method, strconv.FormatFloat(s.TotalTime.Seconds(), 'g', -1, 64))
+		fmt.Fprintf(bw, "panelbase_rpc_call_duration_seconds_count{method=%q} %d\n", s.Method, s.Calls)
+	}
+	return bw.Flush()
+}
+
+// MetricsHandler serves the RPC metrics in the Prometheus text format.
+func MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if err := serverMetrics.writePrometheus(w); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
+// --- Codec ---
+
+// gobServerCodec is the gob codec net/rpc uses for ServeConn. It is not exported by
+// net/rpc, so it is reproduced here to be wrapped by metricsServerCodec.
+type gobServerCodec struct {
+	rwc    io.ReadWriteCloser
+	dec    *gob.Decoder
+	enc    *gob.Encoder
+	encBuf *bufio.Writer
+	closed bool
+}
+
+func newGobServerCodec(conn io.ReadWriteCloser) *gobServerCodec {
+	buf := bufio.NewWriter(conn)
+	return &gobServerCodec{rwc: conn, dec: gob.NewDecoder(conn), enc: gob.NewEncoder(buf), encBuf: buf}
+}
+
+func (c *gobServerCodec) ReadRequestHeader(r *rpc.Request) error {
+	return c.dec.Decode(r)
+}
+
+func (c *gobServerCodec) ReadRequestBody(body interface{}) error {
+	return c.dec.Decode(body)
+}
+
+func (c *gobServerCodec) WriteResponse(r *rpc.Response, body interface{}) (err error) {
+	if err = c.enc.Encode(r); err != nil {
+		if c.encBuf.Flush() == nil {
+			// Gob couldn't encode the header. Should not happen, so if it does,
+			// shut down the connection to signal that the connection is broken.
+			log.Println("rpc: gob error encoding response:", err)
+			c.Close()
+		}
+		return
+	}
+	if err = c.enc.Encode(body); err != nil {
+		if c.encBuf.Flush() == nil {
+			// Was a gob problem encoding the body but the header has been written.
+			// Shut down the connection to signal that the connection is broken.
+			log.Println("rpc: gob error encoding body:", err)
+			c.Close()
+		}
+		return
+	}
+	return c.encBuf.Flush()
+}
+
+func (c *gobServerCodec) Close() error {
+	if c.closed {
+		// Only call c.rwc.Close once; otherwise the semantics are undefined.
+		return nil
+	}
+	c.closed = true
+	return c.rwc.Close()
+}
+
+// metricsServerCodec wraps a ServerCodec and records the latency and outcome of every call,
+// measured from reading the request header to writing the response.
+type metricsServerCodec struct {
+	rpc.ServerCodec
+	metrics *callMetrics
+	mu      sync.Mutex
+	pending map[uint64]pendingCall // Keyed by request sequence number (unique per connection)
+}
+
+type pendingCall struct {
+	method string
+	start  time.Time
+}
+
+func newMetricsServerCodec(inner rpc.ServerCodec, metrics *callMetrics) *metricsServerCodec {
+	return &metricsServerCodec{ServerCodec: inner, metrics: metrics, pending: make(map[uint64]pendingCall)}
+}
+
+func (c *metricsServerCodec) ReadRequestHeader(r *rpc.Request) error {
+	if err := c.ServerCodec.ReadRequestHeader(r); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.pending[r.Seq] = pendingCall{method: r.ServiceMethod, start: time.Now()}
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *metricsServerCodec) WriteResponse(r *rpc.Response, body interface{}) error {
+	c.mu.Lock()
+	call, ok := c.pending[r.Seq]
+	delete(c.pending, r.Seq)
+	c.mu.Unlock()
+	if ok {
+		c.metrics.record(call.method, time.Since(call.start), r.Error != "")
+	}
+	return c.ServerCodec.WriteResponse(r, body)
+}
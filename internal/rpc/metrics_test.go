@@ -0,0 +1,135 @@
+package rpc
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http/httptest"
+	"net/rpc"
+	"strings"
+	"testing"
+	"time"
+)
+
+// metricsTestService is served through the metrics codec in tests.
+type metricsTestService struct{}
+
+func (metricsTestService) Sleep(millis int, reply *int) error {
+	time.Sleep(time.Duration(millis) * time.Millisecond)
+	*reply = millis
+	return nil
+}
+
+func (metricsTestService) Fail(args int, reply *int) error {
+	return errors.New("failed on purpose")
+}
+
+func TestMetricsCodecRecordsCalls(t *testing.T) {
+	metrics := newCallMetrics()
+	server := rpc.NewServer()
+	if err := server.RegisterName("Test", metricsTestService{}); err != nil {
+		t.Fatal(err)
+	}
+	serverConn, clientConn := net.Pipe()
+	// The same codec stack StartRPCServer uses for every connection.
+	go server.ServeCodec(newMetricsServerCodec(newGobServerCodec(serverConn), metrics))
+	client := rpc.NewClient(clientConn)
+	defer client.Close()
+
+	var reply int
+	if err := client.Call("Test.Sleep", 30, &reply); err != nil || reply != 30 {
+		t.Fatalf("Sleep = %d, %v", reply, err)
+	}
+	if err := client.Call("Test.Fail", 0, &reply); err == nil {
+		t.Fatalf("Fail returned no error")
+	}
+	if err := client.Call("Test.Fail", 0, &reply); err == nil {
+		t.Fatalf("Fail returned no error")
+	}
+
+	stats := make(map[string]MethodStats)
+	for _, s := range metrics.snapshot() {
+		stats[s.Method] = s
+	}
+	if len(stats) != 2 {
+		t.Fatalf("tracked methods = %v; want Test.Sleep and Test.Fail", stats)
+	}
+	if s := stats["Test.Fail"]; s.Calls != 2 || s.Errors != 2 {
+		t.Errorf("Test.Fail calls = %d, errors = %d; want 2, 2", s.Calls, s.Errors)
+	}
+	sleep := stats["Test.Sleep"]
+	if sleep.Calls != 1 || sleep.Errors != 0 || sleep.TotalTime < 30*time.Millisecond || sleep.MaxTime != sleep.TotalTime {
+		t.Errorf("Test.Sleep = %+v; want one successful call of at least 30ms", sleep)
+	}
+	// Cumulative buckets: the 30ms call is above the 0.025 bound and within every bound from 0.05 on.
+	for i, bound := range sleep.BoundsSecs {
+		want := uint64(0)
+		if bound >= sleep.TotalTime.Seconds() {
+			want = 1
+		}
+		if sleep.Buckets[i] != want {
+			t.Errorf("Test.Sleep bucket le=%g = %d; want %d", bound, sleep.Buckets[i], want)
+		}
+	}
+	if sleep.Buckets[3] != 0 || sleep.Buckets[len(sleep.Buckets)-1] != 1 { // le=0.025 and le=60
+		t.Errorf("Test.Sleep buckets = %v; want 0 at le=0.025 and 1 at le=60", sleep.Buckets)
+	}
+}
+
+func TestMetricsHandlerWritesHistogram(t *testing.T) {
+	saved := serverMetrics
+	serverMetrics = newCallMetrics()
+	defer func() { serverMetrics = saved }()
+	serverMetrics.record("Test.Quick", 3*time.Millisecond, false)
+	serverMetrics.record("Test.Quick", 20*time.Millisecond, true)
+	serverMetrics.record("Test.Quick", 2*time.Minute, false) // Above every bound; only counted in +Inf
+
+	recorder := httptest.NewRecorder()
+	MetricsHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := recorder.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q; want the Prometheus text format", ct)
+	}
+	body := recorder.Body.String()
+	for _, want := range []string{
+		`panelbase_rpc_calls_total{method="Test.Quick"} 3`,
+		`panelbase_rpc_errors_total{method="Test.Quick"} 1`,
+		`panelbase_rpc_call_duration_seconds_bucket{method="Test.Quick",le="0.001"} 0`,
+		`panelbase_rpc_call_duration_seconds_bucket{method="Test.Quick",le="0.005"} 1`,
+		`panelbase_rpc_call_duration_seconds_bucket{method="Test.Quick",le="0.025"} 2`,
+		`panelbase_rpc_call_duration_seconds_bucket{method="Test.Quick",le="60"} 2`,
+		`panelbase_rpc_call_duration_seconds_bucket{method="Test.Quick",le="+Inf"} 3`,
+		`panelbase_rpc_call_duration_seconds_sum{method="Test.Quick"} 120.023`,
+		`panelbase_rpc_call_duration_seconds_count{method="Test.Quick"} 3`,
+	} {
+		if !strings.Contains(body, want+"\n") {
+			t.Errorf("metrics output is missing %q:\n%s", want, body)
+		}
+	}
+}
+
+func TestMetricsFoldMethodsBeyondCap(t *testing.T) {
+	metrics := newCallMetrics()
+	for i := 0; i < maxTrackedMethods; i++ {
+		metrics.record(fmt.Sprintf("Test.M%d", i), time.Millisecond, false)
+	}
+	metrics.record("Test.Extra1", time.Millisecond, false)
+	metrics.record("Test.Extra2", time.Millisecond, true)
+	metrics.record("Test.M0", time.Millisecond, false) // Tracked before the cap; still counted on its own
+
+	stats := make(map[string]MethodStats)
+	for _, s := range metrics.snapshot() {
+		stats[s.Method] = s
+	}
+	if len(stats) != maxTrackedMethods+1 {
+		t.Errorf("tracked methods = %d; want %d plus %q", len(stats), maxTrackedMethods, otherMethodLabel)
+	}
+	if _, ok := stats["Test.Extra1"]; ok {
+		t.Errorf("method beyond the cap was tracked on its own")
+	}
+	if other := stats[otherMethodLabel]; other.Calls != 2 || other.Errors != 1 {
+		t.Errorf("%q calls = %d, errors = %d; want 2, 1", otherMethodLabel, other.Calls, other.Errors)
+	}
+	if m0 := stats["Test.M0"]; m0.Calls != 2 {
+		t.Errorf("Test.M0 calls = %d; want 2", m0.Calls)
+	}
+}
@@ -0,0 +1,162 @@
+package rpc
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/rpc"
+	"strings"
+	"sync"
+
+	"github.com/OG-Open-Source/PanelBase/internal/logger"
+)
+
+// --- Plugin Authentication (per-connection plugin identity) ---
+
+// errNotAuthenticated is returned by plugin services that act on behalf of a plugin when the
+// connection has not logged in yet.
+var errNotAuthenticated = fmt.Errorf("plugin not authenticated: call PluginAuthService.Login with the token from the plugin's directory first")
+
+// PluginCredentials issues and verifies the tokens plugins authenticate with. A token is an
+// HMAC of the plugin ID under a key generated at server start, so tokens need no storage and
+// all tokens of a previous server run become invalid when it restarts.
+type PluginCredentials struct {
+	key []byte
+}
+
+// NewPluginCredentials creates credentials with a fresh random key.
+func NewPluginCredentials() (*PluginCredentials, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate plugin credential key: %w", err)
+	}
+	return &PluginCredentials{key: key}, nil
+}
+
+// Token returns the token of a plugin.
+func (c *PluginCredentials) Token(pluginID string) string {
+	mac := hmac.New(sha256.New, c.key)
+	mac.Write([]byte(pluginID))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether token belongs to pluginID.
+func (c *PluginCredentials) Verify(pluginID, token string) bool {
+	expected, err := hex.DecodeString(token)
+	if err != nil || pluginID == "" {
+		return false
+	}
+	mac := hmac.New(sha256.New, c.key)
+	mac.Write([]byte(pluginID))
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+// pluginSession holds the identity of one plugin connection. It is empty until the plugin
+// logs in, and services read the plugin ID from it instead of trusting their arguments.
+type pluginSession struct {
+	mu       sync.Mutex
+	pluginID string
+}
+
+// plugin returns the authenticated plugin ID of the connection.
+func (s *pluginSession) plugin() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pluginID == "" {
+		return "", errNotAuthenticated
+	}
+	return s.pluginID, nil
+}
+
+// PluginAuthServiceRPC binds a connection to the plugin whose token it presents.
+type PluginAuthServiceRPC struct {
+	credentials *PluginCredentials
+	session     *pluginSession
+	appLogger   *logger.Logger
+}
+
+// PluginLoginArgs holds a plugin's ID and the token the server wrote to its directory.
+type PluginLoginArgs struct {
+	PluginID string
+	Token    string
+}
+
+// Login authenticates the connection as args.PluginID. A connection can only log in once.
+func (s *PluginAuthServiceRPC) Login(args PluginLoginArgs, reply *struct{}) error {
+	if !s.credentials.Verify(args.PluginID, strings.TrimSpace(args.Token)) {
+		s.appLogger.Logf("Rejected RPC login claiming plugin '%s': invalid token.", args.PluginID)
+		return fmt.Errorf("invalid plugin ID or token")
+	}
+	s.session.mu.Lock()
+	defer s.session.mu.Unlock()
+	if s.session.pluginID != "" && s.session.pluginID != args.PluginID {
+		return fmt.Errorf("connection is already authenticated as plugin '%s'", s.session.pluginID)
+	}
+	s.session.pluginID = args.PluginID
+	return nil
+}
+
+// pluginServiceFactory builds the instance of a plugin service for one connection.
+type pluginServiceFactory struct {
+	name  string
+	build func(session *pluginSession) interface{}
+}
+
+// pluginServices lists the services served on every plugin connection in addition to
+// IDService and LogService, in registration order.
+var pluginServices struct {
+	mu        sync.Mutex
+	factories []pluginServiceFactory
+}
+
+// registerPluginService adds a service to every plugin connection accepted afterwards.
+func registerPluginService(name string, build func(session *pluginSession) interface{}) error {
+	pluginServices.mu.Lock()
+	defer pluginServices.mu.Unlock()
+	for _, f := range pluginServices.factories {
+		if f.name == name {
+			return fmt.Errorf("rpc: service already defined: %s", name)
+		}
+	}
+	pluginServices.factories = append(pluginServices.factories, pluginServiceFactory{name: name, build: build})
+	return nil
+}
+
+// newPluginConnServer returns an RPC server for one plugin connection whose services share
+// a single, initially unauthenticated session.
+func newPluginConnServer(common map[string]func(session *pluginSession) interface{}) (*rpc.Server, error) {
+	session := &pluginSession{}
+	server := rpc.NewServer()
+	for name, build := range common {
+		if err := server.RegisterName(name, build(session)); err != nil {
+			return nil, fmt.Errorf("failed to register %s for RPC: %w", name, err)
+		}
+	}
+	pluginServices.mu.Lock()
+	factories := append([]pluginServiceFactory(nil), pluginServices.factories...)
+	pluginServices.mu.Unlock()
+	for _, f := range factories {
+		if err := server.RegisterName(f.name, f.build(session)); err != nil {
+			return nil, fmt.Errorf("failed to register %s for RPC: %w", f.name, err)
+		}
+	}
+	return server, nil
+}
+
+// RegisterPluginAuth registers the PluginAuthService that plugins log in with. It must be called
+// before StartRPCServer; without it, services that need a plugin identity reject every call.
+func RegisterPluginAuth(appLogger *logger.Logger, credentials *PluginCredentials) error {
+	if appLogger == nil || credentials == nil {
+		return fmt.Errorf("logger and plugin credentials must be provided to register PluginAuthService")
+	}
+	err := registerPluginService("PluginAuthService", func(session *pluginSession) interface{} {
+		return &PluginAuthServiceRPC{credentials: credentials, session: session, appLogger: appLogger}
+	})
+	if err != nil {
+		return fmt.Errorf("failed to register PluginAuthService for RPC: %w", err)
+	}
+	appLogger.Log("Plugin authentication RPC service registered.")
+	return nil
+}
@@ -0,0 +1,169 @@
+package rpc
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/OG-Open-Source/PanelBase/internal/configuration"
+)
+
+// LogLevel is the severity of a structured plugin log entry.
+type LogLevel int
+
+const (
+	LevelDebug LogLevel = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+// String returns the upper-case name used in log lines (e.g., "WARN").
+func (l LogLevel) String() string {
+	switch l {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	default:
+		return fmt.Sprintf("LEVEL(%d)", int(l))
+	}
+}
+
+// ParseLogLevel converts a level name (case-insensitive; "warning" is accepted for "warn").
+func ParseLogLevel(name string) (LogLevel, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "debug":
+		return LevelDebug, nil
+	case "info":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	default:
+		return 0, fmt.Errorf("unknown log level '%s' (expected debug, info, warn or error)", name)
+	}
+}
+
+// bucketSweepPeriod is how often Allow removes the buckets of plugins that stopped logging.
+const bucketSweepPeriod = time.Minute
+
+// tokenBucket tracks the rate limit state of a single plugin.
+type tokenBucket struct {
+	tokens  float64
+	last    time.Time
+	dropped int // Entries dropped since the last accepted entry
+}
+
+// PluginLogPolicy decides which plugin log entries reach the core logger.
+// It applies per-plugin minimum levels and a per-plugin token bucket rate limit.
+// It is safe for concurrent use and can be reconfigured while the server runs.
+type PluginLogPolicy struct {
+	mu           sync.Mutex
+	defaultLevel LogLevel
+	levels       map[string]LogLevel // Keyed by plugin ID
+	perSecond    float64
+	burst        float64
+	buckets      map[string]*tokenBucket // Keyed by the authenticated plugin ID of the connection
+	lastSweep    time.Time
+}
+
+// NewPluginLogPolicy creates a policy from the plugin logging configuration.
+func NewPluginLogPolicy(cfg configuration.PluginLoggingConfig) (*PluginLogPolicy, error) {
+	p := &PluginLogPolicy{buckets: make(map[string]*tokenBucket)}
+	if err := p.Update(cfg); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Update replaces the levels and rate limit. Existing buckets are kept but capped to the new burst.
+func (p *PluginLogPolicy) Update(cfg configuration.PluginLoggingConfig) error {
+	defaultLevel, err := ParseLogLevel(cfg.DefaultLevel)
+	if err != nil {
+		return fmt.Errorf("invalid logging.plugins.default_level: %w", err)
+	}
+	levels := make(map[string]LogLevel, len(cfg.Levels))
+	for pluginID, name := range cfg.Levels {
+		level, err := ParseLogLevel(name)
+		if err != nil {
+			return fmt.Errorf("invalid logging.plugins.levels entry for '%s': %w", pluginID, err)
+		}
+		levels[pluginID] = level
+	}
+	if cfg.RateLimit.PerSecond <= 0 || cfg.RateLimit.Burst <= 0 {
+		return fmt.Errorf("logging.plugins.rate_limit per_second and burst must be positive")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.defaultLevel = defaultLevel
+	p.levels = levels
+	p.perSecond = cfg.RateLimit.PerSecond
+	p.burst = float64(cfg.RateLimit.Burst)
+	for _, b := range p.buckets {
+		if b.tokens > p.burst {
+			b.tokens = p.burst
+		}
+	}
+	return nil
+}
+
+// Enabled reports whether entries at level from pluginID pass the plugin's minimum level.
+func (p *PluginLogPolicy) Enabled(pluginID string, level LogLevel) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	minLevel, ok := p.levels[pluginID]
+	if !ok {
+		minLevel = p.defaultLevel
+	}
+	return level >= minLevel
+}
+
+// Allow takes a token from the plugin's bucket. It returns false when the entry must be dropped.
+// When an entry is allowed after some were dropped, dropped holds how many were lost in between.
+func (p *PluginLogPolicy) Allow(pluginID string, now time.Time) (allowed bool, dropped int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	b, ok := p.buckets[pluginID]
+	if !ok {
+		b = &tokenBucket{tokens: p.burst, last: now}
+		p.buckets[pluginID] = b
+	}
+	// Refill based on the time since the last entry.
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens += elapsed * p.perSecond
+		if b.tokens > p.burst {
+			b.tokens = p.burst
+		}
+	}
+	b.last = now
+	if b.tokens < 1 {
+		b.dropped++
+		return false, 0
+	}
+	b.tokens--
+	dropped, b.dropped = b.dropped, 0
+	if now.Sub(p.lastSweep) >= bucketSweepPeriod {
+		p.sweep(now)
+	}
+	return true, dropped
+}
+
+// sweep removes buckets that have been idle long enough to be full again, since a new bucket
+// behaves the same. Buckets with unreported drops are kept. The caller must hold p.mu.
+func (p *PluginLogPolicy) sweep(now time.Time) {
+	p.lastSweep = now
+	refill := time.Duration(p.burst / p.perSecond * float64(time.Second))
+	for pluginID, b := range p.buckets {
+		if b.dropped == 0 && now.Sub(b.last) >= refill {
+			delete(p.buckets, pluginID)
+		}
+	}
+}
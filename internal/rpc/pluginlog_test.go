@@ -0,0 +1,149 @@
+package rpc
+
+import (
+	"net"
+	"net/rpc"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/OG-Open-Source/PanelBase/internal/configuration"
+	"github.com/OG-Open-Source/PanelBase/internal/logger"
+)
+
+func TestPluginLogPolicyLevelsAndRateLimit(t *testing.T) {
+	policy, err := NewPluginLogPolicy(configuration.PluginLoggingConfig{
+		DefaultLevel: "info",
+		Levels:       map[string]string{"plg_verbose": "debug"},
+		RateLimit:    configuration.RateLimitConfig{PerSecond: 1, Burst: 2},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if policy.Enabled("plg_a", LevelDebug) || !policy.Enabled("plg_a", LevelInfo) || !policy.Enabled("plg_verbose", LevelDebug) {
+		t.Errorf("levels not applied: default info, plg_verbose debug")
+	}
+
+	now := time.Unix(1000, 0)
+	for i, want := range []bool{true, true, false, false} { // Burst of 2, then dropped
+		if allowed, _ := policy.Allow("plg_a", now); allowed != want {
+			t.Errorf("entry %d allowed = %v; want %v", i, allowed, want)
+		}
+	}
+	if allowed, dropped := policy.Allow("plg_a", now.Add(time.Second)); !allowed || dropped != 2 {
+		t.Errorf("after refill: allowed = %v, dropped = %d; want true, 2", allowed, dropped)
+	}
+
+	// Buckets of plugins that stopped logging are removed once they would be full again.
+	policy.Allow("plg_b", now.Add(time.Second))
+	policy.Allow("plg_b", now.Add(2*bucketSweepPeriod))
+	policy.mu.Lock()
+	_, kept := policy.buckets["plg_a"]
+	policy.mu.Unlock()
+	if kept {
+		t.Errorf("idle bucket of plg_a was not evicted")
+	}
+}
+
+func TestLogEntryUsesAuthenticatedPlugin(t *testing.T) {
+	log, err := logger.NewLogger(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+	policy, _ := NewPluginLogPolicy(configuration.PluginLoggingConfig{
+		DefaultLevel: "info",
+		Levels:       map[string]string{"plg_quiet": "error"},
+		RateLimit:    configuration.RateLimitConfig{PerSecond: 10, Burst: 10},
+	})
+	credentials, err := NewPluginCredentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := newPluginConnServer(map[string]func(session *pluginSession) interface{}{
+		"PluginAuthService": func(session *pluginSession) interface{} {
+			return &PluginAuthServiceRPC{credentials: credentials, session: session, appLogger: log}
+		},
+		"LogService": func(session *pluginSession) interface{} {
+			return &LogServiceRPC{appLogger: log, policy: policy, session: session}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverConn, clientConn := net.Pipe()
+	go server.ServeConn(serverConn)
+	client := rpc.NewClient(clientConn)
+	defer client.Close()
+
+	var reply LogEntryReply
+	entry := LogEntryArgs{Level: "warn", Message: "hello"}
+	if err := client.Call("LogService.Entry", entry, &reply); err == nil || !strings.Contains(err.Error(), "not authenticated") {
+		t.Fatalf("Entry before login: err = %v; want not authenticated", err)
+	}
+	// Another plugin's ID with a token that is not its own is rejected.
+	login := PluginLoginArgs{PluginID: "plg_other", Token: credentials.Token("plg_quiet")}
+	if err := client.Call("PluginAuthService.Login", login, &struct{}{}); err == nil {
+		t.Fatalf("Login with another plugin's token succeeded")
+	}
+	login.PluginID = "plg_quiet"
+	if err := client.Call("PluginAuthService.Login", login, &struct{}{}); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	// The level of the logged-in plugin applies: plg_quiet only logs errors.
+	if err := client.Call("LogService.Entry", entry, &reply); err != nil || reply.Accepted {
+		t.Errorf("warn entry of plg_quiet: accepted = %v, err = %v; want filtered", reply.Accepted, err)
+	}
+	entry.Level = "error"
+	if err := client.Call("LogService.Entry", entry, &reply); err != nil || !reply.Accepted {
+		t.Errorf("error entry of plg_quiet: accepted = %v, err = %v; want accepted", reply.Accepted, err)
+	}
+}
+
+func TestLogEntryCannotForgeLines(t *testing.T) {
+	logDir := t.TempDir()
+	log, err := logger.NewLogger(logDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+	policy, _ := NewPluginLogPolicy(configuration.PluginLoggingConfig{
+		DefaultLevel: "info",
+		RateLimit:    configuration.RateLimitConfig{PerSecond: 10, Burst: 10},
+	})
+	service := &LogServiceRPC{appLogger: log, policy: policy, session: &pluginSession{pluginID: "plg_a"}}
+
+	var reply LogEntryReply
+	badKey := LogEntryArgs{Level: "info", Message: "m", Fields: map[string]string{"k\nforged": "v"}}
+	if err := service.Entry(badKey, &reply); err == nil {
+		t.Errorf("Entry accepted a field key with a newline")
+	}
+	entry := LogEntryArgs{
+		Level:   "info",
+		Message: "first\nFORGED message",
+		Fields:  map[string]string{"k": "v\r\nFORGED field", "bell": "\a", "plain": "ok"},
+	}
+	if err := service.Entry(entry, &reply); err != nil || !reply.Accepted {
+		t.Fatalf("Entry: accepted = %v, err = %v", reply.Accepted, err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(logDir, "*.log"))
+	if len(files) != 1 {
+		t.Fatalf("log files = %v; want one", files)
+	}
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("entry was written as %d lines; want 1:\n%s", len(lines), data)
+	}
+	for _, want := range []string{`first\nFORGED message`, `bell="\a"`, `k="v\r\nFORGED field"`, ` plain=ok`} {
+		if !strings.Contains(lines[0], want) {
+			t.Errorf("log line %q does not contain %q", lines[0], want)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package rpc
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/OG-Open-Source/PanelBase/internal/atomicfile"
+)
+
+const defaultRuntimeInfoPath = "configs/server.json" // Written by a running server, read by CLI invocations
+
+// RuntimeInfo describes a running PanelBase server so that CLI invocations can find it
+// and route mutations through RPC instead of building their own managers.
+type RuntimeInfo struct {
+	PID       int    `json:"pid"`        // Process ID of the server
+	Address   string `json:"address"`    // Loopback address of the management services (host:port)
+	Token     string `json:"token"`      // Shared secret required by the management services
+	StartedAt string `json:"started_at"` // RFC3339 UTC timestamp
+}
+
+// WriteRuntimeInfo writes the runtime info file. The file holds the management token,
+// so it is only readable by the user running the server.
+func WriteRuntimeInfo(info *RuntimeInfo, infoPath ...string) error {
+	path := defaultRuntimeInfoPath
+	if len(infoPath) > 0 && infoPath[0] != "" {
+		path = infoPath[0]
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create runtime info directory '%s': %w", filepath.Dir(path), err)
+	}
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal runtime info: %w", err)
+	}
+	if err := atomicfile.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write runtime info file '%s': %w", path, err)
+	}
+	return nil
+}
+
+// ReadRuntimeInfo reads the runtime info file. It returns an error wrapping os.ErrNotExist
+// when no server has written one.
+func ReadRuntimeInfo(infoPath ...string) (*RuntimeInfo, error) {
+	path := defaultRuntimeInfoPath
+	if len(infoPath) > 0 && infoPath[0] != "" {
+		path = infoPath[0]
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read runtime info file '%s': %w", path, err)
+	}
+	var info RuntimeInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, fmt.Errorf("failed to parse runtime info file '%s': %w", path, err)
+	}
+	if info.Address == "" {
+		return nil, fmt.Errorf("runtime info file '%s' does not contain an address", path)
+	}
+	return &info, nil
+}
+
+// RemoveRuntimeInfo deletes the runtime info file and its backup (which holds an older token).
+// A missing file is not an error.
+func RemoveRuntimeInfo(infoPath ...string) error {
+	path := defaultRuntimeInfoPath
+	if len(infoPath) > 0 && infoPath[0] != "" {
+		path = infoPath[0]
+	}
+	if err := atomicfile.Remove(path); err != nil {
+		return fmt.Errorf("failed to remove runtime info file '%s': %w", path, err)
+	}
+	return nil
+}
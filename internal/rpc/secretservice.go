@@ -0,0 +1,57 @@
+package rpc
+
+import (
+	"fmt"
+
+	"github.com/OG-Open-Source/PanelBase/internal/logger"
+	"github.com/OG-Open-Source/PanelBase/internal/secrets"
+)
+
+// --- Secrets Service (plugin access to granted secrets) ---
+
+// SecretServiceRPC lets plugins read the secrets they were granted with
+// 'panelbase secrets set --allow-plugin'. Values are never listed or written over this service.
+// Each plugin connection has its own instance; grants are checked against the plugin the
+// connection logged in as.
+type SecretServiceRPC struct {
+	store   *secrets.Store
+	logger  *logger.Logger
+	session *pluginSession
+}
+
+// SecretGetArgs identifies the secret a plugin asks for.
+type SecretGetArgs struct {
+	ContainerID string // Optional; the container's secret is preferred over a global one of the same name
+	Name        string
+}
+
+// Get returns the value of a secret if the calling plugin is allowed to read it.
+func (s *SecretServiceRPC) Get(args SecretGetArgs, reply *[]byte) error {
+	pluginID, err := s.session.plugin()
+	if err != nil {
+		return err
+	}
+	value, err := s.store.GetForPlugin(pluginID, args.ContainerID, args.Name)
+	if err != nil {
+		s.logger.Logf("Secret '%s' denied to plugin '%s' (container '%s'): %v", args.Name, pluginID, args.ContainerID, err)
+		return err
+	}
+	s.logger.Logf("Secret '%s' read by plugin '%s' (container '%s')", args.Name, pluginID, args.ContainerID)
+	*reply = value
+	return nil
+}
+
+// RegisterSecretService registers the SecretService backed by store. It must be called before StartRPCServer.
+func RegisterSecretService(appLogger *logger.Logger, store *secrets.Store) error {
+	if appLogger == nil || store == nil {
+		return fmt.Errorf("logger and secrets store must be provided to register SecretService")
+	}
+	err := registerPluginService("SecretService", func(session *pluginSession) interface{} {
+		return &SecretServiceRPC{store: store, logger: appLogger, session: session}
+	})
+	if err != nil {
+		return fmt.Errorf("failed to register SecretService for RPC: %w", err)
+	}
+	appLogger.Log("Secret RPC service registered.")
+	return nil
+}
@@ -0,0 +1,40 @@
+package rpc
+
+import (
+	"testing"
+
+	"github.com/OG-Open-Source/PanelBase/internal/logger"
+	"github.com/OG-Open-Source/PanelBase/internal/secrets"
+)
+
+func TestSecretServiceChecksGrantsOfLoggedInPlugin(t *testing.T) {
+	store, err := secrets.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set(secrets.GlobalScope, "API_TOKEN", []byte("s3cr3t"), []string{"plg_reader"}); err != nil {
+		t.Fatal(err)
+	}
+	log, err := logger.NewLogger(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+	service := map[string]func(session *pluginSession) interface{}{
+		"SecretService": func(session *pluginSession) interface{} {
+			return &SecretServiceRPC{store: store, logger: log, session: session}
+		},
+	}
+
+	var value []byte
+	args := SecretGetArgs{Name: "API_TOKEN"}
+	if err := dialPluginConn(t, "plg_reader", service).Call("SecretService.Get", args, &value); err != nil || string(value) != "s3cr3t" {
+		t.Errorf("Get as plg_reader = %q, %v; want the granted secret", value, err)
+	}
+	if err := dialPluginConn(t, "plg_other", service).Call("SecretService.Get", args, &value); err == nil {
+		t.Errorf("Get as plg_other succeeded; the secret was not granted to it")
+	}
+	if err := dialPluginConn(t, "", service).Call("SecretService.Get", args, &value); err == nil {
+		t.Errorf("Get without login succeeded")
+	}
+}
@@ -3,7 +3,8 @@ package rpc
 import (
 	"fmt"
 	"net"
-	"net/rpc"
+	"strings"
+	"time"
 
 	// "os" // No longer needed for socket operations
 
@@ -53,7 +54,9 @@ func (s *IDServiceRPC) RequestID(args struct{}, reply *string) error { return s.
 
 // LogServiceRPC provides the RPC implementation for the pkgLog.LogService interface.
 type LogServiceRPC struct {
-	appLogger *logger.Logger // Reference to the internal logger
+	appLogger *logger.Logger   // Reference to the internal logger
+	policy    *PluginLogPolicy // Level filtering and rate limiting for Entry
+	session   *pluginSession   // Identity of the connection; Entry requires a logged-in plugin
 }
 
 // LogArgs holds arguments for the Log RPC method.
@@ -73,7 +76,7 @@ func (s *LogServiceRPC) Log(args LogArgs, reply *struct{}) error {
 	if s.appLogger == nil {
 		return fmt.Errorf("logger not initialized in RPC service")
 	}
-	s.appLogger.Log("(plugin) " + args.Message) // Prepend indication it's from a plugin
+	s.appLogger.Log("(plugin) " + logger.EscapeControl(args.Message)) // Prepend indication it's from a plugin
 	return nil
 }
 
@@ -88,16 +91,68 @@ func (s *LogServiceRPC) Logf(args LogfArgs, reply *struct{}) error {
 	return nil
 }
 
-// Convenience methods removed as LogLevel is gone.
+// LogEntryArgs holds arguments for the Entry RPC method. The plugin is the one the connection
+// logged in as (see PluginAuthService.Login).
+type LogEntryArgs struct {
+	Level   string            // debug, info, warn or error
+	Message string            // Human-readable message
+	Fields  map[string]string // Optional structured key/value pairs, preserved in the log line
+}
+
+// LogEntryReply reports whether an entry was written.
+type LogEntryReply struct {
+	Accepted bool // False when filtered out by the plugin's minimum level or dropped by rate limiting
+}
+
+// Entry implements the structured, leveled RPC logging method for plugins.
+// Entries below the plugin's minimum level are discarded, and noisy plugins are rate-limited;
+// the number of dropped entries is reported once the plugin is allowed to log again.
+func (s *LogServiceRPC) Entry(args LogEntryArgs, reply *LogEntryReply) error {
+	if s.appLogger == nil || s.policy == nil {
+		return fmt.Errorf("logger not initialized in RPC service")
+	}
+	pluginID, err := s.session.plugin()
+	if err != nil {
+		return err
+	}
+	level, err := ParseLogLevel(args.Level)
+	if err != nil {
+		return err
+	}
+	for key := range args.Fields {
+		if key == "" || strings.ContainsAny(key, " \t\"=") || logger.HasControl(key) {
+			return fmt.Errorf("invalid log field key %q: must be non-empty without spaces, quotes, '=' or control characters", key)
+		}
+	}
+
+	if !s.policy.Enabled(pluginID, level) {
+		return nil
+	}
+	allowed, dropped := s.policy.Allow(pluginID, time.Now())
+	if !allowed {
+		return nil
+	}
+	if dropped > 0 {
+		s.appLogger.Logf("(plugin %s) [%s] %d log entries dropped by rate limiting", pluginID, LevelWarn, dropped)
+	}
+	// Escape newlines and other control characters so one entry always stays on one line.
+	s.appLogger.LogFields(fmt.Sprintf("(plugin %s) [%s] %s", pluginID, level, logger.EscapeControl(args.Message)), args.Fields)
+	reply.Accepted = true
+	return nil
+}
 
 // --- RPC Server Setup ---
 
 // StartRPCServer initializes and starts the RPC server listening on the given host and port.
 // It signals on the ready channel once the server is ready to accept connections.
-func StartRPCServer(appLogger *logger.Logger, idGen *utils.IDGenerator, host string, port int, ready chan<- struct{}) error {
+// logPolicy controls the structured LogService.Entry method.
+func StartRPCServer(appLogger *logger.Logger, idGen *utils.IDGenerator, logPolicy *PluginLogPolicy, host string, port int, ready chan<- struct{}) error {
 	if appLogger == nil || idGen == nil {
 		return fmt.Errorf("logger and id generator must be provided to start RPC server")
 	}
+	if logPolicy == nil {
+		return fmt.Errorf("plugin log policy must be provided to start RPC server")
+	}
 	if ready == nil {
 		return fmt.Errorf("ready channel cannot be nil")
 	}
@@ -111,19 +166,17 @@ func StartRPCServer(appLogger *logger.Logger, idGen *utils.IDGenerator, host str
 		appLogger.Logf("RPC host not specified, defaulting to %s", host)
 	}
 
-	// Create RPC service instances
+	// Every connection gets its own RPC server, so services that act on behalf of a plugin can
+	// use the identity the connection logged in with (see pluginauth.go).
 	idService := &IDServiceRPC{generator: idGen}
-	logService := &LogServiceRPC{appLogger: appLogger}
-
-	// Register the services
-	err := rpc.RegisterName("IDService", idService) // Use specific names for clarity
-	if err != nil {
-		return fmt.Errorf("failed to register IDService for RPC: %w", err)
+	common := map[string]func(session *pluginSession) interface{}{
+		"IDService": func(*pluginSession) interface{} { return idService },
+		"LogService": func(session *pluginSession) interface{} {
+			return &LogServiceRPC{appLogger: appLogger, policy: logPolicy, session: session}
+		},
 	}
-	err = rpc.RegisterName("LogService", logService)
-	if err != nil {
-		// Attempt to unregister the first one if the second fails? Maybe not necessary.
-		return fmt.Errorf("failed to register LogService for RPC: %w", err)
+	if _, err := newPluginConnServer(common); err != nil { // Fail at startup rather than per connection
+		return err
 	}
 
 	// Construct the RPC listen address (listen on all interfaces for simplicity, like the main server)
@@ -147,10 +200,23 @@ func StartRPCServer(appLogger *logger.Logger, idGen *utils.IDGenerator, host str
 		ready <- struct{}{} // Send signal (empty struct uses no memory)
 		close(ready)        // Close the channel after signaling
 
-		// Now block and accept connections
-		appLogger.Log("RPC server accepting connections...")       // Use Log
-		rpc.Accept(listener)                                       // Blocks until listener is closed
-		appLogger.Log("RPC server stopped accepting connections.") // Use Log (or maybe Errorf if unexpected?)
+		// Now block and accept connections. Each connection is served with a codec that
+		// records per-method call metrics (see metrics.go) instead of plain rpc.Accept.
+		appLogger.Log("RPC server accepting connections...") // Use Log
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				appLogger.Logf("RPC server stopped accepting connections: %v", err)
+				return
+			}
+			server, err := newPluginConnServer(common)
+			if err != nil {
+				appLogger.Logf("RPC connection from %s rejected: %v", conn.RemoteAddr(), err)
+				conn.Close()
+				continue
+			}
+			go server.ServeCodec(newMetricsServerCodec(newGobServerCodec(conn), serverMetrics))
+		}
 	}()
 
 	// TODO: Implement graceful shutdown of the RPC server.
@@ -0,0 +1,430 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/OG-Open-Source/PanelBase/internal/atomicfile"
+	"github.com/OG-Open-Source/PanelBase/internal/filelock"
+)
+
+const (
+	FileName          = "secrets.json"               // Encrypted store inside the state directory (configs/ by default)
+	KeyFileName       = "secrets.key"                // Default key file, created on first use if no key is configured
+	KeyEnvVar         = "PANELBASE_SECRETS_KEY"      // Base64-encoded 32-byte key; takes precedence over key files
+	KeyFileEnvVar     = "PANELBASE_SECRETS_KEY_FILE" // Path of a file holding the base64-encoded key
+	GlobalScope       = "global"                     // Scope of secrets shared by every container
+	MaxValueBytes     = 64 << 10                     // 64KB limit per secret
+	keySize           = 32                           // AES-256
+	storeVersion      = 1
+	containerIDPrefix = "ctr_"
+)
+
+var (
+	// ErrNotFound is returned when a secret does not exist in the requested scope.
+	ErrNotFound = errors.New("secret not found")
+	// ErrNotAuthorized is returned when a plugin asks for a secret it was not granted.
+	ErrNotAuthorized = errors.New("plugin is not authorized to read this secret")
+
+	// Secret names become environment variable names when commands run.
+	nameRE = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+	// reservedNames are environment variables a secret must not replace: they control how the
+	// shell and the dynamic loader run a command, or are set by PanelBase itself.
+	reservedNames = map[string]bool{
+		"PATH": true, "HOME": true, "USER": true, "LOGNAME": true, "SHELL": true, "PWD": true,
+		"IFS": true, "ENV": true, "BASH_ENV": true, "CDPATH": true, "TMPDIR": true, "LANG": true,
+	}
+	reservedPrefixes = []string{"PANELBASE_", "LD_", "DYLD_", "LC_"}
+)
+
+// Info describes a secret without its value.
+type Info struct {
+	Scope          string
+	Name           string
+	AllowedPlugins []string // Plugin IDs allowed to read the secret over RPC
+	UpdatedAt      time.Time
+}
+
+// entry is one encrypted secret as stored on disk.
+type entry struct {
+	Nonce          string    `json:"nonce"`      // Base64
+	Ciphertext     string    `json:"ciphertext"` // Base64 AES-GCM output (includes the tag)
+	AllowedPlugins []string  `json:"allowed_plugins,omitempty"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// fileData is the JSON layout of the secrets file.
+type fileData struct {
+	Version int                         `json:"version"`
+	KeyID   string                      `json:"key_id"` // First bytes of SHA-256(key), to detect a wrong key
+	Scopes  map[string]map[string]entry `json:"scopes"` // Scope -> name -> entry
+}
+
+// Store holds secrets encrypted with AES-256-GCM in a single JSON file. Names and scopes are
+// stored in clear text; values are encrypted with the scope and name as additional data, so
+// entries cannot be swapped between names. The file is re-read on every call, so changes
+// made by the CLI are visible to a running server immediately.
+type Store struct {
+	path           string
+	aead           cipher.AEAD
+	keyID          string
+	defaultKeyFile bool // Key read from (or generated as) secrets.key in the state directory
+}
+
+// Open opens the store in dir (the state directory). The key is taken from PANELBASE_SECRETS_KEY,
+// else from the file named by PANELBASE_SECRETS_KEY_FILE, else from dir/secrets.key, which is
+// generated (mode 0600) if it does not exist yet.
+//
+// The default key file sits next to secrets.json, so it only protects copies of secrets.json
+// made without the key (e.g., a backup of that one file), not the state directory as a whole.
+// Keep the key elsewhere with one of the environment variables for encryption at rest;
+// UsesDefaultKeyFile tells callers when to point this out.
+func Open(dir string) (*Store, error) {
+	key, defaultKeyFile, err := loadKey(dir)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize AES-GCM: %w", err)
+	}
+	sum := sha256.Sum256(key)
+	return &Store{path: filepath.Join(dir, FileName), aead: aead, keyID: hex.EncodeToString(sum[:8]), defaultKeyFile: defaultKeyFile}, nil
+}
+
+// UsesDefaultKeyFile reports whether the key is the secrets.key file next to the secrets file.
+func (s *Store) UsesDefaultKeyFile() bool {
+	return s.defaultKeyFile
+}
+
+// loadKey resolves the encryption key as described on Open. defaultKeyFile is true when the
+// key came from (or was generated as) dir/secrets.key.
+func loadKey(dir string) (key []byte, defaultKeyFile bool, err error) {
+	if encoded, ok := os.LookupEnv(KeyEnvVar); ok {
+		key, err := decodeKey(encoded)
+		if err != nil {
+			return nil, false, fmt.Errorf("invalid %s: %w", KeyEnvVar, err)
+		}
+		return key, false, nil
+	}
+	keyPath := os.Getenv(KeyFileEnvVar)
+	generate := false
+	if keyPath == "" {
+		keyPath = filepath.Join(dir, KeyFileName)
+		generate = true // Only the default key file is created automatically
+	}
+	data, err := os.ReadFile(keyPath)
+	if err == nil {
+		key, err := decodeKey(string(data))
+		if err != nil {
+			return nil, false, fmt.Errorf("invalid secrets key file '%s': %w", keyPath, err)
+		}
+		return key, generate, nil
+	}
+	if !os.IsNotExist(err) || !generate {
+		return nil, false, fmt.Errorf("failed to read secrets key file '%s': %w", keyPath, err)
+	}
+
+	key = make([]byte, keySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, false, fmt.Errorf("failed to generate secrets key: %w", err)
+	}
+	encoded := base64.StdEncoding.EncodeToString(key) + "\n"
+	if err := atomicfile.WriteFile(keyPath, []byte(encoded), 0600); err != nil {
+		return nil, false, fmt.Errorf("failed to write secrets key file '%s': %w", keyPath, err)
+	}
+	return key, true, nil
+}
+
+func decodeKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("key must be base64-encoded: %w", err)
+	}
+	if len(key) != keySize {
+		return nil, fmt.Errorf("key must be %d bytes, got %d", keySize, len(key))
+	}
+	return key, nil
+}
+
+// Path returns the location of the secrets file.
+func (s *Store) Path() string {
+	return s.path
+}
+
+// ValidateScope checks that scope is GlobalScope or a container ID.
+func ValidateScope(scope string) error {
+	if scope == GlobalScope || (strings.HasPrefix(scope, containerIDPrefix) && len(scope) > len(containerIDPrefix) && !strings.ContainsAny(scope, "/\\")) {
+		return nil
+	}
+	return fmt.Errorf("invalid secret scope '%s': expected '%s' or a container ID (%s...)", scope, GlobalScope, containerIDPrefix)
+}
+
+// ValidateName checks that name can be used as an environment variable name and does not
+// replace a variable that controls how commands run (see isReserved).
+func ValidateName(name string) error {
+	if !nameRE.MatchString(name) {
+		return fmt.Errorf("invalid secret name '%s': use letters, digits and underscores, not starting with a digit", name)
+	}
+	if isReserved(name) {
+		return fmt.Errorf("invalid secret name '%s': reserved environment variable (PATH, HOME, %s...)", name, strings.Join(reservedPrefixes, "..., "))
+	}
+	return nil
+}
+
+// isReserved reports whether name is an environment variable a secret must not replace.
+// Names are compared case-insensitively, as on Windows.
+func isReserved(name string) bool {
+	upper := strings.ToUpper(name)
+	if reservedNames[upper] {
+		return true
+	}
+	for _, prefix := range reservedPrefixes {
+		if strings.HasPrefix(upper, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// load reads the secrets file. A missing file is an empty store.
+func (s *Store) load() (*fileData, error) {
+	data := &fileData{Version: storeVersion, KeyID: s.keyID, Scopes: make(map[string]map[string]entry)}
+	raw, _, err := atomicfile.ReadFile(s.path, func(b []byte) error {
+		var probe fileData
+		return json.Unmarshal(b, &probe)
+	})
+	if err != nil {
+		if os.IsNotExist(err) {
+			return data, nil
+		}
+		return nil, fmt.Errorf("failed to read secrets file '%s': %w", s.path, err)
+	}
+	if err := json.Unmarshal(raw, data); err != nil {
+		return nil, fmt.Errorf("failed to parse secrets file '%s': %w", s.path, err)
+	}
+	if data.Version > storeVersion {
+		return nil, fmt.Errorf("secrets file '%s' has version %d; this build supports %d", s.path, data.Version, storeVersion)
+	}
+	if data.KeyID != "" && data.KeyID != s.keyID {
+		return nil, fmt.Errorf("secrets file '%s' was encrypted with a different key (key id %s, current key id %s)", s.path, data.KeyID, s.keyID)
+	}
+	if data.Scopes == nil {
+		data.Scopes = make(map[string]map[string]entry)
+	}
+	return data, nil
+}
+
+// update runs fn on the loaded secrets under the file lock and saves the result.
+func (s *Store) update(fn func(data *fileData) error) error {
+	lock, err := filelock.Acquire(s.path+".lock", filelock.DefaultTimeout)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	data, err := s.load()
+	if err != nil {
+		return err
+	}
+	if err := fn(data); err != nil {
+		return err
+	}
+	data.Version, data.KeyID = storeVersion, s.keyID
+	raw, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal secrets: %w", err)
+	}
+	if err := atomicfile.WriteFile(s.path, raw, 0600); err != nil {
+		return fmt.Errorf("failed to write secrets file '%s': %w", s.path, err)
+	}
+	return nil
+}
+
+func additionalData(scope, name string) []byte {
+	return []byte(scope + "\x00" + name)
+}
+
+// Set stores a secret in scope, replacing any previous value and plugin grants.
+func (s *Store) Set(scope, name string, value []byte, allowedPlugins []string) error {
+	if err := ValidateScope(scope); err != nil {
+		return err
+	}
+	if err := ValidateName(name); err != nil {
+		return err
+	}
+	if len(value) > MaxValueBytes {
+		return fmt.Errorf("secret value exceeds %d bytes", MaxValueBytes)
+	}
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	ciphertext := s.aead.Seal(nil, nonce, value, additionalData(scope, name))
+	plugins := append([]string(nil), allowedPlugins...)
+	sort.Strings(plugins)
+
+	return s.update(func(data *fileData) error {
+		if data.Scopes[scope] == nil {
+			data.Scopes[scope] = make(map[string]entry)
+		}
+		data.Scopes[scope][name] = entry{
+			Nonce:          base64.StdEncoding.EncodeToString(nonce),
+			Ciphertext:     base64.StdEncoding.EncodeToString(ciphertext),
+			AllowedPlugins: plugins,
+			UpdatedAt:      time.Now().UTC(),
+		}
+		return nil
+	})
+}
+
+// Get returns the decrypted value of a secret. It returns ErrNotFound if it does not exist.
+func (s *Store) Get(scope, name string) ([]byte, error) {
+	data, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	e, ok := data.Scopes[scope][name]
+	if !ok {
+		return nil, fmt.Errorf("%w: '%s' in scope '%s'", ErrNotFound, name, scope)
+	}
+	return s.decrypt(scope, name, e)
+}
+
+func (s *Store) decrypt(scope, name string, e entry) ([]byte, error) {
+	nonce, err := base64.StdEncoding.DecodeString(e.Nonce)
+	if err != nil {
+		return nil, fmt.Errorf("corrupt nonce for secret '%s' in scope '%s': %w", name, scope, err)
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(e.Ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("corrupt value for secret '%s' in scope '%s': %w", name, scope, err)
+	}
+	value, err := s.aead.Open(nil, nonce, ciphertext, additionalData(scope, name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt secret '%s' in scope '%s' (wrong key or tampered file)", name, scope)
+	}
+	return value, nil
+}
+
+// Delete removes a secret and reports whether it existed.
+func (s *Store) Delete(scope, name string) (bool, error) {
+	existed := false
+	err := s.update(func(data *fileData) error {
+		if _, existed = data.Scopes[scope][name]; existed {
+			delete(data.Scopes[scope], name)
+			if len(data.Scopes[scope]) == 0 {
+				delete(data.Scopes, scope)
+			}
+		}
+		return nil
+	})
+	return existed, err
+}
+
+// List describes the secrets in scope, or in every scope when scope is empty,
+// sorted by scope (global first) and name.
+func (s *Store) List(scope string) ([]Info, error) {
+	data, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	var infos []Info
+	for sc, entries := range data.Scopes {
+		if scope != "" && sc != scope {
+			continue
+		}
+		for name, e := range entries {
+			infos = append(infos, Info{Scope: sc, Name: name, AllowedPlugins: e.AllowedPlugins, UpdatedAt: e.UpdatedAt})
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].Scope != infos[j].Scope {
+			if infos[i].Scope == GlobalScope || infos[j].Scope == GlobalScope {
+				return infos[i].Scope == GlobalScope
+			}
+			return infos[i].Scope < infos[j].Scope
+		}
+		return infos[i].Name < infos[j].Name
+	})
+	return infos, nil
+}
+
+// Environment returns the secrets visible to a container as environment variables
+// (NAME=value): global secrets, overridden by the container's own. An empty containerID
+// returns only the global secrets. Secrets with reserved names, stored before they were
+// rejected, are left out.
+func (s *Store) Environment(containerID string) ([]string, error) {
+	data, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]string)
+	scopes := []string{GlobalScope}
+	if containerID != "" {
+		scopes = append(scopes, containerID)
+	}
+	for _, scope := range scopes {
+		for name, e := range data.Scopes[scope] {
+			if isReserved(name) {
+				continue
+			}
+			value, err := s.decrypt(scope, name, e)
+			if err != nil {
+				return nil, err
+			}
+			values[name] = string(value)
+		}
+	}
+	env := make([]string, 0, len(values))
+	for name, value := range values {
+		env = append(env, name+"="+value)
+	}
+	sort.Strings(env)
+	return env, nil
+}
+
+// GetForPlugin returns a secret to a plugin if the plugin was granted access. The container's
+// own secret is preferred over a global one of the same name.
+func (s *Store) GetForPlugin(pluginID, containerID, name string) ([]byte, error) {
+	data, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	scopes := []string{GlobalScope}
+	if containerID != "" {
+		scopes = []string{containerID, GlobalScope}
+	}
+	for _, scope := range scopes {
+		e, ok := data.Scopes[scope][name]
+		if !ok {
+			continue
+		}
+		for _, allowed := range e.AllowedPlugins {
+			if pluginID != "" && allowed == pluginID {
+				return s.decrypt(scope, name, e)
+			}
+		}
+		return nil, fmt.Errorf("%w: plugin '%s', secret '%s' in scope '%s'", ErrNotAuthorized, pluginID, name, scope)
+	}
+	return nil, fmt.Errorf("%w: '%s'", ErrNotFound, name)
+}
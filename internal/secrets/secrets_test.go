@@ -0,0 +1,92 @@
+package secrets
+
+import (
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestStoreScopesAndPluginAccess(t *testing.T) {
+	dir := t.TempDir()
+	store, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if err := store.Set(GlobalScope, "API_TOKEN", []byte("global-token"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set("ctr_abc123", "API_TOKEN", []byte("container-token"), []string{"plg_reader"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set("ctr_abc123", "1BAD", []byte("x"), nil); err == nil {
+		t.Error("expected an error for a name that is not a valid environment variable")
+	}
+
+	raw, err := os.ReadFile(filepath.Join(dir, FileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(raw), "token") {
+		t.Fatalf("secrets file contains a plaintext value:\n%s", raw)
+	}
+
+	env, err := store.Environment("ctr_abc123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"API_TOKEN=container-token"}; !reflect.DeepEqual(env, want) {
+		t.Errorf("Environment = %v; want %v", env, want)
+	}
+
+	if value, err := store.GetForPlugin("plg_reader", "ctr_abc123", "API_TOKEN"); err != nil || string(value) != "container-token" {
+		t.Errorf("GetForPlugin(plg_reader) = %q, %v", value, err)
+	}
+	if _, err := store.GetForPlugin("plg_other", "ctr_abc123", "API_TOKEN"); !errors.Is(err, ErrNotAuthorized) {
+		t.Errorf("GetForPlugin(plg_other) error = %v; want ErrNotAuthorized", err)
+	}
+
+	// A store opened with a different key must refuse the file rather than return garbage.
+	t.Setenv(KeyEnvVar, "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=")
+	other, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.Get(GlobalScope, "API_TOKEN"); err == nil || !strings.Contains(err.Error(), "different key") {
+		t.Errorf("Get with the wrong key error = %v; want a key mismatch", err)
+	}
+}
+
+func TestReservedNamesAreRejectedAndNotInjected(t *testing.T) {
+	dir := t.TempDir()
+	store, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !store.UsesDefaultKeyFile() {
+		t.Error("UsesDefaultKeyFile = false for a generated secrets.key")
+	}
+	for _, name := range []string{"PATH", "home", "LD_PRELOAD", "PANELBASE_CONTAINER_ID", "LC_ALL"} {
+		if err := store.Set(GlobalScope, name, []byte("x"), nil); err == nil {
+			t.Errorf("Set(%s) succeeded; want a reserved name error", name)
+		}
+	}
+
+	// A reserved name stored by an older build is skipped when commands run.
+	if err := store.update(func(data *fileData) error {
+		nonce := make([]byte, store.aead.NonceSize())
+		data.Scopes[GlobalScope] = map[string]entry{"PATH": {
+			Nonce:      base64.StdEncoding.EncodeToString(nonce),
+			Ciphertext: base64.StdEncoding.EncodeToString(store.aead.Seal(nil, nonce, []byte("/evil"), additionalData(GlobalScope, "PATH"))),
+		}}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if env, err := store.Environment(""); err != nil || len(env) != 0 {
+		t.Errorf("Environment = %v, %v; want the reserved PATH secret left out", env, err)
+	}
+}
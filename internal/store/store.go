@@ -0,0 +1,538 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/OG-Open-Source/PanelBase/internal/atomicfile"
+	"github.com/OG-Open-Source/PanelBase/internal/filelock"
+)
+
+const (
+	DefaultPath = "configs/state.db" // Default location of the state log
+
+	// Bucket names used by PanelBase. Buckets are created implicitly on first write.
+	BucketThemes     = "themes"
+	BucketPlugins    = "plugins"
+	BucketCommands   = "commands"
+	BucketContainers = "containers"
+	BucketMeta       = "meta" // Store-level bookkeeping such as migration markers
+
+	compactMinRecords = 256 // Never compact logs shorter than this
+	compactRatio      = 4   // Compact when the log holds this many records per live key
+)
+
+// ErrReadOnly is returned when a View transaction attempts to write.
+var ErrReadOnly = errors.New("cannot write in a read-only transaction")
+
+// ErrDamaged is returned by Update and Compact when a record in the middle of the log cannot be
+// decoded. Records after it are valid data, so the log is neither truncated nor appended to.
+var ErrDamaged = errors.New("store log is damaged")
+
+// op is one change inside a committed transaction.
+type op struct {
+	Bucket string          `json:"bucket"`
+	Key    string          `json:"key"`
+	Value  json.RawMessage `json:"value,omitempty"`
+	Delete bool            `json:"delete,omitempty"`
+}
+
+// record is one committed transaction. Each record is written as a single line:
+// "<crc32 hex> <json>\n", so a torn final line is detected and ignored on replay.
+type record struct {
+	Seq  uint64 `json:"seq"`
+	Time string `json:"time"`
+	Ops  []op   `json:"ops"`
+}
+
+// Store is an embedded, transactional key-value store kept in an append-only log.
+// Every committed transaction appends one record; the log is replayed into memory when opened
+// and compacted into a snapshot once it grows well beyond the live data.
+//
+// Several processes (the server and CLI invocations) may open the same store: writes are
+// serialized with a cross-process file lock, and each transaction first catches up with
+// records appended by other processes.
+type Store struct {
+	path string
+	mu   sync.Mutex
+
+	data    map[string]map[string]json.RawMessage // bucket -> key -> JSON value
+	seq     uint64                                // Sequence number of the last applied record
+	records int                                   // Records in the current log file
+	offset  int64                                 // Bytes of the log file already applied
+	file    os.FileInfo                           // Identity of the log file last read (changes on compaction)
+	damaged error                                 // Set when valid records follow an undecodable one
+}
+
+// Open opens (or creates) the store at the given path or DefaultPath and replays its log.
+func Open(path ...string) (*Store, error) {
+	p := DefaultPath
+	if len(path) > 0 && path[0] != "" {
+		p = path[0]
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create store directory '%s': %w", filepath.Dir(p), err)
+	}
+	s := &Store{path: p}
+	s.reset()
+	if err := s.catchUp(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Path returns the log file path.
+func (s *Store) Path() string {
+	return s.path
+}
+
+func (s *Store) reset() {
+	s.data = make(map[string]map[string]json.RawMessage)
+	s.seq = 0
+	s.records = 0
+	s.offset = 0
+	s.file = nil
+	s.damaged = nil
+}
+
+// View runs fn in a read-only transaction over the latest committed state.
+func (s *Store) View(fn func(tx *Tx) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.catchUp(); err != nil {
+		return err
+	}
+	return fn(&Tx{store: s, readOnly: true})
+}
+
+// Update runs fn in a read-write transaction. Changes made through tx are committed atomically
+// (across all buckets) when fn returns nil, and discarded when it returns an error.
+func (s *Store) Update(fn func(tx *Tx) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lock, err := filelock.Acquire(s.path+".lock", filelock.DefaultTimeout)
+	if err != nil {
+		return fmt.Errorf("failed to lock store '%s': %w", s.path, err)
+	}
+	defer lock.Release()
+
+	if err := s.catchUp(); err != nil {
+		return err
+	}
+	if s.damaged != nil {
+		return s.damaged
+	}
+	// A torn record left by a crashed writer would corrupt the next append; cut it off.
+	if err := s.truncateTail(); err != nil {
+		return err
+	}
+
+	tx := &Tx{store: s, pending: make(map[string]map[string]*json.RawMessage)}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	ops := tx.ops()
+	if len(ops) == 0 {
+		return nil
+	}
+	rec := record{Seq: s.seq + 1, Time: time.Now().UTC().Format(time.RFC3339Nano), Ops: ops}
+	if err := s.append(rec); err != nil {
+		return err
+	}
+	s.apply(rec)
+
+	if s.records >= compactMinRecords && s.records > compactRatio*s.liveKeys() {
+		// The append already succeeded; a failed compaction only leaves the log longer.
+		s.compactLocked()
+	}
+	return nil
+}
+
+// Compact rewrites the log as a single snapshot record holding only the live data.
+func (s *Store) Compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lock, err := filelock.Acquire(s.path+".lock", filelock.DefaultTimeout)
+	if err != nil {
+		return fmt.Errorf("failed to lock store '%s': %w", s.path, err)
+	}
+	defer lock.Release()
+	if err := s.catchUp(); err != nil {
+		return err
+	}
+	if s.damaged != nil {
+		return s.damaged // The snapshot would drop the records after the damaged one
+	}
+	return s.compactLocked()
+}
+
+// compactLocked writes the snapshot. Caller must hold s.mu and the file lock.
+func (s *Store) compactLocked() error {
+	rec := record{Seq: s.seq, Time: time.Now().UTC().Format(time.RFC3339Nano)}
+	for _, bucket := range sortedKeys(s.data) {
+		for _, key := range sortedKeys(s.data[bucket]) {
+			rec.Ops = append(rec.Ops, op{Bucket: bucket, Key: key, Value: s.data[bucket][key]})
+		}
+	}
+	line, err := encodeRecord(rec)
+	if err != nil {
+		return err
+	}
+	if err := atomicfile.WriteFile(s.path, line, 0644); err != nil {
+		return fmt.Errorf("failed to compact store '%s': %w", s.path, err)
+	}
+	// Re-read from scratch so offset and file identity match the new file.
+	s.reset()
+	return s.catchUp()
+}
+
+// append writes one record to the end of the log and fsyncs it. Caller must hold the file lock.
+func (s *Store) append(rec record) error {
+	line, err := encodeRecord(rec)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open store '%s' for append: %w", s.path, err)
+	}
+	defer f.Close()
+	if _, err := f.Write(line); err != nil {
+		return fmt.Errorf("failed to append to store '%s': %w", s.path, err)
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("failed to sync store '%s': %w", s.path, err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat store '%s': %w", s.path, err)
+	}
+	s.offset = info.Size()
+	s.file = info
+	s.records++
+	return nil
+}
+
+// catchUp applies records appended since the last read. If the log was replaced (compacted by
+// another process) it is replayed from the start. Replay stops at the first incomplete or corrupt
+// line; if valid records follow it, s.damaged is set so writes are refused. Caller must hold s.mu.
+func (s *Store) catchUp() error {
+	f, err := os.Open(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			s.reset()
+			return nil
+		}
+		return fmt.Errorf("failed to open store '%s': %w", s.path, err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat store '%s': %w", s.path, err)
+	}
+	if s.file == nil || !os.SameFile(s.file, info) || info.Size() < s.offset {
+		s.reset()
+	}
+	s.file = info
+	if info.Size() == s.offset {
+		return nil
+	}
+	if _, err := f.Seek(s.offset, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek store '%s': %w", s.path, err)
+	}
+	s.damaged = nil
+
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			return nil // Any partial line is a write in progress or a torn record
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read store '%s': %w", s.path, err)
+		}
+		rec, decodeErr := decodeRecord(line)
+		if decodeErr != nil {
+			if validRecordFollows(reader) {
+				s.damaged = fmt.Errorf("%w: record at byte %d of '%s' cannot be decoded but valid records follow it; repair or restore the file before writing: %v", ErrDamaged, s.offset, s.path, decodeErr)
+			}
+			return nil // Stop at the first damaged record; Update truncates it if it is the torn tail
+		}
+		s.apply(rec)
+		s.records++
+		s.offset += int64(len(line))
+	}
+}
+
+// validRecordFollows reports whether any complete line left in reader decodes as a record.
+func validRecordFollows(reader *bufio.Reader) bool {
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			return false // A trailing partial line is never a committed record
+		}
+		if _, err := decodeRecord(line); err == nil {
+			return true
+		}
+	}
+}
+
+// truncateTail drops the torn record after the last valid one. Update only calls it when no
+// valid record follows (s.damaged is nil). Caller must hold the file lock.
+func (s *Store) truncateTail() error {
+	if s.file == nil || s.file.Size() == s.offset {
+		return nil
+	}
+	if err := os.Truncate(s.path, s.offset); err != nil {
+		return fmt.Errorf("failed to truncate damaged tail of store '%s': %w", s.path, err)
+	}
+	if info, err := os.Stat(s.path); err == nil {
+		s.file = info
+	}
+	return nil
+}
+
+// apply folds a record into the in-memory state.
+func (s *Store) apply(rec record) {
+	for _, o := range rec.Ops {
+		if o.Delete {
+			delete(s.data[o.Bucket], o.Key)
+			continue
+		}
+		bucket := s.data[o.Bucket]
+		if bucket == nil {
+			bucket = make(map[string]json.RawMessage)
+			s.data[o.Bucket] = bucket
+		}
+		bucket[o.Key] = o.Value
+	}
+	if rec.Seq > s.seq {
+		s.seq = rec.Seq
+	}
+}
+
+func (s *Store) liveKeys() int {
+	n := 0
+	for _, bucket := range s.data {
+		n += len(bucket)
+	}
+	return n
+}
+
+func encodeRecord(rec record) ([]byte, error) {
+	payload, err := json.Marshal(rec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode store record: %w", err)
+	}
+	var buf bytes.Buffer
+	buf.WriteString(fmt.Sprintf("%08x ", crc32.ChecksumIEEE(payload)))
+	buf.Write(payload)
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
+
+func decodeRecord(line []byte) (record, error) {
+	var rec record
+	line = bytes.TrimRight(line, "\n")
+	sep := bytes.IndexByte(line, ' ')
+	if sep != 8 {
+		return rec, fmt.Errorf("malformed record")
+	}
+	sum, err := strconv.ParseUint(string(line[:sep]), 16, 32)
+	if err != nil {
+		return rec, fmt.Errorf("malformed record checksum: %w", err)
+	}
+	payload := line[sep+1:]
+	if crc32.ChecksumIEEE(payload) != uint32(sum) {
+		return rec, fmt.Errorf("record checksum mismatch")
+	}
+	if err := json.Unmarshal(payload, &rec); err != nil {
+		return rec, fmt.Errorf("failed to decode record: %w", err)
+	}
+	return rec, nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// --- Transactions ---
+
+// Tx is a transaction over the store. Reads observe the transaction's own pending writes.
+// A Tx must not be used after the View or Update call that created it returns.
+type Tx struct {
+	store    *Store
+	readOnly bool
+	pending  map[string]map[string]*json.RawMessage // nil value marks a delete
+}
+
+// GetRaw returns the JSON value stored under bucket/key.
+func (tx *Tx) GetRaw(bucket, key string) (json.RawMessage, bool) {
+	if p, ok := tx.pending[bucket][key]; ok {
+		if p == nil {
+			return nil, false
+		}
+		return *p, true
+	}
+	value, ok := tx.store.data[bucket][key]
+	return value, ok
+}
+
+// Get decodes the value stored under bucket/key into v and reports whether it exists.
+func (tx *Tx) Get(bucket, key string, v interface{}) (bool, error) {
+	raw, ok := tx.GetRaw(bucket, key)
+	if !ok {
+		return false, nil
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return true, fmt.Errorf("failed to decode '%s/%s': %w", bucket, key, err)
+	}
+	return true, nil
+}
+
+// Put stores v (encoded as JSON) under bucket/key.
+func (tx *Tx) Put(bucket, key string, v interface{}) error {
+	if tx.readOnly {
+		return ErrReadOnly
+	}
+	if bucket == "" || key == "" {
+		return fmt.Errorf("bucket and key cannot be empty")
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to encode '%s/%s': %w", bucket, key, err)
+	}
+	msg := json.RawMessage(raw)
+	tx.pendingBucket(bucket)[key] = &msg
+	return nil
+}
+
+// Delete removes bucket/key. Deleting a missing key is not an error.
+func (tx *Tx) Delete(bucket, key string) error {
+	if tx.readOnly {
+		return ErrReadOnly
+	}
+	tx.pendingBucket(bucket)[key] = nil
+	return nil
+}
+
+// Keys returns the keys of a bucket in sorted order.
+func (tx *Tx) Keys(bucket string) []string {
+	set := make(map[string]bool)
+	for key := range tx.store.data[bucket] {
+		set[key] = true
+	}
+	for key, p := range tx.pending[bucket] {
+		set[key] = p != nil
+	}
+	keys := make([]string, 0, len(set))
+	for key, live := range set {
+		if live {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (tx *Tx) pendingBucket(bucket string) map[string]*json.RawMessage {
+	b := tx.pending[bucket]
+	if b == nil {
+		b = make(map[string]*json.RawMessage)
+		tx.pending[bucket] = b
+	}
+	return b
+}
+
+// ops converts pending writes into record operations, skipping writes that change nothing.
+func (tx *Tx) ops() []op {
+	var ops []op
+	for _, bucket := range sortedKeys(tx.pending) {
+		for _, key := range sortedKeys(tx.pending[bucket]) {
+			p := tx.pending[bucket][key]
+			current, exists := tx.store.data[bucket][key]
+			if p == nil {
+				if exists {
+					ops = append(ops, op{Bucket: bucket, Key: key, Delete: true})
+				}
+				continue
+			}
+			if exists && bytes.Equal(current, *p) {
+				continue
+			}
+			ops = append(ops, op{Bucket: bucket, Key: key, Value: *p})
+		}
+	}
+	return ops
+}
+
+// --- Typed buckets ---
+
+// Bucket gives typed access to one bucket whose values are all of type T.
+type Bucket[T any] struct {
+	Name string
+}
+
+// Get returns the value stored under key.
+func (b Bucket[T]) Get(tx *Tx, key string) (T, bool, error) {
+	var v T
+	ok, err := tx.Get(b.Name, key, &v)
+	return v, ok, err
+}
+
+// Put stores value under key.
+func (b Bucket[T]) Put(tx *Tx, key string, value T) error {
+	return tx.Put(b.Name, key, value)
+}
+
+// Delete removes key.
+func (b Bucket[T]) Delete(tx *Tx, key string) error {
+	return tx.Delete(b.Name, key)
+}
+
+// All returns every entry of the bucket.
+func (b Bucket[T]) All(tx *Tx) (map[string]T, error) {
+	result := make(map[string]T)
+	for _, key := range tx.Keys(b.Name) {
+		v, _, err := b.Get(tx, key)
+		if err != nil {
+			return nil, err
+		}
+		result[key] = v
+	}
+	return result, nil
+}
+
+// Replace makes the bucket contain exactly the entries of values.
+func (b Bucket[T]) Replace(tx *Tx, values map[string]T) error {
+	for _, key := range tx.Keys(b.Name) {
+		if _, keep := values[key]; !keep {
+			if err := tx.Delete(b.Name, key); err != nil {
+				return err
+			}
+		}
+	}
+	for key, value := range values {
+		if err := tx.Put(b.Name, key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
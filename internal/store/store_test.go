@@ -0,0 +1,132 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var testBucket = Bucket[string]{Name: "test"}
+
+func TestUpdateIsVisibleToOtherInstancesAndSurvivesTornTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.db")
+	a, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Update(func(tx *Tx) error {
+		if err := testBucket.Put(tx, "k1", "v1"); err != nil {
+			return err
+		}
+		return tx.Put(BucketMeta, "marker", true)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate a writer that crashed mid-append.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`0badf00d {"seq":9,"op`)
+	f.Close()
+
+	if err := b.Update(func(tx *Tx) error {
+		return testBucket.Put(tx, "k2", "v2")
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var all map[string]string
+	reopened.View(func(tx *Tx) error {
+		all, err = testBucket.All(tx)
+		return err
+	})
+	if len(all) != 2 || all["k1"] != "v1" || all["k2"] != "v2" {
+		t.Fatalf("entries after reopen = %v; want k1 and k2", all)
+	}
+}
+
+func TestUpdateRefusesToWriteAfterDamagedRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.db")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"k1", "k2"} {
+		if err := s.Update(func(tx *Tx) error { return testBucket.Put(tx, key, "v") }); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Damage the first record; the second one is still valid.
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[0] ^= 0x01
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	damaged, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = damaged.Update(func(tx *Tx) error { return testBucket.Put(tx, "k3", "v") })
+	if !errors.Is(err, ErrDamaged) {
+		t.Fatalf("Update after damaged record: err = %v; want ErrDamaged", err)
+	}
+	if err := damaged.Compact(); !errors.Is(err, ErrDamaged) {
+		t.Fatalf("Compact after damaged record: err = %v; want ErrDamaged", err)
+	}
+	if onDisk, _ := os.ReadFile(path); !bytes.Equal(onDisk, data) {
+		t.Fatalf("log was modified; the valid record after the damaged one must be kept")
+	}
+}
+
+func TestCompactKeepsLiveData(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.db")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		value := string(rune('a' + i))
+		if err := s.Update(func(tx *Tx) error { return testBucket.Put(tx, "k", value) }); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.Update(func(tx *Tx) error { return testBucket.Put(tx, "gone", "x") })
+	s.Update(func(tx *Tx) error { return testBucket.Delete(tx, "gone") })
+	if err := s.Compact(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reopened.records != 1 {
+		t.Fatalf("records after compaction = %d; want 1", reopened.records)
+	}
+	reopened.View(func(tx *Tx) error {
+		keys := tx.Keys(testBucket.Name)
+		if len(keys) != 1 || keys[0] != "k" {
+			t.Fatalf("keys = %v; want [k]", keys)
+		}
+		if v, _, _ := testBucket.Get(tx, "k"); v != "j" {
+			t.Fatalf("k = %q; want %q", v, "j")
+		}
+		return nil
+	})
+}
@@ -3,6 +3,7 @@ package utils
 import (
 	"crypto/rand"
 	"fmt"
+	"sync"
 
 	// "io" // Removed unused import
 	"math/big"
@@ -11,7 +12,9 @@ import (
 )
 
 // IDGenerator holds the configuration needed for generating IDs.
+// The alphabet and length can be changed at runtime with Update.
 type IDGenerator struct {
+	mu       sync.RWMutex
 	alphabet string
 	length   int
 }
@@ -27,9 +30,23 @@ func NewIDGenerator(cfg *configuration.SecurityConfig) (*IDGenerator, error) {
 	}, nil
 }
 
+// Update replaces the alphabet and length used for IDs generated from now on.
+func (g *IDGenerator) Update(cfg *configuration.SecurityConfig) error {
+	if cfg == nil || cfg.Secrets.Alphabet == "" || cfg.Secrets.Length <= 0 {
+		return fmt.Errorf("invalid security config for ID generator: alphabet and length must be set")
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.alphabet = cfg.Secrets.Alphabet
+	g.length = cfg.Secrets.Length
+	return nil
+}
+
 // generateRandomString generates a random string of the configured length using the configured alphabet.
 // Uses crypto/rand for cryptographically secure random numbers.
 func (g *IDGenerator) generateRandomString() (string, error) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
 	alphabetLen := big.NewInt(int64(len(g.alphabet)))
 	bytes := make([]byte, g.length)
 	for i := range bytes {